@@ -9,21 +9,31 @@ package mig /* import "github.com/mozilla/mig" */
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/mozilla/mig/pgp"
 )
 
-// ActionVersion is the version of the syntax that is expected
-const ActionVersion uint16 = 2
+// ActionVersion is the version of the syntax that is expected. Version 3
+// actions are signed over a canonical JSON encoding that covers their ID,
+// description, threat and nonce. Version 2 actions are signed over the name,
+// target, validity and operations only, and can be replayed.
+const ActionVersion uint16 = 3
+
+// ActionVersionLegacy is the previous version of the syntax, that agents
+// only accept when configured to
+const ActionVersionLegacy uint16 = 2
 
 // Action is the json object that is created by an investigator
 // and provided to the MIG platform. It must be PGP signed.
@@ -44,18 +54,42 @@ type Action struct {
 	LastUpdateTime time.Time      `json:"lastupdatetime,omitempty"`
 	Counters       ActionCounters `json:"counters,omitempty"`
 	SyntaxVersion  uint16         `json:"syntaxversion,omitempty"`
+	Nonce          string         `json:"nonce,omitempty"`
 }
 
 // ActionCounters are counters used to track the completion of an action
 type ActionCounters struct {
-	Sent      int `json:"sent,omitempty"`
-	Done      int `json:"done,omitempty"`
-	InFlight  int `json:"inflight,omitempty"`
-	Success   int `json:"success,omitempty"`
-	Cancelled int `json:"cancelled,omitempty"`
-	Expired   int `json:"expired,omitempty"`
-	Failed    int `json:"failed,omitempty"`
-	TimeOut   int `json:"timeout,omitempty"`
+	Sent          int `json:"sent,omitempty"`
+	Done          int `json:"done,omitempty"`
+	InFlight      int `json:"inflight,omitempty"`
+	Success       int `json:"success,omitempty"`
+	Cancelled     int `json:"cancelled,omitempty"`
+	Expired       int `json:"expired,omitempty"`
+	Failed        int `json:"failed,omitempty"`
+	TimeOut       int `json:"timeout,omitempty"`
+	ResourceLimit int `json:"resourcelimit,omitempty"`
+}
+
+// Types of events published while an action is running
+const (
+	ActionEventProgress = "progress"
+	ActionEventCommand  = "command"
+	ActionEventDone     = "done"
+)
+
+// ActionEvent describes a change in the state of a running action, such as
+// updated counters or the completion of a command. Events are published by
+// the scheduler and streamed by the API to clients following the action.
+type ActionEvent struct {
+	Type          string         `json:"type"`
+	ActionID      float64        `json:"actionid"`
+	Status        string         `json:"status,omitempty"`
+	Counters      ActionCounters `json:"counters"`
+	CommandID     float64        `json:"commandid,omitempty"`
+	CommandStatus string         `json:"commandstatus,omitempty"`
+	AgentName     string         `json:"agentname,omitempty"`
+	FoundAnything bool           `json:"foundanything,omitempty"`
+	Time          time.Time      `json:"time"`
 }
 
 // Description is a simple object that contains detail about the
@@ -90,6 +124,11 @@ type Operation struct {
 	// the parameter data.
 	IsCompressed   bool `json:"is_compressed,omitempty"`
 	WantCompressed bool `json:"want_compressed,omitempty"`
+
+	// If Container is set, the agent runs the operation inside the
+	// container it designates, by ID, unique ID prefix or name. Only
+	// modules that support containers accept it.
+	Container string `json:"container,omitempty"`
 }
 
 // CompressOperationParam compresses the parameters stored within an operation
@@ -235,8 +274,17 @@ func (a Action) Validate() (err error) {
 	if a.Target == "" {
 		return errors.New("action target is empty")
 	}
-	if a.SyntaxVersion != ActionVersion {
-		return fmt.Errorf("wring syntax version, expected %v", ActionVersion)
+	switch a.SyntaxVersion {
+	case ActionVersion:
+		if a.ID < 1 {
+			return errors.New("action id is not set by the signer")
+		}
+		if len(a.Nonce) < minNonceLength {
+			return fmt.Errorf("action nonce must be at least %d characters long", minNonceLength)
+		}
+	case ActionVersionLegacy:
+	default:
+		return fmt.Errorf("wrong syntax version, expected %v or %v", ActionVersion, ActionVersionLegacy)
 	}
 	if a.ValidFrom.String() == "" {
 		return errors.New("action validfrom is empty")
@@ -259,6 +307,39 @@ func (a Action) Validate() (err error) {
 	return
 }
 
+// minNonceLength is the minimum length of the nonce of version 3 actions
+const minNonceLength = 16
+
+// GenNonce returns a random hex string suitable as the nonce of an action
+func GenNonce() (nonce string, err error) {
+	buf := make([]byte, 16)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("GenNonce() -> %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// PrepareSigning sets the fields signers choose in version 3 actions: the
+// syntax version if it is not set, and the ID and the nonce if they are
+// missing. It must be called once, before the first signature is computed,
+// as all the signatures of an action cover the same ID and nonce.
+func (a *Action) PrepareSigning() (err error) {
+	if a.SyntaxVersion == 0 {
+		a.SyntaxVersion = ActionVersion
+	}
+	if a.SyntaxVersion != ActionVersion {
+		return
+	}
+	if a.ID < 1 {
+		a.ID = GenID()
+	}
+	if a.Nonce == "" {
+		a.Nonce, err = GenNonce()
+	}
+	return
+}
+
 // Sign computes and returns the GPG signature of a MIG action in its stringified form
 func (a Action) Sign(keyid string, secring io.Reader) (sig string, err error) {
 	defer func() {
@@ -326,8 +407,61 @@ func (a Action) VerifySignatures(keyring io.Reader) (err error) {
 	return
 }
 
-//  concatenates Action components into a string
+// String returns the payload signed by investigators. Version 3 actions are
+// encoded in canonical JSON, legacy actions concatenate some of their
+// components.
 func (a Action) String() (str string, err error) {
+	if a.SyntaxVersion >= ActionVersion {
+		return a.canonicalString()
+	}
+	return a.legacyString()
+}
+
+// canonicalString returns the canonical JSON encoding of the fields of a
+// version 3 action covered by signatures. Keys are sorted at every level and
+// numbers keep their textual representation, so that investigators, the API
+// and agents compute the same payload regardless of how the action was
+// decoded and stored. Times are given in seconds, as the database does not
+// keep their nanoseconds.
+func (a Action) canonicalString() (str string, err error) {
+	payload := map[string]interface{}{
+		"id":            a.ID,
+		"name":          a.Name,
+		"target":        a.Target,
+		"description":   a.Description,
+		"threat":        a.Threat,
+		"validfrom":     a.ValidFrom.UTC().Unix(),
+		"expireafter":   a.ExpireAfter.UTC().Unix(),
+		"operations":    a.Operations,
+		"nonce":         a.Nonce,
+		"syntaxversion": a.SyntaxVersion,
+	}
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	// structures are decoded into maps and encoded again, as maps are
+	// encoded with sorted keys while structures follow their field order.
+	// characters are not escaped for html, which is specific to go.
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	err = dec.Decode(&generic)
+	if err != nil {
+		return
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(generic)
+	if err != nil {
+		return
+	}
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
+
+// legacyString concatenates the components of a version 2 action
+func (a Action) legacyString() (str string, err error) {
 	args, err := json.Marshal(a.Operations)
 	if err != nil {
 		return
@@ -413,6 +547,9 @@ func (a Action) PrintCounters() {
 	if a.Counters.TimeOut > 0 {
 		out += fmt.Sprintf(", %d timed out", a.Counters.TimeOut)
 	}
+	if a.Counters.ResourceLimit > 0 {
+		out += fmt.Sprintf(", %d over resource limits", a.Counters.ResourceLimit)
+	}
 	fmt.Fprintf(os.Stderr, "%s\n", out)
 }
 
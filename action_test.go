@@ -8,7 +8,9 @@ package mig
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mozilla/mig/pgp"
 )
@@ -589,3 +591,133 @@ func TestVerifyNoKeyring(t *testing.T) {
 		t.Fatalf("VerifyACL should have failed")
 	}
 }
+
+func TestActionCanonicalString(t *testing.T) {
+	a := Action{
+		ID:            4477853254868993,
+		Name:          "find <things> & more",
+		Target:        "status='online'",
+		Description:   Description{Author: "Bob", Email: "bob@example.net", Revision: 2},
+		Threat:        Threat{Level: "high", Family: "malware"},
+		ValidFrom:     time.Date(2026, time.October, 18, 10, 0, 0, 123456789, time.UTC),
+		ExpireAfter:   time.Date(2026, time.October, 18, 11, 0, 0, 0, time.UTC),
+		SyntaxVersion: ActionVersion,
+		Nonce:         "0123456789abcdef0123456789abcdef",
+		Operations: []Operation{{Module: "file", Parameters: map[string]interface{}{
+			"searches": map[string]interface{}{"s1": map[string]interface{}{
+				"paths": []string{"/etc"}, "names": []string{"passwd"}, "maxdepth": 12}}}}},
+	}
+	str, err := a.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"description":{"author":"Bob","email":"bob@example.net","revision":2},` +
+		`"expireafter":1792321200,"id":4477853254868993,"name":"find <things> & more",` +
+		`"nonce":"0123456789abcdef0123456789abcdef","operations":[{"module":"file","parameters":` +
+		`{"searches":{"s1":{"maxdepth":12,"names":["passwd"],"paths":["/etc"]}}}}],` +
+		`"syntaxversion":3,"target":"status='online'","threat":{"family":"malware","level":"high"},` +
+		`"validfrom":1792317600}`
+	if str != expect {
+		t.Fatalf("unexpected canonical payload\n%s\nexpected\n%s", str, expect)
+	}
+
+	// the payload does not depend on how the action was decoded, or on the
+	// fields the platform sets once the action is received
+	buf, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a2 Action
+	err = json.Unmarshal(buf, &a2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2.ValidFrom = a2.ValidFrom.Truncate(time.Microsecond).In(time.FixedZone("CEST", 7200))
+	a2.Status = "inflight"
+	a2.Counters.Sent = 10
+	a2.PGPSignatures = []string{"sig"}
+	str2, err := a2.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str2 != str {
+		t.Fatalf("payload changed after decoding\n%s\n%s", str2, str)
+	}
+
+	// the nonce, the id and the intent of the investigator are signed
+	for _, change := range []func(*Action){
+		func(a *Action) { a.Nonce = "fedcba9876543210fedcba9876543210" },
+		func(a *Action) { a.ID++ },
+		func(a *Action) { a.Description.Author = "Mallory" },
+		func(a *Action) { a.Threat.Level = "low" },
+	} {
+		a3 := a
+		change(&a3)
+		str3, err := a3.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if str3 == str {
+			t.Errorf("change of the action did not change the signed payload %s", str3)
+		}
+	}
+
+	// legacy actions keep the payload they were signed with
+	a.SyntaxVersion = ActionVersionLegacy
+	str, err = a.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(str, "name=find <things> & more;target=status='online';validfrom=1792317600;expireafter=%!s(int64=1792321200);") {
+		t.Fatalf("unexpected legacy payload %s", str)
+	}
+}
+
+func TestActionValidateSyntaxVersion(t *testing.T) {
+	a := Action{
+		Name:          "find things",
+		Target:        "status='online'",
+		ValidFrom:     time.Now().Add(-time.Minute),
+		ExpireAfter:   time.Now().Add(time.Hour),
+		Operations:    []Operation{{Module: "file"}},
+		PGPSignatures: []string{"sig"},
+	}
+	err := a.Validate()
+	if err == nil {
+		t.Fatal("expected action without syntax version to be invalid")
+	}
+	err = a.PrepareSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SyntaxVersion != ActionVersion || a.ID < 1 || len(a.Nonce) != 32 {
+		t.Fatalf("signing fields were not set: %+v", a)
+	}
+	err = a.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	// further signers keep the id and the nonce
+	id, nonce := a.ID, a.Nonce
+	err = a.PrepareSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != id || a.Nonce != nonce {
+		t.Fatal("PrepareSigning changed the id or the nonce of the action")
+	}
+
+	a.Nonce = "short"
+	if a.Validate() == nil {
+		t.Fatal("expected action with a short nonce to be invalid")
+	}
+	a.Nonce = ""
+	if a.Validate() == nil {
+		t.Fatal("expected action without nonce to be invalid")
+	}
+	a.SyntaxVersion = ActionVersionLegacy
+	err = a.Validate()
+	if err != nil {
+		t.Fatalf("expected legacy action to be valid: %v", err)
+	}
+}
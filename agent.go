@@ -6,7 +6,12 @@
 
 package mig /* import "github.com/mozilla/mig" */
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Various agent status values
 const (
@@ -14,8 +19,41 @@ const (
 	AgtStatusDestroyed string = "destroyed"
 	AgtStatusOffline   string = "offline"
 	AgtStatusIdle      string = "idle"
+
+	// Lifecycle status values are set by investigators on all the agents of an
+	// endpoint, and are kept when the agents send heartbeats. Quarantined
+	// endpoints are excluded from targeting, and decommissioned endpoints from
+	// targeting and statistics. Decommissioned agents are archived by the
+	// scheduler once the retention period has passed.
+	AgtStatusQuarantined    string = "quarantined"
+	AgtStatusDecommissioned string = "decommissioned"
+	AgtStatusArchived       string = "archived"
 )
 
+// Lifecycle operations investigators can apply to an endpoint
+const (
+	AgtLifecycleQuarantine   string = "quarantine"
+	AgtLifecycleDecommission string = "decommission"
+	AgtLifecycleRelease      string = "release"
+)
+
+// AgentLifecycleTransition returns the status the agents of an endpoint are set
+// to by a lifecycle operation, and the statuses agents must be in for the
+// operation to apply to them. Released agents are set to idle, and return to
+// online with their next heartbeat.
+func AgentLifecycleTransition(op string) (to string, from []string, err error) {
+	switch op {
+	case AgtLifecycleQuarantine:
+		return AgtStatusQuarantined, []string{AgtStatusOnline, AgtStatusIdle, AgtStatusOffline}, nil
+	case AgtLifecycleDecommission:
+		return AgtStatusDecommissioned, []string{AgtStatusOnline, AgtStatusIdle, AgtStatusOffline,
+			AgtStatusDestroyed, AgtStatusQuarantined}, nil
+	case AgtLifecycleRelease:
+		return AgtStatusIdle, []string{AgtStatusQuarantined, AgtStatusDecommissioned}, nil
+	}
+	return "", nil, fmt.Errorf("invalid lifecycle operation %q", op)
+}
+
 // Agent stores the description of an agent and serves as a canvas
 // for heartbeat messages
 type Agent struct {
@@ -31,6 +69,7 @@ type Agent struct {
 	HeartBeatTS     time.Time         `json:"heartbeatts,omitempty"`
 	RefreshTS       time.Time         `json:"refreshts,omitempty"`
 	Status          string            `json:"status,omitempty"`
+	LifecycleTS     time.Time         `json:"lifecyclets,omitempty"`
 	Authorized      bool              `json:"authorized,omitempty"`
 	Env             AgentEnv          `json:"environment,omitempty"`
 	Tags            map[string]string `json:"tags,omitempty"`
@@ -38,18 +77,76 @@ type Agent struct {
 
 // AgentEnv stores basic information of the endpoint
 type AgentEnv struct {
-	Init      string      `json:"init,omitempty"`
-	Ident     string      `json:"ident,omitempty"`
-	OS        string      `json:"os,omitempty"`
-	Arch      string      `json:"arch,omitempty"`
-	IsProxied bool        `json:"isproxied"`
-	Proxy     string      `json:"proxy,omitempty"`
-	Addresses []string    `json:"addresses,omitempty"`
-	PublicIP  string      `json:"publicip,omitempty"`
-	AWS       AgentEnvAWS `json:"aws,omitempty"`
-	Modules   []string    `json:"modules,omitempty"`
+	Init       string           `json:"init,omitempty"`
+	Ident      string           `json:"ident,omitempty"`
+	OS         string           `json:"os,omitempty"`
+	Arch       string           `json:"arch,omitempty"`
+	IsProxied  bool             `json:"isproxied"`
+	Proxy      string           `json:"proxy,omitempty"`
+	Addresses  []string         `json:"addresses,omitempty"`
+	PublicIP   string           `json:"publicip,omitempty"`
+	PublicIPs  []AgentPublicIP  `json:"publicips,omitempty"`
+	AWS        AgentEnvAWS      `json:"aws,omitempty"`
+	Modules    []string         `json:"modules,omitempty"`
+	Facts      AgentFacts       `json:"facts,omitempty"`
+	Containers []AgentContainer `json:"containers,omitempty"`
 }
 
+// AgentContainer stores a container found on the endpoint. Pid is the pid, in
+// the namespace of the agent, of the init process of the container.
+type AgentContainer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Image   string `json:"image,omitempty"`
+	Runtime string `json:"runtime,omitempty"`
+	Pid     int    `json:"pid"`
+}
+
+// ContainerNames returns the name of each container of the environment, or
+// its ID if it has no name
+func (env AgentEnv) ContainerNames() (names []string) {
+	for _, c := range env.Containers {
+		if c.Name != "" {
+			names = append(names, c.Name)
+		} else {
+			names = append(names, c.ID)
+		}
+	}
+	return
+}
+
+// AgentPublicIP is a public address of the endpoint, as seen by the API when
+// the agent connects to it. Family is ipv4 or ipv6. Proxy is the proxy the
+// agent connected through, and is empty for direct connections. Source is the
+// way the API determined the address: peer for the address of the connection,
+// or x-forwarded-for for the header set by a reverse proxy in front of the API.
+type AgentPublicIP struct {
+	IP     string `json:"ip"`
+	Family string `json:"family"`
+	Proxy  string `json:"proxy,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// PublicIPList returns the distinct public addresses of the environment. The
+// address of agents that do not report a list is returned on its own.
+func (env AgentEnv) PublicIPList() (ips []string) {
+	seen := make(map[string]bool)
+	for _, pip := range env.PublicIPs {
+		if !seen[pip.IP] {
+			seen[pip.IP] = true
+			ips = append(ips, pip.IP)
+		}
+	}
+	if env.PublicIP != "" && !seen[env.PublicIP] {
+		ips = append(ips, env.PublicIP)
+	}
+	return
+}
+
+// AgentFacts stores the facts gathered by the agent collectors, indexed by
+// collector name and fact name, as in facts["kernel"]["release"]
+type AgentFacts map[string]map[string]string
+
 // AgentEnvAWS stores AWS specific agent environment values
 type AgentEnvAWS struct {
 	InstanceID   string `json:"instanceid,omitempty"`
@@ -61,17 +158,19 @@ type AgentEnvAWS struct {
 // AgentsStats stores information about the global MIG environment, primarily used
 // in command line tools and the API/scheduler
 type AgentsStats struct {
-	Timestamp             time.Time           `json:"timestamp"`
-	OnlineAgents          float64             `json:"onlineagents"`
-	OnlineAgentsByVersion []AgentsVersionsSum `json:"onlineagentsbyversion"`
-	OnlineEndpoints       float64             `json:"onlineendpoints"`
-	IdleAgents            float64             `json:"idleagents"`
-	IdleAgentsByVersion   []AgentsVersionsSum `json:"idleagentsbyversion"`
-	IdleEndpoints         float64             `json:"idleendpoints"`
-	NewEndpoints          float64             `json:"newendpoints"`
-	MultiAgentsEndpoints  float64             `json:"multiagentsendpoints"`
-	DisappearedEndpoints  float64             `json:"disappearedendpoints"`
-	FlappingEndpoints     float64             `json:"flappingendpoints"`
+	Timestamp               time.Time           `json:"timestamp"`
+	OnlineAgents            float64             `json:"onlineagents"`
+	OnlineAgentsByVersion   []AgentsVersionsSum `json:"onlineagentsbyversion"`
+	OnlineEndpoints         float64             `json:"onlineendpoints"`
+	IdleAgents              float64             `json:"idleagents"`
+	IdleAgentsByVersion     []AgentsVersionsSum `json:"idleagentsbyversion"`
+	IdleEndpoints           float64             `json:"idleendpoints"`
+	NewEndpoints            float64             `json:"newendpoints"`
+	MultiAgentsEndpoints    float64             `json:"multiagentsendpoints"`
+	DisappearedEndpoints    float64             `json:"disappearedendpoints"`
+	FlappingEndpoints       float64             `json:"flappingendpoints"`
+	QuarantinedEndpoints    float64             `json:"quarantinedendpoints"`
+	DecommissionedEndpoints float64             `json:"decommissionedendpoints"`
 }
 
 // AgentsVersionsSum stores information on the count of agents at a specific version
@@ -80,3 +179,127 @@ type AgentsVersionsSum struct {
 	Version string  `json:"version"`
 	Count   float64 `json:"count"`
 }
+
+// AgentFields are the fields agents can be projected on when they are exported.
+// Keys of the environment and of the tags are selected with the env. and tags.
+// prefixes, as in env.os, env.aws.instanceid or tags.operator. Facts are selected
+// by collector and name, as in env.facts.kernel.release. lastseen is the time of
+// the last heartbeat of the agent.
+var AgentFields = []string{"id", "name", "queueloc", "mode", "version", "pid",
+	"loadername", "starttime", "lastseen", "status"}
+
+// AgentEnvFields are the keys of the environment agents can be projected on
+var AgentEnvFields = []string{"init", "ident", "os", "arch", "isproxied", "proxy",
+	"addresses", "publicip", "publicips", "aws.instanceid", "aws.localipv4", "aws.amiid",
+	"aws.instancetype", "modules", "containers"}
+
+// DefaultAgentFields are the fields of agents exported when none are selected
+var DefaultAgentFields = []string{"id", "name", "queueloc", "status", "version", "env.os", "lastseen"}
+
+// ValidateAgentField returns an error if agents cannot be projected on field
+func ValidateAgentField(field string) error {
+	if strings.HasPrefix(field, "tags.") && len(field) > len("tags.") {
+		return nil
+	}
+	list := AgentFields
+	if strings.HasPrefix(field, "env.") {
+		list = AgentEnvFields
+		field = strings.TrimPrefix(field, "env.")
+		if strings.HasPrefix(field, "facts.") {
+			parts := strings.SplitN(strings.TrimPrefix(field, "facts."), ".", 2)
+			if len(parts) == 2 && parts[0] != "" && parts[1] != "" {
+				return nil
+			}
+		}
+	}
+	for _, f := range list {
+		if f == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown agent field %q", field)
+}
+
+// FieldValue returns the value of a field of the agent, formatted as a string.
+// Times are formatted in RFC3339, and lists are joined with commas. Unknown
+// fields and unset values return an empty string.
+func (agt Agent) FieldValue(field string) string {
+	if strings.HasPrefix(field, "tags.") {
+		return agt.Tags[strings.TrimPrefix(field, "tags.")]
+	}
+	if strings.HasPrefix(field, "env.") {
+		return agt.Env.fieldValue(strings.TrimPrefix(field, "env."))
+	}
+	switch field {
+	case "id":
+		return fmt.Sprintf("%.0f", agt.ID)
+	case "name":
+		return agt.Name
+	case "queueloc":
+		return agt.QueueLoc
+	case "mode":
+		return agt.Mode
+	case "version":
+		return agt.Version
+	case "pid":
+		return strconv.Itoa(agt.PID)
+	case "loadername":
+		return agt.LoaderName
+	case "starttime":
+		return formatFieldTime(agt.StartTime)
+	case "lastseen":
+		return formatFieldTime(agt.HeartBeatTS)
+	case "status":
+		return agt.Status
+	}
+	return ""
+}
+
+func (env AgentEnv) fieldValue(field string) string {
+	switch field {
+	case "init":
+		return env.Init
+	case "ident":
+		return env.Ident
+	case "os":
+		return env.OS
+	case "arch":
+		return env.Arch
+	case "isproxied":
+		return strconv.FormatBool(env.IsProxied)
+	case "proxy":
+		return env.Proxy
+	case "addresses":
+		return strings.Join(env.Addresses, ",")
+	case "publicip":
+		return env.PublicIP
+	case "publicips":
+		return strings.Join(env.PublicIPList(), ",")
+	case "aws.instanceid":
+		return env.AWS.InstanceID
+	case "aws.localipv4":
+		return env.AWS.LocalIPV4
+	case "aws.amiid":
+		return env.AWS.AMIID
+	case "aws.instancetype":
+		return env.AWS.InstanceType
+	case "modules":
+		return strings.Join(env.Modules, ",")
+	case "containers":
+		return strings.Join(env.ContainerNames(), ",")
+	}
+	if strings.HasPrefix(field, "facts.") {
+		parts := strings.SplitN(strings.TrimPrefix(field, "facts."), ".", 2)
+		if len(parts) == 2 {
+			return env.Facts[parts[0]][parts[1]]
+		}
+	}
+	return ""
+}
+
+func formatFieldTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgentFieldValue(t *testing.T) {
+	agt := Agent{ID: 1234, Name: "db1.example.net", QueueLoc: "linux.db1", PID: 42,
+		HeartBeatTS: time.Date(2017, 11, 2, 10, 0, 0, 0, time.UTC),
+		Env: AgentEnv{OS: "linux", Addresses: []string{"10.0.0.1/24", "fe80::1/64"},
+			PublicIP: "203.0.113.7",
+			PublicIPs: []AgentPublicIP{{IP: "203.0.113.7", Family: "ipv4"},
+				{IP: "2001:db8::7", Family: "ipv6"}, {IP: "203.0.113.7", Family: "ipv4", Proxy: "proxy:3128"}},
+			AWS:        AgentEnvAWS{InstanceID: "i-0123"},
+			Facts:      AgentFacts{"kernel": {"release": "4.15.0"}},
+			Containers: []AgentContainer{{ID: "4f3c2b1a", Name: "nginx"}, {ID: "9e8d7c6b"}}},
+		Tags: map[string]string{"operator": "IT"}}
+	testcases := []struct {
+		field, value string
+	}{
+		{"id", "1234"},
+		{"name", "db1.example.net"},
+		{"pid", "42"},
+		{"lastseen", "2017-11-02T10:00:00Z"},
+		{"starttime", ""},
+		{"env.os", "linux"},
+		{"env.addresses", "10.0.0.1/24,fe80::1/64"},
+		{"env.aws.instanceid", "i-0123"},
+		{"env.facts.kernel.release", "4.15.0"},
+		{"env.facts.gce.zone", ""},
+		{"env.containers", "nginx,9e8d7c6b"},
+		{"env.publicips", "203.0.113.7,2001:db8::7"},
+		{"tags.operator", "IT"},
+		{"tags.missing", ""},
+	}
+	for _, tc := range testcases {
+		if err := ValidateAgentField(tc.field); err != nil {
+			t.Errorf("field %s: %v", tc.field, err)
+		}
+		if v := agt.FieldValue(tc.field); v != tc.value {
+			t.Errorf("field %s: expected %q, got %q", tc.field, tc.value, v)
+		}
+	}
+	for _, field := range []string{"environment", "env.nosuchkey", "tags.", "heartbeatts", "env.facts.kernel", "env.facts..release"} {
+		if ValidateAgentField(field) == nil {
+			t.Errorf("expected field %q to be invalid", field)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig/pgp"
+)
+
+// AuthBundleModule is the name of the ACL entry that authorizes the signers
+// of an AuthBundle. If the ACL has no entry with that name, the default entry
+// is used, as for modules.
+const AuthBundleModule = "authbundle"
+
+// ACLPolicy defines the weights investigators are given for a module in the
+// ACLs rendered by the API, and the minimum weight an action needs to run
+// that module. The module can be "default", in which case the policy applies
+// to modules that have no policy of their own.
+type ACLPolicy struct {
+	Module        string            `json:"module"`
+	MinimumWeight int               `json:"minimumweight"`
+	Weights       []ACLPolicyWeight `json:"weights"`
+}
+
+// ACLPolicyWeight is the weight an investigator has in an ACLPolicy
+type ACLPolicyWeight struct {
+	InvestigatorID float64 `json:"investigatorid"`
+	Weight         int     `json:"weight"`
+}
+
+// Validate verifies that an ACL policy is well formed
+func (p ACLPolicy) Validate() error {
+	if p.Module == "" {
+		return fmt.Errorf("ACL policy has no module name")
+	}
+	if p.MinimumWeight < 1 {
+		return fmt.Errorf("ACL policy for %s must have a minimum weight greater than zero", p.Module)
+	}
+	seen := make(map[float64]bool)
+	for _, w := range p.Weights {
+		if w.Weight < 1 {
+			return fmt.Errorf("ACL policy for %s gives invalid weight %d to investigator %.0f",
+				p.Module, w.Weight, w.InvestigatorID)
+		}
+		if seen[w.InvestigatorID] {
+			return fmt.Errorf("ACL policy for %s lists investigator %.0f multiple times",
+				p.Module, w.InvestigatorID)
+		}
+		seen[w.InvestigatorID] = true
+	}
+	return nil
+}
+
+// AuthBundle contains the ACL and the keyring agents use to authorize actions.
+// The API renders it from the ACL policies and the keys of the investigators,
+// and investigators sign it before it is distributed to agents.
+type AuthBundle struct {
+	GeneratedAt time.Time `json:"generatedat"`
+	ACL         ACL       `json:"acl"`
+	PublicKeys  []string  `json:"publickeys"`
+	Signatures  []string  `json:"signatures"`
+}
+
+// AuthBundleState identifies the last bundle an agent applied. Agents keep it
+// across restarts to refuse bundles older than the one they use, so a bundle
+// that granted access to a revoked investigator cannot be installed again.
+type AuthBundleState struct {
+	GeneratedAt time.Time `json:"generatedat"`
+	Digest      string    `json:"digest"`
+}
+
+// NewAuthBundle renders an AuthBundle from ACL policies. investigators must
+// contain the investigators referenced by the policies, with their keys.
+// Investigators that are disabled or have no key valid at time now are left
+// out of the ACL.
+func NewAuthBundle(policies []ACLPolicy, investigators []Investigator, now time.Time) (b AuthBundle, err error) {
+	invs := make(map[float64]Investigator)
+	for _, inv := range investigators {
+		invs[inv.ID] = inv
+	}
+	b.GeneratedAt = now.UTC()
+	b.ACL = make(ACL)
+	b.PublicKeys = make([]string, 0)
+	b.Signatures = make([]string, 0)
+	seenKeys := make(map[string]bool)
+	for _, p := range policies {
+		err = p.Validate()
+		if err != nil {
+			return
+		}
+		var entry struct {
+			MinimumWeight int
+			Investigators map[string]struct {
+				Fingerprint string
+				Weight      int
+			}
+		}
+		entry.MinimumWeight = p.MinimumWeight
+		entry.Investigators = make(map[string]struct {
+			Fingerprint string
+			Weight      int
+		})
+		for _, w := range p.Weights {
+			inv, ok := invs[w.InvestigatorID]
+			if !ok {
+				err = fmt.Errorf("investigator %.0f of ACL policy %s not found", w.InvestigatorID, p.Module)
+				return
+			}
+			key, ok := inv.CurrentKey(now)
+			if !ok {
+				continue
+			}
+			// investigators are named after their ID, as names are not unique
+			name := fmt.Sprintf("%s (%.0f)", inv.Name, inv.ID)
+			signer := entry.Investigators[name]
+			signer.Fingerprint = strings.ToUpper(key.PGPFingerprint)
+			signer.Weight = w.Weight
+			entry.Investigators[name] = signer
+			if !seenKeys[signer.Fingerprint] {
+				if len(key.PublicKey) == 0 {
+					err = fmt.Errorf("public key %s of investigator %.0f is missing",
+						signer.Fingerprint, inv.ID)
+					return
+				}
+				seenKeys[signer.Fingerprint] = true
+				b.PublicKeys = append(b.PublicKeys, string(key.PublicKey))
+			}
+		}
+		b.ACL[p.Module] = entry
+	}
+	sort.Strings(b.PublicKeys)
+	return
+}
+
+// signedData returns the document that signatures of the bundle are made on,
+// which is the bundle without its signatures
+func (b AuthBundle) signedData() (string, error) {
+	b.Signatures = make([]string, 0)
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// State returns the state an agent records once it has applied the bundle. The
+// digest covers the signed content of the bundle, so adding signatures to a
+// bundle does not change its state.
+func (b AuthBundle) State() (st AuthBundleState, err error) {
+	data, err := b.signedData()
+	if err != nil {
+		return
+	}
+	st.GeneratedAt = b.GeneratedAt
+	st.Digest = fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+	return
+}
+
+// Sign signs the bundle with the key identified by keyid, and appends the
+// signature to the signatures of the bundle
+func (b *AuthBundle) Sign(keyid string, secring io.Reader) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("Sign() -> %v", e)
+		}
+	}()
+	data, err := b.signedData()
+	if err != nil {
+		panic(err)
+	}
+	sig, err := pgp.Sign(data, keyid, secring)
+	if err != nil {
+		panic(err)
+	}
+	b.Signatures = append(b.Signatures, sig)
+	return
+}
+
+// Validate verifies that the ACL and the keys of the bundle can be used by an agent
+func (b AuthBundle) Validate() error {
+	if len(b.ACL) == 0 {
+		return fmt.Errorf("bundle has no ACL")
+	}
+	for name, entry := range b.ACL {
+		if entry.MinimumWeight < 1 {
+			return fmt.Errorf("invalid ACL %v, weight must be > 0", name)
+		}
+	}
+	for i, key := range b.PublicKeys {
+		_, err := pgp.LoadArmoredPubKey([]byte(key))
+		if err != nil {
+			return fmt.Errorf("invalid key num.%d in bundle: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Verify verifies the signatures of the bundle against keyring, and checks
+// that the signers are authorized to distribute it by acl. The keyring and
+// the ACL are the ones trusted before the bundle is applied. last is the state
+// of the bundle applied before, if any: the bundle must be newer, or be that
+// same bundle, which agents load again when they restart.
+func (b AuthBundle) Verify(acl ACL, keyring io.Reader, last AuthBundleState) (err error) {
+	if len(b.Signatures) == 0 {
+		return fmt.Errorf("bundle is not signed")
+	}
+	st, err := b.State()
+	if err != nil {
+		return
+	}
+	if st.Digest != last.Digest && !st.GeneratedAt.After(last.GeneratedAt) {
+		return fmt.Errorf("bundle generated at %s is not newer than the bundle applied, generated at %s",
+			st.GeneratedAt.Format(time.RFC3339Nano), last.GeneratedAt.Format(time.RFC3339Nano))
+	}
+	data, err := b.signedData()
+	if err != nil {
+		return
+	}
+	keycopy, err := ioutil.ReadAll(keyring)
+	if err != nil {
+		return
+	}
+	var fingerprints []string
+	for _, sig := range b.Signatures {
+		fp, err := pgp.GetFingerprintFromSignature(data, sig, bytes.NewBuffer(keycopy))
+		if err != nil {
+			return fmt.Errorf("failed to verify bundle signature: %v", err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return verifyPermission(AuthBundleModule, acl, fingerprints)
+}
@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig/pgp"
+)
+
+func TestAuthBundle(t *testing.T) {
+	now := time.Now()
+	adminPub, adminPriv, adminFP, err := pgp.GenerateKeyPair("admin", "", "admin@example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invPub, _, invFP, err := pgp.GenerateKeyPair("bob", "", "bob@example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invs := []Investigator{
+		{ID: 1, Name: "admin", Status: StatusActiveInvestigator, PGPFingerprint: adminFP,
+			Keys: []InvestigatorKey{{PGPFingerprint: adminFP, PublicKey: adminPub,
+				Status: StatusActiveKey, ValidFrom: now.Add(-time.Hour)}}},
+		{ID: 2, Name: "bob", Status: StatusActiveInvestigator, PGPFingerprint: invFP,
+			Keys: []InvestigatorKey{{PGPFingerprint: invFP, PublicKey: invPub,
+				Status: StatusActiveKey, ValidFrom: now.Add(-time.Hour)}}},
+		{ID: 3, Name: "gone", Status: StatusDisabledInvestigator},
+	}
+	policies := []ACLPolicy{
+		{Module: "default", MinimumWeight: 2, Weights: []ACLPolicyWeight{
+			{InvestigatorID: 1, Weight: 2}, {InvestigatorID: 2, Weight: 2}, {InvestigatorID: 3, Weight: 2}}},
+		{Module: AuthBundleModule, MinimumWeight: 1, Weights: []ACLPolicyWeight{
+			{InvestigatorID: 1, Weight: 1}}},
+	}
+	b, err := NewAuthBundle(policies, invs, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.PublicKeys) != 2 || len(b.ACL["default"].Investigators) != 2 ||
+		len(b.ACL[AuthBundleModule].Investigators) != 1 {
+		t.Fatalf("unexpected bundle %+v", b)
+	}
+	err = b.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bundle is verified against the ACL and keyring trusted by the agent
+	var trustedACL ACL
+	buf, _ := json.Marshal(b.ACL)
+	err = json.Unmarshal(buf, &trustedACL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, _, err := pgp.ArmoredKeysToKeyring([][]byte{adminPub, invPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Verify(trustedACL, keyring, AuthBundleState{})
+	if err == nil {
+		t.Fatal("expected unsigned bundle to be rejected")
+	}
+	secring, _, err := pgp.ArmoredKeysToKeyring([][]byte{adminPriv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Sign(adminFP, secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring.Seek(0, 0)
+	err = b.Verify(trustedACL, keyring, AuthBundleState{})
+	if err != nil {
+		t.Fatalf("expected signed bundle to be accepted: %v", err)
+	}
+
+	// the bundle is accepted again once applied, but not if an agent has
+	// applied a newer one, or another bundle generated at the same time
+	st, err := b.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		last   AuthBundleState
+		accept bool
+	}{
+		{st, true},
+		{AuthBundleState{GeneratedAt: b.GeneratedAt.Add(-time.Second), Digest: "older"}, true},
+		{AuthBundleState{GeneratedAt: b.GeneratedAt, Digest: "other"}, false},
+		{AuthBundleState{GeneratedAt: b.GeneratedAt.Add(time.Second), Digest: "newer"}, false},
+	}
+	for i, tc := range tests {
+		keyring.Seek(0, 0)
+		err = b.Verify(trustedACL, keyring, tc.last)
+		if tc.accept && err != nil {
+			t.Errorf("test %d: expected bundle to be accepted: %v", i, err)
+		}
+		if !tc.accept && err == nil {
+			t.Errorf("test %d: expected bundle to be rejected as a rollback", i)
+		}
+	}
+	// adding a signature does not change the state of the bundle
+	signed := b
+	signed.Signatures = append([]string{}, b.Signatures...)
+	signed.Signatures = append(signed.Signatures, b.Signatures[0])
+	if sst, _ := signed.State(); sst != st {
+		t.Errorf("expected the state of the bundle not to depend on its signatures")
+	}
+
+	// a signer that is not in the authbundle ACL entry is not authorized
+	delete(trustedACL[AuthBundleModule].Investigators, "admin (1)")
+	keyring.Seek(0, 0)
+	err = b.Verify(trustedACL, keyring, AuthBundleState{})
+	if err == nil {
+		t.Fatal("expected bundle signed by an unauthorized key to be rejected")
+	}
+
+	// a modified bundle no longer matches its signature
+	b.GeneratedAt = b.GeneratedAt.Add(time.Second)
+	keyring.Seek(0, 0)
+	err = b.Verify(b.ACL, keyring, AuthBundleState{})
+	if err == nil {
+		t.Fatal("expected modified bundle to be rejected")
+	}
+}
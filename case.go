@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Possible status values for a case
+const (
+	CaseStatusOpen          string = "open"
+	CaseStatusInvestigating string = "investigating"
+	CaseStatusClosed        string = "closed"
+)
+
+// Case tracks an investigation: the investigators assigned to it, the notes
+// they took, the actions that were run for it and the agents it is about.
+type Case struct {
+	ID           float64        `json:"id"`
+	Title        string         `json:"title"`
+	Status       string         `json:"status"`
+	CreatedBy    float64        `json:"createdby"`
+	CreatedAt    time.Time      `json:"createdat"`
+	LastModified time.Time      `json:"lastmodified"`
+	Assignees    []CaseAssignee `json:"assignees,omitempty"`
+	Notes        []CaseNote     `json:"notes,omitempty"`
+	ActionIDs    []float64      `json:"actionids,omitempty"`
+	Agents       []CaseAgent    `json:"agents,omitempty"`
+}
+
+// CaseAssignee is an investigator assigned to a case
+type CaseAssignee struct {
+	InvestigatorID float64 `json:"investigatorid"`
+	Name           string  `json:"name"`
+}
+
+// CaseNote is a free-form note an investigator recorded on a case
+type CaseNote struct {
+	ID             float64   `json:"id"`
+	InvestigatorID float64   `json:"investigatorid"`
+	Author         string    `json:"author"`
+	Text           string    `json:"text"`
+	CreatedAt      time.Time `json:"createdat"`
+}
+
+// CaseAgent is an agent of interest in a case. Agents are recorded by name
+// and queue location, so that they remain attached to the case when the
+// agent restarts or is archived.
+type CaseAgent struct {
+	AgentID  float64   `json:"agentid"`
+	Name     string    `json:"name"`
+	QueueLoc string    `json:"queueloc"`
+	AddedAt  time.Time `json:"addedat"`
+}
+
+// Validate returns an error if the case has no title or an unknown status
+func (c Case) Validate() error {
+	if strings.TrimSpace(c.Title) == "" {
+		return fmt.Errorf("case title must be set")
+	}
+	return ValidateCaseStatus(c.Status)
+}
+
+// ValidateCaseStatus returns an error if status is not a known case status
+func ValidateCaseStatus(status string) error {
+	switch status {
+	case CaseStatusOpen, CaseStatusInvestigating, CaseStatusClosed:
+		return nil
+	}
+	return fmt.Errorf("invalid case status %q, must be one of %s, %s or %s", status,
+		CaseStatusOpen, CaseStatusInvestigating, CaseStatusClosed)
+}
+
+// HasAction returns true if action aid is attached to the case
+func (c Case) HasAction(aid float64) bool {
+	for _, id := range c.ActionIDs {
+		if id == aid {
+			return true
+		}
+	}
+	return false
+}
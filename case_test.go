@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig
+
+import "testing"
+
+func TestCaseValidate(t *testing.T) {
+	testcases := []struct {
+		c     Case
+		valid bool
+	}{
+		{Case{Title: "compromised bastion", Status: CaseStatusOpen}, true},
+		{Case{Title: "compromised bastion", Status: CaseStatusInvestigating}, true},
+		{Case{Title: "compromised bastion", Status: CaseStatusClosed}, true},
+		{Case{Title: "compromised bastion", Status: "resolved"}, false},
+		{Case{Title: "compromised bastion"}, false},
+		{Case{Title: "  ", Status: CaseStatusOpen}, false},
+	}
+	for i, tc := range testcases {
+		err := tc.c.Validate()
+		if (err == nil) != tc.valid {
+			t.Errorf("case %d: expected valid=%t, got %v", i, tc.valid, err)
+		}
+	}
+}
+
+func TestCasePermission(t *testing.T) {
+	var ip InvestigatorPerms
+	ip.DefaultSet()
+	if ip.ToMask()&PermCase == 0 {
+		t.Fatal("expected the default permission set to include cases")
+	}
+	var back InvestigatorPerms
+	back.FromMask(PermCase)
+	inv := Investigator{Permissions: back}
+	if !inv.CheckPermission(PermCase) || inv.CheckPermission(PermWebhook) {
+		t.Fatal("expected the case permission to round trip through its mask")
+	}
+}
@@ -845,7 +845,10 @@ func (cli Client) PostAction(a mig.Action) (a2 mig.Action, err error) {
 			err = fmt.Errorf("PostAction() -> %v", e)
 		}
 	}()
-	a.SyntaxVersion = mig.ActionVersion
+	if a.SyntaxVersion == 0 {
+		// actions signed without a syntax version predate version 3
+		a.SyntaxVersion = mig.ActionVersionLegacy
+	}
 	// serialize
 	ajson, err := json.Marshal(a)
 	if err != nil {
@@ -1002,6 +1005,95 @@ func (cli Client) GetAgent(agtid float64) (agt mig.Agent, err error) {
 	return
 }
 
+// ExportAgents streams the agents that match a search query string, in the
+// format of the search API, to w. fields selects the fields of the agents that
+// are exported, and format is either "ndjson" or "csv". The default fields of
+// the API are used if fields is empty.
+func (cli Client) ExportAgents(query string, fields []string, format string, w io.Writer) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("ExportAgents() -> %v", e)
+		}
+	}()
+	target := "agent/export?" + query
+	if len(fields) > 0 {
+		target += "&fields=" + url.QueryEscape(strings.Join(fields, ","))
+	}
+	if format != "" {
+		target += "&format=" + url.QueryEscape(format)
+	}
+	r, err := http.NewRequest("GET", cli.Conf.API.URL+target, nil)
+	if err != nil {
+		panic(err)
+	}
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var resource *cljs.Resource
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			panic(err)
+		}
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(fmt.Sprintf("error: HTTP %d. Agents export failed", resp.StatusCode))
+		}
+		panic(fmt.Sprintf("error: HTTP %d. Agents export failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code))
+	}
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostAgentLifecycle applies a lifecycle operation (quarantine, decommission or release)
+// to the endpoint of an agent and returns the updated agent
+func (cli Client) PostAgentLifecycle(agtid float64, operation string) (agt mig.Agent, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostAgentLifecycle() -> %v", e)
+		}
+	}()
+	data := url.Values{"agentid": {fmt.Sprintf("%.0f", agtid)}, "operation": {operation}}
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+"agent/lifecycle/",
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	var resource *cljs.Resource
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("error: HTTP %d. Agent %s failed with error '%v' (code %s)",
+			resp.StatusCode, operation, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	agt, err = ValueToAgent(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
 // ValueToAgent converts JSON data in interface v into a mig.Agent
 func ValueToAgent(v interface{}) (agt mig.Agent, err error) {
 	defer func() {
@@ -1240,6 +1332,237 @@ func (cli Client) PostInvestigatorAPIKeyStatus(iid float64, newstatus string) (i
 	return
 }
 
+// PostInvestigatorKeyRotation replaces the PGP key of an investigator with pubkey. The
+// previous keys of the investigator remain valid for the duration of overlap. If expireafter
+// is not zero, the new key stops being valid at that date.
+func (cli Client) PostInvestigatorKeyRotation(iid float64, pubkey []byte, overlap time.Duration,
+	expireafter time.Time) (key mig.InvestigatorKey, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostInvestigatorKeyRotation() -> %v", e)
+		}
+	}()
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	err = writer.WriteField("id", fmt.Sprintf("%.0f", iid))
+	if err != nil {
+		panic(err)
+	}
+	err = writer.WriteField("overlap", overlap.String())
+	if err != nil {
+		panic(err)
+	}
+	if !expireafter.IsZero() {
+		err = writer.WriteField("expireafter", expireafter.UTC().Format(time.RFC3339))
+		if err != nil {
+			panic(err)
+		}
+	}
+	part, err := writer.CreateFormFile("publickey", fmt.Sprintf("%.0f.asc", iid))
+	if err != nil {
+		panic(err)
+	}
+	_, err = io.Copy(part, bytes.NewReader(pubkey))
+	if err != nil {
+		panic(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		panic(err)
+	}
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+"investigator/key/rotate/", buf)
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	var resource *cljs.Resource
+	err = json.Unmarshal(body, &resource)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		err = fmt.Errorf("HTTP %d: %v (code %s)", resp.StatusCode,
+			resource.Collection.Error.Message, resource.Collection.Error.Code)
+		return
+	}
+	bData, err := json.Marshal(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(bData, &key)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostInvestigatorKeyRevocation revokes the key of an investigator that has fingerprint fp
+func (cli Client) PostInvestigatorKeyRevocation(iid float64, fp string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostInvestigatorKeyRevocation() -> %v", e)
+		}
+	}()
+	data := url.Values{"id": {fmt.Sprintf("%.0f", iid)}, "pgpfingerprint": {fp}}
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+"investigator/key/revoke/", strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	var resource *cljs.Resource
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("error: HTTP %d. key revocation failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	return
+}
+
+// GetACLPolicies retrieves the ACL policies agent ACLs are rendered from
+func (cli Client) GetACLPolicies() (policies []mig.ACLPolicy, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetACLPolicies() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource("acl/policy")
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "aclpolicy" {
+				continue
+			}
+			bData, err := json.Marshal(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			var p mig.ACLPolicy
+			err = json.Unmarshal(bData, &p)
+			if err != nil {
+				panic(err)
+			}
+			policies = append(policies, p)
+		}
+	}
+	return
+}
+
+// PostACLPolicy creates or replaces the ACL policy of a module
+func (cli Client) PostACLPolicy(p mig.ACLPolicy) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostACLPolicy() -> %v", e)
+		}
+	}()
+	err = p.Validate()
+	if err != nil {
+		panic(err)
+	}
+	pj, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	data := url.Values{"policy": {string(pj)}}
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+"acl/policy/", strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	var resource *cljs.Resource
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("error: HTTP %d. ACL policy update failed with error '%v' (code %s)",
+			resp.StatusCode, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	return
+}
+
+// GetAuthBundle retrieves an unsigned AuthBundle rendered by the API from the
+// ACL policies and the current keys of investigators
+func (cli Client) GetAuthBundle() (b mig.AuthBundle, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetAuthBundle() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource("acl/bundle")
+	if err != nil {
+		panic(err)
+	}
+	if len(resource.Collection.Items) == 0 || resource.Collection.Items[0].Data[0].Name != "authbundle" {
+		panic("API returned something that is not an authbundle... something's wrong.")
+	}
+	bData, err := json.Marshal(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(bData, &b)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// SignAuthBundle signs an AuthBundle with the key of the investigator
+func (cli Client) SignAuthBundle(b *mig.AuthBundle) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("SignAuthBundle() -> %v", e)
+		}
+	}()
+	secring, err := os.Open(cli.Conf.GPG.Home + "/secring.gpg")
+	if err != nil {
+		panic(err)
+	}
+	defer secring.Close()
+	err = b.Sign(cli.Conf.GPG.KeyID, secring)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
 // ValueToInvestigator converts JSON data in interface v into a mig.Investigator
 func ValueToInvestigator(v interface{}) (inv mig.Investigator, err error) {
 	defer func() {
@@ -1258,6 +1581,166 @@ func ValueToInvestigator(v interface{}) (inv mig.Investigator, err error) {
 	return
 }
 
+// GetCase retrieves a case with its assignees, notes, actions and agents
+func (cli Client) GetCase(caseid float64) (c mig.Case, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetCase() -> %v", e)
+		}
+	}()
+	resource, err := cli.GetAPIResource(fmt.Sprintf("case?caseid=%.0f", caseid))
+	if err != nil {
+		panic(err)
+	}
+	if len(resource.Collection.Items) == 0 || resource.Collection.Items[0].Data[0].Name != "case" {
+		panic("API returned something that is not a case... something's wrong.")
+	}
+	c, err = ValueToCase(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// GetCases retrieves the last modified cases, most recent first. If status is
+// set, only cases with this status are returned.
+func (cli Client) GetCases(status string, limit int) (cases []mig.Case, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("GetCases() -> %v", e)
+		}
+	}()
+	target := fmt.Sprintf("case?limit=%d", limit)
+	if status != "" {
+		target += "&status=" + url.QueryEscape(status)
+	}
+	resource, err := cli.GetAPIResource(target)
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "case" {
+				continue
+			}
+			c, err := ValueToCase(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			cases = append(cases, c)
+		}
+	}
+	return
+}
+
+// PostCase creates a case and returns it as stored by the API. The title,
+// status and assignees of c are used, other fields are set by the API.
+func (cli Client) PostCase(c mig.Case) (c2 mig.Case, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("PostCase() -> %v", e)
+		}
+	}()
+	cj, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	c2, err = cli.postCase("case/create/", url.Values{"case": {string(cj)}}, http.StatusCreated)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// PostCaseUpdate changes the title, status or assignees of a case. Only the
+// fields set in data are changed, using the parameters of the case/update/
+// endpoint: title, status, and assignees as a comma separated list of
+// investigator IDs.
+func (cli Client) PostCaseUpdate(caseid float64, data url.Values) (c mig.Case, err error) {
+	data.Set("caseid", fmt.Sprintf("%.0f", caseid))
+	return cli.postCase("case/update/", data, http.StatusOK)
+}
+
+// PostCaseNote records a note on a case
+func (cli Client) PostCaseNote(caseid float64, note string) (c mig.Case, err error) {
+	data := url.Values{"caseid": {fmt.Sprintf("%.0f", caseid)}, "note": {note}}
+	return cli.postCase("case/note/", data, http.StatusOK)
+}
+
+// PostCaseAction attaches an action to a case, or detaches it if operation is remove
+func (cli Client) PostCaseAction(caseid, actionid float64, operation string) (c mig.Case, err error) {
+	data := url.Values{"caseid": {fmt.Sprintf("%.0f", caseid)},
+		"actionid": {fmt.Sprintf("%.0f", actionid)}, "operation": {operation}}
+	return cli.postCase("case/action/", data, http.StatusOK)
+}
+
+// PostCaseAgent records an agent of interest in a case, or removes it if
+// operation is remove
+func (cli Client) PostCaseAgent(caseid, agentid float64, operation string) (c mig.Case, err error) {
+	data := url.Values{"caseid": {fmt.Sprintf("%.0f", caseid)},
+		"agentid": {fmt.Sprintf("%.0f", agentid)}, "operation": {operation}}
+	return cli.postCase("case/agent/", data, http.StatusOK)
+}
+
+// postCase posts data to a case endpoint of the API and returns the case in
+// the response
+func (cli Client) postCase(endpoint string, data url.Values, expect int) (c mig.Case, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("postCase() -> %v", e)
+		}
+	}()
+	r, err := http.NewRequest("POST", cli.Conf.API.URL+endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	var resource *cljs.Resource
+	if len(body) > 1 {
+		err = json.Unmarshal(body, &resource)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if resp.StatusCode != expect {
+		err = fmt.Errorf("error: HTTP %d. %s failed with error '%v' (code %s)",
+			resp.StatusCode, endpoint, resource.Collection.Error.Message, resource.Collection.Error.Code)
+		panic(err)
+	}
+	c, err = ValueToCase(resource.Collection.Items[0].Data[0].Value)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// ValueToCase converts JSON data in interface v into a mig.Case
+func ValueToCase(v interface{}) (c mig.Case, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("valueToCase() -> %v", e)
+		}
+	}()
+	bData, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(bData, &c)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
 // MakeSignedToken encrypts a timestamp and a random number with the users GPG key
 // to use as an auth token with the API
 func (cli Client) MakeSignedToken() (token string, err error) {
@@ -1321,6 +1804,19 @@ func (cli Client) SignAction(a mig.Action) (signedAction mig.Action, err error)
 		panic(err)
 	}
 	defer secring.Close()
+	// the first signer signs the action with the current syntax, and gives
+	// it a new ID and nonce, as the ones of an action reloaded from a file or
+	// the API were already used. Further signers keep them, as all the
+	// signatures of an action cover the same payload.
+	if len(a.PGPSignatures) == 0 {
+		a.SyntaxVersion = mig.ActionVersion
+		a.ID = 0
+		a.Nonce = ""
+	}
+	err = a.PrepareSigning()
+	if err != nil {
+		panic(err)
+	}
 	sig, err := a.Sign(cli.Conf.GPG.KeyID, secring)
 	if err != nil {
 		panic(err)
@@ -1388,8 +1884,73 @@ func (cli Client) EvaluateAgentTarget(target string) (agents []mig.Agent, err er
 	return
 }
 
-// FollowAction continuously loops over an action and prints its completion status in os.Stderr.
-// When the action reaches its expiration date, FollowAction prints its final status and returns.
+// ActionStream is a stream of events emitted by the API while an action runs,
+// as returned by OpenActionStream
+type ActionStream struct {
+	resp   *http.Response
+	reader *bufio.Reader
+}
+
+// OpenActionStream subscribes to the events of action aid on the API. An error
+// is returned if the API does not support streaming, in which case the caller
+// should fall back to polling the action with GetAction.
+func (cli Client) OpenActionStream(aid float64) (stream *ActionStream, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("OpenActionStream() -> %v", e)
+		}
+	}()
+	r, err := http.NewRequest("GET", fmt.Sprintf("%saction/stream?actionid=%.0f", cli.Conf.API.URL, aid), nil)
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Accept", "text/event-stream")
+	resp, err := cli.Do(r)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		panic(fmt.Sprintf("error: HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	stream = &ActionStream{resp: resp, reader: bufio.NewReader(resp.Body)}
+	return
+}
+
+// Next blocks until the next event is received on the stream and returns it.
+// io.EOF is returned when the API closes the stream.
+func (s *ActionStream) Next() (ev mig.ActionEvent, err error) {
+	var data []byte
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err != nil {
+			return ev, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			// an empty line terminates an event, comments and
+			// keepalives have no data and are skipped
+			if len(data) == 0 {
+				continue
+			}
+			err = json.Unmarshal(data, &ev)
+			return ev, err
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimSpace(line[5:])...)
+		}
+	}
+}
+
+// Close terminates the stream
+func (s *ActionStream) Close() error {
+	return s.resp.Body.Close()
+}
+
+// FollowAction follows an action and prints its completion status in os.Stderr.
+// When the action completes or reaches its expiration date, FollowAction prints
+// its final status and returns. The progress is received from the API action
+// stream when available, and by polling the action otherwise.
 //
 // a represents the action being followed, and total indicates the total number of agents
 // the action was submitted to and is used to initialize the progress meter.
@@ -1413,12 +1974,44 @@ func (cli Client) FollowAction(a mig.Action, total int, stop chan bool) (err err
 	bar.SetMaxWidth(80)
 	bar.Output = os.Stderr
 	bar.Start()
+	stream, serr := cli.OpenActionStream(a.ID)
 	go func() {
 		_ = <-stop
 		bar.Postfix(" [cancelling]")
 		cancelfollow = true
 		bar.Finish()
+		if serr == nil {
+			stream.Close()
+		}
 	}()
+	if serr == nil {
+		for {
+			ev, err := stream.Next()
+			if err != nil {
+				if cancelfollow {
+					return nil
+				}
+				// the stream was interrupted, keep following the
+				// action by polling it
+				break
+			}
+			switch ev.Type {
+			case mig.ActionEventCommand:
+				previousctr++
+				bar.Increment()
+			case mig.ActionEventProgress, mig.ActionEventDone:
+				if ev.Counters.Done > previousctr {
+					bar.Add(ev.Counters.Done - previousctr)
+					previousctr = ev.Counters.Done
+				}
+			}
+			bar.Update()
+			if ev.Type == mig.ActionEventDone {
+				goto finish
+			}
+		}
+		stream.Close()
+	}
 	for {
 		a, _, err = cli.GetAction(a.ID)
 		if err != nil {
@@ -1439,7 +2032,6 @@ func (cli Client) FollowAction(a mig.Action, total int, stop chan bool) (err err
 			(a.Counters.Done > 0 && a.Counters.Done >= a.Counters.Sent) ||
 			(time.Now().After(a.ExpireAfter.Add(10 * time.Second))) {
 			goto finish
-			break
 		}
 		if cancelfollow {
 			// We have been asked to stop, just return
@@ -1456,7 +2048,9 @@ func (cli Client) FollowAction(a mig.Action, total int, stop chan bool) (err err
 		time.Sleep(2 * time.Second)
 	}
 finish:
-	bar.Add(total - previousctr)
+	if total > previousctr {
+		bar.Add(total - previousctr)
+	}
 	bar.Update()
 	bar.Finish()
 	a, _, err = cli.GetAction(a.ID)
@@ -1598,7 +2192,7 @@ func (cli Client) PrintActionResults(a mig.Action, show string) (err error) {
 	if show != "all" {
 		var unsuccessful map[string][]string
 		unsuccessful = make(map[string][]string)
-		for _, status := range []string{mig.StatusCancelled, mig.StatusExpired, mig.StatusFailed, mig.StatusTimeout} {
+		for _, status := range []string{mig.StatusCancelled, mig.StatusExpired, mig.StatusFailed, mig.StatusTimeout, mig.StatusResourceLimit} {
 			offset = 0
 			for {
 				// print commands that have not returned successfully
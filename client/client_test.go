@@ -0,0 +1,161 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package client /* import "github.com/mozilla/mig/client" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// testClient returns a client of the api served by srv
+func testClient(srv *httptest.Server) Client {
+	var cli Client
+	cli.API = srv.Client()
+	cli.Conf.API.URL = srv.URL + "/api/v1/"
+	cli.Conf.GPG.UseAPIKeyAuth = "testkey"
+	return cli
+}
+
+// writeEvent writes an action event in the server-sent events format
+func writeEvent(w http.ResponseWriter, ev mig.ActionEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	w.(http.Flusher).Flush()
+}
+
+func TestActionStreamNext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/action/stream" || r.URL.Query().Get("actionid") != "12" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Accept") != "text/event-stream" || r.Header.Get("X-MIGAPIKEY") != "testkey" {
+			http.Error(w, "bad request headers", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keepalive\n\n")
+		writeEvent(w, mig.ActionEvent{Type: mig.ActionEventProgress, ActionID: 12, Status: "inflight",
+			Counters: mig.ActionCounters{Sent: 3, Done: 1}})
+		// data split over several lines, with windows line endings
+		fmt.Fprint(w, "event: command\r\ndata: {\"type\": \"command\",\r\ndata: \"actionid\": 12, \"commandid\": 7}\r\n\r\n")
+		writeEvent(w, mig.ActionEvent{Type: mig.ActionEventDone, ActionID: 12, Status: "completed",
+			Counters: mig.ActionCounters{Sent: 3, Done: 3, Success: 3}})
+	}))
+	defer srv.Close()
+
+	stream, err := testClient(srv).OpenActionStream(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	ev, err := stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != mig.ActionEventProgress || ev.Status != "inflight" || ev.Counters.Done != 1 {
+		t.Errorf("unexpected first event %+v", ev)
+	}
+	ev, err = stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != mig.ActionEventCommand || ev.CommandID != 7 {
+		t.Errorf("unexpected command event %+v", ev)
+	}
+	ev, err = stream.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != mig.ActionEventDone || ev.Status != "completed" || ev.Counters.Success != 3 {
+		t.Errorf("unexpected done event %+v", ev)
+	}
+	if _, err = stream.Next(); err != io.EOF {
+		t.Errorf("expected the end of the stream, got %v", err)
+	}
+
+	if _, err = testClient(srv).OpenActionStream(13); err == nil {
+		t.Error("expected opening the stream of an unknown action to fail")
+	}
+}
+
+// testActionAPI serves the action endpoint of the api, and the stream
+// endpoint with the given handler. It returns the number of times the
+// action was retrieved.
+func testActionAPI(t *testing.T, a mig.Action, stream http.HandlerFunc) (*httptest.Server, func() int) {
+	var (
+		lock sync.Mutex
+		gets int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/action", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		gets++
+		lock.Unlock()
+		resource := cljs.New(r.URL.String())
+		err := resource.AddItem(cljs.Item{
+			Href: r.URL.String(),
+			Data: []cljs.Data{{Name: "action", Value: a}},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(resource)
+	})
+	mux.HandleFunc("/api/v1/action/stream", stream)
+	return httptest.NewServer(mux), func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return gets
+	}
+}
+
+func TestFollowAction(t *testing.T) {
+	a := mig.Action{ID: 12, Name: "test", Status: "completed", StartTime: time.Now().Add(-time.Minute),
+		ExpireAfter: time.Now().Add(time.Hour), Counters: mig.ActionCounters{Sent: 2, Done: 2, Success: 2}}
+
+	// progress is received from the stream, the action is only retrieved
+	// to print its final counters
+	srv, gets := testActionAPI(t, a, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeEvent(w, mig.ActionEvent{Type: mig.ActionEventProgress, ActionID: 12, Status: "inflight",
+			Counters: mig.ActionCounters{Sent: 2}})
+		writeEvent(w, mig.ActionEvent{Type: mig.ActionEventCommand, ActionID: 12, CommandID: 1})
+		writeEvent(w, mig.ActionEvent{Type: mig.ActionEventDone, ActionID: 12, Status: "completed",
+			Counters: a.Counters})
+	})
+	err := testClient(srv).FollowAction(a, 2, make(chan bool))
+	srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets() != 1 {
+		t.Errorf("expected the action to be retrieved once, got %d", gets())
+	}
+
+	// without streaming, the action is polled until it completes
+	srv, gets = testActionAPI(t, a, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Action streaming is not available", http.StatusServiceUnavailable)
+	})
+	defer srv.Close()
+	err = testClient(srv).FollowAction(a, 2, make(chan bool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gets() != 2 {
+		t.Errorf("expected the action to be polled, then retrieved for its counters, got %d requests", gets())
+	}
+}
@@ -0,0 +1,280 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/client"
+	migdbsearch "github.com/mozilla/mig/database/search"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `%s - Regenerate agent ACLs with the current keys of investigators
+
+Usage: %s [-V] [-c path] -a acl.cfg [-o output]
+       %s [-c path] -bundle [-o output]
+       %s [-c path] -signbundle authbundle.json [-o output]
+       %s [-c path] -policies
+       %s [-c path] -setpolicy policy.json
+
+With -a, reads an agent ACL file, and replaces the fingerprint of each investigator it
+references with the fingerprint of the investigator's current key, as known by
+the MIG API. Investigators are found by any of their past or present keys, or
+by name if the fingerprint is unknown to the API.
+
+Entries of investigators that are disabled, or that no longer have a valid key,
+are removed from the ACL. Entries that cannot be matched to an investigator
+are left unchanged. The changes are listed on stderr, and the new ACL is written
+to stdout unless -o is set.
+
+With -bundle, retrieves the ACL and keyring rendered by the API from the ACL
+policies, signs it with the key of the investigator, and writes it to stdout
+unless -o is set. -signbundle adds the signature of the investigator to an
+existing bundle, when more than one signature is required by the agents.
+-policies prints the ACL policies, and -setpolicy creates or replaces the ACL
+policy of a module from a JSON file.
+
+Command line flags:
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	homedir, err := client.FindHomedir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	var (
+		config      = flag.String("c", homedir+"/.migrc", "Load configuration from file")
+		showversion = flag.Bool("V", false, "Show build version and exit")
+		aclfile     = flag.String("a", "", "ACL file to regenerate, such as /etc/mig/acl.cfg")
+		outfile     = flag.String("o", "", "Write the new ACL or bundle to this file instead of stdout")
+		getbundle   = flag.Bool("bundle", false, "Retrieve and sign an ACL and keyring bundle")
+		signbundle  = flag.String("signbundle", "", "Add a signature to an existing bundle")
+		policies    = flag.Bool("policies", false, "Print the ACL policies")
+		setpolicy   = flag.String("setpolicy", "", "Create or replace an ACL policy from a JSON file")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	errex := func(s string, optarg ...interface{}) {
+		buf := fmt.Sprintf(s, optarg...)
+		fmt.Fprintf(os.Stderr, "error: %v\n", buf)
+		os.Exit(1)
+	}
+
+	if *showversion {
+		fmt.Println(mig.Version)
+		os.Exit(0)
+	}
+	if *aclfile == "" && !*getbundle && *signbundle == "" && !*policies && *setpolicy == "" {
+		errex("must specify an ACL file with -a, or one of -bundle, -signbundle, -policies or -setpolicy, see help")
+	}
+
+	conf, err := client.ReadConfiguration(*config)
+	if err != nil {
+		errex("%v", err)
+	}
+	conf, err = client.ReadEnvConfiguration(conf)
+	if err != nil {
+		errex("%v", err)
+	}
+	cli, err := client.NewClient(conf, "acl-gen-"+mig.Version)
+	if err != nil {
+		errex("%v", err)
+	}
+
+	var out []byte
+	switch {
+	case *policies:
+		pols, err := cli.GetACLPolicies()
+		if err != nil {
+			errex("%v", err)
+		}
+		out, err = json.MarshalIndent(pols, "", "\t")
+		if err != nil {
+			errex("%v", err)
+		}
+	case *setpolicy != "":
+		buf, err := ioutil.ReadFile(*setpolicy)
+		if err != nil {
+			errex("%v", err)
+		}
+		var p mig.ACLPolicy
+		err = json.Unmarshal(buf, &p)
+		if err != nil {
+			errex("parsing policy: %v", err)
+		}
+		err = cli.PostACLPolicy(p)
+		if err != nil {
+			errex("%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "ACL policy of module %s updated\n", p.Module)
+		os.Exit(0)
+	case *getbundle || *signbundle != "":
+		var b mig.AuthBundle
+		if *getbundle {
+			b, err = cli.GetAuthBundle()
+			if err != nil {
+				errex("%v", err)
+			}
+		} else {
+			buf, err := ioutil.ReadFile(*signbundle)
+			if err != nil {
+				errex("%v", err)
+			}
+			err = json.Unmarshal(buf, &b)
+			if err != nil {
+				errex("parsing bundle: %v", err)
+			}
+		}
+		err = b.Validate()
+		if err != nil {
+			errex("%v", err)
+		}
+		err = cli.SignAuthBundle(&b)
+		if err != nil {
+			errex("%v", err)
+		}
+		fmt.Fprintf(os.Stderr, "bundle generated at %s signed, %d signature(s)\n",
+			b.GeneratedAt.Format(time.RFC3339), len(b.Signatures))
+		out, err = json.MarshalIndent(b, "", "\t")
+		if err != nil {
+			errex("%v", err)
+		}
+	default:
+		buf, err := ioutil.ReadFile(*aclfile)
+		if err != nil {
+			errex("%v", err)
+		}
+		var acl mig.ACL
+		err = json.Unmarshal(buf, &acl)
+		if err != nil {
+			errex("parsing ACL: %v", err)
+		}
+		invs, err := getInvestigators(cli)
+		if err != nil {
+			errex("%v", err)
+		}
+		for _, change := range updateACL(acl, invs, time.Now()) {
+			fmt.Fprintln(os.Stderr, change)
+		}
+		out, err = json.MarshalIndent(acl, "", "\t")
+		if err != nil {
+			errex("%v", err)
+		}
+	}
+	out = append(out, '\n')
+	if *outfile != "" {
+		err = ioutil.WriteFile(*outfile, out, 0644)
+		if err != nil {
+			errex("%v", err)
+		}
+	} else {
+		os.Stdout.Write(out)
+	}
+	os.Exit(0)
+}
+
+// getInvestigators retrieves all the investigators from the API, along with
+// the list of their keys
+func getInvestigators(cli client.Client) (invs []mig.Investigator, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("getInvestigators() -> %v", e)
+		}
+	}()
+	p := migdbsearch.NewParameters()
+	p.Type = "investigator"
+	p.After = time.Unix(0, 0).UTC()
+	p.Limit = 10000
+	resource, err := cli.GetAPIResource("search?" + p.String())
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "investigator" {
+				continue
+			}
+			inv, err := client.ValueToInvestigator(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			// the search results do not include keys, fetch them
+			inv, err = cli.GetInvestigator(inv.ID)
+			if err != nil {
+				panic(err)
+			}
+			invs = append(invs, inv)
+		}
+	}
+	return
+}
+
+// updateACL replaces the fingerprints of the investigators in acl with the
+// ones of their current keys, and returns a description of the changes made
+func updateACL(acl mig.ACL, invs []mig.Investigator, now time.Time) (changes []string) {
+	byFingerprint := make(map[string]mig.Investigator)
+	byName := make(map[string][]mig.Investigator)
+	for _, inv := range invs {
+		for _, key := range inv.Keys {
+			byFingerprint[strings.ToUpper(key.PGPFingerprint)] = inv
+		}
+		byName[inv.Name] = append(byName[inv.Name], inv)
+	}
+	var aclnames []string
+	for aclname := range acl {
+		aclnames = append(aclnames, aclname)
+	}
+	sort.Strings(aclnames)
+	for _, aclname := range aclnames {
+		entry := acl[aclname]
+		var names []string
+		for name := range entry.Investigators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			signer := entry.Investigators[name]
+			inv, ok := byFingerprint[strings.ToUpper(signer.Fingerprint)]
+			if !ok {
+				if len(byName[name]) != 1 {
+					changes = append(changes, fmt.Sprintf("%s: %q with key %s is unknown, left unchanged",
+						aclname, name, signer.Fingerprint))
+					continue
+				}
+				inv = byName[name][0]
+			}
+			key, ok := inv.CurrentKey(now)
+			if !ok {
+				delete(entry.Investigators, name)
+				changes = append(changes, fmt.Sprintf("%s: removed %q, investigator %.0f is disabled or has no valid key",
+					aclname, name, inv.ID))
+				continue
+			}
+			fp := strings.ToUpper(key.PGPFingerprint)
+			if fp != strings.ToUpper(signer.Fingerprint) {
+				changes = append(changes, fmt.Sprintf("%s: %q key changed from %s to %s",
+					aclname, name, signer.Fingerprint, fp))
+				signer.Fingerprint = fp
+				entry.Investigators[name] = signer
+			}
+		}
+	}
+	return
+}
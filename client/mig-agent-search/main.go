@@ -12,6 +12,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -25,10 +26,17 @@ import (
 func usage() {
 	fmt.Fprintf(os.Stderr, `%s <query> - Search for MIG Agents
 
-Usage: %s [-V] [-c path] -p "console style query" | -t "target style query"
+Usage: %s [-V] [-c path] [-o format] [-f fields] -p "console style query" | -t "target style query"
 
 The -p or -t flag must be specified to run a search.
 
+The -o flag exports the matching agents in "ndjson" or "csv" format instead of
+printing them. Exports are streamed by the API and are not limited to 100
+agents, unless a limit is set in the query. Use -f to select the exported
+fields as a comma separated list, among id, name, queueloc, mode, version, pid,
+loadername, starttime, lastseen, status, env.<key> (such as env.os, env.arch,
+env.publicip or env.aws.instanceid) and tags.<key> (such as tags.operator).
+
 The -V flag can be used to display MIG version.
 
 Use -c to specify an alternate path to .migrc (by default, $HOME/.migrc)
@@ -48,6 +56,9 @@ All online agents:
 All agents regardless of status:
   $ mig-agent-search -p "status=%%"
 
+Inventory of all online agents in CSV:
+  $ mig-agent-search -o csv -f name,queueloc,env.os,tags.operator,lastseen -p "status=online"
+
 See the output of "search help" in mig-console for additional information on
 how to format these queries.
 
@@ -133,6 +144,8 @@ func main() {
 		showversion  = flag.Bool("V", false, "Show build version and exit")
 		paramSearch  = flag.String("p", "", "Search using mig-console search style query")
 		targetSearch = flag.String("t", "", "Search using agent targeting string")
+		exportFormat = flag.String("o", "", "Export agents in ndjson or csv format")
+		exportFields = flag.String("f", "", "Comma separated list of fields to export with -o")
 	)
 	flag.Usage = usage
 	flag.Parse()
@@ -162,6 +175,37 @@ func main() {
 		errex(err.Error())
 	}
 
+	if *exportFields != "" && *exportFormat == "" {
+		errex("-f can only be used with -o")
+	}
+	if *exportFormat != "" {
+		var query string
+		if *paramSearch != "" {
+			p, err := parseSearchQuery(*paramSearch)
+			if err != nil {
+				errex("parsing search query: %v", err.Error())
+			}
+			// exports are not limited unless a limit is set in the query
+			if !strings.Contains(*paramSearch, "limit=") {
+				p.Limit = -1
+			}
+			query = p.String()
+		} else if *targetSearch != "" {
+			query = "type=agent&target=" + url.QueryEscape(cli.ResolveTargetMacro(*targetSearch))
+		} else {
+			errex("must specify -p or -t, see help")
+		}
+		var fields []string
+		if *exportFields != "" {
+			fields = strings.Split(*exportFields, ",")
+		}
+		err = cli.ExportAgents(query, fields, *exportFormat, os.Stdout)
+		if err != nil {
+			errex("%v", err)
+		}
+		os.Exit(0)
+	}
+
 	if *paramSearch != "" {
 		// Search using mig-console style keywords
 		p, err := parseSearchQuery(*paramSearch)
@@ -466,9 +466,9 @@ func followAction(a mig.Action, cli client.Client) (err error) {
 	}
 finish:
 	fmt.Printf("leaving follower mode after %s\n", a.LastUpdateTime.Sub(a.StartTime).String())
-	fmt.Printf("%d sent, %d done: %d returned, %d cancelled, %d expired, %d failed, %d timed out, %d still in flight\n",
+	fmt.Printf("%d sent, %d done: %d returned, %d cancelled, %d expired, %d failed, %d timed out, %d over resource limits, %d still in flight\n",
 		a.Counters.Sent, a.Counters.Done, a.Counters.Done, a.Counters.Cancelled, a.Counters.Expired,
-		a.Counters.Failed, a.Counters.TimeOut, a.Counters.InFlight)
+		a.Counters.Failed, a.Counters.TimeOut, a.Counters.ResourceLimit, a.Counters.InFlight)
 	return
 }
 
@@ -285,9 +285,9 @@ Times          valid from %s until %s
 	}
 	fmt.Printf("\n")
 	fmt.Printf("Counters       sent=%d; done=%d; in flight=%d\n"+
-		"               success=%d; cancelled=%d; expired=%d; failed=%d; timeout=%d\n",
+		"               success=%d; cancelled=%d; expired=%d; failed=%d; timeout=%d; resourcelimit=%d\n",
 		a.Counters.Sent, a.Counters.Done, a.Counters.InFlight, a.Counters.Success,
-		a.Counters.Cancelled, a.Counters.Expired, a.Counters.Failed, a.Counters.TimeOut)
+		a.Counters.Cancelled, a.Counters.Expired, a.Counters.Failed, a.Counters.TimeOut, a.Counters.ResourceLimit)
 	return
 }
 
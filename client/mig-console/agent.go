@@ -44,7 +44,8 @@ func agentReader(input string, cli client.Client) (err error) {
 	prompt := fmt.Sprintf("\x1b[34;1magent %d>\x1b[0m ", uint64(agtid)%1000)
 	for {
 		// completion
-		var symbols = []string{"details", "exit", "help", "json", "pretty", "r", "lastactions"}
+		var symbols = []string{"decommission", "details", "exit", "help", "json", "pretty",
+			"quarantine", "r", "release", "lastactions"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -88,21 +89,39 @@ platform   %s %s
 pid        %d
 starttime  %s
 status     %s
+lifecycle  %s
 environment %s
 tags %s
 `, agt.ID, agt.Name, time.Now().Sub(agt.HeartBeatTS).String(), agt.Version, agt.Mode, agt.QueueLoc,
-				agt.Env.OS, agt.Env.Arch, agt.PID, agt.StartTime, agt.Status, jEnv, jTags)
+				agt.Env.OS, agt.Env.Arch, agt.PID, agt.StartTime, agt.Status, agt.LifecycleTS, jEnv, jTags)
+		case "decommission", "quarantine", "release":
+			confirm, err := readline.String(fmt.Sprintf("%s endpoint '%s'? (y/n)> ", orders[0], agt.QueueLoc))
+			if err != nil {
+				panic(err)
+			}
+			if confirm != "y" {
+				fmt.Println("aborted")
+				break
+			}
+			agt, err = cli.PostAgentLifecycle(agtid, orders[0])
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Endpoint '%s' is now %s\n", agt.QueueLoc, agt.Status)
 		case "exit":
 			fmt.Printf("exit\n")
 			goto exit
 		case "help":
 			fmt.Printf(`The following orders are available:
+decommission		retire the endpoint of this agent, excluding it from targeting and stats
 details			print the details of the agent
 exit			exit this mode
 help			show this help
 json <pretty>		show the json of the agent registration
 r			refresh the agent (get latest version from upstream)
 lastactions <limit>	print the last actions that ran on the agent. limit=10 by default.
+quarantine		exclude the endpoint of this agent from targeting until released
+release			return a quarantined or decommissioned endpoint to service
 `)
 		case "lastactions":
 			limit := 10
@@ -0,0 +1,362 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobappleyard/readline"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/client"
+)
+
+// caseReader retrieves a case from the api and enters prompt mode to
+// review and update it
+func caseReader(input string, cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("caseReader() -> %v", e)
+		}
+	}()
+	inputArr := strings.Split(input, " ")
+	if len(inputArr) < 2 {
+		panic("wrong order format. must be 'case <caseid>'")
+	}
+	caseid, err := strconv.ParseFloat(inputArr[1], 64)
+	if err != nil {
+		panic(err)
+	}
+	c, err := cli.GetCase(caseid)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Entering case mode. Type \x1b[32;1mexit\x1b[0m or press \x1b[32;1mctrl+d\x1b[0m to leave. \x1b[32;1mhelp\x1b[0m may help.")
+	fmt.Printf("Case %.0f '%s' is %s\n", c.ID, c.Title, c.Status)
+	prompt := fmt.Sprintf("\x1b[33;1mcase %.0f>\x1b[0m ", caseid)
+	for {
+		var symbols = []string{"actions", "addagent", "addaction", "agents", "assign", "details",
+			"exit", "help", "note", "notes", "r", "rmaction", "rmagent", "setstatus", "settitle", "timeline"}
+		readline.Completer = func(query, ctx string) []string {
+			var res []string
+			for _, sym := range symbols {
+				if strings.HasPrefix(sym, query) {
+					res = append(res, sym)
+				}
+			}
+			return res
+		}
+
+		input, err := readline.String(prompt)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("error: ", err)
+			break
+		}
+		orders := strings.Split(strings.TrimSpace(input), " ")
+		switch orders[0] {
+		case "actions":
+			err = printCaseActions(c, cli)
+			if err != nil {
+				panic(err)
+			}
+		case "addaction", "rmaction":
+			if len(orders) != 2 {
+				fmt.Printf("error: must be '%s <actionid>'. try 'help'\n", orders[0])
+				break
+			}
+			aid, err := strconv.ParseFloat(orders[1], 64)
+			if err != nil {
+				panic(err)
+			}
+			operation := "add"
+			if orders[0] == "rmaction" {
+				operation = "remove"
+			}
+			c, err = cli.PostCaseAction(caseid, aid, operation)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("case has %d actions\n", len(c.ActionIDs))
+		case "addagent", "rmagent":
+			if len(orders) != 2 {
+				fmt.Printf("error: must be '%s <agentid>'. try 'help'\n", orders[0])
+				break
+			}
+			agtid, err := strconv.ParseFloat(orders[1], 64)
+			if err != nil {
+				panic(err)
+			}
+			operation := "add"
+			if orders[0] == "rmagent" {
+				operation = "remove"
+			}
+			c, err = cli.PostCaseAgent(caseid, agtid, operation)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("case has %d agents of interest\n", len(c.Agents))
+		case "agents":
+			for _, agt := range c.Agents {
+				fmt.Printf("%.0f %s (%s) added %s\n", agt.AgentID, agt.Name, agt.QueueLoc,
+					agt.AddedAt.Format(time.RFC3339))
+			}
+		case "assign":
+			// an empty list unassigns everyone
+			c, err = cli.PostCaseUpdate(caseid, url.Values{"assignees": {strings.Join(orders[1:], ",")}})
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Case assigned to", caseAssignees(c))
+		case "details":
+			fmt.Printf("Case ID %.0f\n"+
+				"title          %s\n"+
+				"status         %s\n"+
+				"assignees      %s\n"+
+				"created        %s by investigator %.0f\n"+
+				"modified       %s\n"+
+				"notes          %d\n"+
+				"actions        %d\n"+
+				"agents         %d\n",
+				c.ID, c.Title, c.Status, caseAssignees(c), c.CreatedAt.Format(time.RFC3339), c.CreatedBy,
+				c.LastModified.Format(time.RFC3339), len(c.Notes), len(c.ActionIDs), len(c.Agents))
+		case "exit":
+			fmt.Printf("exit\n")
+			goto exit
+		case "help":
+			fmt.Printf(`The following orders are available:
+actions			  list the actions attached to the case
+addaction <id>		  attach action <id> to the case
+addagent <id>		  record agent <id> as an agent of interest in the case
+agents			  list the agents of interest
+assign <id> [<id>...]	  assign the case to investigators, no arguments to unassign everyone
+details			  print the details of the case
+exit			  exit this mode
+help			  show this help
+note <text>		  record a note on the case
+notes			  print the notes of the case
+r			  refresh the case (get latest version from upstream)
+rmaction <id>		  detach action <id> from the case
+rmagent <id>		  remove agent <id> from the agents of interest
+setstatus <status>	  change the status of the case to open, investigating or closed
+settitle <title>	  change the title of the case
+timeline		  print the notes and actions of the case in chronological order
+`)
+		case "note":
+			if len(orders) < 2 {
+				fmt.Println("error: must be 'note <text>'. try 'help'")
+				break
+			}
+			c, err = cli.PostCaseNote(caseid, strings.Join(orders[1:], " "))
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Note recorded")
+		case "notes":
+			for _, n := range c.Notes {
+				fmt.Printf("%s %s: %s\n", n.CreatedAt.Format(time.RFC3339), n.Author, n.Text)
+			}
+		case "r":
+			c, err = cli.GetCase(caseid)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Reload succeeded")
+		case "setstatus":
+			if len(orders) != 2 {
+				fmt.Println("error: must be 'setstatus <status>'. try 'help'")
+				break
+			}
+			c, err = cli.PostCaseUpdate(caseid, url.Values{"status": {orders[1]}})
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Case status set to", c.Status)
+		case "settitle":
+			if len(orders) < 2 {
+				fmt.Println("error: must be 'settitle <title>'. try 'help'")
+				break
+			}
+			c, err = cli.PostCaseUpdate(caseid, url.Values{"title": {strings.Join(orders[1:], " ")}})
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Case title set to", c.Title)
+		case "timeline":
+			err = printCaseTimeline(c, cli)
+			if err != nil {
+				panic(err)
+			}
+		case "":
+			break
+		default:
+			fmt.Printf("Unknown order '%s'. You are in case mode. Try `help`.\n", orders[0])
+		}
+		readline.AddHistory(input)
+	}
+exit:
+	fmt.Printf("\n")
+	return
+}
+
+// caseAssignees returns the names of the investigators assigned to a case
+func caseAssignees(c mig.Case) string {
+	if len(c.Assignees) == 0 {
+		return "nobody"
+	}
+	var names []string
+	for _, a := range c.Assignees {
+		names = append(names, fmt.Sprintf("%s (%.0f)", a.Name, a.InvestigatorID))
+	}
+	return strings.Join(names, ", ")
+}
+
+// caseActions retrieves the actions attached to a case
+func caseActions(c mig.Case, cli client.Client) (actions []mig.Action, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("caseActions() -> %v", e)
+		}
+	}()
+	if len(c.ActionIDs) == 0 {
+		return
+	}
+	target := fmt.Sprintf("search?type=action&caseid=%.0f&limit=%d", c.ID, len(c.ActionIDs))
+	resource, err := cli.GetAPIResource(target)
+	if err != nil {
+		panic(err)
+	}
+	for _, item := range resource.Collection.Items {
+		for _, data := range item.Data {
+			if data.Name != "action" {
+				continue
+			}
+			a, err := client.ValueToAction(data.Value)
+			if err != nil {
+				panic(err)
+			}
+			actions = append(actions, a)
+		}
+	}
+	return
+}
+
+func printCaseActions(c mig.Case, cli client.Client) (err error) {
+	actions, err := caseActions(c, cli)
+	if err != nil {
+		return
+	}
+	fmt.Printf("----- ID ----- + --------    Action Name ------- + ----    Date    ---- + -- Status -- + -- Found --\n")
+	for _, a := range actions {
+		name := a.Name
+		if len(name) < 30 {
+			name += strings.Repeat(" ", 30-len(name))
+		}
+		if len(name) > 30 {
+			name = name[0:27] + "..."
+		}
+		fmt.Printf("%.0f     %s   %s    %-12s   %d/%d\n", a.ID, name,
+			a.StartTime.Format(time.RFC3339), a.Status, a.Counters.Success, a.Counters.Done)
+	}
+	return
+}
+
+// printCaseTimeline prints the notes and actions of a case in chronological
+// order, to review the history of an investigation
+func printCaseTimeline(c mig.Case, cli client.Client) (err error) {
+	actions, err := caseActions(c, cli)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		ts   time.Time
+		desc string
+	}
+	entries := []entry{{c.CreatedAt, fmt.Sprintf("case opened: %s", c.Title)}}
+	for _, n := range c.Notes {
+		entries = append(entries, entry{n.CreatedAt, fmt.Sprintf("note by %s: %s", n.Author, n.Text)})
+	}
+	for _, a := range actions {
+		entries = append(entries, entry{a.StartTime, fmt.Sprintf("action %.0f '%s' %s, %d/%d commands succeeded",
+			a.ID, a.Name, a.Status, a.Counters.Success, a.Counters.Done)})
+	}
+	for _, agt := range c.Agents {
+		entries = append(entries, entry{agt.AddedAt, fmt.Sprintf("agent of interest %s (%s)", agt.Name, agt.QueueLoc)})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ts.Before(entries[j].ts)
+	})
+	for _, e := range entries {
+		fmt.Printf("%s  %s\n", e.ts.Format(time.RFC3339), e.desc)
+	}
+	return
+}
+
+// caseCreator prompts the user for the title of a new case and calls the API
+// to create it
+func caseCreator(cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("caseCreator() -> %v", e)
+		}
+	}()
+	var c mig.Case
+	fmt.Println("Entering case creation mode.\nPlease provide the title of the new case")
+	c.Title, err = readline.String("title> ")
+	if err != nil {
+		panic(err)
+	}
+	c.Status = mig.CaseStatusOpen
+	err = c.Validate()
+	if err != nil {
+		panic(err)
+	}
+	input, err := readline.String("create case? (y/n)> ")
+	if err != nil {
+		panic(err)
+	}
+	if input != "y" {
+		fmt.Println("abort")
+		return
+	}
+	c, err = cli.PostCase(c)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Case %.0f created. Use 'case %.0f' to attach actions and record notes.\n", c.ID, c.ID)
+	return
+}
+
+// printCases lists the last modified cases, optionally filtered by status
+func printCases(input string, cli client.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("printCases() -> %v", e)
+		}
+	}()
+	status := ""
+	orders := strings.Split(strings.TrimSpace(input), " ")
+	if len(orders) > 1 {
+		status = orders[1]
+	}
+	cases, err := cli.GetCases(status, 100)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("----- ID ----- + ---- Status ---- + --- Last Modified --- + ---- Title ----")
+	for _, c := range cases {
+		fmt.Printf("%.0f   %-16s   %s   %s\n", c.ID, c.Status, c.LastModified.Format(time.RFC3339), c.Title)
+	}
+	return
+}
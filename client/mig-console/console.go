@@ -16,6 +16,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bobappleyard/readline"
 	"github.com/mozilla/mig"
@@ -86,7 +87,7 @@ func main() {
 		cli.EnableDebug()
 	}
 	// print platform status
-	err = printStatus(cli)
+	err = printStatus(cli, "")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -95,7 +96,7 @@ func main() {
 		// completion
 		var symbols = []string{"action", "agent", "create", "command", "help", "history",
 			"exit", "manifest", "showcfg", "status", "investigator", "search", "query",
-			"where", "and", "loader"}
+			"where", "and", "loader", "case", "cases"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -136,6 +137,8 @@ func main() {
 				case "action":
 					var a mig.Action
 					err = actionLauncher(a, cli)
+				case "case":
+					err = caseCreator(cli)
 				case "investigator":
 					err = investigatorCreator(cli)
 				case "loader":
@@ -149,7 +152,17 @@ func main() {
 					log.Println(err)
 				}
 			} else {
-				fmt.Println("error: missing order, must be 'create <action|investigator>'")
+				fmt.Println("error: missing order, must be 'create <action|case|investigator|loader|manifest>'")
+			}
+		case "case":
+			err = caseReader(input, cli)
+			if err != nil {
+				log.Println(err)
+			}
+		case "cases":
+			err = printCases(input, cli)
+			if err != nil {
+				log.Println(err)
 			}
 		case "command":
 			err = commandReader(input, cli)
@@ -163,7 +176,10 @@ func main() {
 			fmt.Printf(`The following orders are available:
 action <id>		enter interactive action reader mode for action <id>
 agent <id>		enter interactive agent reader mode for agent <id>
+case <id>		enter case mode to review and update case <id>
+cases <status>		list the last modified cases, optionally only those with <status>
 create action		create a new action
+create case		create a new case, will prompt for its title
 create investigator	create a new investigator, will prompt for name and public key
 create loader           create a new loader entry
 create manifest         create a new manifest
@@ -176,7 +192,8 @@ manifest <id>           enter manifest management mode for manifest <id>
 query <uri>		send a raw query string, without the base url, to the api
 search <search>		perform a search. see "search help" for more information.
 showcfg			display running configuration
-status			display platform status: connected agents, latest actions, ...
+status <window>		display platform status: connected agents, latest actions, ...
+			with their history over <window>, such as 24h. 168h by default.
 `)
 		case "history":
 			var count int64 = 10
@@ -233,7 +250,11 @@ status			display platform status: connected agents, latest actions, ...
 			fmt.Printf("homedir = %s\n[api]\n    url = %s\n[gpg]\n    home = %s\n    keyid = %s\n",
 				cli.Conf.API.URL, cli.Conf.Homedir, cli.Conf.GPG.Home, cli.Conf.GPG.KeyID)
 		case "status":
-			err = printStatus(cli)
+			window := ""
+			if len(orders) > 1 {
+				window = orders[1]
+			}
+			err = printStatus(cli, window)
 			if err != nil {
 				log.Println(err)
 			}
@@ -252,21 +273,30 @@ exit:
 	}
 }
 
-func printStatus(cli client.Client) (err error) {
+func printStatus(cli client.Client, window string) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = fmt.Errorf("printStatus() -> %v", e)
 		}
 	}()
-	st, err := cli.GetAPIResource("dashboard")
+	target := "dashboard"
+	if window != "" {
+		_, err = time.ParseDuration(window)
+		if err != nil {
+			panic(fmt.Sprintf("invalid window %q, must be a duration such as 24h", window))
+		}
+		target += "?window=" + window
+	}
+	st, err := cli.GetAPIResource(target)
 	if err != nil {
 		panic(err)
 	}
+	var hist dashboardHistory
 	var onlineagt, idleagt []string
 	actout := make([]string, 2)
 	actout[0] = "Latest Actions:"
 	actout[1] = "----  ID  ---- + ----         Name         ---- + -Sent- + ----    Date    ---- + ---- Investigators ----"
-	var onlineagents, onlineendpoints, idleagents, idleendpoints, newendpoints, doubleagents, disappearedendpoints, flappingendpoints float64
+	var onlineagents, onlineendpoints, idleagents, idleendpoints, newendpoints, doubleagents, disappearedendpoints, flappingendpoints, quarantinedendpoints, decommissionedendpoints float64
 	for _, item := range st.Collection.Items {
 		for _, data := range item.Data {
 			switch data.Name {
@@ -293,6 +323,10 @@ func printStatus(cli client.Client) (err error) {
 				disappearedendpoints = data.Value.(float64)
 			case "flapping endpoints":
 				flappingendpoints = data.Value.(float64)
+			case "quarantined endpoints":
+				quarantinedendpoints = data.Value.(float64)
+			case "decommissioned endpoints":
+				decommissionedendpoints = data.Value.(float64)
 			case "online agents by version":
 				bData, err := json.Marshal(data.Value)
 				if err != nil {
@@ -310,6 +344,12 @@ func printStatus(cli client.Client) (err error) {
 					}
 					onlineagt = append(onlineagt, s)
 				}
+			case "agents stats series", "agents breakdown", "action throughput", "slowest modules",
+				"duplicate agents decisions":
+				err = hist.add(data.Name, data.Value)
+				if err != nil {
+					panic(err)
+				}
 			case "idle agents by version":
 				bData, err := json.Marshal(data.Value)
 				if err != nil {
@@ -337,9 +377,11 @@ func printStatus(cli client.Client) (err error) {
 		"\x1b[31;1m|\x1b[0m * %.0f endpoints are running 2 or more agents\n"+
 		"\x1b[31;1m|\x1b[0m * %.0f endpoints appeared over the last 7 days\n"+
 		"\x1b[31;1m|\x1b[0m * %.0f endpoints disappeared over the last 7 days\n"+
-		"\x1b[31;1m|\x1b[0m * %.0f endpoints have been flapping\n",
+		"\x1b[31;1m|\x1b[0m * %.0f endpoints have been flapping\n"+
+		"\x1b[31;1m|\x1b[0m * %.0f endpoints are quarantined\n"+
+		"\x1b[31;1m|\x1b[0m * %.0f endpoints are decommissioned\n",
 		onlineagents, onlineendpoints, idleagents, idleendpoints, doubleagents, newendpoints,
-		disappearedendpoints, flappingendpoints)
+		disappearedendpoints, flappingendpoints, quarantinedendpoints, decommissionedendpoints)
 	fmt.Println("\x1b[31;1m| Online agents by version:\x1b[0m")
 	for _, s := range onlineagt {
 		fmt.Println("\x1b[31;1m|\x1b[0m " + s)
@@ -348,6 +390,7 @@ func printStatus(cli client.Client) (err error) {
 	for _, s := range idleagt {
 		fmt.Println("\x1b[31;1m|\x1b[0m " + s)
 	}
+	hist.print()
 	fmt.Println("\x1b[31;1m|\x1b[0m")
 	for _, s := range actout {
 		fmt.Println("\x1b[31;1m|\x1b[0m " + s)
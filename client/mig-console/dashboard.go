@@ -0,0 +1,149 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mozilla/mig"
+)
+
+// dashboardHistory holds the history of the platform returned by the dashboard
+type dashboardHistory struct {
+	stats      []mig.AgentsStats
+	breakdown  []mig.AgentsBreakdown
+	throughput []mig.ActionThroughput
+	slowest    []mig.ModuleDuration
+	duplicates []mig.DuplicateDecision
+}
+
+// add decodes a data element of the dashboard into the history
+func (h *dashboardHistory) add(name string, value interface{}) error {
+	bData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case "agents stats series":
+		return json.Unmarshal(bData, &h.stats)
+	case "agents breakdown":
+		return json.Unmarshal(bData, &h.breakdown)
+	case "action throughput":
+		return json.Unmarshal(bData, &h.throughput)
+	case "slowest modules":
+		return json.Unmarshal(bData, &h.slowest)
+	case "duplicate agents decisions":
+		return json.Unmarshal(bData, &h.duplicates)
+	}
+	return fmt.Errorf("unknown dashboard data %q", name)
+}
+
+// sparkTicks are the characters sparklines are drawn with, from lowest to highest
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values as a line of bars scaled between their minimum and maximum
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var ret []rune
+	for _, v := range values {
+		i := 0
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkTicks)-1))
+		}
+		ret = append(ret, sparkTicks[i])
+	}
+	return string(ret)
+}
+
+// print displays the history as sparklines and tables
+func (h dashboardHistory) print() {
+	const bar = "\x1b[31;1m|\x1b[0m "
+	if len(h.stats) > 1 {
+		var online, idle []float64
+		for _, s := range h.stats {
+			online = append(online, s.OnlineAgents)
+			idle = append(idle, s.IdleAgents)
+		}
+		first, last := h.stats[0].Timestamp, h.stats[len(h.stats)-1].Timestamp
+		fmt.Printf("\x1b[31;1m| Agents from %s to %s:\x1b[0m\n",
+			first.Local().Format("2006-01-02 15:04"), last.Local().Format("2006-01-02 15:04"))
+		fmt.Printf("%s* online %s %.0f\n", bar, sparkline(online), online[len(online)-1])
+		fmt.Printf("%s* idle   %s %.0f\n", bar, sparkline(idle), idle[len(idle)-1])
+	}
+	if len(h.breakdown) > 0 {
+		fmt.Println("\x1b[31;1m| Online agents breakdown:\x1b[0m")
+		var category string
+		var values []string
+		flush := func() {
+			if category != "" {
+				fmt.Printf("%s* %-8s %s\n", bar, category, strings.Join(values, ", "))
+			}
+		}
+		for _, b := range h.breakdown {
+			if b.Category != category {
+				flush()
+				category = b.Category
+				values = nil
+			}
+			// only list the 5 largest values of each category
+			if len(values) == 5 {
+				values = append(values, "...")
+			}
+			if len(values) > 5 {
+				continue
+			}
+			value := b.Value
+			if value == "" {
+				value = "none"
+			}
+			values = append(values, fmt.Sprintf("%s (%.0f)", value, b.Count))
+		}
+		flush()
+	}
+	if len(h.throughput) > 0 {
+		var actions []float64
+		for _, t := range h.throughput {
+			actions = append(actions, t.Actions)
+		}
+		fmt.Printf("\x1b[31;1m| Actions per day:\x1b[0m %s\n", sparkline(actions))
+		fmt.Printf("%s----   Day    ---- + Actions + Commands + Success + Timeout\n", bar)
+		for _, t := range h.throughput {
+			fmt.Printf("%s    %s      %7.0f   %8.0f   %6.1f%%   %6.1f%%\n", bar,
+				t.Day.Format("2006-01-02"), t.Actions, t.Commands, t.SuccessRate*100, t.TimeoutRate*100)
+		}
+	}
+	if len(h.slowest) > 0 {
+		fmt.Println("\x1b[31;1m| Slowest modules:\x1b[0m")
+		fmt.Printf("%s----   Module   ---- + Commands + Average + Maximum\n", bar)
+		for _, m := range h.slowest {
+			fmt.Printf("%s  %-18s   %8.0f   %6.1fs   %6.1fs\n", bar,
+				m.Module, m.Commands, m.AverageDuration, m.MaximumDuration)
+		}
+	}
+	if len(h.duplicates) > 0 {
+		fmt.Println("\x1b[31;1m| Duplicate agents decisions:\x1b[0m")
+		fmt.Printf("%s----    Date    ---- + ---  Kind  --- + - Decision - + Agents + ---- Queue ----\n", bar)
+		for _, d := range h.duplicates {
+			fmt.Printf("%s  %s   %-9s        %-6s         %6d   %s\n", bar,
+				d.CreatedAt.Local().Format("2006-01-02 15:04"), d.Kind, d.Decision,
+				len(d.Evidence.Agents), d.QueueLoc)
+		}
+	}
+}
@@ -46,8 +46,8 @@ func investigatorReader(input string, cli client.Client) (err error) {
 	prompt := fmt.Sprintf("\x1b[35;1minv %.0f>\x1b[0m ", iid)
 	for {
 		// completion, for convenience also add permission categories here
-		var symbols = []string{"apikey", "details", "exit", "help", "pubkey", "r", "lastactions",
-			"setperms", "setstatus", "PermManifest", "PermLoader", "PermAdmin"}
+		var symbols = []string{"apikey", "details", "exit", "help", "keys", "pubkey", "r", "lastactions",
+			"revokekey", "rotatekey", "setperms", "setstatus", "PermManifest", "PermLoader", "PermAdmin"}
 		readline.Completer = func(query, ctx string) []string {
 			var res []string
 			for _, sym := range symbols {
@@ -130,13 +130,31 @@ apikey <status>           enable or disable X-MIGAPIKEY access for investigator
 details			  print the details of the investigator
 exit			  exit this mode
 help			  show this help
+keys			  list the PGP keys of the investigator and their validity
 lastactions <limit>	  print the last actions ran by the investigator. limit=10 by default.
 pubkey			  show the armored public key of the investigator
 r			  refresh the investigator (get latest version from upstream)
+revokekey <fingerprint>   revoke a PGP key of the investigator
+rotatekey <file> [overlap] replace the PGP key of the investigator with the armored public key in <file>,
+			  previous keys remain valid for [overlap] (ex: 24h, 0s by default)
 setperms [permissions...] set permissions for investigator, no arguments to apply default
 showperms                 display possible permission values
 setstatus <status>	  changes the status of the investigator to <status> (can be 'active' or 'disabled')
 `)
+		case "keys":
+			now := time.Now()
+			for _, key := range inv.Keys {
+				validity := "valid"
+				if !key.ValidAt(now) {
+					validity = "not valid"
+				}
+				expire := "never"
+				if !key.ExpireAfter.IsZero() {
+					expire = key.ExpireAfter.Format(time.RFC3339)
+				}
+				fmt.Printf("%s status=%s from=%s expire=%s (%s)\n", key.PGPFingerprint, key.Status,
+					key.ValidFrom.Format(time.RFC3339), expire, validity)
+			}
 		case "lastactions":
 			limit := 10
 			if len(orders) > 1 {
@@ -161,6 +179,45 @@ setstatus <status>	  changes the status of the investigator to <status> (can be
 				panic(err)
 			}
 			fmt.Println("Reload succeeded")
+		case "revokekey":
+			if len(orders) != 2 {
+				fmt.Println("error: must be 'revokekey <fingerprint>'. try 'help'")
+				break
+			}
+			err = cli.PostInvestigatorKeyRevocation(iid, orders[1])
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Key", orders[1], "revoked")
+			inv, err = cli.GetInvestigator(iid)
+			if err != nil {
+				panic(err)
+			}
+		case "rotatekey":
+			if len(orders) < 2 || len(orders) > 3 {
+				fmt.Println("error: must be 'rotatekey <file> [overlap]'. try 'help'")
+				break
+			}
+			var overlap time.Duration
+			if len(orders) == 3 {
+				overlap, err = time.ParseDuration(orders[2])
+				if err != nil {
+					panic(err)
+				}
+			}
+			pubkey, err := ioutil.ReadFile(orders[1])
+			if err != nil {
+				panic(err)
+			}
+			key, err := cli.PostInvestigatorKeyRotation(iid, pubkey, overlap, time.Time{})
+			if err != nil {
+				panic(err)
+			}
+			fmt.Println("Investigator key rotated to", key.PGPFingerprint)
+			inv, err = cli.GetInvestigator(iid)
+			if err != nil {
+				panic(err)
+			}
 		case "setstatus":
 			if len(orders) != 2 {
 				fmt.Println("error: must be 'setstatus <status>'. try 'help'")
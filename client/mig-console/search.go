@@ -44,6 +44,7 @@ The following search parameters are available, per search type:
 	- name=<str>		search actions by name <str>
 	- before=<rfc3339>	search actions that expired before <rfc3339 date>
 	- after=<rfc3339>	search actions were valid after <rfc3339 date>
+	- caseid=<id>		search actions attached to case <id>
 	- commandid=<id>	search action that spawned a given command
 	- agentid=<id>		search actions that ran on a given agent
 	- agentname=<str>	search actions that ran on an agent named <str>
@@ -63,6 +64,12 @@ The following search parameters are available, per search type:
 	- investigatorname=<str>search commands signed by investigator named <str>
 	- status=<str>		search commands with a given status amongst:
 				prepared, sent, success, timeout, cancelled, expired, failed
+	- result.<path>=<str>	search commands with <str> at <path> in their results, where <path>
+				is a dot separated list of keys, * matches any key and ** any
+				number of levels (ex: result.elements.*.file=/tmp/.x)
+	- resultvalue=<str>	search commands with <str> anywhere in their results
+				(ex: resultvalue=e3b0c44298fc1c149afbf4c8996fb924...)
+	- resulttext=<str>	full-text search of the words of <str> in the results of commands
 * agent:
 	- name=<str>		search agents by hostname
 	- before=<rfc3339>	search agents that have sent a heartbeat before <rfc3339 date>
@@ -297,7 +304,7 @@ func parseSearchQuery(orders []string) (p migdbsearch.Parameters, err error) {
 		if order == "and" {
 			continue
 		}
-		params := strings.Split(order, "=")
+		params := strings.SplitN(order, "=", 2)
 		if len(params) != 2 {
 			panic(fmt.Sprintf("Invalid `key=value` in search parameter '%s'", order))
 		}
@@ -326,6 +333,8 @@ func parseSearchQuery(orders []string) (p migdbsearch.Parameters, err error) {
 			if err != nil {
 				panic("before date not in RFC3339 format, ex: 2015-09-23T14:14:16Z")
 			}
+		case "caseid":
+			p.CaseID = value
 		case "commandid":
 			p.CommandID = value
 		case "investigatorid":
@@ -345,6 +354,10 @@ func parseSearchQuery(orders []string) (p migdbsearch.Parameters, err error) {
 			p.ManifestName = value
 		case "manifestid":
 			p.ManifestID = value
+		case "resulttext":
+			p.ResultText = value
+		case "resultvalue":
+			p.ResultValue = value
 		case "status":
 			p.Status = value
 		case "name":
@@ -357,6 +370,11 @@ func parseSearchQuery(orders []string) (p migdbsearch.Parameters, err error) {
 				p.InvestigatorName = value
 			}
 		default:
+			if strings.HasPrefix(key, "result.") {
+				p.ResultPath = strings.TrimPrefix(key, "result.")
+				p.ResultValue = value
+				break
+			}
 			panic(fmt.Sprintf("Unknown search key '%s'", key))
 		}
 	}
@@ -32,6 +32,12 @@ usage: %s <module> <global options> <module parameters>
 
 -c <path>	 Path to config file, defaults to ~/.migrc
 
+-container <id>  Run the operation inside a container of the targeted agents, selected
+		 by ID, unique ID prefix or name. Only the file, pkg and memory
+		 modules support containers. Agents list their containers in
+		 environment->>'containers'.
+		 example: -t "environment->>'containers' LIKE '%%nginx%%'" -container nginx
+
 -e <duration>	 Time after which the action expires, defaults to 60 seconds.
 
 		 Example: -e 300s (5 minutes)
@@ -99,6 +105,7 @@ func main() {
 		op                                        mig.Operation
 		a                                         mig.Action
 		migrc, show, target, expiration           string
+		container                                 string
 		afile, aname, targetfound, targetnotfound string
 		signAndOutput                             bool
 		printAndExit                              bool
@@ -132,6 +139,7 @@ func main() {
 	fs.BoolVar(&verbose, "v", false, "Enable verbose output")
 	fs.BoolVar(&showversion, "V", false, "Show version")
 	fs.BoolVar(&compressAction, "z", false, "Request compression of action parameters")
+	fs.StringVar(&container, "container", "", "Run the operation inside a container of the agents")
 
 	// if first argument is missing, or is help, print help
 	// otherwise, pass the remainder of the arguments to the module for parsing
@@ -229,6 +237,13 @@ func main() {
 	if err != nil || op.Parameters == nil {
 		panic(err)
 	}
+	if container != "" {
+		cs, ok := run.(modules.HasContainerSupport)
+		if !ok || !cs.ContainerSupport() {
+			panic(fmt.Sprintf("module '%s' does not support containers", op.Module))
+		}
+		op.Container = container
+	}
 	// If compression has been enabled, flag it in the operation.
 	if compressAction {
 		op.WantCompressed = true
@@ -246,6 +261,9 @@ func main() {
 	// If running against the local target, don't post the action to the MIG API
 	// but run it locally instead.
 	if target == "local" {
+		if container != "" {
+			panic("containers cannot be selected when running against the local target")
+		}
 		msg, err := modules.MakeMessage(modules.MsgClassParameters, op.Parameters, false)
 		if err != nil {
 			panic(err)
@@ -315,12 +333,12 @@ func main() {
 		panic("Both -target-found and -target-foundnothing cannot be used simultaneously")
 	}
 	if targetfound != "" {
-		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, json_array_elements(commands.results) as `+
+		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, jsonb_array_elements(commands.results) as `+
 			`r where actionid=%s and r#>>'{foundanything}' = 'true')`, targetfound)
 		target = targetQuery + " AND " + target
 	}
 	if targetnotfound != "" {
-		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, json_array_elements(commands.results) as `+
+		targetQuery := fmt.Sprintf(`id IN (select agentid from commands, jsonb_array_elements(commands.results) as `+
 			`r where actionid=%s and r#>>'{foundanything}' = 'false')`, targetnotfound)
 		target = targetQuery + " AND " + target
 	}
@@ -29,6 +29,7 @@ type Command struct {
 	// expired: the command has been expired by the scheduler
 	// failed: the command has failed on the agent and been returned to the scheduler
 	// timeout: module execution has timed out, and the agent returned the command to the scheduler
+	// resourcelimit: a module exceeded the resource limits set in the agent configuration
 	Status string `json:"status"`
 
 	Results    []modules.Result `json:"results"`
@@ -38,12 +39,13 @@ type Command struct {
 
 // Various command status values
 const (
-	StatusSent      string = "sent"
-	StatusSuccess   string = "success"
-	StatusCancelled string = "cancelled"
-	StatusExpired   string = "expired"
-	StatusFailed    string = "failed"
-	StatusTimeout   string = "timeout"
+	StatusSent          string = "sent"
+	StatusSuccess       string = "success"
+	StatusCancelled     string = "cancelled"
+	StatusExpired       string = "expired"
+	StatusFailed        string = "failed"
+	StatusTimeout       string = "timeout"
+	StatusResourceLimit string = "resourcelimit"
 )
 
 // CmdFromFile reads a command from a local file on the file system
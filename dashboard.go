@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import "time"
+
+// Categories of agents breakdowns
+const (
+	BreakdownOS      string = "os"
+	BreakdownVersion string = "version"
+	BreakdownTag     string = "tag"
+	BreakdownLoader  string = "loader"
+)
+
+// AgentsBreakdown is the count of online agents that share a value in a
+// category. Values of tags are formatted as key=value, and agents that were
+// not deployed by a loader are counted under an empty loader name.
+type AgentsBreakdown struct {
+	Category string  `json:"category"`
+	Value    string  `json:"value"`
+	Count    float64 `json:"count"`
+}
+
+// ActionThroughput summarizes the actions started during a day, and the
+// commands sent to agents that day
+type ActionThroughput struct {
+	Day         time.Time `json:"day"`
+	Actions     float64   `json:"actions"`
+	Commands    float64   `json:"commands"`
+	Succeeded   float64   `json:"succeeded"`
+	TimedOut    float64   `json:"timedout"`
+	SuccessRate float64   `json:"successrate"`
+	TimeoutRate float64   `json:"timeoutrate"`
+}
+
+// NewActionThroughput returns the throughput of a day, with the success and
+// timeout rates of its commands computed
+func NewActionThroughput(day time.Time, actions, commands, succeeded, timedout float64) (t ActionThroughput) {
+	t = ActionThroughput{Day: day, Actions: actions, Commands: commands,
+		Succeeded: succeeded, TimedOut: timedout}
+	if commands > 0 {
+		t.SuccessRate = succeeded / commands
+		t.TimeoutRate = timedout / commands
+	}
+	return
+}
+
+// ModuleDuration stores how long the commands that ran a module took to
+// complete, in seconds. Commands of actions that have several operations
+// are counted in each of their modules.
+type ModuleDuration struct {
+	Module          string  `json:"module"`
+	Commands        float64 `json:"commands"`
+	AverageDuration float64 `json:"averageduration"`
+	MaximumDuration float64 `json:"maximumduration"`
+}
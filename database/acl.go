@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// ACLPolicies returns the ACL policies of all modules, ordered by module name
+func (db *DB) ACLPolicies() (policies []mig.ACLPolicy, err error) {
+	rows, err := db.c.Query(`SELECT aclpolicies.module, aclpolicies.minimumweight,
+		aclweights.investigatorid, aclweights.weight
+		FROM aclpolicies LEFT JOIN aclweights ON aclweights.module=aclpolicies.module
+		ORDER BY aclpolicies.module, aclweights.investigatorid`)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while listing ACL policies: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var (
+			module        string
+			minimumweight int
+			iid           sql.NullFloat64
+			weight        sql.NullInt64
+		)
+		err = rows.Scan(&module, &minimumweight, &iid, &weight)
+		if err != nil {
+			err = fmt.Errorf("Error while retrieving ACL policy: '%v'", err)
+			return
+		}
+		if len(policies) == 0 || policies[len(policies)-1].Module != module {
+			policies = append(policies, mig.ACLPolicy{Module: module, MinimumWeight: minimumweight,
+				Weights: make([]mig.ACLPolicyWeight, 0)})
+		}
+		if iid.Valid && weight.Valid {
+			p := &policies[len(policies)-1]
+			p.Weights = append(p.Weights, mig.ACLPolicyWeight{InvestigatorID: iid.Float64,
+				Weight: int(weight.Int64)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete ACL policies query: '%v'", err)
+	}
+	return
+}
+
+// SetACLPolicy creates the ACL policy of a module, or replaces it if it exists
+func (db *DB) SetACLPolicy(p mig.ACLPolicy) (err error) {
+	err = p.Validate()
+	if err != nil {
+		return
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`INSERT INTO aclpolicies (module, minimumweight, lastmodified)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (module) DO UPDATE SET minimumweight=$2, lastmodified=$3`,
+		p.Module, p.MinimumWeight, time.Now().UTC())
+	if err != nil {
+		err = fmt.Errorf("Failed to store ACL policy: '%v'", err)
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM aclweights WHERE module=$1`, p.Module)
+	if err != nil {
+		err = fmt.Errorf("Failed to store ACL policy: '%v'", err)
+		return
+	}
+	for _, w := range p.Weights {
+		_, err = tx.Exec(`INSERT INTO aclweights (module, investigatorid, weight)
+			VALUES ($1, $2, $3)`, p.Module, w.InvestigatorID, w.Weight)
+		if err != nil {
+			err = fmt.Errorf("Failed to store ACL policy weight of investigator %.0f: '%v'",
+				w.InvestigatorID, err)
+			return
+		}
+	}
+	err = tx.Commit()
+	return
+}
+
+// DeleteACLPolicy removes the ACL policy of a module
+func (db *DB) DeleteACLPolicy(module string) (err error) {
+	res, err := db.c.Exec(`DELETE FROM aclpolicies WHERE module=$1`, module)
+	if err != nil {
+		return fmt.Errorf("Failed to delete ACL policy: '%v'", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n != 1 {
+		return fmt.Errorf("No ACL policy found for module '%s'", module)
+	}
+	return
+}
@@ -27,6 +27,7 @@ type actionFromDB struct {
 	ExpireAfter     time.Time
 	Status          string
 	SyntaxVersion   uint16
+	Nonce           string
 	DescriptionJSON []byte
 	ThreatJSON      []byte
 	OperationsJSON  []byte
@@ -42,6 +43,7 @@ func deserializeActionFromDB(retrieved actionFromDB) (mig.Action, error) {
 		ExpireAfter:   retrieved.ExpireAfter,
 		Status:        retrieved.Status,
 		SyntaxVersion: retrieved.SyntaxVersion,
+		Nonce:         retrieved.Nonce,
 	}
 
 	deserializeErrors := map[string]error{
@@ -65,7 +67,7 @@ func deserializeActionFromDB(retrieved actionFromDB) (mig.Action, error) {
 func (db *DB) LastActions(limit int) (actions []mig.Action, err error) {
 	rows, err := db.c.Query(`SELECT id, name, target, description, threat, operations,
 		validfrom, expireafter, starttime, finishtime, lastupdatetime,
-		status, pgpsignatures, syntaxversion
+		status, pgpsignatures, syntaxversion, nonce
 		FROM actions ORDER BY starttime DESC LIMIT $1`, limit)
 	if rows != nil {
 		defer rows.Close()
@@ -79,7 +81,7 @@ func (db *DB) LastActions(limit int) (actions []mig.Action, err error) {
 		var a mig.Action
 		err = rows.Scan(&a.ID, &a.Name, &a.Target,
 			&jDesc, &jThreat, &jOps, &a.ValidFrom, &a.ExpireAfter,
-			&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status, &jSig, &a.SyntaxVersion)
+			&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status, &jSig, &a.SyntaxVersion, &a.Nonce)
 		if err != nil {
 			err = fmt.Errorf("Error while retrieving action: '%v'", err)
 			return
@@ -123,10 +125,10 @@ func (db *DB) ActionByID(id float64) (a mig.Action, err error) {
 	var jDesc, jThreat, jOps, jSig []byte
 	err = db.c.QueryRow(`SELECT id, name, target, description, threat, operations,
 		validfrom, expireafter, starttime, finishtime, lastupdatetime,
-		status, pgpsignatures, syntaxversion
+		status, pgpsignatures, syntaxversion, nonce
 		FROM actions WHERE id=$1`, id).Scan(&a.ID, &a.Name, &a.Target,
 		&jDesc, &jThreat, &jOps, &a.ValidFrom, &a.ExpireAfter,
-		&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status, &jSig, &a.SyntaxVersion)
+		&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status, &jSig, &a.SyntaxVersion, &a.Nonce)
 	if err != nil {
 		err = fmt.Errorf("Error while retrieving action: '%v'", err)
 		return
@@ -194,11 +196,11 @@ func (db *DB) InsertAction(a mig.Action) (err error) {
 	_, err = db.c.Exec(`INSERT INTO actions
 		(id, name, target, description, threat, operations,
 		validfrom, expireafter, starttime, finishtime, lastupdatetime,
-		status, pgpsignatures, syntaxversion)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		status, pgpsignatures, syntaxversion, nonce)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
 		a.ID, a.Name, a.Target, jDesc, jThreat, jOperations,
 		a.ValidFrom, a.ExpireAfter, a.StartTime, a.FinishTime, a.LastUpdateTime,
-		a.Status, aPGPSignatures, a.SyntaxVersion)
+		a.Status, aPGPSignatures, a.SyntaxVersion, a.Nonce)
 	if err != nil {
 		return fmt.Errorf("Failed to store action: '%v'", err)
 	}
@@ -316,6 +318,10 @@ func (db *DB) GetActionCounters(aid float64) (counters mig.ActionCounters, err e
 			counters.TimeOut = count
 			counters.Done += count
 			counters.Sent += count
+		case mig.StatusResourceLimit:
+			counters.ResourceLimit = count
+			counters.Done += count
+			counters.Sent += count
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -331,7 +337,7 @@ func (db *DB) SetupRunnableActions() (actions []mig.Action, err error) {
 	rows, err := db.c.Query(`UPDATE actions SET status='scheduled'
 		WHERE status='pending' AND validfrom < NOW() AND expireafter > NOW()
 		RETURNING id, name, target, description, threat, operations,
-		validfrom, expireafter, status, pgpsignatures, syntaxversion`)
+		validfrom, expireafter, status, pgpsignatures, syntaxversion, nonce`)
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -352,7 +358,8 @@ func (db *DB) SetupRunnableActions() (actions []mig.Action, err error) {
 			&retrieved.ExpireAfter,
 			&retrieved.Status,
 			&retrieved.SignaturesJSON,
-			&retrieved.SyntaxVersion)
+			&retrieved.SyntaxVersion,
+			&retrieved.Nonce)
 		if err != nil {
 			err = fmt.Errorf("Error while retrieving action: '%s'", err.Error())
 			return
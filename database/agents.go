@@ -14,7 +14,7 @@ import (
 
 	"github.com/mozilla/mig"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // AgentByQueueAndPID returns a single agent that is located at a given queueloc and has a given PID
@@ -36,12 +36,15 @@ func (db *DB) AgentByQueueAndPID(queueloc string, pid int) (agent mig.Agent, err
 
 // AgentByID returns a single agent identified by its ID
 func (db *DB) AgentByID(id float64) (agent mig.Agent, err error) {
-	var jTags, jEnv []byte
+	var (
+		jTags, jEnv   []byte
+		lifecycleTime pq.NullTime
+	)
 	err = db.c.QueryRow(`SELECT id, name, queueloc, mode, version, pid, starttime, heartbeattime,
-		refreshtime, status, tags, environment FROM agents WHERE id=$1`, id).Scan(
+		refreshtime, status, tags, environment, lifecycletime FROM agents WHERE id=$1`, id).Scan(
 		&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Mode, &agent.Version, &agent.PID,
 		&agent.StartTime, &agent.HeartBeatTS, &agent.RefreshTS, &agent.Status,
-		&jTags, &jEnv)
+		&jTags, &jEnv, &lifecycleTime)
 	if err != nil {
 		err = fmt.Errorf("Error while retrieving agent: '%v'", err)
 		return
@@ -49,6 +52,9 @@ func (db *DB) AgentByID(id float64) (agent mig.Agent, err error) {
 	if err == sql.ErrNoRows {
 		return
 	}
+	if lifecycleTime.Valid {
+		agent.LifecycleTS = lifecycleTime.Time
+	}
 	err = json.Unmarshal(jTags, &agent.Tags)
 	if err != nil {
 		err = fmt.Errorf("failed to unmarshal agent tags")
@@ -91,10 +97,15 @@ func (db *DB) AgentsActiveSince(pointInTime time.Time) (agents []mig.Agent, err
 }
 
 // InsertAgent creates a new agent in the database
+func (db *DB) InsertAgent(agt mig.Agent) (err error) {
+	return db.insertAgent(agt, nil)
+}
+
+// insertAgent creates a new agent in the database
 //
 // If useTx is not nil, the transaction will be used instead of the standard
 // connection
-func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
+func (db *DB) insertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
 	jEnv, err := json.Marshal(agt.Env)
 	if err != nil {
 		err = fmt.Errorf("Failed to marshal agent environment: '%v'", err)
@@ -109,24 +120,30 @@ func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
 	// Insert the new agent; note here we also attempt to query the loaders table
 	// and see if we can get a loadername for the new agent instance, if it's not
 	// associated with a loader the value will just be NULL.
-	if useTx != nil {
-		_, err = useTx.Exec(`INSERT INTO agents
+	//
+	// If the endpoint was quarantined or decommissioned, the new agent instance
+	// inherits that status instead of the one it was given.
+	//
+	// The public IPs of the environment are copied into the publicips column,
+	// so targets can match any of them.
+	query := `INSERT INTO agents
 		(id, name, queueloc, mode, version, pid, starttime, destructiontime,
-		heartbeattime, refreshtime, status, environment, tags, loadername)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
-		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1))`,
-			agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
-			agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
-			agt.Status, jEnv, jTags, agt.QueueLoc)
+		heartbeattime, refreshtime, status, environment, tags, loadername, lifecycletime,
+		publicips)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+		COALESCE((SELECT status FROM agents WHERE queueloc = $14 AND status IN ($15, $16)
+			ORDER BY lifecycletime DESC LIMIT 1), $11), $12, $13,
+		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1),
+		(SELECT lifecycletime FROM agents WHERE queueloc = $14 AND status IN ($15, $16)
+			ORDER BY lifecycletime DESC LIMIT 1), $17)`
+	args := []interface{}{agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
+		agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
+		agt.Status, jEnv, jTags, agt.QueueLoc, mig.AgtStatusQuarantined, mig.AgtStatusDecommissioned,
+		pq.Array(agt.Env.PublicIPList())}
+	if useTx != nil {
+		_, err = useTx.Exec(query, args...)
 	} else {
-		_, err = db.c.Exec(`INSERT INTO agents
-		(id, name, queueloc, mode, version, pid, starttime, destructiontime,
-		heartbeattime, refreshtime, status, environment, tags, loadername)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,
-		(SELECT loadername FROM loaders WHERE queueloc = $14 LIMIT 1))`,
-			agtid, agt.Name, agt.QueueLoc, agt.Mode, agt.Version, agt.PID,
-			agt.StartTime, agt.DestructionTime, agt.HeartBeatTS, agt.RefreshTS,
-			agt.Status, jEnv, jTags, agt.QueueLoc)
+		_, err = db.c.Exec(query, args...)
 	}
 	if err != nil {
 		return fmt.Errorf("Failed to insert agent in database: '%v'", err)
@@ -135,12 +152,14 @@ func (db *DB) InsertAgent(agt mig.Agent, useTx *sql.Tx) (err error) {
 }
 
 // UpdateAgentHeartbeat updates the heartbeat timestamp of an agent in the database
-// unless the agent has been marked as destroyed
+// and sets it online, unless the agent is quarantined or decommissioned
 func (db *DB) UpdateAgentHeartbeat(agt mig.Agent) (err error) {
-	_, err = db.c.Exec(`UPDATE agents SET status=$1, heartbeattime=$2,
+	_, err = db.c.Exec(`UPDATE agents SET heartbeattime=$2,
+		status=(CASE WHEN status IN ($5, $6) THEN status ELSE $1 END),
 		loadername=(SELECT loadername FROM loaders WHERE queueloc = $3 LIMIT 1)
 		WHERE id=$4`,
-		mig.AgtStatusOnline, agt.HeartBeatTS, agt.QueueLoc, agt.ID)
+		mig.AgtStatusOnline, agt.HeartBeatTS, agt.QueueLoc, agt.ID,
+		mig.AgtStatusQuarantined, mig.AgtStatusDecommissioned)
 	if err != nil {
 		return fmt.Errorf("Failed to update agent in database: '%v'", err)
 	}
@@ -152,18 +171,19 @@ func (db *DB) UpdateAgentHeartbeat(agt mig.Agent) (err error) {
 // time indicates newer environment information exists.
 func (db *DB) ReplaceRefreshedAgent(agt mig.Agent) (err error) {
 	// Do this in a transaction to ensure other parts of the scheduler don't
-	// pick up invalid information
+	// pick up invalid information. The new agent is inserted before the old
+	// one is set offline, so it inherits its lifecycle status.
 	tx, err := db.c.Begin()
 	if err != nil {
 		return
 	}
-	_, err = tx.Exec(`UPDATE agents SET status=$1 WHERE id=$2`,
-		mig.AgtStatusOffline, agt.ID)
+	err = db.insertAgent(agt, tx)
 	if err != nil {
 		_ = tx.Rollback()
 		return
 	}
-	err = db.InsertAgent(agt, tx)
+	_, err = tx.Exec(`UPDATE agents SET status=$1 WHERE id=$2`,
+		mig.AgtStatusOffline, agt.ID)
 	if err != nil {
 		_ = tx.Rollback()
 		return
@@ -203,10 +223,11 @@ func (db *DB) ListMultiAgentsQueues(pointInTime time.Time) (queues []string, err
 	return
 }
 
-// ActiveAgentsByQueue retrieves an array of agents identified by their QueueLoc value
+// ActiveAgentsByQueue retrieves an array of agents identified by their QueueLoc value.
+// The environment of the agents is returned, as it is used to detect clones.
 func (db *DB) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agents []mig.Agent, err error) {
 	rows, err := db.c.Query(`SELECT id, name, queueloc, mode, version, pid, starttime,
-		heartbeattime, refreshtime, status
+		destructiontime, heartbeattime, refreshtime, status, environment
 		FROM agents WHERE agents.heartbeattime > $1 AND agents.queueloc=$2
 		AND agents.status!=$3`,
 		pointInTime, queueloc, mig.AgtStatusOffline)
@@ -218,14 +239,22 @@ func (db *DB) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agent
 		return
 	}
 	for rows.Next() {
-		var agent mig.Agent
+		var (
+			agent mig.Agent
+			jEnv  []byte
+		)
 		err = rows.Scan(&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Mode, &agent.Version,
-			&agent.PID, &agent.StartTime, &agent.HeartBeatTS,
-			&agent.RefreshTS, &agent.Status)
+			&agent.PID, &agent.StartTime, &agent.DestructionTime, &agent.HeartBeatTS,
+			&agent.RefreshTS, &agent.Status, &jEnv)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve agent data: '%v'", err)
 			return
 		}
+		err = json.Unmarshal(jEnv, &agent.Env)
+		if err != nil {
+			err = fmt.Errorf("failed to unmarshal agent environment")
+			return
+		}
 		agents = append(agents, agent)
 	}
 	if err := rows.Err(); err != nil {
@@ -237,6 +266,18 @@ func (db *DB) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agent
 // ActiveAgentsByTarget runs a search for all agents that match a given target string.
 // For safety, it does so in a transaction that runs as a readonly user.
 func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error) {
+	err = db.IterActiveAgentsByTarget(target, func(agent mig.Agent) error {
+		agents = append(agents, agent)
+		return nil
+	})
+	return
+}
+
+// IterActiveAgentsByTarget calls fn on each agent that matches a given target
+// string. Agents are read from the database one at a time, in a transaction that
+// runs as a readonly user. If fn returns an error, the iteration stops and the
+// error is returned.
+func (db *DB) IterActiveAgentsByTarget(target string, fn func(mig.Agent) error) (err error) {
 	var jTags, jEnv []byte
 	// save current user
 	var dbuser string
@@ -275,27 +316,37 @@ func (db *DB) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error
 			&agent.PID, &agent.StartTime, &agent.DestructionTime, &agent.HeartBeatTS,
 			&agent.RefreshTS, &agent.Status, &agent.Mode, &jEnv, &jTags, &loaderName)
 		if err != nil {
+			_ = txn.Rollback()
 			err = fmt.Errorf("Failed to retrieve agent data: '%v'", err)
 			return
 		}
 		err = json.Unmarshal(jTags, &agent.Tags)
 		if err != nil {
+			_ = txn.Rollback()
 			err = fmt.Errorf("failed to unmarshal agent tags")
 			return
 		}
 		err = json.Unmarshal(jEnv, &agent.Env)
 		if err != nil {
+			_ = txn.Rollback()
 			err = fmt.Errorf("failed to unmarshal agent environment")
 			return
 		}
 		if loaderName.Valid && loaderName.String != "" {
 			agent.LoaderName = loaderName.String
 		}
-		agents = append(agents, agent)
+		err = fn(agent)
+		if err != nil {
+			_ = txn.Rollback()
+			return
+		}
 	}
-	if err := rows.Err(); err != nil {
+	if err = rows.Err(); err != nil {
+		_ = txn.Rollback()
 		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+		return
 	}
+	rows.Close()
 	_, err = txn.Exec(`SET ROLE ` + dbuser)
 	if err != nil {
 		_ = txn.Rollback()
@@ -321,12 +372,110 @@ func (db *DB) MarkAgentDestroyed(agent mig.Agent) (err error) {
 	return
 }
 
+// SetEndpointLifecycle sets the status of the agents of the endpoint identified
+// by queueloc that are in one of the statuses listed in from, and records the
+// time of the change. It returns the number of agents updated.
+func (db *DB) SetEndpointLifecycle(queueloc, status string, from []string) (count float64, err error) {
+	res, err := db.c.Exec(`UPDATE agents SET status=$1, lifecycletime=NOW()
+		WHERE queueloc=$2 AND status = ANY($3)`, status, queueloc, pq.Array(from))
+	if err != nil {
+		err = fmt.Errorf("Failed to update endpoint lifecycle in database: '%v'", err)
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("Failed to update endpoint lifecycle in database: '%v'", err)
+		return
+	}
+	return float64(n), nil
+}
+
+// ArchiveDecommissionedAgents moves the agents that were decommissioned before
+// pointInTime, and their commands, from the agents and commands tables to the
+// agents_archive and commands_archive tables. It returns the number of agents
+// archived.
+func (db *DB) ArchiveDecommissionedAgents(pointInTime time.Time) (count float64, err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		err = fmt.Errorf("Failed to archive agents: '%v'", err)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	now := time.Now().UTC()
+	// lock the agents to archive so their status does not change while their
+	// commands are moved
+	rows, err := tx.Query(`SELECT id FROM agents
+		WHERE status=$1 AND lifecycletime < $2 FOR UPDATE`,
+		mig.AgtStatusDecommissioned, pointInTime)
+	if err != nil {
+		err = fmt.Errorf("Failed to archive agents: '%v'", err)
+		return
+	}
+	var ids []float64
+	for rows.Next() {
+		var id float64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			err = fmt.Errorf("Failed to archive agents: '%v'", err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to archive agents: '%v'", err)
+		return
+	}
+	if len(ids) == 0 {
+		err = tx.Commit()
+		return
+	}
+	idArray := pq.Array(ids)
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO agents_archive (id, name, queueloc, mode, version, pid, starttime,
+			destructiontime, heartbeattime, refreshtime, status, environment, tags,
+			loadername, lifecycletime, publicips, archivedat)
+			SELECT id, name, queueloc, mode, version, pid, starttime, destructiontime,
+			heartbeattime, refreshtime, $2, environment, tags, loadername, lifecycletime,
+			publicips, $3
+			FROM agents WHERE id = ANY($1)`, []interface{}{idArray, mig.AgtStatusArchived, now}},
+		{`INSERT INTO commands_archive (id, actionid, agentid, status, results, starttime, finishtime)
+			SELECT id, actionid, agentid, status, results, starttime, finishtime
+			FROM commands WHERE agentid = ANY($1)`, []interface{}{idArray}},
+		{`DELETE FROM commands WHERE agentid = ANY($1)`, []interface{}{idArray}},
+		{`DELETE FROM invagtmodperm WHERE agentid = ANY($1)`, []interface{}{idArray}},
+		{`DELETE FROM agents WHERE id = ANY($1)`, []interface{}{idArray}},
+	}
+	for _, step := range steps {
+		_, err = tx.Exec(step.query, step.args...)
+		if err != nil {
+			err = fmt.Errorf("Failed to archive agents: '%v'", err)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("Failed to archive agents: '%v'", err)
+		return
+	}
+	return float64(len(ids)), nil
+}
+
 // GetAgentsStats retrieves the latest agents statistics. limit controls how many rows
 // of statistics are returned
 func (db *DB) GetAgentsStats(limit int) (stats []mig.AgentsStats, err error) {
 	rows, err := db.c.Query(`SELECT timestamp, online_agents, online_agents_by_version,
 		online_endpoints, idle_agents, idle_agents_by_version, idle_endpoints, new_endpoints,
-		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints
+		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints,
+		COALESCE(quarantined_endpoints, 0), COALESCE(decommissioned_endpoints, 0)
 		FROM agents_stats ORDER BY timestamp DESC LIMIT $1`, limit)
 	if rows != nil {
 		defer rows.Close()
@@ -335,12 +484,36 @@ func (db *DB) GetAgentsStats(limit int) (stats []mig.AgentsStats, err error) {
 		err = fmt.Errorf("Error while retrieving agent statistics: '%v'", err)
 		return
 	}
+	return scanAgentsStats(rows)
+}
+
+// AgentsStatsSince retrieves the agents statistics stored after pointInTime,
+// ordered from the oldest to the most recent
+func (db *DB) AgentsStatsSince(pointInTime time.Time) (stats []mig.AgentsStats, err error) {
+	rows, err := db.c.Query(`SELECT timestamp, online_agents, online_agents_by_version,
+		online_endpoints, idle_agents, idle_agents_by_version, idle_endpoints, new_endpoints,
+		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints,
+		COALESCE(quarantined_endpoints, 0), COALESCE(decommissioned_endpoints, 0)
+		FROM agents_stats WHERE timestamp > $1 ORDER BY timestamp ASC`, pointInTime)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving agent statistics: '%v'", err)
+		return
+	}
+	return scanAgentsStats(rows)
+}
+
+// scanAgentsStats reads rows of the agents_stats table
+func scanAgentsStats(rows *sql.Rows) (stats []mig.AgentsStats, err error) {
 	for rows.Next() {
 		var jOnlAgtVer, jIdlAgtVer []byte
 		var s mig.AgentsStats
 		err = rows.Scan(&s.Timestamp, &s.OnlineAgents, &jOnlAgtVer, &s.OnlineEndpoints,
 			&s.IdleAgents, &jIdlAgtVer, &s.IdleEndpoints, &s.NewEndpoints,
-			&s.MultiAgentsEndpoints, &s.DisappearedEndpoints, &s.FlappingEndpoints)
+			&s.MultiAgentsEndpoints, &s.DisappearedEndpoints, &s.FlappingEndpoints,
+			&s.QuarantinedEndpoints, &s.DecommissionedEndpoints)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve agent statistics data: '%v'", err)
 			return
@@ -378,11 +551,13 @@ func (db *DB) StoreAgentsStats(stats mig.AgentsStats) (err error) {
 	_, err = db.c.Exec(`INSERT INTO agents_stats
 		(timestamp, online_agents, online_agents_by_version, online_endpoints,
 		idle_agents, idle_agents_by_version, idle_endpoints, new_endpoints,
-		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		multi_agents_endpoints, disappeared_endpoints, flapping_endpoints,
+		quarantined_endpoints, decommissioned_endpoints)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
 		time.Now().UTC(), stats.OnlineAgents, jOnlAgtVer, stats.OnlineEndpoints,
 		stats.IdleAgents, jIdlAgtVer, stats.IdleEndpoints, stats.NewEndpoints,
-		stats.MultiAgentsEndpoints, stats.DisappearedEndpoints, stats.FlappingEndpoints)
+		stats.MultiAgentsEndpoints, stats.DisappearedEndpoints, stats.FlappingEndpoints,
+		stats.QuarantinedEndpoints, stats.DecommissionedEndpoints)
 	if err != nil {
 		return fmt.Errorf("Failed to insert agent statistics in database: '%v'", err)
 	}
@@ -481,7 +656,8 @@ func (db *DB) CountIdleEndpoints() (sum float64, err error) {
 	return
 }
 
-// CountNewEndpointsretrieves a count of new endpoints that started after `pointInTime`
+// CountNewEndpointsretrieves a count of new endpoints that started after `pointInTime`.
+// Decommissioned endpoints are not counted.
 func (db *DB) CountNewEndpoints(recent, old time.Time) (sum float64, err error) {
 	err = db.c.QueryRow(`SELECT COUNT(*) FROM (
 				SELECT queueloc FROM agents
@@ -492,8 +668,9 @@ func (db *DB) CountNewEndpoints(recent, old time.Time) (sum float64, err error)
 					GROUP BY queueloc
 				)
 				AND starttime > $1
+				AND status != $3
 				GROUP BY queueloc
-			)AS newendpoints`, recent, old).Scan(&sum)
+			)AS newendpoints`, recent, old, mig.AgtStatusDecommissioned).Scan(&sum)
 	if err != nil {
 		err = fmt.Errorf("Error while counting new endpoints: '%v'", err)
 		return
@@ -504,6 +681,17 @@ func (db *DB) CountNewEndpoints(recent, old time.Time) (sum float64, err error)
 	return
 }
 
+// CountEndpointsByStatus retrieves a count of unique endpoints that have agents in status
+func (db *DB) CountEndpointsByStatus(status string) (sum float64, err error) {
+	err = db.c.QueryRow(`SELECT COUNT(DISTINCT(queueloc)) FROM agents WHERE status=$1`,
+		status).Scan(&sum)
+	if err != nil {
+		err = fmt.Errorf("Error while counting %s endpoints: '%v'", status, err)
+		return
+	}
+	return
+}
+
 // CountDoubleAgents counts the number of endpoints that run more than one agent
 func (db *DB) CountDoubleAgents() (sum float64, err error) {
 	err = db.c.QueryRow(`SELECT COUNT(*) FROM (
@@ -526,18 +714,20 @@ func (db *DB) CountDoubleAgents() (sum float64, err error) {
 	return
 }
 
-// CountDisappearedEndpoints a count of endpoints that have disappeared over a given period
+// CountDisappearedEndpoints a count of endpoints that have disappeared over a given period.
+// Quarantined and decommissioned endpoints have not disappeared, and are not counted.
 func (db *DB) CountDisappearedEndpoints(pointInTime time.Time) (sum float64, err error) {
 	err = db.c.QueryRow(`SELECT COUNT(*) FROM (
 			SELECT queueloc FROM agents
 			WHERE queueloc NOT IN (
 				SELECT queueloc FROM agents
-				WHERE status=$1 OR status=$2
+				WHERE status IN ($1, $2, $4, $5)
 				GROUP BY queueloc
 			)
 			AND heartbeattime > $3
 			GROUP BY queueloc) AS disappeared`,
-		mig.AgtStatusIdle, mig.AgtStatusOnline, pointInTime).Scan(&sum)
+		mig.AgtStatusIdle, mig.AgtStatusOnline, pointInTime,
+		mig.AgtStatusQuarantined, mig.AgtStatusDecommissioned).Scan(&sum)
 	if err != nil {
 		err = fmt.Errorf("Error while counting disappeared endpoints: '%v'", err)
 		return
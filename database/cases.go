@@ -0,0 +1,268 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// InsertCase stores a new case and its assignees
+func (db *DB) InsertCase(c mig.Case) (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`INSERT INTO cases (id, title, status, createdby, createdat, lastmodified)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		c.ID, c.Title, c.Status, c.CreatedBy, c.CreatedAt, c.LastModified)
+	if err != nil {
+		err = fmt.Errorf("Failed to insert case: '%v'", err)
+		return
+	}
+	err = insertCaseAssignees(tx, c)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+// UpdateCase updates the title, status and assignees of a case
+func (db *DB) UpdateCase(c mig.Case) (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	res, err := tx.Exec(`UPDATE cases SET title=$1, status=$2, lastmodified=$3 WHERE id=$4`,
+		c.Title, c.Status, time.Now().UTC(), c.ID)
+	if err != nil {
+		err = fmt.Errorf("Failed to update case: '%v'", err)
+		return
+	}
+	ctr, err := res.RowsAffected()
+	if err != nil {
+		err = fmt.Errorf("Failed to update case: '%v'", err)
+		return
+	}
+	if ctr != 1 {
+		err = fmt.Errorf("Failed to update case, %d rows affected", ctr)
+		return
+	}
+	_, err = tx.Exec(`DELETE FROM case_assignees WHERE caseid=$1`, c.ID)
+	if err != nil {
+		err = fmt.Errorf("Failed to update case assignees: '%v'", err)
+		return
+	}
+	err = insertCaseAssignees(tx, c)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	return
+}
+
+func insertCaseAssignees(tx *sql.Tx, c mig.Case) (err error) {
+	for _, a := range c.Assignees {
+		_, err = tx.Exec(`INSERT INTO case_assignees (caseid, investigatorid) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, c.ID, a.InvestigatorID)
+		if err != nil {
+			return fmt.Errorf("Failed to assign investigator %.0f to case: '%v'", a.InvestigatorID, err)
+		}
+	}
+	return
+}
+
+// CaseByID retrieves a case with its assignees, notes, actions and agents
+func (db *DB) CaseByID(id float64) (c mig.Case, err error) {
+	err = db.c.QueryRow(`SELECT id, title, status, createdby, createdat, lastmodified
+		FROM cases WHERE id=$1`, id).Scan(&c.ID, &c.Title, &c.Status, &c.CreatedBy,
+		&c.CreatedAt, &c.LastModified)
+	if err != nil {
+		err = fmt.Errorf("Error while retrieving case: '%v'", err)
+		return
+	}
+	err = db.caseDetails(&c)
+	return
+}
+
+// Cases returns the last modified cases, most recent first. If status is set,
+// only cases with this status are returned.
+func (db *DB) Cases(status string, limit int) (cases []mig.Case, err error) {
+	rows, err := db.c.Query(`SELECT id, title, status, createdby, createdat, lastmodified
+		FROM cases WHERE ($1 = '' OR status = $1) ORDER BY lastmodified DESC LIMIT $2`,
+		status, limit)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while listing cases: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var c mig.Case
+		err = rows.Scan(&c.ID, &c.Title, &c.Status, &c.CreatedBy, &c.CreatedAt, &c.LastModified)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve case: '%v'", err)
+			return
+		}
+		cases = append(cases, c)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+		return
+	}
+	for i := range cases {
+		err = db.caseDetails(&cases[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// caseDetails retrieves the assignees, notes, actions and agents of a case
+func (db *DB) caseDetails(c *mig.Case) (err error) {
+	rows, err := db.c.Query(`SELECT investigators.id, investigators.name
+		FROM case_assignees INNER JOIN investigators ON (case_assignees.investigatorid = investigators.id)
+		WHERE case_assignees.caseid=$1 ORDER BY investigators.name`, c.ID)
+	err = scanCaseRows(rows, err, func(r *sql.Rows) error {
+		var a mig.CaseAssignee
+		err := r.Scan(&a.InvestigatorID, &a.Name)
+		c.Assignees = append(c.Assignees, a)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve case assignees: '%v'", err)
+	}
+	rows, err = db.c.Query(`SELECT case_notes.id, investigators.id, investigators.name,
+		case_notes.note, case_notes.createdat
+		FROM case_notes INNER JOIN investigators ON (case_notes.investigatorid = investigators.id)
+		WHERE case_notes.caseid=$1 ORDER BY case_notes.createdat ASC`, c.ID)
+	err = scanCaseRows(rows, err, func(r *sql.Rows) error {
+		var n mig.CaseNote
+		err := r.Scan(&n.ID, &n.InvestigatorID, &n.Author, &n.Text, &n.CreatedAt)
+		c.Notes = append(c.Notes, n)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve case notes: '%v'", err)
+	}
+	rows, err = db.c.Query(`SELECT actionid FROM case_actions WHERE caseid=$1
+		ORDER BY addedat ASC`, c.ID)
+	err = scanCaseRows(rows, err, func(r *sql.Rows) error {
+		var aid float64
+		err := r.Scan(&aid)
+		c.ActionIDs = append(c.ActionIDs, aid)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve case actions: '%v'", err)
+	}
+	rows, err = db.c.Query(`SELECT agentid, name, queueloc, addedat FROM case_agents
+		WHERE caseid=$1 ORDER BY addedat ASC`, c.ID)
+	err = scanCaseRows(rows, err, func(r *sql.Rows) error {
+		var agt mig.CaseAgent
+		err := r.Scan(&agt.AgentID, &agt.Name, &agt.QueueLoc, &agt.AddedAt)
+		c.Agents = append(c.Agents, agt)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve case agents: '%v'", err)
+	}
+	return
+}
+
+// scanCaseRows calls scan on each row returned by a query on the details of a case
+func scanCaseRows(rows *sql.Rows, qerr error, scan func(*sql.Rows) error) (err error) {
+	if rows != nil {
+		defer rows.Close()
+	}
+	if qerr != nil {
+		return qerr
+	}
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return
+		}
+	}
+	return rows.Err()
+}
+
+// AddCaseNote records a note on a case
+func (db *DB) AddCaseNote(caseid float64, n mig.CaseNote) (err error) {
+	_, err = db.c.Exec(`INSERT INTO case_notes (id, caseid, investigatorid, note, createdat)
+		VALUES ($1, $2, $3, $4, $5)`, n.ID, caseid, n.InvestigatorID, n.Text, n.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to add note to case: '%v'", err)
+	}
+	return db.touchCase(caseid)
+}
+
+// AddCaseAction attaches an action to a case
+func (db *DB) AddCaseAction(caseid, actionid float64) (err error) {
+	_, err = db.c.Exec(`INSERT INTO case_actions (caseid, actionid, addedat) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, caseid, actionid, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("Failed to attach action to case: '%v'", err)
+	}
+	return db.touchCase(caseid)
+}
+
+// RemoveCaseAction detaches an action from a case
+func (db *DB) RemoveCaseAction(caseid, actionid float64) (err error) {
+	_, err = db.c.Exec(`DELETE FROM case_actions WHERE caseid=$1 AND actionid=$2`, caseid, actionid)
+	if err != nil {
+		return fmt.Errorf("Failed to detach action from case: '%v'", err)
+	}
+	return db.touchCase(caseid)
+}
+
+// AddCaseAgent records an agent of interest in a case. An agent that shares
+// the queue location of one already recorded replaces it.
+func (db *DB) AddCaseAgent(caseid float64, agt mig.CaseAgent) (err error) {
+	_, err = db.c.Exec(`INSERT INTO case_agents (caseid, agentid, name, queueloc, addedat)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (caseid, queueloc) DO UPDATE SET agentid=$2, name=$3`,
+		caseid, agt.AgentID, agt.Name, agt.QueueLoc, agt.AddedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to add agent to case: '%v'", err)
+	}
+	return db.touchCase(caseid)
+}
+
+// RemoveCaseAgent removes the agent of interest at queueloc from a case
+func (db *DB) RemoveCaseAgent(caseid float64, queueloc string) (err error) {
+	_, err = db.c.Exec(`DELETE FROM case_agents WHERE caseid=$1 AND queueloc=$2`, caseid, queueloc)
+	if err != nil {
+		return fmt.Errorf("Failed to remove agent from case: '%v'", err)
+	}
+	return db.touchCase(caseid)
+}
+
+// touchCase sets the last modification time of a case to now
+func (db *DB) touchCase(caseid float64) (err error) {
+	_, err = db.c.Exec(`UPDATE cases SET lastmodified=$1 WHERE id=$2`, time.Now().UTC(), caseid)
+	if err != nil {
+		return fmt.Errorf("Failed to update case: '%v'", err)
+	}
+	return
+}
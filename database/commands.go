@@ -25,14 +25,14 @@ func (db *DB) CommandByID(id float64) (cmd mig.Command, err error) {
 	err = db.c.QueryRow(`SELECT commands.id, commands.status, commands.results, commands.starttime, commands.finishtime,
 		actions.id, actions.name, actions.target, actions.description, actions.threat,
 		actions.operations, actions.validfrom, actions.expireafter,
-		actions.pgpsignatures, actions.syntaxversion,
+		actions.pgpsignatures, actions.syntaxversion, actions.nonce,
 		agents.id, agents.name, agents.queueloc, agents.mode, agents.version
 		FROM commands, actions, agents
 		WHERE commands.id=$1
 		AND commands.actionid = actions.id AND commands.agentid = agents.id`, id).Scan(
 		&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &cmd.FinishTime,
 		&cmd.Action.ID, &cmd.Action.Name, &cmd.Action.Target, &jDesc, &jThreat, &jOps,
-		&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion,
+		&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion, &cmd.Action.Nonce,
 		&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.QueueLoc, &cmd.Agent.Mode, &cmd.Agent.Version)
 	if err != nil {
 		err = fmt.Errorf("Error while retrieving command: '%v'", err)
@@ -73,7 +73,7 @@ func (db *DB) CommandsByActionID(actionid float64) (commands []mig.Command, err
 	rows, err := db.c.Query(`SELECT commands.id, commands.status, commands.results, commands.starttime, commands.finishtime,
 		actions.id, actions.name, actions.target, actions.description, actions.threat,
 		actions.operations, actions.validfrom, actions.expireafter,
-		actions.pgpsignatures, actions.syntaxversion,
+		actions.pgpsignatures, actions.syntaxversion, actions.nonce,
 		agents.id, agents.name, agents.version
 		FROM commands, actions, agents
 		WHERE commands.actionid=actions.id AND commands.agentid=agents.id AND actions.id=$1`, actionid)
@@ -89,7 +89,7 @@ func (db *DB) CommandsByActionID(actionid float64) (commands []mig.Command, err
 		var cmd mig.Command
 		err = rows.Scan(&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &cmd.FinishTime,
 			&cmd.Action.ID, &cmd.Action.Name, &cmd.Action.Target, &jDesc, &jThreat, &jOps,
-			&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion,
+			&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion, &cmd.Action.Nonce,
 			&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.Version)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve command: '%v'", err)
@@ -228,9 +228,10 @@ func (db *DB) FinishCommand(cmd mig.Command) (err error) {
 	res, err := db.c.Exec(`UPDATE commands SET status=$1, results=$2, finishtime=$3
 		WHERE id=$4 AND status!=$5 AND agentid IN (
 			SELECT id FROM agents
-			WHERE agents.queueloc=$6 AND agents.pid=$7 AND status IN ('online','idle')
+			WHERE agents.queueloc=$6 AND agents.pid=$7 AND status IN ($8, $9, $10, $11)
 		)`, cmd.Status, jResults, cmd.FinishTime, cmd.ID, mig.StatusSuccess,
-		cmd.Agent.QueueLoc, cmd.Agent.PID)
+		cmd.Agent.QueueLoc, cmd.Agent.PID, mig.AgtStatusOnline, mig.AgtStatusIdle,
+		mig.AgtStatusQuarantined, mig.AgtStatusDecommissioned)
 	if err != nil {
 		return fmt.Errorf("Error while updating command: '%v'", err)
 	}
@@ -243,3 +244,63 @@ func (db *DB) FinishCommand(cmd mig.Command) (err error) {
 	}
 	return
 }
+
+// IterCommandsByActionID calls fn on each command of action actionid, along with
+// the name, environment and tags of the agent that ran it. Commands are read from
+// the database one at a time, so that large actions can be processed without
+// loading all of their results in memory. If fn returns an error, the iteration
+// stops and the error is returned.
+func (db *DB) IterCommandsByActionID(actionid float64, fn func(mig.Command) error) (err error) {
+	rows, err := db.c.Query(`SELECT commands.id, commands.status, commands.results,
+		commands.starttime, commands.finishtime, agents.id, agents.name,
+		agents.queueloc, agents.version, agents.environment, agents.tags
+		FROM commands, agents
+		WHERE commands.agentid=agents.id AND commands.actionid=$1
+		ORDER BY commands.id`, actionid)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while finding commands: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var jRes, jEnv, jTags []byte
+		var cmd mig.Command
+		err = rows.Scan(&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &cmd.FinishTime,
+			&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.QueueLoc, &cmd.Agent.Version,
+			&jEnv, &jTags)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve command: '%v'", err)
+			return
+		}
+		cmd.Action.ID = actionid
+		err = json.Unmarshal(jRes, &cmd.Results)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal command results: '%v'", err)
+			return
+		}
+		if len(jEnv) > 0 {
+			err = json.Unmarshal(jEnv, &cmd.Agent.Env)
+			if err != nil {
+				err = fmt.Errorf("Failed to unmarshal agent environment: '%v'", err)
+				return
+			}
+		}
+		if len(jTags) > 0 {
+			err = json.Unmarshal(jTags, &cmd.Agent.Tags)
+			if err != nil {
+				err = fmt.Errorf("Failed to unmarshal agent tags: '%v'", err)
+				return
+			}
+		}
+		err = fn(cmd)
+		if err != nil {
+			return
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
@@ -0,0 +1,122 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// AgentsBreakdown counts online agents by operating system, version, tag and loader
+func (db *DB) AgentsBreakdown() (breakdown []mig.AgentsBreakdown, err error) {
+	rows, err := db.c.Query(`SELECT $2::text, COALESCE(environment->>'os', ''), COUNT(*)
+			FROM agents WHERE status=$1 GROUP BY 2
+		UNION ALL SELECT $3::text, version, COUNT(*)
+			FROM agents WHERE status=$1 GROUP BY 2
+		UNION ALL SELECT $4::text, t.key || '=' || t.value, COUNT(*)
+			FROM agents, json_each_text(CASE WHEN json_typeof(tags) = 'object' THEN tags ELSE '{}'::json END) AS t
+			WHERE status=$1 GROUP BY 2
+		UNION ALL SELECT $5::text, COALESCE(loadername, ''), COUNT(*)
+			FROM agents WHERE status=$1 GROUP BY 2
+		ORDER BY 1, 3 DESC, 2`, mig.AgtStatusOnline, mig.BreakdownOS, mig.BreakdownVersion,
+		mig.BreakdownTag, mig.BreakdownLoader)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while counting agents: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var b mig.AgentsBreakdown
+		err = rows.Scan(&b.Category, &b.Value, &b.Count)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve breakdown data: '%v'", err)
+			return
+		}
+		breakdown = append(breakdown, b)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// ActionThroughput returns, for each day since pointInTime, the number of actions
+// started and of commands sent, and how many of those commands succeeded or
+// timed out. Days are ordered from the oldest to the most recent.
+func (db *DB) ActionThroughput(pointInTime time.Time) (throughput []mig.ActionThroughput, err error) {
+	rows, err := db.c.Query(`SELECT d.day, COALESCE(a.actions, 0), COALESCE(c.commands, 0),
+			COALESCE(c.succeeded, 0), COALESCE(c.timedout, 0)
+		FROM generate_series(date_trunc('day', $1::timestamptz), date_trunc('day', NOW()), '1 day') AS d(day)
+		LEFT JOIN (SELECT date_trunc('day', starttime) AS day, COUNT(*) AS actions
+			FROM actions WHERE starttime > $1 GROUP BY 1) AS a ON a.day = d.day
+		LEFT JOIN (SELECT date_trunc('day', starttime) AS day, COUNT(*) AS commands,
+			COUNT(*) FILTER (WHERE status=$2) AS succeeded,
+			COUNT(*) FILTER (WHERE status=$3) AS timedout
+			FROM commands WHERE starttime > $1 GROUP BY 1) AS c ON c.day = d.day
+		ORDER BY d.day ASC`, pointInTime, mig.StatusSuccess, mig.StatusTimeout)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while computing action throughput: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var (
+			day                                    time.Time
+			actions, commands, succeeded, timedout float64
+		)
+		err = rows.Scan(&day, &actions, &commands, &succeeded, &timedout)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve throughput data: '%v'", err)
+			return
+		}
+		throughput = append(throughput, mig.NewActionThroughput(day, actions, commands, succeeded, timedout))
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// SlowestModules returns the modules whose commands started since pointInTime took
+// the longest to complete on average, slowest first. Commands that have not returned
+// are not counted. limit controls how many modules are returned.
+func (db *DB) SlowestModules(pointInTime time.Time, limit int) (durations []mig.ModuleDuration, err error) {
+	rows, err := db.c.Query(`SELECT op->>'module', COUNT(*),
+			AVG(EXTRACT(EPOCH FROM (commands.finishtime - commands.starttime))),
+			MAX(EXTRACT(EPOCH FROM (commands.finishtime - commands.starttime)))
+		FROM commands INNER JOIN actions ON commands.actionid = actions.id,
+			json_array_elements(actions.operations) AS op
+		WHERE commands.starttime > $1 AND commands.status != $3
+			AND commands.finishtime IS NOT NULL AND commands.finishtime >= commands.starttime
+		GROUP BY 1 ORDER BY 3 DESC LIMIT $2`, pointInTime, limit, mig.StatusSent)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while computing module durations: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var md mig.ModuleDuration
+		err = rows.Scan(&md.Module, &md.Commands, &md.AverageDuration, &md.MaximumDuration)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve module duration data: '%v'", err)
+			return
+		}
+		durations = append(durations, md)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
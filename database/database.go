@@ -14,7 +14,8 @@ import (
 )
 
 type DB struct {
-	c *sql.DB
+	c   *sql.DB
+	url string
 }
 
 // NewDB constructs a new DB from a SQL database connection.
@@ -28,6 +29,7 @@ func NewDB(conn *sql.DB) DB {
 func Open(dbname, user, password, host string, port int, sslmode string) (db DB, err error) {
 	url := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
 		user, password, host, port, dbname, sslmode)
+	db.url = url
 	db.c, err = sql.Open("postgres", url)
 	if err != nil {
 		return
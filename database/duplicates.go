@@ -0,0 +1,82 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// InsertDuplicateDecision records a decision taken on duplicate agents
+func (db *DB) InsertDuplicateDecision(d mig.DuplicateDecision) (err error) {
+	jEvidence, err := json.Marshal(d.Evidence)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal duplicate agents evidence: '%v'", err)
+		return
+	}
+	_, err = db.c.Exec(`INSERT INTO agents_duplicates
+		(id, queueloc, kind, policy, decision, evidence, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, d.ID, d.QueueLoc, d.Kind, d.Policy,
+		d.Decision, jEvidence, d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to insert duplicate agents decision: '%v'", err)
+	}
+	return
+}
+
+// DuplicateDecisionsByQueue returns the last decisions taken on the duplicate
+// agents of a queue location, most recent first
+func (db *DB) DuplicateDecisionsByQueue(queueloc string, limit int) (decisions []mig.DuplicateDecision, err error) {
+	rows, err := db.c.Query(`SELECT id, queueloc, kind, policy, decision, evidence, createdat
+		FROM agents_duplicates WHERE queueloc=$1
+		ORDER BY createdat DESC LIMIT $2`, queueloc, limit)
+	return scanDuplicateDecisions(rows, err)
+}
+
+// DuplicateDecisionsSince returns the last decisions taken on duplicate agents
+// since pointInTime, most recent first
+func (db *DB) DuplicateDecisionsSince(pointInTime time.Time, limit int) (decisions []mig.DuplicateDecision, err error) {
+	rows, err := db.c.Query(`SELECT id, queueloc, kind, policy, decision, evidence, createdat
+		FROM agents_duplicates WHERE createdat > $1
+		ORDER BY createdat DESC LIMIT $2`, pointInTime, limit)
+	return scanDuplicateDecisions(rows, err)
+}
+
+func scanDuplicateDecisions(rows *sql.Rows, qerr error) (decisions []mig.DuplicateDecision, err error) {
+	if rows != nil {
+		defer rows.Close()
+	}
+	if qerr != nil {
+		err = fmt.Errorf("Error while retrieving duplicate agents decisions: '%v'", qerr)
+		return
+	}
+	for rows.Next() {
+		var (
+			d         mig.DuplicateDecision
+			jEvidence []byte
+		)
+		err = rows.Scan(&d.ID, &d.QueueLoc, &d.Kind, &d.Policy, &d.Decision, &jEvidence, &d.CreatedAt)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve duplicate agents decision: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jEvidence, &d.Evidence)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal duplicate agents evidence: '%v'", err)
+			return
+		}
+		decisions = append(decisions, d)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mozilla/mig"
+)
+
+// ActionEventsChannel is the name of the postgres notification channel
+// action events are published on
+const ActionEventsChannel = "mig_action_events"
+
+// NotifyActionEvent publishes an action event to all the processes listening
+// on the action events channel. Postgres limits the size of a notification
+// payload to 8000 bytes, so events must not carry command results.
+func (db *DB) NotifyActionEvent(ev mig.ActionEvent) (err error) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal action event: '%v'", err)
+	}
+	_, err = db.c.Exec(`SELECT pg_notify($1, $2)`, ActionEventsChannel, string(payload))
+	if err != nil {
+		return fmt.Errorf("Failed to publish action event: '%v'", err)
+	}
+	return
+}
+
+// ListenActionEvents opens a dedicated connection to the database that listens
+// for action events, and returns a channel the events are delivered on. The
+// listener reconnects automatically if the connection to the database is lost,
+// but events published while disconnected are not recovered.
+func (db *DB) ListenActionEvents() (events chan mig.ActionEvent, err error) {
+	if db.url == "" {
+		return nil, fmt.Errorf("ListenActionEvents: database connection url is unknown")
+	}
+	listener := pq.NewListener(db.url, time.Second, time.Minute, nil)
+	err = listener.Listen(ActionEventsChannel)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("ListenActionEvents: %v", err)
+	}
+	events = make(chan mig.ActionEvent, 128)
+	go func() {
+		for {
+			select {
+			case n := <-listener.Notify:
+				// a nil notification is sent after the listener
+				// reestablished a lost connection
+				if n == nil {
+					continue
+				}
+				var ev mig.ActionEvent
+				err := json.Unmarshal([]byte(n.Extra), &ev)
+				if err != nil {
+					continue
+				}
+				events <- ev
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return
+}
@@ -11,14 +11,19 @@ import (
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/mozilla/mig"
 )
 
-// ActiveInvestigators returns a slice of investigators keys marked as active
+// ActiveInvestigatorsPubKeys returns the public keys of active investigators
+// that are valid at the current time and have not been revoked
 func (db *DB) ActiveInvestigatorsPubKeys() (keys [][]byte, err error) {
-	rows, err := db.c.Query(`SELECT publickey FROM investigators WHERE status='active'
-		AND publickey IS NOT NULL`)
+	rows, err := db.c.Query(`SELECT investigator_keys.publickey
+		FROM investigator_keys, investigators
+		WHERE investigator_keys.investigatorid=investigators.id
+		AND investigators.status='active' AND investigator_keys.status='active'
+		AND investigator_keys.validfrom <= NOW()
+		AND (investigator_keys.expireafter IS NULL OR investigator_keys.expireafter > NOW())`)
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -65,14 +70,18 @@ func (db *DB) InvestigatorByID(iid float64) (inv mig.Investigator, err error) {
 }
 
 // InvestigatorByFingerprint searches the database for an investigator that
-// has a given fingerprint
+// has a given fingerprint. Keys that were rotated, revoked or have expired
+// are also searched, so the signers of past actions can still be found. The
+// PGPFingerprint and PublicKey of the investigator returned are the ones of
+// the key that matched.
 func (db *DB) InvestigatorByFingerprint(fp string) (inv mig.Investigator, err error) {
 	var perm int64
-	err = db.c.QueryRow(`SELECT investigators.id, investigators.name, investigators.pgpfingerprint,
-		investigators.publickey, investigators.status, investigators.createdat,
+	err = db.c.QueryRow(`SELECT investigators.id, investigators.name, investigator_keys.pgpfingerprint,
+		investigator_keys.publickey, investigators.status, investigators.createdat,
 		investigators.lastmodified, investigators.permissions
-		FROM investigators WHERE pgpfingerprint IS NOT NULL AND
-		LOWER(pgpfingerprint)=LOWER($1)`,
+		FROM investigators, investigator_keys
+		WHERE investigator_keys.investigatorid=investigators.id
+		AND LOWER(investigator_keys.pgpfingerprint)=LOWER($1)`,
 		fp).Scan(&inv.ID, &inv.Name, &inv.PGPFingerprint, &inv.PublicKey, &inv.Status,
 		&inv.CreatedAt, &inv.LastModified, &perm)
 	if err != nil && err != sql.ErrNoRows {
@@ -87,11 +96,163 @@ func (db *DB) InvestigatorByFingerprint(fp string) (inv mig.Investigator, err er
 	return
 }
 
+// InvestigatorKeys returns the keys of an investigator, the most recent first
+func (db *DB) InvestigatorKeys(iid float64) (keys []mig.InvestigatorKey, err error) {
+	rows, err := db.c.Query(`SELECT id, investigatorid, pgpfingerprint, publickey, status,
+		validfrom, expireafter, createdat, revokedat
+		FROM investigator_keys WHERE investigatorid=$1
+		ORDER BY validfrom DESC, id DESC`, iid)
+	if rows != nil {
+		defer rows.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("Error while listing investigator keys: '%v'", err)
+		return
+	}
+	for rows.Next() {
+		var (
+			key                    mig.InvestigatorKey
+			expireafter, revokedat pq.NullTime
+		)
+		err = rows.Scan(&key.ID, &key.InvestigatorID, &key.PGPFingerprint, &key.PublicKey,
+			&key.Status, &key.ValidFrom, &expireafter, &key.CreatedAt, &revokedat)
+		if err != nil {
+			err = fmt.Errorf("Error while retrieving investigator key: '%v'", err)
+			return
+		}
+		if expireafter.Valid {
+			key.ExpireAfter = expireafter.Time
+		}
+		if revokedat.Valid {
+			key.RevokedAt = revokedat.Time
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete investigator keys query: '%v'", err)
+	}
+	return
+}
+
+// insertInvestigatorKey stores a new key of an investigator as part of transaction tx
+func insertInvestigatorKey(tx *sql.Tx, key mig.InvestigatorKey) (kid float64, err error) {
+	var expireafter pq.NullTime
+	if !key.ExpireAfter.IsZero() {
+		expireafter = pq.NullTime{Time: key.ExpireAfter, Valid: true}
+	}
+	if key.ValidFrom.IsZero() {
+		key.ValidFrom = time.Now().UTC()
+	}
+	var newid int
+	err = tx.QueryRow(`INSERT INTO investigator_keys
+		(investigatorid, pgpfingerprint, publickey, status, validfrom, expireafter, createdat)
+		VALUES ($1, $2, $3, 'active', $4, $5, $6) RETURNING id`,
+		key.InvestigatorID, key.PGPFingerprint, key.PublicKey, key.ValidFrom, expireafter,
+		time.Now().UTC()).Scan(&newid)
+	if err != nil {
+		if err.Error() == `pq: duplicate key value violates unique constraint "investigator_keys_pgpfingerprint_idx"` {
+			return kid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+		}
+		return kid, fmt.Errorf("Failed to store investigator key: '%v'", err)
+	}
+	kid = float64(newid)
+	return
+}
+
+// RotateInvestigatorKey adds a new key to an investigator and makes it its
+// current key. The keys that were active before the rotation remain valid
+// for the duration of overlap, to let actions signed with them complete.
+func (db *DB) RotateInvestigatorKey(key mig.InvestigatorKey, overlap time.Duration) (newkey mig.InvestigatorKey, err error) {
+	if key.PGPFingerprint == "" || len(key.PublicKey) == 0 {
+		return newkey, fmt.Errorf("Investigator key must have a fingerprint and a public key")
+	}
+	if overlap < 0 {
+		return newkey, fmt.Errorf("Key rotation overlap cannot be negative")
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	retire := time.Now().UTC().Add(overlap)
+	_, err = tx.Exec(`UPDATE investigator_keys SET expireafter=$1
+		WHERE investigatorid=$2 AND status='active'
+		AND (expireafter IS NULL OR expireafter > $1)`, retire, key.InvestigatorID)
+	if err != nil {
+		err = fmt.Errorf("Failed to retire investigator keys: '%v'", err)
+		return
+	}
+	key.ID, err = insertInvestigatorKey(tx, key)
+	if err != nil {
+		return
+	}
+	res, err := tx.Exec(`UPDATE investigators SET pgpfingerprint=$1, publickey=$2, lastmodified=$3
+		WHERE id=$4`, key.PGPFingerprint, key.PublicKey, time.Now().UTC(), key.InvestigatorID)
+	if err != nil {
+		if err.Error() == `pq: duplicate key value violates unique constraint "investigators_pgpfingerprint_idx"` {
+			err = fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+			return
+		}
+		err = fmt.Errorf("Failed to update investigator key: '%v'", err)
+		return
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n != 1 {
+		err = fmt.Errorf("Failed to update investigator key: 'investigator %.0f not found'", key.InvestigatorID)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		return
+	}
+	keys, err := db.InvestigatorKeys(key.InvestigatorID)
+	if err != nil {
+		return
+	}
+	for _, k := range keys {
+		if k.ID == key.ID {
+			return k, nil
+		}
+	}
+	err = fmt.Errorf("Failed to retrieve new investigator key")
+	return
+}
+
+// RevokeInvestigatorKey revokes the key of investigator iid that has fingerprint
+// fp. A revoked key is no longer accepted, but still identifies the investigator
+// in the history of actions.
+func (db *DB) RevokeInvestigatorKey(iid float64, fp string) (err error) {
+	res, err := db.c.Exec(`UPDATE investigator_keys SET status='revoked', revokedat=$1
+		WHERE investigatorid=$2 AND LOWER(pgpfingerprint)=LOWER($3)`,
+		time.Now().UTC(), iid, fp)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke investigator key: '%v'", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if n != 1 {
+		return fmt.Errorf("No key with fingerprint '%s' found for investigator %.0f", fp, iid)
+	}
+	return
+}
+
 // Returns a set of InvestigatorAPIAuthHelper structs that the API can utilize to
-// authorize requests containing the X-MIGAPIKEY header
+// authorize requests containing the X-MIGAPIKEY header. Only the keys that were
+// created without a prefix are returned, prefixed keys are retrieved using
+// InvestigatorAPIKeyAuthHelperByPrefix.
 func (db *DB) InvestigatorAPIKeyAuthHelpers() (ret []mig.InvestigatorAPIAuthHelper, err error) {
 	rows, err := db.c.Query(`SELECT id, apikey, apisalt FROM investigators
-		WHERE apikey IS NOT NULL AND apisalt IS NOT NULL AND status='active'`)
+		WHERE apikey IS NOT NULL AND apisalt IS NOT NULL AND apikeyprefix IS NULL
+		AND status='active'`)
 	if err != nil {
 		return
 	}
@@ -111,6 +272,15 @@ func (db *DB) InvestigatorAPIKeyAuthHelpers() (ret []mig.InvestigatorAPIAuthHelp
 	return
 }
 
+// InvestigatorAPIKeyAuthHelperByPrefix returns the InvestigatorAPIAuthHelper of the
+// active investigator that owns the API key with the given prefix
+func (db *DB) InvestigatorAPIKeyAuthHelperByPrefix(prefix string) (ret mig.InvestigatorAPIAuthHelper, err error) {
+	err = db.c.QueryRow(`SELECT id, apikey, apisalt FROM investigators
+		WHERE apikeyprefix=$1 AND apikey IS NOT NULL AND apisalt IS NOT NULL
+		AND status='active'`, prefix).Scan(&ret.ID, &ret.APIKey, &ret.Salt)
+	return
+}
+
 //InvestigatorByActionID returns the list of investigators that signed a given action
 func (db *DB) InvestigatorByActionID(aid float64) (invs []mig.Investigator, err error) {
 	var perm int64
@@ -146,45 +316,62 @@ func (db *DB) InvestigatorByActionID(aid float64) (invs []mig.Investigator, err
 // InsertInvestigator creates a new investigator in the database and returns its ID,
 // or an error if the insertion failed, or if the investigator already exists
 func (db *DB) InsertInvestigator(inv mig.Investigator) (iid float64, err error) {
-	var newid int
-	err = db.c.QueryRow(`INSERT INTO investigators
-		(name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions)
-		VALUES ($1, NULLIF($2, ''), NULLIF($3, E'\\x'::bytea), 'active', $4, $5, $6)
-		RETURNING id`,
-		inv.Name, inv.PGPFingerprint, inv.PublicKey, time.Now().UTC(), time.Now().UTC(),
-		inv.Permissions.ToMask()).Scan(&newid)
-	if err != nil {
-		if err.Error() == `pq: duplicate key value violates unique constraint "investigators_pgpfingerprint_idx"` {
-			return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
-		}
-		return iid, fmt.Errorf("Failed to create investigator: '%v'", err)
-	}
-	iid = float64(newid)
-	inv, err = db.InvestigatorByID(iid)
-	if err != nil {
-		return 0, fmt.Errorf("Failed to retrieve investigator ID: '%v'", err)
-	}
-	return
+	inv.PrivateKey = nil
+	return db.insertInvestigator(inv)
 }
 
 // InsertSchedulerInvestigator creates a new migscheduler investigator in the database
 // and returns its ID, or an error if the insertion failed, or if the investigator already exists
 func (db *DB) InsertSchedulerInvestigator(inv mig.Investigator) (iid float64, err error) {
-	_, err = db.c.Exec(`INSERT INTO investigators
-		(name, pgpfingerprint, publickey, privatekey, status, createdat, lastmodified)
-		VALUES ($1, $2, $3, $4, 'active', $5, $6)`,
-		inv.Name, inv.PGPFingerprint, inv.PublicKey, inv.PrivateKey, time.Now().UTC(), time.Now().UTC())
+	inv.Permissions = mig.InvestigatorPerms{}
+	return db.insertInvestigator(inv)
+}
+
+// insertInvestigator stores an investigator and, if it has one, its public key
+func (db *DB) insertInvestigator(inv mig.Investigator) (iid float64, err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	var newid int
+	now := time.Now().UTC()
+	err = tx.QueryRow(`INSERT INTO investigators
+		(name, pgpfingerprint, publickey, privatekey, status, createdat, lastmodified, permissions)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, E'\\x'::bytea), NULLIF($4, E'\\x'::bytea),
+		'active', $5, $6, $7)
+		RETURNING id`,
+		inv.Name, inv.PGPFingerprint, inv.PublicKey, inv.PrivateKey, now, now,
+		inv.Permissions.ToMask()).Scan(&newid)
 	if err != nil {
 		if err.Error() == `pq: duplicate key value violates unique constraint "investigators_pgpfingerprint_idx"` {
-			return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+			err = fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+			return
 		}
-		return iid, fmt.Errorf("Failed to create investigator: '%v'", err)
+		err = fmt.Errorf("Failed to create investigator: '%v'", err)
+		return
 	}
-	inv, err = db.InvestigatorByFingerprint(inv.PGPFingerprint)
+	if inv.PGPFingerprint != "" && len(inv.PublicKey) > 0 {
+		_, err = insertInvestigatorKey(tx, mig.InvestigatorKey{
+			InvestigatorID: float64(newid),
+			PGPFingerprint: inv.PGPFingerprint,
+			PublicKey:      inv.PublicKey,
+			ValidFrom:      now,
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
 	if err != nil {
-		return 0, fmt.Errorf("Failed to retrieve investigator ID: '%v'", err)
+		err = fmt.Errorf("Failed to create investigator: '%v'", err)
+		return
 	}
-	iid = inv.ID
+	iid = float64(newid)
 	return
 }
 
@@ -201,17 +388,18 @@ func (db *DB) UpdateInvestigatorStatus(inv mig.Investigator) (err error) {
 	return
 }
 
-// UpdateInvestigatorAPIKey enables or disabled a standard API key for an investigator
-func (db *DB) UpdateInvestigatorAPIKey(inv mig.Investigator, key []byte, salt []byte) (err error) {
+// UpdateInvestigatorAPIKey enables or disabled a standard API key for an investigator.
+// The prefix is stored in clear and used to find the key when a request is authenticated.
+func (db *DB) UpdateInvestigatorAPIKey(inv mig.Investigator, prefix string, key []byte, salt []byte) (err error) {
 	if len(key) == 0 {
-		_, err = db.c.Exec(`UPDATE investigators SET apikey=NULL, apisalt=NULL
+		_, err = db.c.Exec(`UPDATE investigators SET apikey=NULL, apisalt=NULL, apikeyprefix=NULL
 			WHERE id=$1`, inv.ID)
 		if err != nil {
 			return err
 		}
 	} else {
-		_, err = db.c.Exec(`UPDATE investigators SET apikey=$1, apisalt=$2
-			WHERE id=$3`, key, salt, inv.ID)
+		_, err = db.c.Exec(`UPDATE investigators SET apikey=$1, apisalt=$2, apikeyprefix=$3
+			WHERE id=$4`, key, salt, prefix, inv.ID)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mozilla/mig"
+)
+
+// ACLPolicies returns the ACL policies of all modules, ordered by module name
+func (s *Store) ACLPolicies() (policies []mig.ACLPolicy, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range s.aclPolicies {
+		cp := p
+		cp.Weights = make([]mig.ACLPolicyWeight, len(p.Weights))
+		copy(cp.Weights, p.Weights)
+		sort.Slice(cp.Weights, func(i, j int) bool {
+			return cp.Weights[i].InvestigatorID < cp.Weights[j].InvestigatorID
+		})
+		policies = append(policies, cp)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Module < policies[j].Module
+	})
+	return
+}
+
+// SetACLPolicy creates the ACL policy of a module, or replaces it if it exists
+func (s *Store) SetACLPolicy(p mig.ACLPolicy) (err error) {
+	err = p.Validate()
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, w := range p.Weights {
+		if s.investigatorIndex(w.InvestigatorID) < 0 {
+			return fmt.Errorf("Failed to store ACL policy weight of investigator %.0f: 'no investigator found'",
+				w.InvestigatorID)
+		}
+	}
+	stored := p
+	stored.Weights = make([]mig.ACLPolicyWeight, len(p.Weights))
+	copy(stored.Weights, p.Weights)
+	for i := range s.aclPolicies {
+		if s.aclPolicies[i].Module == p.Module {
+			s.aclPolicies[i] = stored
+			return
+		}
+	}
+	s.aclPolicies = append(s.aclPolicies, stored)
+	return
+}
+
+// DeleteACLPolicy removes the ACL policy of a module
+func (s *Store) DeleteACLPolicy(module string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.aclPolicies {
+		if s.aclPolicies[i].Module == module {
+			s.aclPolicies = append(s.aclPolicies[:i], s.aclPolicies[i+1:]...)
+			return
+		}
+	}
+	return fmt.Errorf("No ACL policy found for module '%s'", module)
+}
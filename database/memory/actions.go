@@ -0,0 +1,249 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+func (s *Store) actionIndex(id float64) int {
+	for i, a := range s.actions {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// LastActions retrieves the last X actions by time
+func (s *Store) LastActions(limit int) (actions []mig.Action, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, a := range s.actions {
+		a.Counters = s.actionCounters(a.ID)
+		actions = append(actions, a)
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].StartTime.After(actions[j].StartTime)
+	})
+	if len(actions) > limit {
+		actions = actions[:limit]
+	}
+	return
+}
+
+// ActionByID retrieves an action using its ID
+// If the action is not found, the returned action will have ID -1
+func (s *Store) ActionByID(id float64) (a mig.Action, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(id)
+	if i < 0 {
+		a.ID = -1
+		err = fmt.Errorf("Error while retrieving action: 'no action found'")
+		return
+	}
+	a = s.actions[i]
+	a.Counters = s.actionCounters(id)
+	return
+}
+
+// ActionMetaByID retrieves the metadata fields of an action using its ID
+func (s *Store) ActionMetaByID(id float64) (a mig.Action, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(id)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving action: 'no action found'")
+		return
+	}
+	orig := s.actions[i]
+	a = mig.Action{ID: orig.ID, Name: orig.Name, ValidFrom: orig.ValidFrom,
+		ExpireAfter: orig.ExpireAfter, StartTime: orig.StartTime, FinishTime: orig.FinishTime,
+		LastUpdateTime: orig.LastUpdateTime, Status: orig.Status}
+	return
+}
+
+// InsertAction writes an action into the store
+func (s *Store) InsertAction(a mig.Action) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.actionIndex(a.ID) >= 0 {
+		return fmt.Errorf("Failed to store action: 'action %.0f already exists'", a.ID)
+	}
+	if a.Nonce != "" {
+		for _, stored := range s.actions {
+			if stored.Nonce == a.Nonce {
+				return fmt.Errorf("Failed to store action: 'nonce %s is already used by action %.0f'", a.Nonce, stored.ID)
+			}
+		}
+	}
+	a.Counters = mig.ActionCounters{}
+	s.actions = append(s.actions, a)
+	return
+}
+
+// UpdateAction stores updated action fields
+func (s *Store) UpdateAction(a mig.Action) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(a.ID)
+	if i < 0 {
+		return
+	}
+	s.actions[i].StartTime = a.StartTime
+	s.actions[i].LastUpdateTime = a.LastUpdateTime
+	s.actions[i].Status = a.Status
+	return
+}
+
+// InsertOrUpdateAction looks for an existing action and update it,
+// or insert a new one if none is found
+func (s *Store) InsertOrUpdateAction(a mig.Action) (inserted bool, err error) {
+	s.lock.Lock()
+	exists := s.actionIndex(a.ID) >= 0
+	s.lock.Unlock()
+	if !exists {
+		return true, s.InsertAction(a)
+	}
+	return false, s.UpdateAction(a)
+}
+
+// UpdateActionStatus updates the status of an action
+func (s *Store) UpdateActionStatus(a mig.Action) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(a.ID)
+	if i >= 0 {
+		s.actions[i].Status = a.Status
+	}
+	return
+}
+
+// UpdateRunningAction stores updated time and counters on a running action
+func (s *Store) UpdateRunningAction(a mig.Action) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(a.ID)
+	if i >= 0 {
+		s.actions[i].LastUpdateTime = a.LastUpdateTime
+	}
+	return
+}
+
+// FinishAction updates the action fields to mark it as done
+func (s *Store) FinishAction(a mig.Action) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.actionIndex(a.ID)
+	if i >= 0 {
+		s.actions[i].FinishTime = time.Now()
+		s.actions[i].LastUpdateTime = a.LastUpdateTime
+		s.actions[i].Status = "completed"
+	}
+	return
+}
+
+// InsertSignature maps an investigator to an action and a signature
+func (s *Store) InsertSignature(aid, iid float64, sig string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.signatures = append(s.signatures, signature{actionID: aid, investigatorID: iid, pgpSignature: sig})
+	return
+}
+
+// GetActionCounters counts the commands of an action by status
+func (s *Store) GetActionCounters(aid float64) (counters mig.ActionCounters, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.actionCounters(aid), nil
+}
+
+func (s *Store) actionCounters(aid float64) (counters mig.ActionCounters) {
+	for _, cmd := range s.commands {
+		if cmd.Action.ID != aid {
+			continue
+		}
+		switch cmd.Status {
+		case mig.StatusSent:
+			counters.InFlight++
+			counters.Sent++
+		case mig.StatusSuccess:
+			counters.Success++
+			counters.Done++
+			counters.Sent++
+		case mig.StatusCancelled:
+			counters.Cancelled++
+			counters.Done++
+			counters.Sent++
+		case mig.StatusExpired:
+			counters.Expired++
+			counters.Done++
+			counters.Sent++
+		case mig.StatusFailed:
+			counters.Failed++
+			counters.Done++
+			counters.Sent++
+		case mig.StatusTimeout:
+			counters.TimeOut++
+			counters.Done++
+			counters.Sent++
+		case mig.StatusResourceLimit:
+			counters.ResourceLimit++
+			counters.Done++
+			counters.Sent++
+		}
+	}
+	return
+}
+
+// SetupRunnableActions marks pending actions that are valid now as scheduled,
+// and returns them
+func (s *Store) SetupRunnableActions() (actions []mig.Action, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for i, a := range s.actions {
+		if a.Status != "pending" || !a.ValidFrom.Before(now) || !a.ExpireAfter.After(now) {
+			continue
+		}
+		s.actions[i].Status = "scheduled"
+		a.Status = "scheduled"
+		actions = append(actions, a)
+	}
+	return
+}
+
+// NotifyActionEvent delivers an action event to all the listeners of the store.
+// Events are dropped for listeners that are not keeping up.
+func (s *Store) NotifyActionEvent(ev mig.ActionEvent) (err error) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.listeners {
+		select {
+		case l <- ev:
+		default:
+		}
+	}
+	return
+}
+
+// ListenActionEvents returns a channel action events are delivered on
+func (s *Store) ListenActionEvents() (events chan mig.ActionEvent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	events = make(chan mig.ActionEvent, 128)
+	s.listeners = append(s.listeners, events)
+	return
+}
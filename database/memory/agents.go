@@ -0,0 +1,504 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// AgentByQueueAndPID returns a single agent that is located at a given queueloc and has a given PID
+func (s *Store) AgentByQueueAndPID(queueloc string, pid int) (agent mig.Agent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, agt := range s.agents {
+		if agt.QueueLoc == queueloc && agt.PID == pid && agt.Status != mig.AgtStatusOffline {
+			return agt, nil
+		}
+	}
+	err = fmt.Errorf("Error while retrieving agent: 'no agent found'")
+	return
+}
+
+// AgentByID returns a single agent identified by its ID
+func (s *Store) AgentByID(id float64) (agent mig.Agent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.agentIndex(id)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving agent: 'no agent found'")
+		return
+	}
+	return s.agents[i], nil
+}
+
+func (s *Store) agentIndex(id float64) int {
+	for i, agt := range s.agents {
+		if agt.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// AgentsActiveSince returns an array of Agents that have sent a heartbeat between
+// a point in time and now
+func (s *Store) AgentsActiveSince(pointInTime time.Time) (agents []mig.Agent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, agt := range s.agents {
+		if agt.HeartBeatTS.Before(pointInTime) || agt.HeartBeatTS.After(now) {
+			continue
+		}
+		key := agt.QueueLoc + "\x00" + agt.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		agents = append(agents, mig.Agent{QueueLoc: agt.QueueLoc, Name: agt.Name})
+	}
+	return
+}
+
+// InsertAgent creates a new agent in the store
+func (s *Store) InsertAgent(agt mig.Agent) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.insertAgent(agt)
+	return
+}
+
+func (s *Store) insertAgent(agt mig.Agent) {
+	agt.ID = mig.GenID()
+	agt.LoaderName = ""
+	for _, l := range s.loaders {
+		if l.queueLoc != "" && l.queueLoc == agt.QueueLoc {
+			agt.LoaderName = l.entry.Name
+			break
+		}
+	}
+	// new agent instances of quarantined or decommissioned endpoints inherit
+	// the lifecycle status of the endpoint
+	agt.LifecycleTS = time.Time{}
+	for _, other := range s.agents {
+		if other.QueueLoc == agt.QueueLoc && isLifecycleStatus(other.Status) &&
+			!other.LifecycleTS.Before(agt.LifecycleTS) {
+			agt.Status = other.Status
+			agt.LifecycleTS = other.LifecycleTS
+		}
+	}
+	s.agents = append(s.agents, agt)
+}
+
+// isLifecycleStatus returns true if status is set by investigators and kept
+// across heartbeats
+func isLifecycleStatus(status string) bool {
+	return status == mig.AgtStatusQuarantined || status == mig.AgtStatusDecommissioned
+}
+
+// UpdateAgentHeartbeat updates the heartbeat timestamp of an agent and sets it
+// online, unless the agent is quarantined or decommissioned
+func (s *Store) UpdateAgentHeartbeat(agt mig.Agent) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.agentIndex(agt.ID)
+	if i < 0 {
+		return
+	}
+	if !isLifecycleStatus(s.agents[i].Status) {
+		s.agents[i].Status = mig.AgtStatusOnline
+	}
+	s.agents[i].HeartBeatTS = agt.HeartBeatTS
+	return
+}
+
+// ReplaceRefreshedAgent marks an existing agent offline and inserts a new agent
+// with newer environment information in its place
+func (s *Store) ReplaceRefreshedAgent(agt mig.Agent) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// the new agent is inserted first to inherit the lifecycle status of the old one
+	i := s.agentIndex(agt.ID)
+	s.insertAgent(agt)
+	if i >= 0 {
+		s.agents[i].Status = mig.AgtStatusOffline
+	}
+	return
+}
+
+// ListMultiAgentsQueues retrieves an array of queues that have more than one active agent
+func (s *Store) ListMultiAgentsQueues(pointInTime time.Time) (queues []string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	count := make(map[string]int)
+	for _, agt := range s.agents {
+		if agt.HeartBeatTS.After(pointInTime) && agt.Mode != "checkin" {
+			count[agt.QueueLoc]++
+		}
+	}
+	for q, c := range count {
+		if c > 1 {
+			queues = append(queues, q)
+		}
+	}
+	sort.Strings(queues)
+	return
+}
+
+// ActiveAgentsByQueue retrieves an array of agents identified by their QueueLoc value.
+// Only the fields the postgres store selects are returned, so that callers
+// relying on other fields fail here as well.
+func (s *Store) ActiveAgentsByQueue(queueloc string, pointInTime time.Time) (agents []mig.Agent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, agt := range s.agents {
+		if agt.HeartBeatTS.After(pointInTime) && agt.QueueLoc == queueloc &&
+			agt.Status != mig.AgtStatusOffline {
+			agents = append(agents, mig.Agent{ID: agt.ID, Name: agt.Name, QueueLoc: agt.QueueLoc,
+				Mode: agt.Mode, Version: agt.Version, PID: agt.PID, StartTime: agt.StartTime,
+				DestructionTime: agt.DestructionTime, HeartBeatTS: agt.HeartBeatTS,
+				RefreshTS: agt.RefreshTS, Status: agt.Status, Env: agt.Env})
+		}
+	}
+	return
+}
+
+// ActiveAgentsByTarget returns the online and idle agents that match a target,
+// one per queue location
+func (s *Store) ActiveAgentsByTarget(target string) (agents []mig.Agent, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	seen := make(map[string]bool)
+	for _, agt := range s.agents {
+		if agt.Status != mig.AgtStatusOnline && agt.Status != mig.AgtStatusIdle {
+			continue
+		}
+		if seen[agt.QueueLoc] {
+			continue
+		}
+		cols, jsonCols := agentColumns(agt)
+		var ok bool
+		ok, err = matchTarget(target, cols, jsonCols)
+		if err != nil {
+			return nil, fmt.Errorf("Error while finding agents: '%v'", err)
+		}
+		if ok {
+			seen[agt.QueueLoc] = true
+			agents = append(agents, agt)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].QueueLoc < agents[j].QueueLoc })
+	return
+}
+
+// IterActiveAgentsByTarget calls fn on each agent that matches a target. If fn
+// returns an error, the iteration stops and the error is returned.
+func (s *Store) IterActiveAgentsByTarget(target string, fn func(mig.Agent) error) (err error) {
+	agents, err := s.ActiveAgentsByTarget(target)
+	if err != nil {
+		return
+	}
+	for _, agt := range agents {
+		err = fn(agt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// MarkAgentDestroyed updates the status and destructiontime of an agent
+func (s *Store) MarkAgentDestroyed(agent mig.Agent) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.agentIndex(agent.ID)
+	if i < 0 {
+		return
+	}
+	s.agents[i].DestructionTime = time.Now()
+	s.agents[i].Status = mig.AgtStatusDestroyed
+	return
+}
+
+// SetEndpointLifecycle sets the status of the agents of the endpoint identified
+// by queueloc that are in one of the statuses listed in from, and records the
+// time of the change. It returns the number of agents updated.
+func (s *Store) SetEndpointLifecycle(queueloc, status string, from []string) (count float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for i := range s.agents {
+		if s.agents[i].QueueLoc != queueloc {
+			continue
+		}
+		for _, st := range from {
+			if s.agents[i].Status == st {
+				s.agents[i].Status = status
+				s.agents[i].LifecycleTS = now
+				count++
+				break
+			}
+		}
+	}
+	return
+}
+
+// ArchiveDecommissionedAgents moves the agents that were decommissioned before
+// pointInTime, and their commands, out of the store. It returns the number of
+// agents archived.
+func (s *Store) ArchiveDecommissionedAgents(pointInTime time.Time) (count float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	archived := make(map[float64]bool)
+	var agents []mig.Agent
+	for _, agt := range s.agents {
+		if agt.Status == mig.AgtStatusDecommissioned && agt.LifecycleTS.Before(pointInTime) {
+			agt.Status = mig.AgtStatusArchived
+			s.archivedAgents = append(s.archivedAgents, agt)
+			archived[agt.ID] = true
+			continue
+		}
+		agents = append(agents, agt)
+	}
+	s.agents = agents
+	var commands []mig.Command
+	for _, cmd := range s.commands {
+		if archived[cmd.Agent.ID] {
+			s.archivedCommands = append(s.archivedCommands, cmd)
+			continue
+		}
+		commands = append(commands, cmd)
+	}
+	s.commands = commands
+	return float64(len(archived)), nil
+}
+
+// MarkOfflineAgents updates the status of idle agents that have not sent a heartbeat since pointInTime
+func (s *Store) MarkOfflineAgents(pointInTime time.Time) (err error) {
+	s.setStatusBefore(pointInTime, mig.AgtStatusIdle, mig.AgtStatusOffline)
+	return
+}
+
+// MarkIdleAgents updates the status of online agents that have not sent a heartbeat since pointInTime
+func (s *Store) MarkIdleAgents(pointInTime time.Time) (err error) {
+	s.setStatusBefore(pointInTime, mig.AgtStatusOnline, mig.AgtStatusIdle)
+	return
+}
+
+func (s *Store) setStatusBefore(pointInTime time.Time, from, to string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.agents {
+		if s.agents[i].HeartBeatTS.Before(pointInTime) && s.agents[i].Status == from {
+			s.agents[i].Status = to
+		}
+	}
+}
+
+// GetAgentsStats retrieves the latest agents statistics. limit controls how many rows
+// of statistics are returned
+func (s *Store) GetAgentsStats(limit int) (stats []mig.AgentsStats, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.agentsStats) - 1; i >= 0 && len(stats) < limit; i-- {
+		stats = append(stats, s.agentsStats[i])
+	}
+	return
+}
+
+// AgentsStatsSince retrieves the agents statistics stored after pointInTime,
+// ordered from the oldest to the most recent
+func (s *Store) AgentsStatsSince(pointInTime time.Time) (stats []mig.AgentsStats, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, st := range s.agentsStats {
+		if st.Timestamp.After(pointInTime) {
+			stats = append(stats, st)
+		}
+	}
+	return
+}
+
+// StoreAgentsStats store a new row of agents statistics and sets the timestamp to the current time
+func (s *Store) StoreAgentsStats(stats mig.AgentsStats) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats.Timestamp = time.Now().UTC()
+	s.agentsStats = append(s.agentsStats, stats)
+	return
+}
+
+// queuesWithStatus returns the queue locations that have at least one agent in
+// one of the given statuses
+func (s *Store) queuesWithStatus(status ...string) map[string]int {
+	ret := make(map[string]int)
+	for _, agt := range s.agents {
+		for _, st := range status {
+			if agt.Status == st {
+				ret[agt.QueueLoc]++
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func sumByVersion(agents []mig.Agent) (sum []mig.AgentsVersionsSum) {
+	count := make(map[string]float64)
+	for _, agt := range agents {
+		count[agt.Version]++
+	}
+	for v, c := range count {
+		sum = append(sum, mig.AgentsVersionsSum{Version: v, Count: c})
+	}
+	sort.Slice(sum, func(i, j int) bool { return sum[i].Version < sum[j].Version })
+	return
+}
+
+// SumOnlineAgentsByVersion retrieves a sum of online agents grouped by version
+func (s *Store) SumOnlineAgentsByVersion() (sum []mig.AgentsVersionsSum, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var agents []mig.Agent
+	for _, agt := range s.agents {
+		if agt.Status == mig.AgtStatusOnline {
+			agents = append(agents, agt)
+		}
+	}
+	return sumByVersion(agents), nil
+}
+
+// SumIdleAgentsByVersion retrieves a sum of idle agents grouped by version
+// and excludes endpoints where an online agent is running
+func (s *Store) SumIdleAgentsByVersion() (sum []mig.AgentsVersionsSum, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	online := s.queuesWithStatus(mig.AgtStatusOnline)
+	var agents []mig.Agent
+	for _, agt := range s.agents {
+		if agt.Status == mig.AgtStatusIdle && online[agt.QueueLoc] == 0 {
+			agents = append(agents, agt)
+		}
+	}
+	return sumByVersion(agents), nil
+}
+
+// CountOnlineEndpoints retrieves a count of unique endpoints that have online agents
+func (s *Store) CountOnlineEndpoints() (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return float64(len(s.queuesWithStatus(mig.AgtStatusOnline))), nil
+}
+
+// CountIdleEndpoints retrieves a count of unique endpoints that have idle agents
+// and do not have an online agent
+func (s *Store) CountIdleEndpoints() (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	online := s.queuesWithStatus(mig.AgtStatusOnline)
+	for q := range s.queuesWithStatus(mig.AgtStatusIdle) {
+		if online[q] == 0 {
+			sum++
+		}
+	}
+	return
+}
+
+// CountNewEndpoints retrieves a count of endpoints that started after recent and
+// did not send a heartbeat between old and recent. Decommissioned endpoints are
+// not counted.
+func (s *Store) CountNewEndpoints(recent, old time.Time) (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	known := make(map[string]bool)
+	for _, agt := range s.agents {
+		if agt.HeartBeatTS.After(old) && agt.HeartBeatTS.Before(recent) {
+			known[agt.QueueLoc] = true
+		}
+	}
+	counted := make(map[string]bool)
+	for _, agt := range s.agents {
+		if agt.StartTime.After(recent) && !known[agt.QueueLoc] && !counted[agt.QueueLoc] &&
+			agt.Status != mig.AgtStatusDecommissioned {
+			counted[agt.QueueLoc] = true
+			sum++
+		}
+	}
+	return
+}
+
+// CountEndpointsByStatus retrieves a count of unique endpoints that have agents in status
+func (s *Store) CountEndpointsByStatus(status string) (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return float64(len(s.queuesWithStatus(status))), nil
+}
+
+// CountDoubleAgents counts the number of endpoints that run more than one agent
+func (s *Store) CountDoubleAgents() (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.queuesWithStatus(mig.AgtStatusOnline) {
+		if c > 1 {
+			sum++
+		}
+	}
+	return
+}
+
+// CountDisappearedEndpoints a count of endpoints that have disappeared over a given period.
+// Quarantined and decommissioned endpoints have not disappeared, and are not counted.
+func (s *Store) CountDisappearedEndpoints(pointInTime time.Time) (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return float64(len(s.disappearedEndpoints(pointInTime, "",
+		mig.AgtStatusQuarantined, mig.AgtStatusDecommissioned))), nil
+}
+
+// GetDisappearedEndpoints retrieves a list of queues from endpoints that are no longer active
+func (s *Store) GetDisappearedEndpoints(oldest time.Time) (queues []string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.disappearedEndpoints(oldest, mig.AgtStatusOffline), nil
+}
+
+// disappearedEndpoints returns the queues of the agents that sent a heartbeat
+// after pointInTime, but have no online or idle agent anymore, nor an agent in
+// one of the statuses in exclude. If status is set, only agents in that status
+// are considered.
+func (s *Store) disappearedEndpoints(pointInTime time.Time, status string, exclude ...string) (queues []string) {
+	active := s.queuesWithStatus(append([]string{mig.AgtStatusOnline, mig.AgtStatusIdle}, exclude...)...)
+	seen := make(map[string]bool)
+	for _, agt := range s.agents {
+		if status != "" && agt.Status != status {
+			continue
+		}
+		if agt.HeartBeatTS.After(pointInTime) && active[agt.QueueLoc] == 0 && !seen[agt.QueueLoc] {
+			seen[agt.QueueLoc] = true
+			queues = append(queues, agt.QueueLoc)
+		}
+	}
+	sort.Strings(queues)
+	return
+}
+
+// CountFlappingEndpoints a count of endpoints that have restarted their agent recently
+func (s *Store) CountFlappingEndpoints() (sum float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.queuesWithStatus(mig.AgtStatusOnline, mig.AgtStatusIdle) {
+		if c > 1 {
+			sum++
+		}
+	}
+	return
+}
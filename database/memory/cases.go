@@ -0,0 +1,222 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// caseIndex returns the index of case id in the store, or -1
+func (s *Store) caseIndex(id float64) int {
+	for i, c := range s.cases {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// inCase returns true if action aid is attached to case caseid
+func (s *Store) inCase(caseid, aid float64) bool {
+	i := s.caseIndex(caseid)
+	return i >= 0 && s.cases[i].HasAction(aid)
+}
+
+// copyCase returns a copy of a stored case, with the names of its assignees
+// and of the authors of its notes resolved from the investigators
+func (s *Store) copyCase(c mig.Case) mig.Case {
+	name := func(iid float64) string {
+		if i := s.investigatorIndex(iid); i >= 0 {
+			return s.investigators[i].inv.Name
+		}
+		return ""
+	}
+	ret := c
+	ret.Assignees = nil
+	for _, a := range c.Assignees {
+		ret.Assignees = append(ret.Assignees, mig.CaseAssignee{InvestigatorID: a.InvestigatorID, Name: name(a.InvestigatorID)})
+	}
+	sort.SliceStable(ret.Assignees, func(i, j int) bool {
+		return ret.Assignees[i].Name < ret.Assignees[j].Name
+	})
+	ret.Notes = nil
+	for _, n := range c.Notes {
+		n.Author = name(n.InvestigatorID)
+		ret.Notes = append(ret.Notes, n)
+	}
+	ret.ActionIDs = append([]float64(nil), c.ActionIDs...)
+	ret.Agents = append([]mig.CaseAgent(nil), c.Agents...)
+	return ret
+}
+
+// InsertCase stores a new case and its assignees
+func (s *Store) InsertCase(c mig.Case) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.caseIndex(c.ID) >= 0 {
+		return fmt.Errorf("Failed to insert case: duplicate id %.0f", c.ID)
+	}
+	if s.investigatorIndex(c.CreatedBy) < 0 {
+		return fmt.Errorf("Failed to insert case: unknown investigator %.0f", c.CreatedBy)
+	}
+	err = s.checkAssignees(c)
+	if err != nil {
+		return
+	}
+	stored := mig.Case{ID: c.ID, Title: c.Title, Status: c.Status, CreatedBy: c.CreatedBy,
+		CreatedAt: c.CreatedAt, LastModified: c.LastModified}
+	stored.Assignees = append(stored.Assignees, c.Assignees...)
+	s.cases = append(s.cases, stored)
+	return
+}
+
+// UpdateCase updates the title, status and assignees of a case
+func (s *Store) UpdateCase(c mig.Case) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.caseIndex(c.ID)
+	if i < 0 {
+		return fmt.Errorf("Failed to update case, 0 rows affected")
+	}
+	err = s.checkAssignees(c)
+	if err != nil {
+		return
+	}
+	s.cases[i].Title = c.Title
+	s.cases[i].Status = c.Status
+	s.cases[i].Assignees = append([]mig.CaseAssignee(nil), c.Assignees...)
+	s.cases[i].LastModified = time.Now().UTC()
+	return
+}
+
+func (s *Store) checkAssignees(c mig.Case) error {
+	for _, a := range c.Assignees {
+		if s.investigatorIndex(a.InvestigatorID) < 0 {
+			return fmt.Errorf("Failed to assign investigator %.0f to case: unknown investigator", a.InvestigatorID)
+		}
+	}
+	return nil
+}
+
+// CaseByID retrieves a case with its assignees, notes, actions and agents
+func (s *Store) CaseByID(id float64) (c mig.Case, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.caseIndex(id)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving case: 'sql: no rows in result set'")
+		return
+	}
+	return s.copyCase(s.cases[i]), nil
+}
+
+// Cases returns the last modified cases, most recent first. If status is set,
+// only cases with this status are returned.
+func (s *Store) Cases(status string, limit int) (cases []mig.Case, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.cases {
+		if status == "" || c.Status == status {
+			cases = append(cases, s.copyCase(c))
+		}
+	}
+	sort.SliceStable(cases, func(i, j int) bool {
+		return cases[i].LastModified.After(cases[j].LastModified)
+	})
+	if len(cases) > limit {
+		cases = cases[:limit]
+	}
+	return
+}
+
+// updateCase applies fn to case caseid and sets its last modification time
+func (s *Store) updateCase(caseid float64, fn func(c *mig.Case) error) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.caseIndex(caseid)
+	if i < 0 {
+		return fmt.Errorf("Failed to update case: unknown case %.0f", caseid)
+	}
+	err = fn(&s.cases[i])
+	if err != nil {
+		return
+	}
+	s.cases[i].LastModified = time.Now().UTC()
+	return
+}
+
+// AddCaseNote records a note on a case
+func (s *Store) AddCaseNote(caseid float64, n mig.CaseNote) error {
+	return s.updateCase(caseid, func(c *mig.Case) error {
+		if s.investigatorIndex(n.InvestigatorID) < 0 {
+			return fmt.Errorf("Failed to add note to case: unknown investigator %.0f", n.InvestigatorID)
+		}
+		c.Notes = append(c.Notes, n)
+		return nil
+	})
+}
+
+// AddCaseAction attaches an action to a case
+func (s *Store) AddCaseAction(caseid, actionid float64) error {
+	return s.updateCase(caseid, func(c *mig.Case) error {
+		if s.actionIndex(actionid) < 0 {
+			return fmt.Errorf("Failed to attach action to case: unknown action %.0f", actionid)
+		}
+		if !c.HasAction(actionid) {
+			c.ActionIDs = append(c.ActionIDs, actionid)
+		}
+		return nil
+	})
+}
+
+// RemoveCaseAction detaches an action from a case
+func (s *Store) RemoveCaseAction(caseid, actionid float64) error {
+	return s.updateCase(caseid, func(c *mig.Case) error {
+		var ids []float64
+		for _, id := range c.ActionIDs {
+			if id != actionid {
+				ids = append(ids, id)
+			}
+		}
+		c.ActionIDs = ids
+		return nil
+	})
+}
+
+// AddCaseAgent records an agent of interest in a case. An agent that shares
+// the queue location of one already recorded replaces it.
+func (s *Store) AddCaseAgent(caseid float64, agt mig.CaseAgent) error {
+	return s.updateCase(caseid, func(c *mig.Case) error {
+		for i := range c.Agents {
+			if c.Agents[i].QueueLoc == agt.QueueLoc {
+				c.Agents[i].AgentID = agt.AgentID
+				c.Agents[i].Name = agt.Name
+				return nil
+			}
+		}
+		c.Agents = append(c.Agents, agt)
+		return nil
+	})
+}
+
+// RemoveCaseAgent removes the agent of interest at queueloc from a case
+func (s *Store) RemoveCaseAgent(caseid float64, queueloc string) error {
+	return s.updateCase(caseid, func(c *mig.Case) error {
+		var agents []mig.CaseAgent
+		for _, agt := range c.Agents {
+			if agt.QueueLoc != queueloc {
+				agents = append(agents, agt)
+			}
+		}
+		c.Agents = agents
+		return nil
+	})
+}
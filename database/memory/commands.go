@@ -0,0 +1,197 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/mozilla/mig"
+)
+
+func (s *Store) commandIndex(id float64) int {
+	for i, cmd := range s.commands {
+		if cmd.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// joinCommand fills the action and agent of a stored command, the way the
+// database joins the commands table with the actions and agents tables. It
+// returns false if the action or the agent of the command do not exist.
+func (s *Store) joinCommand(cmd mig.Command) (mig.Command, bool) {
+	ai := s.actionIndex(cmd.Action.ID)
+	gi := s.agentIndex(cmd.Agent.ID)
+	if ai < 0 || gi < 0 {
+		return cmd, false
+	}
+	cmd.Action = s.actions[ai]
+	cmd.Action.Counters = mig.ActionCounters{}
+	cmd.Agent = s.agents[gi]
+	return cmd, true
+}
+
+// copyResults returns a deep copy of the results of a command, so callers
+// cannot modify the stored results
+func copyResults(cmd mig.Command) (mig.Command, error) {
+	if cmd.Results == nil {
+		return cmd, nil
+	}
+	buf, err := json.Marshal(cmd.Results)
+	if err != nil {
+		return cmd, fmt.Errorf("Failed to marshal results: '%v'", err)
+	}
+	cmd.Results = nil
+	err = json.Unmarshal(buf, &cmd.Results)
+	if err != nil {
+		return cmd, fmt.Errorf("Failed to unmarshal command results: '%v'", err)
+	}
+	return cmd, nil
+}
+
+// CommandByID retrieves a command using its ID
+func (s *Store) CommandByID(id float64) (cmd mig.Command, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.commandIndex(id)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving command: 'no command found'")
+		return
+	}
+	cmd, ok := s.joinCommand(s.commands[i])
+	if !ok {
+		err = fmt.Errorf("Error while retrieving command: 'no command found'")
+		return
+	}
+	return copyResults(cmd)
+}
+
+// CommandsByActionID retrieves the commands of an action
+func (s *Store) CommandsByActionID(actionid float64) (commands []mig.Command, err error) {
+	err = s.IterCommandsByActionID(actionid, func(cmd mig.Command) error {
+		commands = append(commands, cmd)
+		return nil
+	})
+	return
+}
+
+// IterCommandsByActionID calls fn on each command of action actionid, ordered by ID.
+// If fn returns an error, the iteration stops and the error is returned.
+func (s *Store) IterCommandsByActionID(actionid float64, fn func(mig.Command) error) (err error) {
+	s.lock.Lock()
+	var commands []mig.Command
+	for _, cmd := range s.commands {
+		if cmd.Action.ID != actionid {
+			continue
+		}
+		cmd, ok := s.joinCommand(cmd)
+		if !ok {
+			continue
+		}
+		cmd, err = copyResults(cmd)
+		if err != nil {
+			s.lock.Unlock()
+			return
+		}
+		commands = append(commands, cmd)
+	}
+	// fn is called without holding the lock, so it can use the store
+	s.lock.Unlock()
+	sort.Slice(commands, func(i, j int) bool { return commands[i].ID < commands[j].ID })
+	for _, cmd := range commands {
+		err = fn(cmd)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// InsertCommand writes a command sent to agent agt into the store
+func (s *Store) InsertCommand(cmd mig.Command, agt mig.Agent) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.commandIndex(cmd.ID) >= 0 {
+		return fmt.Errorf("Error while inserting command: 'command %.0f already exists'", cmd.ID)
+	}
+	cmd.Agent = mig.Agent{ID: agt.ID}
+	cmd.Action = mig.Action{ID: cmd.Action.ID}
+	cmd, err = copyResults(cmd)
+	if err != nil {
+		return
+	}
+	s.commands = append(s.commands, cmd)
+	return
+}
+
+// InsertCommands writes an array of commands into the store
+func (s *Store) InsertCommands(cmds []mig.Command) (insertCount int64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, cmd := range cmds {
+		if s.commandIndex(cmd.ID) >= 0 {
+			return insertCount, fmt.Errorf("Error while inserting commands: 'command %.0f already exists'", cmd.ID)
+		}
+	}
+	for _, cmd := range cmds {
+		cmd.Agent = mig.Agent{ID: cmd.Agent.ID}
+		cmd.Action = mig.Action{ID: cmd.Action.ID}
+		cmd.FinishTime = futureDate
+		cmd, err = copyResults(cmd)
+		if err != nil {
+			return
+		}
+		s.commands = append(s.commands, cmd)
+		insertCount++
+	}
+	return
+}
+
+// UpdateSentCommand updates the status of a command, unless its status is already
+// set to 'success'
+func (s *Store) UpdateSentCommand(cmd mig.Command) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.commandIndex(cmd.ID)
+	if i < 0 || s.commands[i].Status == mig.StatusSuccess {
+		return fmt.Errorf("Failed to update command status correctly, 0 rows affected")
+	}
+	s.commands[i].Status = cmd.Status
+	return
+}
+
+// FinishCommand stores the results of a command unless its status is already set
+// to 'success', or the agent that returned it is no longer active
+func (s *Store) FinishCommand(cmd mig.Command) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.commandIndex(cmd.ID)
+	if i < 0 || s.commands[i].Status == mig.StatusSuccess {
+		return fmt.Errorf("Failed to finish command status correctly, 0 rows affected")
+	}
+	gi := s.agentIndex(s.commands[i].Agent.ID)
+	if gi < 0 {
+		return fmt.Errorf("Failed to finish command status correctly, 0 rows affected")
+	}
+	agt := s.agents[gi]
+	if agt.QueueLoc != cmd.Agent.QueueLoc || agt.PID != cmd.Agent.PID ||
+		(agt.Status != mig.AgtStatusOnline && agt.Status != mig.AgtStatusIdle &&
+			!isLifecycleStatus(agt.Status)) {
+		return fmt.Errorf("Failed to finish command status correctly, 0 rows affected")
+	}
+	cmd, err = copyResults(cmd)
+	if err != nil {
+		return
+	}
+	s.commands[i].Status = cmd.Status
+	s.commands[i].Results = cmd.Results
+	s.commands[i].FinishTime = cmd.FinishTime
+	return
+}
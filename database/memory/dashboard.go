@@ -0,0 +1,137 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// AgentsBreakdown counts online agents by operating system, version, tag and loader
+func (s *Store) AgentsBreakdown() (breakdown []mig.AgentsBreakdown, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[mig.AgentsBreakdown]float64)
+	for _, agt := range s.agents {
+		if agt.Status != mig.AgtStatusOnline {
+			continue
+		}
+		counts[mig.AgentsBreakdown{Category: mig.BreakdownOS, Value: agt.Env.OS}]++
+		counts[mig.AgentsBreakdown{Category: mig.BreakdownVersion, Value: agt.Version}]++
+		for k, v := range agt.Tags {
+			counts[mig.AgentsBreakdown{Category: mig.BreakdownTag, Value: k + "=" + v}]++
+		}
+		counts[mig.AgentsBreakdown{Category: mig.BreakdownLoader, Value: agt.LoaderName}]++
+	}
+	for b, count := range counts {
+		b.Count = count
+		breakdown = append(breakdown, b)
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Category != breakdown[j].Category {
+			return breakdown[i].Category < breakdown[j].Category
+		}
+		if breakdown[i].Count != breakdown[j].Count {
+			return breakdown[i].Count > breakdown[j].Count
+		}
+		return breakdown[i].Value < breakdown[j].Value
+	})
+	return
+}
+
+// truncateDay returns the start of the day of t, in the local time zone
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// ActionThroughput returns, for each day since pointInTime, the number of actions
+// started and of commands sent, and how many of those commands succeeded or
+// timed out. Days are ordered from the oldest to the most recent.
+func (s *Store) ActionThroughput(pointInTime time.Time) (throughput []mig.ActionThroughput, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	type counters struct{ actions, commands, succeeded, timedout float64 }
+	days := make(map[time.Time]*counters)
+	var order []time.Time
+	for day := truncateDay(pointInTime.Local()); !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		days[day] = &counters{}
+		order = append(order, day)
+	}
+	for _, a := range s.actions {
+		if c, ok := days[truncateDay(a.StartTime.Local())]; ok && a.StartTime.After(pointInTime) {
+			c.actions++
+		}
+	}
+	for _, cmd := range s.commands {
+		c, ok := days[truncateDay(cmd.StartTime.Local())]
+		if !ok || !cmd.StartTime.After(pointInTime) {
+			continue
+		}
+		c.commands++
+		switch cmd.Status {
+		case mig.StatusSuccess:
+			c.succeeded++
+		case mig.StatusTimeout:
+			c.timedout++
+		}
+	}
+	for _, day := range order {
+		c := days[day]
+		throughput = append(throughput, mig.NewActionThroughput(day, c.actions, c.commands, c.succeeded, c.timedout))
+	}
+	return
+}
+
+// SlowestModules returns the modules whose commands started since pointInTime took
+// the longest to complete on average, slowest first. Commands that have not returned
+// are not counted. limit controls how many modules are returned.
+func (s *Store) SlowestModules(pointInTime time.Time, limit int) (durations []mig.ModuleDuration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	total := make(map[string]float64)
+	modules := make(map[string]*mig.ModuleDuration)
+	for _, cmd := range s.commands {
+		if !cmd.StartTime.After(pointInTime) || cmd.Status == mig.StatusSent ||
+			cmd.FinishTime.Before(cmd.StartTime) {
+			continue
+		}
+		ai := s.actionIndex(cmd.Action.ID)
+		if ai < 0 {
+			continue
+		}
+		duration := cmd.FinishTime.Sub(cmd.StartTime).Seconds()
+		for _, op := range s.actions[ai].Operations {
+			md, ok := modules[op.Module]
+			if !ok {
+				md = &mig.ModuleDuration{Module: op.Module}
+				modules[op.Module] = md
+			}
+			md.Commands++
+			total[op.Module] += duration
+			if duration > md.MaximumDuration {
+				md.MaximumDuration = duration
+			}
+		}
+	}
+	for name, md := range modules {
+		md.AverageDuration = total[name] / md.Commands
+		durations = append(durations, *md)
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		if durations[i].AverageDuration != durations[j].AverageDuration {
+			return durations[i].AverageDuration > durations[j].AverageDuration
+		}
+		return durations[i].Module < durations[j].Module
+	})
+	if len(durations) > limit {
+		durations = durations[:limit]
+	}
+	return
+}
@@ -0,0 +1,55 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// InsertDuplicateDecision records a decision taken on duplicate agents
+func (s *Store) InsertDuplicateDecision(d mig.DuplicateDecision) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.duplicates = append(s.duplicates, d)
+	return
+}
+
+// DuplicateDecisionsByQueue returns the last decisions taken on the duplicate
+// agents of a queue location, most recent first
+func (s *Store) DuplicateDecisionsByQueue(queueloc string, limit int) (decisions []mig.DuplicateDecision, err error) {
+	return s.duplicateDecisions(func(d mig.DuplicateDecision) bool {
+		return d.QueueLoc == queueloc
+	}, limit), nil
+}
+
+// DuplicateDecisionsSince returns the last decisions taken on duplicate agents
+// since pointInTime, most recent first
+func (s *Store) DuplicateDecisionsSince(pointInTime time.Time, limit int) (decisions []mig.DuplicateDecision, err error) {
+	return s.duplicateDecisions(func(d mig.DuplicateDecision) bool {
+		return d.CreatedAt.After(pointInTime)
+	}, limit), nil
+}
+
+func (s *Store) duplicateDecisions(match func(mig.DuplicateDecision) bool, limit int) (decisions []mig.DuplicateDecision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, d := range s.duplicates {
+		if match(d) {
+			decisions = append(decisions, d)
+		}
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].CreatedAt.After(decisions[j].CreatedAt)
+	})
+	if len(decisions) > limit {
+		decisions = decisions[:limit]
+	}
+	return
+}
@@ -0,0 +1,385 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// investigator is an investigator with the credentials that are never
+// returned as part of mig.Investigator
+type investigator struct {
+	inv          mig.Investigator
+	apiKey       []byte
+	apiSalt      []byte
+	apiKeyPrefix string
+}
+
+func (s *Store) investigatorIndex(iid float64) int {
+	for i, inv := range s.investigators {
+		if inv.inv.ID == iid {
+			return i
+		}
+	}
+	return -1
+}
+
+// keyIndex returns the index of the investigator key with fingerprint fp
+func (s *Store) keyIndex(fp string) int {
+	if fp == "" {
+		return -1
+	}
+	for i, key := range s.keys {
+		if strings.ToLower(key.PGPFingerprint) == strings.ToLower(fp) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ActiveInvestigatorsPubKeys returns the public keys of active investigators
+// that are valid at the current time and have not been revoked
+func (s *Store) ActiveInvestigatorsPubKeys() (keys [][]byte, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for _, key := range s.keys {
+		i := s.investigatorIndex(key.InvestigatorID)
+		if i < 0 || s.investigators[i].inv.Status != mig.StatusActiveInvestigator {
+			continue
+		}
+		if key.ValidAt(now) {
+			keys = append(keys, key.PublicKey)
+		}
+	}
+	return
+}
+
+// InvestigatorByID returns an investigator with a given ID
+func (s *Store) InvestigatorByID(iid float64) (inv mig.Investigator, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.investigatorIndex(iid)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving investigator: 'no investigator found'")
+		return
+	}
+	inv = s.investigators[i].inv
+	inv.PrivateKey = nil
+	if len(s.investigators[i].apiKey) > 0 {
+		inv.APIKey = "set"
+	}
+	return
+}
+
+// InvestigatorByFingerprint returns the investigator that has, or had, a key
+// with a given fingerprint
+func (s *Store) InvestigatorByFingerprint(fp string) (inv mig.Investigator, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k := s.keyIndex(fp)
+	i := -1
+	if k >= 0 {
+		i = s.investigatorIndex(s.keys[k].InvestigatorID)
+	}
+	if i < 0 {
+		err = fmt.Errorf("InvestigatorByFingerprint: no investigator found for fingerprint '%s'", fp)
+		return
+	}
+	inv = s.investigators[i].inv
+	inv.PGPFingerprint = s.keys[k].PGPFingerprint
+	inv.PublicKey = s.keys[k].PublicKey
+	inv.PrivateKey = nil
+	return
+}
+
+// InvestigatorKeys returns the keys of an investigator, the most recent first
+func (s *Store) InvestigatorKeys(iid float64) (keys []mig.InvestigatorKey, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range s.keys {
+		if key.InvestigatorID == iid {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].ValidFrom.Equal(keys[j].ValidFrom) {
+			return keys[i].ID > keys[j].ID
+		}
+		return keys[i].ValidFrom.After(keys[j].ValidFrom)
+	})
+	return
+}
+
+// addKey stores a new active key
+func (s *Store) addKey(key mig.InvestigatorKey) (mig.InvestigatorKey, error) {
+	if s.keyIndex(key.PGPFingerprint) >= 0 {
+		return key, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+	}
+	key.ID = s.nextID()
+	key.Status = mig.StatusActiveKey
+	key.CreatedAt = time.Now().UTC()
+	if key.ValidFrom.IsZero() {
+		key.ValidFrom = key.CreatedAt
+	}
+	key.RevokedAt = time.Time{}
+	s.keys = append(s.keys, key)
+	return key, nil
+}
+
+// RotateInvestigatorKey adds a new key to an investigator and makes it its
+// current key. The keys that were active before the rotation remain valid
+// for the duration of overlap.
+func (s *Store) RotateInvestigatorKey(key mig.InvestigatorKey, overlap time.Duration) (newkey mig.InvestigatorKey, err error) {
+	if key.PGPFingerprint == "" || len(key.PublicKey) == 0 {
+		return newkey, fmt.Errorf("Investigator key must have a fingerprint and a public key")
+	}
+	if overlap < 0 {
+		return newkey, fmt.Errorf("Key rotation overlap cannot be negative")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.investigatorIndex(key.InvestigatorID)
+	if i < 0 {
+		return newkey, fmt.Errorf("Failed to update investigator key: 'investigator %.0f not found'", key.InvestigatorID)
+	}
+	if s.keyIndex(key.PGPFingerprint) >= 0 {
+		return newkey, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+	}
+	retire := time.Now().UTC().Add(overlap)
+	for k := range s.keys {
+		if s.keys[k].InvestigatorID != key.InvestigatorID || s.keys[k].Status != mig.StatusActiveKey {
+			continue
+		}
+		if s.keys[k].ExpireAfter.IsZero() || s.keys[k].ExpireAfter.After(retire) {
+			s.keys[k].ExpireAfter = retire
+		}
+	}
+	newkey, err = s.addKey(key)
+	if err != nil {
+		return
+	}
+	s.investigators[i].inv.PGPFingerprint = newkey.PGPFingerprint
+	s.investigators[i].inv.PublicKey = newkey.PublicKey
+	s.investigators[i].inv.LastModified = time.Now().UTC()
+	return
+}
+
+// RevokeInvestigatorKey revokes the key of investigator iid that has fingerprint fp
+func (s *Store) RevokeInvestigatorKey(iid float64, fp string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k := s.keyIndex(fp)
+	if k < 0 || s.keys[k].InvestigatorID != iid {
+		return fmt.Errorf("No key with fingerprint '%s' found for investigator %.0f", fp, iid)
+	}
+	s.keys[k].Status = mig.StatusRevokedKey
+	s.keys[k].RevokedAt = time.Now().UTC()
+	return
+}
+
+// InvestigatorAPIKeyAuthHelpers returns the API key hashes of the active
+// investigators whose keys were created without a prefix
+func (s *Store) InvestigatorAPIKeyAuthHelpers() (ret []mig.InvestigatorAPIAuthHelper, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, inv := range s.investigators {
+		if len(inv.apiKey) == 0 || inv.apiKeyPrefix != "" ||
+			inv.inv.Status != mig.StatusActiveInvestigator {
+			continue
+		}
+		ret = append(ret, mig.InvestigatorAPIAuthHelper{ID: inv.inv.ID, APIKey: inv.apiKey, Salt: inv.apiSalt})
+	}
+	return
+}
+
+// InvestigatorAPIKeyAuthHelperByPrefix returns the InvestigatorAPIAuthHelper of the
+// active investigator that owns the API key with the given prefix
+func (s *Store) InvestigatorAPIKeyAuthHelperByPrefix(prefix string) (ret mig.InvestigatorAPIAuthHelper, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, inv := range s.investigators {
+		if prefix != "" && inv.apiKeyPrefix == prefix && len(inv.apiKey) > 0 &&
+			inv.inv.Status == mig.StatusActiveInvestigator {
+			return mig.InvestigatorAPIAuthHelper{ID: inv.inv.ID, APIKey: inv.apiKey, Salt: inv.apiSalt}, nil
+		}
+	}
+	err = fmt.Errorf("no investigator found for API key prefix")
+	return
+}
+
+// InvestigatorByActionID returns the list of investigators that signed a given action
+func (s *Store) InvestigatorByActionID(aid float64) (invs []mig.Investigator, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sig := range s.signatures {
+		if sig.actionID != aid {
+			continue
+		}
+		i := s.investigatorIndex(sig.investigatorID)
+		if i < 0 {
+			continue
+		}
+		inv := s.investigators[i].inv
+		inv.PublicKey = nil
+		inv.PrivateKey = nil
+		invs = append(invs, inv)
+	}
+	return
+}
+
+// InsertInvestigator creates a new investigator and returns its ID, or an error
+// if an investigator with the same fingerprint already exists
+func (s *Store) InsertInvestigator(inv mig.Investigator) (iid float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inv.PrivateKey = nil
+	return s.insertInvestigator(inv)
+}
+
+// InsertSchedulerInvestigator creates a new migscheduler investigator, along
+// with its private key, and returns its ID
+func (s *Store) InsertSchedulerInvestigator(inv mig.Investigator) (iid float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inv.Permissions = mig.InvestigatorPerms{}
+	return s.insertInvestigator(inv)
+}
+
+func (s *Store) insertInvestigator(inv mig.Investigator) (iid float64, err error) {
+	if s.keyIndex(inv.PGPFingerprint) >= 0 {
+		return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+	}
+	for _, other := range s.investigators {
+		if inv.PGPFingerprint != "" && strings.ToLower(other.inv.PGPFingerprint) == strings.ToLower(inv.PGPFingerprint) {
+			return iid, fmt.Errorf("Investigator's PGP Fingerprint already exists in database")
+		}
+	}
+	inv.ID = s.nextID()
+	inv.Status = mig.StatusActiveInvestigator
+	inv.CreatedAt = time.Now().UTC()
+	inv.LastModified = inv.CreatedAt
+	inv.APIKey = ""
+	inv.Keys = nil
+	if inv.PGPFingerprint != "" && len(inv.PublicKey) > 0 {
+		_, err = s.addKey(mig.InvestigatorKey{InvestigatorID: inv.ID, PGPFingerprint: inv.PGPFingerprint,
+			PublicKey: inv.PublicKey, ValidFrom: inv.CreatedAt})
+		if err != nil {
+			return
+		}
+	}
+	s.investigators = append(s.investigators, investigator{inv: inv})
+	return inv.ID, nil
+}
+
+// UpdateInvestigatorStatus updates the status of an investigator
+func (s *Store) UpdateInvestigatorStatus(inv mig.Investigator) (err error) {
+	if inv.Status != mig.StatusActiveInvestigator && inv.Status != mig.StatusDisabledInvestigator {
+		return fmt.Errorf("Invalid investigator status '%s'", inv.Status)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.investigatorIndex(inv.ID)
+	if i >= 0 {
+		s.investigators[i].inv.Status = inv.Status
+	}
+	return
+}
+
+// UpdateInvestigatorAPIKey enables or disables a standard API key for an investigator
+func (s *Store) UpdateInvestigatorAPIKey(inv mig.Investigator, prefix string, key []byte, salt []byte) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.investigatorIndex(inv.ID)
+	if i < 0 {
+		return
+	}
+	if len(key) == 0 {
+		s.investigators[i].apiKey = nil
+		s.investigators[i].apiSalt = nil
+		s.investigators[i].apiKeyPrefix = ""
+		return
+	}
+	for j, other := range s.investigators {
+		if j != i && prefix != "" && other.apiKeyPrefix == prefix {
+			return fmt.Errorf("API key prefix already exists")
+		}
+	}
+	s.investigators[i].apiKey = key
+	s.investigators[i].apiSalt = salt
+	s.investigators[i].apiKeyPrefix = prefix
+	return
+}
+
+// UpdateInvestigatorPerms updates the permissions of an investigator, unless
+// it would remove the last administrator
+func (s *Store) UpdateInvestigatorPerms(inv mig.Investigator) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mask := inv.Permissions.ToMask()
+	if ((mask & mig.PermInvestigator) == 0) || ((mask & mig.PermInvestigatorUpdate) == 0) {
+		var ts mig.InvestigatorPerms
+		ts.AdminSet()
+		tmask := ts.ToMask()
+		cnt := 0
+		for _, other := range s.investigators {
+			if other.inv.ID != inv.ID && (other.inv.Permissions.ToMask()&tmask) != 0 {
+				cnt++
+			}
+		}
+		if cnt < 1 {
+			return fmt.Errorf("Failed to update investigator: 'will not remove last admin'")
+		}
+	}
+	i := s.investigatorIndex(inv.ID)
+	if i >= 0 {
+		s.investigators[i].inv.Permissions.FromMask(mask)
+	}
+	return
+}
+
+// schedulerInvestigator returns the first active scheduler investigator
+func (s *Store) schedulerInvestigator() (investigator, bool) {
+	for _, inv := range s.investigators {
+		if inv.inv.Name == "migscheduler" && inv.inv.Status == mig.StatusActiveInvestigator {
+			return inv, true
+		}
+	}
+	return investigator{}, false
+}
+
+// GetSchedulerPrivKey returns the first active private key found for user migscheduler
+func (s *Store) GetSchedulerPrivKey() (key []byte, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	inv, ok := s.schedulerInvestigator()
+	if !ok {
+		err = fmt.Errorf("no private key found for migscheduler")
+		return
+	}
+	return inv.inv.PrivateKey, nil
+}
+
+// GetSchedulerInvestigator returns the first active scheduler investigator
+func (s *Store) GetSchedulerInvestigator() (inv mig.Investigator, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	found, ok := s.schedulerInvestigator()
+	if !ok {
+		err = fmt.Errorf("Error while retrieving scheduler investigator: 'no investigator found'")
+		return
+	}
+	inv = found.inv
+	inv.PrivateKey = nil
+	inv.Permissions = mig.InvestigatorPerms{}
+	return
+}
@@ -0,0 +1,361 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// loader is a loader entry with its key and the environment last reported
+// by the loader instance that uses it
+type loader struct {
+	entry    mig.LoaderEntry
+	hash     []byte
+	salt     []byte
+	env      mig.AgentEnv
+	tags     map[string]string
+	queueLoc string
+}
+
+// columns returns the columns of a loader that manifest targets and expected
+// environments can use
+func (l loader) columns() (map[string]string, map[string]map[string]string) {
+	return map[string]string{
+		"id":         fmt.Sprintf("%.0f", l.entry.ID),
+		"loadername": l.entry.Name,
+		"keyprefix":  l.entry.Prefix,
+		"name":       l.entry.AgentName,
+		"queueloc":   l.queueLoc,
+		"enabled":    fmt.Sprintf("%t", l.entry.Enabled),
+	}, map[string]map[string]string{
+		"env":  envColumns(l.env),
+		"tags": l.tags,
+	}
+}
+
+func (s *Store) loaderIndex(lid float64) int {
+	for i, l := range s.loaders {
+		if l.entry.ID == lid {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetLoaderEntryID returns the ID of the enabled loader entry whose stored key is key
+func (s *Store) GetLoaderEntryID(key string) (ret float64, err error) {
+	if key == "" {
+		return ret, fmt.Errorf("key cannot be empty")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.loaders {
+		if l.entry.Enabled && string(l.hash) == key {
+			return l.entry.ID, nil
+		}
+	}
+	err = fmt.Errorf("No matching loader entry found for key")
+	return
+}
+
+// GetLoaderAuthDetails returns a loader ID and hashed key given a prefix string
+func (s *Store) GetLoaderAuthDetails(prefix string) (lad mig.LoaderAuthDetails, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.loaders {
+		if l.entry.Enabled && l.entry.Prefix == prefix {
+			lad = mig.LoaderAuthDetails{ID: l.entry.ID, Hash: l.hash, Salt: l.salt}
+			err = lad.Validate()
+			return
+		}
+	}
+	err = fmt.Errorf("Unable to locate loader from prefix")
+	return
+}
+
+// GetLoaderName returns a loader name given an ID
+func (s *Store) GetLoaderName(id float64) (ret string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(id)
+	if i < 0 || !s.loaders[i].entry.Enabled {
+		err = fmt.Errorf("Unable to locate name for loader ID")
+		return
+	}
+	return s.loaders[i].entry.Name, nil
+}
+
+// UpdateLoaderEntry updates a loader entry using the agent information
+// provided by a loader instance during a manifest request
+func (s *Store) UpdateLoaderEntry(lid float64, agt mig.Agent) (err error) {
+	if agt.Name == "" {
+		return fmt.Errorf("will not update loader entry with no agent name")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i < 0 {
+		return
+	}
+	s.loaders[i].entry.AgentName = agt.Name
+	s.loaders[i].env = agt.Env
+	s.loaders[i].tags = agt.Tags
+	s.loaders[i].queueLoc = agt.QueueLoc
+	s.loaders[i].entry.LastSeen = time.Now()
+	return
+}
+
+// CompareLoaderExpectEnv returns an error if the environment reported by a
+// loader does not match the expected environment set on its entry
+func (s *Store) CompareLoaderExpectEnv(lid float64) error {
+	rerr := fmt.Errorf("loader environment verification failed")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i < 0 {
+		return rerr
+	}
+	l := s.loaders[i]
+	if l.entry.ExpectEnv == "" {
+		return nil
+	}
+	cols, jsonCols := l.columns()
+	ok, err := matchTarget(l.entry.ExpectEnv, cols, jsonCols)
+	if err != nil || !ok {
+		return rerr
+	}
+	return nil
+}
+
+// GetLoaderFromID returns a loader entry given an ID
+func (s *Store) GetLoaderFromID(lid float64) (ret mig.LoaderEntry, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving loader: 'no loader found'")
+		return
+	}
+	return s.loaders[i].entry, nil
+}
+
+// LoaderUpdateStatus enables or disables a loader entry
+func (s *Store) LoaderUpdateStatus(lid float64, status bool) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i >= 0 {
+		s.loaders[i].entry.Enabled = status
+	}
+	return
+}
+
+// LoaderUpdateExpect updates the expected environment of a loader entry
+func (s *Store) LoaderUpdateExpect(lid float64, eenv string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i >= 0 {
+		s.loaders[i].entry.ExpectEnv = eenv
+	}
+	return
+}
+
+// LoaderUpdateKey changes the key of a loader entry, hashkey should be the
+// hashed version of the key component
+func (s *Store) LoaderUpdateKey(lid float64, hashkey []byte, salt []byte) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.loaderIndex(lid)
+	if i >= 0 {
+		s.loaders[i].hash = hashkey
+		s.loaders[i].salt = salt
+	}
+	return
+}
+
+// LoaderAdd adds a new, disabled, loader entry; the hashed loader key should
+// be provided as hashkey
+func (s *Store) LoaderAdd(le mig.LoaderEntry, hashkey []byte, salt []byte) (newle mig.LoaderEntry, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.loaders {
+		if l.entry.Name == le.Name || l.entry.Prefix == le.Prefix {
+			err = fmt.Errorf("loader name or prefix already exists")
+			return
+		}
+	}
+	le.ID = s.nextID()
+	le.LastSeen = time.Now()
+	le.Enabled = false
+	s.loaders = append(s.loaders, loader{entry: le, hash: hashkey, salt: salt})
+	newle = le
+	return
+}
+
+func (s *Store) manifestIndex(mid float64) int {
+	for i, m := range s.manifests {
+		if m.ID == mid {
+			return i
+		}
+	}
+	return -1
+}
+
+// ManifestAdd adds a new staged manifest record
+func (s *Store) ManifestAdd(mr mig.ManifestRecord) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mr.ID = s.nextID()
+	mr.Timestamp = time.Now()
+	mr.Status = "staged"
+	mr.Signatures = nil
+	s.manifests = append(s.manifests, mr)
+	return
+}
+
+// ManifestAddSignature adds a signature to an existing manifest, and updates its status
+func (s *Store) ManifestAddSignature(mid float64, sig string, invid float64, reqsig int) (err error) {
+	s.lock.Lock()
+	i := s.manifestIndex(mid)
+	if i < 0 || s.manifests[i].Status == "disabled" {
+		s.lock.Unlock()
+		return fmt.Errorf("Manifest signing operation failed")
+	}
+	s.manifestSigs = append(s.manifestSigs, manifestSignature{manifestID: mid,
+		investigatorID: invid, pgpSignature: sig})
+	s.lock.Unlock()
+	return s.ManifestUpdateStatus(mid, reqsig)
+}
+
+// ManifestDisable disables a manifest record
+func (s *Store) ManifestDisable(mid float64) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.manifestIndex(mid)
+	if i >= 0 {
+		s.manifests[i].Status = "disabled"
+	}
+	return
+}
+
+// ManifestUpdateStatus activates a manifest that has at least reqsig signatures,
+// and stages it otherwise
+func (s *Store) ManifestUpdateStatus(mid float64, reqsig int) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.manifestIndex(mid)
+	if i < 0 || s.manifests[i].Status == "disabled" {
+		return
+	}
+	cnt := 0
+	for _, sig := range s.manifestSigs {
+		if sig.manifestID == mid {
+			cnt++
+		}
+	}
+	s.manifests[i].Status = "staged"
+	if cnt >= reqsig {
+		s.manifests[i].Status = "active"
+	}
+	return
+}
+
+// ManifestClearSignatures removes the signatures of a manifest record
+func (s *Store) ManifestClearSignatures(mid float64) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var sigs []manifestSignature
+	for _, sig := range s.manifestSigs {
+		if sig.manifestID != mid {
+			sigs = append(sigs, sig)
+		}
+	}
+	s.manifestSigs = sigs
+	return
+}
+
+// GetManifestFromID returns a manifest record with its signatures
+func (s *Store) GetManifestFromID(mid float64) (ret mig.ManifestRecord, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.manifestIndex(mid)
+	if i < 0 {
+		err = fmt.Errorf("Error while retrieving manifest: 'no manifest found'")
+		return
+	}
+	ret = s.manifests[i]
+	for _, sig := range s.manifestSigs {
+		if sig.manifestID == mid {
+			ret.Signatures = append(ret.Signatures, sig.pgpSignature)
+		}
+	}
+	return
+}
+
+// ManifestIDFromLoaderID returns the ID of the most recent active manifest
+// whose target matches a loader
+func (s *Store) ManifestIDFromLoaderID(lid float64) (ret float64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	li := s.loaderIndex(lid)
+	if li >= 0 {
+		cols, jsonCols := s.loaders[li].columns()
+		found := false
+		var newest time.Time
+		for _, m := range s.manifests {
+			if m.Status != "active" {
+				continue
+			}
+			ok, err := matchTarget(m.Target, cols, jsonCols)
+			if err != nil {
+				return 0, err
+			}
+			if ok && (!found || m.Timestamp.After(newest)) {
+				found = true
+				newest = m.Timestamp
+				ret = m.ID
+			}
+		}
+		if found {
+			return ret, nil
+		}
+	}
+	err = fmt.Errorf("No matching manifest was found for loader entry")
+	return 0, err
+}
+
+// AllLoadersFromManifestID returns the enabled loader entries that match the
+// target of manifest mid
+func (s *Store) AllLoadersFromManifestID(mid float64) (ret []mig.LoaderEntry, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.manifestIndex(mid)
+	if i < 0 || (s.manifests[i].Status != "active" && s.manifests[i].Status != "staged") {
+		err = fmt.Errorf("no active or staged manifest found")
+		return
+	}
+	for _, l := range s.loaders {
+		if !l.entry.Enabled {
+			continue
+		}
+		cols, jsonCols := l.columns()
+		var ok bool
+		ok, err = matchTarget(s.manifests[i].Target, cols, jsonCols)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, mig.LoaderEntry{ID: l.entry.ID, Name: l.entry.Name,
+				AgentName: l.entry.AgentName, LastSeen: l.entry.LastSeen, Enabled: l.entry.Enabled})
+		}
+	}
+	return
+}
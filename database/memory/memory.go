@@ -0,0 +1,291 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+// Package memory implements the storage interfaces of the database package
+// in memory. It is meant to test the API and the scheduler without a
+// database server, and does not persist anything.
+//
+// Targets of actions, manifests and loader environments are SQL conditions
+// that Postgres evaluates. The memory store only understands a subset of
+// them: TRUE and FALSE, and comparisons of a column with a quoted string using
+// =, !=, LIKE or ILIKE, joined with AND and OR, without parentheses. Columns
+// are the ones of the agents and loaders tables, and keys of the json columns
+// can be read with the ->> operator, as in environment->>'os'='linux'. Array
+// columns are matched with ANY, as in '198.51.100.4' = ANY(publicips).
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+)
+
+// Store keeps MIG data in memory. The zero value is not usable, stores are
+// created with New.
+type Store struct {
+	lock             sync.Mutex
+	agents           []mig.Agent
+	agentsStats      []mig.AgentsStats
+	archivedAgents   []mig.Agent
+	archivedCommands []mig.Command
+	duplicates       []mig.DuplicateDecision
+	actions          []mig.Action
+	signatures       []signature
+	commands         []mig.Command
+	investigators    []investigator
+	keys             []mig.InvestigatorKey
+	aclPolicies      []mig.ACLPolicy
+	loaders          []loader
+	manifests        []mig.ManifestRecord
+	manifestSigs     []manifestSignature
+	webhooks         []mig.Webhook
+	deliveries       []mig.WebhookDelivery
+	cases            []mig.Case
+	listeners        []chan mig.ActionEvent
+	lastID           float64
+}
+
+// signature maps an investigator to an action they signed
+type signature struct {
+	actionID, investigatorID float64
+	pgpSignature             string
+}
+
+// manifestSignature is a signature applied to a manifest
+type manifestSignature struct {
+	manifestID, investigatorID float64
+	pgpSignature               string
+}
+
+var _ migdb.Store = (*Store)(nil)
+
+// New returns an empty memory store
+func New() *Store {
+	return &Store{}
+}
+
+// Close releases the channels of the action event listeners
+func (s *Store) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.listeners {
+		close(l)
+	}
+	s.listeners = nil
+}
+
+// nextID returns the next value of the sequence used for the identifiers
+// the database generates, like the ones of investigators and loaders
+func (s *Store) nextID() float64 {
+	s.lastID++
+	return s.lastID
+}
+
+// futureDate is the finish time of commands that have not returned yet
+var futureDate = time.Date(9998, time.January, 11, 11, 11, 11, 11, time.UTC)
+
+// likeToRegexp converts a SQL LIKE pattern into a regular expression
+func likeToRegexp(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if caseInsensitive {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// like returns true if value matches the SQL LIKE pattern
+func like(value, pattern string) bool {
+	if pattern == "%" {
+		return true
+	}
+	re, err := likeToRegexp(pattern, false)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// ilike returns true if value matches the SQL ILIKE pattern
+func ilike(value, pattern string) bool {
+	if pattern == "%" {
+		return true
+	}
+	re, err := likeToRegexp(pattern, true)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+var (
+	orRe         = regexp.MustCompile(`(?i)\s+or\s+`)
+	andRe        = regexp.MustCompile(`(?i)\s+and\s+`)
+	anyRe        = regexp.MustCompile(`(?i)^'((?:[^']|'')*)'\s*=\s*any\s*\(\s*([a-z_]+)\s*\)$`)
+	comparisonRe = regexp.MustCompile(`(?i)^([a-z_]+)(?:\s*->>\s*'([^']+)'|\s*#>>\s*'\{([^'}]+)\}')?\s*(=|!=|<>|\s+not\s+ilike\s+|\s+not\s+like\s+|\s+ilike\s+|\s+like\s+)\s*'((?:[^']|'')*)'$`)
+)
+
+// matchTarget evaluates the target condition against the columns of a row.
+// Columns holding json documents are passed as maps in jsonCols.
+func matchTarget(target string, cols map[string]string, jsonCols map[string]map[string]string) (bool, error) {
+	target = strings.TrimSpace(target)
+	for _, or := range orRe.Split(target, -1) {
+		match := true
+		for _, and := range andRe.Split(or, -1) {
+			ok, err := matchComparison(strings.TrimSpace(and), cols, jsonCols)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchComparison(cond string, cols map[string]string, jsonCols map[string]map[string]string) (bool, error) {
+	switch strings.ToLower(cond) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if m := anyRe.FindStringSubmatch(cond); m != nil {
+		return matchAny(strings.Replace(m[1], "''", "'", -1), strings.ToLower(m[2]), cols)
+	}
+	m := comparisonRe.FindStringSubmatch(cond)
+	if m == nil {
+		return false, fmt.Errorf("condition %q is not supported by the memory store", cond)
+	}
+	column := strings.ToLower(m[1])
+	var value string
+	if m[2] != "" || m[3] != "" {
+		doc, ok := jsonCols[column]
+		if !ok {
+			return false, fmt.Errorf("unknown json column %q", column)
+		}
+		key := m[2]
+		if m[3] != "" {
+			// nested keys are stored with their path, as in facts,kernel,release
+			key = strings.Replace(m[3], " ", "", -1)
+		}
+		value = doc[key]
+	} else {
+		var ok bool
+		value, ok = cols[column]
+		if !ok {
+			return false, fmt.Errorf("unknown column %q", column)
+		}
+	}
+	operand := strings.Replace(m[5], "''", "'", -1)
+	switch strings.ToLower(strings.Join(strings.Fields(m[4]), " ")) {
+	case "=":
+		return value == operand, nil
+	case "!=", "<>":
+		return value != operand, nil
+	case "like":
+		return like(value, operand), nil
+	case "not like":
+		return !like(value, operand), nil
+	case "ilike":
+		return ilike(value, operand), nil
+	case "not ilike":
+		return !ilike(value, operand), nil
+	}
+	return false, fmt.Errorf("condition %q is not supported by the memory store", cond)
+}
+
+// arrayColumns are the columns that hold arrays. Their elements are joined
+// with commas in the columns of a row.
+var arrayColumns = map[string]bool{"publicips": true}
+
+// matchAny returns true if operand is an element of the array column. Inet
+// elements are compared as addresses, so that 2001:DB8::1 matches 2001:db8::1
+// as it does in Postgres.
+func matchAny(operand, column string, cols map[string]string) (bool, error) {
+	value, ok := cols[column]
+	if !ok || !arrayColumns[column] {
+		return false, fmt.Errorf("unknown array column %q", column)
+	}
+	if ip := net.ParseIP(operand); ip != nil {
+		operand = ip.String()
+	}
+	for _, elem := range strings.Split(value, ",") {
+		if elem != "" && elem == operand {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// envColumns returns the keys of an agent environment the way Postgres
+// returns them with the ->> operator, and facts the way it returns them with
+// the #>> operator. Containers are returned as their JSON encoding, so
+// targets can match them with LIKE.
+func envColumns(env mig.AgentEnv) map[string]string {
+	cols := map[string]string{
+		"init":      env.Init,
+		"ident":     env.Ident,
+		"os":        env.OS,
+		"arch":      env.Arch,
+		"isproxied": fmt.Sprintf("%t", env.IsProxied),
+		"proxy":     env.Proxy,
+		"publicip":  env.PublicIP,
+	}
+	if len(env.Containers) > 0 {
+		buf, err := json.Marshal(env.Containers)
+		if err == nil {
+			cols["containers"] = string(buf)
+		}
+	}
+	for collector, facts := range env.Facts {
+		for name, value := range facts {
+			cols["facts,"+collector+","+name] = value
+		}
+	}
+	return cols
+}
+
+// agentColumns returns the columns of an agent that targets can use
+func agentColumns(agt mig.Agent) (map[string]string, map[string]map[string]string) {
+	return map[string]string{
+		"id":         fmt.Sprintf("%.0f", agt.ID),
+		"name":       agt.Name,
+		"queueloc":   agt.QueueLoc,
+		"mode":       agt.Mode,
+		"version":    agt.Version,
+		"pid":        fmt.Sprintf("%d", agt.PID),
+		"status":     agt.Status,
+		"loadername": agt.LoaderName,
+		"publicips":  strings.Join(agt.Env.PublicIPList(), ","),
+	}, map[string]map[string]string{
+		"environment": envColumns(agt.Env),
+		"tags":        agt.Tags,
+	}
+}
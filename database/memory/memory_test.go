@@ -0,0 +1,690 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/search"
+	"github.com/mozilla/mig/modules"
+)
+
+func TestMatchTarget(t *testing.T) {
+	agt := mig.Agent{ID: 12, Name: "db1.example.net", QueueLoc: "linux.db1", Status: mig.AgtStatusOnline,
+		Env: mig.AgentEnv{OS: "linux", Facts: mig.AgentFacts{"kernel": {"release": "4.15.0-20-generic"}},
+			Containers: []mig.AgentContainer{{ID: "4f3c2b1a", Name: "nginx", Image: "nginx:1.21", Runtime: "docker", Pid: 1234}},
+			PublicIP:   "203.0.113.7",
+			PublicIPs:  []mig.AgentPublicIP{{IP: "203.0.113.7", Family: "ipv4"}, {IP: "2001:db8::7", Family: "ipv6"}}},
+		Tags: map[string]string{"operator": "IT"}}
+	cols, jsonCols := agentColumns(agt)
+	testcases := []struct {
+		target string
+		expect bool
+		fails  bool
+	}{
+		{"TRUE", true, false},
+		{"false", false, false},
+		{"name='db1.example.net'", true, false},
+		{"name = 'web1.example.net'", false, false},
+		{"name ILIKE 'DB%'", true, false},
+		{"name LIKE 'DB%'", false, false},
+		{"name NOT LIKE 'web%'", true, false},
+		{"environment->>'os'='linux' AND tags->>'operator'='IT'", true, false},
+		{"environment->>'os'='darwin' OR name like '%.example.net'", true, false},
+		{"environment->>'os'='darwin' AND name like '%.example.net'", false, false},
+		{"environment#>>'{facts,kernel,release}' LIKE '4.15.%'", true, false},
+		{"environment #>> '{facts, kernel, release}'='4.4.0'", false, false},
+		{"environment#>>'{facts,gce,zone}'='us-east1-b'", false, false},
+		{"environment->>'containers' LIKE '%nginx%'", true, false},
+		{"environment->>'containers' LIKE '%redis%'", false, false},
+		{"'2001:db8::7' = ANY(publicips)", true, false},
+		{"'2001:DB8:0::7'=any(publicips) AND environment->>'os'='linux'", true, false},
+		{"'198.51.100.4' = ANY(publicips) OR '203.0.113.7' = ANY(publicips)", true, false},
+		{"'198.51.100.4' = ANY(publicips)", false, false},
+		{"'linux' = ANY(queueloc)", false, true},
+		{"id=12", false, true},
+		{"nosuchcolumn='x'", false, true},
+	}
+	for _, tc := range testcases {
+		ok, err := matchTarget(tc.target, cols, jsonCols)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("expected target %q to fail", tc.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("target %q failed: %v", tc.target, err)
+			continue
+		}
+		if ok != tc.expect {
+			t.Errorf("target %q returned %t, expected %t", tc.target, ok, tc.expect)
+		}
+	}
+}
+
+func TestAgentLifecycle(t *testing.T) {
+	s := New()
+	now := time.Now()
+	err := s.InsertAgent(mig.Agent{Name: "agent1", QueueLoc: "linux.agent1", PID: 100,
+		Version: "1", Status: mig.AgtStatusOnline, HeartBeatTS: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, err := s.AgentByQueueAndPID("linux.agent1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents, err := s.ActiveAgentsByTarget("name='agent1'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 1 || agents[0].ID != agt.ID {
+		t.Fatalf("expected agent %.0f to match its target, got %v", agt.ID, agents)
+	}
+	err = s.MarkIdleAgents(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _ := s.CountIdleEndpoints()
+	if count != 1 {
+		t.Fatalf("expected 1 idle endpoint, got %.0f", count)
+	}
+	err = s.MarkOfflineAgents(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AgentByQueueAndPID("linux.agent1", 100)
+	if err == nil {
+		t.Fatal("expected offline agent to not be found by queue and pid")
+	}
+	queues, _ := s.GetDisappearedEndpoints(now.Add(-time.Minute))
+	if len(queues) != 1 || queues[0] != "linux.agent1" {
+		t.Fatalf("expected linux.agent1 to have disappeared, got %v", queues)
+	}
+}
+
+func TestEndpointDecommission(t *testing.T) {
+	s := New()
+	now := time.Now()
+	err := s.InsertAgent(mig.Agent{Name: "agent1", QueueLoc: "linux.agent1", PID: 100,
+		Version: "1", Status: mig.AgtStatusOnline, HeartBeatTS: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, err := s.AgentByQueueAndPID("linux.agent1", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, from, err := mig.AgentLifecycleTransition(mig.AgtLifecycleQuarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := s.SetEndpointLifecycle(agt.QueueLoc, to, from)
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 agent to be quarantined, got %.0f (%v)", count, err)
+	}
+	// quarantine is kept across heartbeats and excludes the endpoint from targeting
+	err = s.UpdateAgentHeartbeat(mig.Agent{ID: agt.ID, HeartBeatTS: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, _ = s.AgentByID(agt.ID)
+	if agt.Status != mig.AgtStatusQuarantined {
+		t.Fatalf("expected agent to remain quarantined after heartbeat, got %q", agt.Status)
+	}
+	agents, _ := s.ActiveAgentsByTarget("name='agent1'")
+	if len(agents) != 0 {
+		t.Fatalf("expected quarantined endpoint to be excluded from targeting, got %v", agents)
+	}
+	// a quarantined endpoint cannot be quarantined again
+	count, _ = s.SetEndpointLifecycle(agt.QueueLoc, to, from)
+	if count != 0 {
+		t.Fatalf("expected no agent to be quarantined twice, got %.0f", count)
+	}
+	to, from, _ = mig.AgentLifecycleTransition(mig.AgtLifecycleDecommission)
+	count, _ = s.SetEndpointLifecycle(agt.QueueLoc, to, from)
+	if count != 1 {
+		t.Fatalf("expected 1 agent to be decommissioned, got %.0f", count)
+	}
+	// a new agent started on a decommissioned endpoint inherits its status
+	err = s.InsertAgent(mig.Agent{Name: "agent1", QueueLoc: "linux.agent1", PID: 200,
+		Version: "2", Status: mig.AgtStatusOnline, HeartBeatTS: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, _ = s.CountEndpointsByStatus(mig.AgtStatusDecommissioned)
+	if count != 1 {
+		t.Fatalf("expected 1 decommissioned endpoint, got %.0f", count)
+	}
+	count, _ = s.CountDisappearedEndpoints(now.Add(time.Hour))
+	if count != 0 {
+		t.Fatalf("expected decommissioned endpoint to not count as disappeared, got %.0f", count)
+	}
+	count, _ = s.ArchiveDecommissionedAgents(now.Add(-time.Hour))
+	if count != 0 {
+		t.Fatalf("expected no agent to be archived before the retention period, got %.0f", count)
+	}
+	count, err = s.ArchiveDecommissionedAgents(time.Now().Add(time.Hour))
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 agents to be archived, got %.0f (%v)", count, err)
+	}
+	_, err = s.AgentByID(agt.ID)
+	if err == nil {
+		t.Fatal("expected archived agent to be removed from the agents")
+	}
+	_, _, err = mig.AgentLifecycleTransition("destroy")
+	if err == nil {
+		t.Fatal("expected unknown lifecycle operation to fail")
+	}
+}
+
+func TestCommandsAndSearch(t *testing.T) {
+	s := New()
+	iid, err := s.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "ABCD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.InsertInvestigator(mig.Investigator{Name: "Bob again", PGPFingerprint: "abcd"})
+	if err == nil {
+		t.Fatal("expected duplicate fingerprint to be rejected")
+	}
+	err = s.InsertAgent(mig.Agent{Name: "agent1", QueueLoc: "linux.agent1", PID: 100,
+		Status: mig.AgtStatusOnline, HeartBeatTS: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, _ := s.AgentByQueueAndPID("linux.agent1", 100)
+	a := mig.Action{ID: 1, Name: "find things", Status: "pending",
+		ValidFrom: time.Now().Add(-time.Minute), ExpireAfter: time.Now().Add(time.Hour)}
+	err = s.InsertAction(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.InsertSignature(a.ID, iid, "sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runnable, _ := s.SetupRunnableActions()
+	if len(runnable) != 1 || runnable[0].Status != "scheduled" {
+		t.Fatalf("expected action to be scheduled, got %v", runnable)
+	}
+	cmd := mig.Command{ID: 10, Action: a, Agent: agt, Status: mig.StatusSent, StartTime: time.Now()}
+	n, err := s.InsertCommands([]mig.Command{cmd})
+	if err != nil || n != 1 {
+		t.Fatalf("failed to insert command: %v", err)
+	}
+	cmd.Status = mig.StatusSuccess
+	cmd.Results = []modules.Result{{FoundAnything: true}}
+	cmd.FinishTime = time.Now()
+	err = s.FinishCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.FinishCommand(cmd)
+	if err == nil {
+		t.Fatal("expected a successful command to not be finished twice")
+	}
+	counters, _ := s.GetActionCounters(a.ID)
+	if counters.Success != 1 || counters.Done != 1 {
+		t.Fatalf("unexpected action counters %+v", counters)
+	}
+
+	p := search.NewParameters()
+	p.Type = "command"
+	p.InvestigatorName = "bob"
+	p.FoundAnything = true
+	commands, err := s.SearchCommands(p, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 1 || commands[0].Agent.Name != "agent1" || len(commands[0].Action.Investigators) != 1 {
+		t.Fatalf("unexpected search results %v", commands)
+	}
+	p.FoundAnything = false
+	commands, _ = s.SearchCommands(p, true)
+	if len(commands) != 0 {
+		t.Fatalf("expected no command without results, got %v", commands)
+	}
+	p = search.NewParameters()
+	p.AgentName = "agent%"
+	actions, err := s.SearchActions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Counters.Success != 1 {
+		t.Fatalf("unexpected search results %v", actions)
+	}
+	p.ResultText = "anything"
+	_, err = s.SearchCommands(p, false)
+	if err == nil {
+		t.Fatal("expected searching inside results to be unsupported")
+	}
+}
+
+func TestActionNonces(t *testing.T) {
+	s := New()
+	a := mig.Action{ID: 1, Name: "find things", Status: "pending", SyntaxVersion: mig.ActionVersion,
+		Nonce: "0123456789abcdef", ValidFrom: time.Now().Add(-time.Minute), ExpireAfter: time.Now().Add(time.Hour)}
+	err := s.InsertAction(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a signed action submitted again under another ID is refused
+	a.ID = 2
+	err = s.InsertAction(a)
+	if err == nil {
+		t.Fatal("expected an action reusing a nonce to be refused")
+	}
+	// legacy actions have no nonce
+	for i := 3; i <= 4; i++ {
+		err = s.InsertAction(mig.Action{ID: float64(i), Name: "legacy", SyntaxVersion: mig.ActionVersionLegacy})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored, err := s.ActionByID(1)
+	if err != nil || stored.Nonce != a.Nonce {
+		t.Fatalf("expected nonce to be stored, got %q (%v)", stored.Nonce, err)
+	}
+}
+
+func TestActionEvents(t *testing.T) {
+	s := New()
+	events, err := s.ListenActionEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.NotifyActionEvent(mig.ActionEvent{Type: "action", ActionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-events:
+		if ev.ActionID != 1 || ev.Time.IsZero() {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+	s.Close()
+	if _, ok := <-events; ok {
+		t.Fatal("expected events channel to be closed")
+	}
+}
+
+func TestInvestigatorKeyRotation(t *testing.T) {
+	s := New()
+	iid, err := s.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "AAAA",
+		PublicKey: []byte("key a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RotateInvestigatorKey(mig.InvestigatorKey{InvestigatorID: iid,
+		PGPFingerprint: "aaaa", PublicKey: []byte("key a")}, 0)
+	if err == nil {
+		t.Fatal("expected rotation to an existing key to fail")
+	}
+	key, err := s.RotateInvestigatorKey(mig.InvestigatorKey{InvestigatorID: iid,
+		PGPFingerprint: "BBBB", PublicKey: []byte("key b")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.ValidAt(time.Now()) {
+		t.Fatalf("expected new key to be valid, got %+v", key)
+	}
+	keys, _ := s.ActiveInvestigatorsPubKeys()
+	if len(keys) != 2 {
+		t.Fatalf("expected both keys to be valid during the overlap, got %d", len(keys))
+	}
+	invkeys, _ := s.InvestigatorKeys(iid)
+	if len(invkeys) != 2 || invkeys[0].PGPFingerprint != "BBBB" || invkeys[1].ExpireAfter.IsZero() {
+		t.Fatalf("unexpected investigator keys %+v", invkeys)
+	}
+	inv, err := s.InvestigatorByID(iid)
+	if err != nil || inv.PGPFingerprint != "BBBB" {
+		t.Fatalf("expected investigator to use the new key, got %q (%v)", inv.PGPFingerprint, err)
+	}
+
+	err = s.RevokeInvestigatorKey(iid, "aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = s.ActiveInvestigatorsPubKeys()
+	if len(keys) != 1 || string(keys[0]) != "key b" {
+		t.Fatalf("expected revoked key to be excluded, got %q", keys)
+	}
+	// revoked keys still identify the investigator
+	inv, err = s.InvestigatorByFingerprint("AAAA")
+	if err != nil || inv.ID != iid || inv.PGPFingerprint != "AAAA" {
+		t.Fatalf("expected revoked key to identify investigator %.0f, got %+v (%v)", iid, inv, err)
+	}
+
+	_, err = s.RotateInvestigatorKey(mig.InvestigatorKey{InvestigatorID: iid,
+		PGPFingerprint: "CCCC", PublicKey: []byte("key c")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = s.ActiveInvestigatorsPubKeys()
+	if len(keys) != 1 || string(keys[0]) != "key c" {
+		t.Fatalf("expected previous key to expire without overlap, got %q", keys)
+	}
+}
+
+func TestACLPolicies(t *testing.T) {
+	s := New()
+	iid, err := s.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "ABCD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetACLPolicy(mig.ACLPolicy{Module: "file", MinimumWeight: 1,
+		Weights: []mig.ACLPolicyWeight{{InvestigatorID: iid + 1, Weight: 1}}})
+	if err == nil {
+		t.Fatal("expected policy of unknown investigator to be rejected")
+	}
+	err = s.SetACLPolicy(mig.ACLPolicy{Module: "file", MinimumWeight: 1,
+		Weights: []mig.ACLPolicyWeight{{InvestigatorID: iid, Weight: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetACLPolicy(mig.ACLPolicy{Module: "file", MinimumWeight: 2,
+		Weights: []mig.ACLPolicyWeight{{InvestigatorID: iid, Weight: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, _ := s.ACLPolicies()
+	if len(policies) != 1 || policies[0].MinimumWeight != 2 || policies[0].Weights[0].Weight != 2 {
+		t.Fatalf("expected policy of file to be replaced, got %v", policies)
+	}
+	err = s.DeleteACLPolicy("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.DeleteACLPolicy("file")
+	if err == nil {
+		t.Fatal("expected deletion of missing policy to fail")
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i, agt := range []mig.Agent{
+		{Name: "agent1", QueueLoc: "linux.agent1", Version: "1", Env: mig.AgentEnv{OS: "linux"},
+			Tags: map[string]string{"operator": "IT"}},
+		{Name: "agent2", QueueLoc: "linux.agent2", Version: "2", Env: mig.AgentEnv{OS: "linux"}},
+		{Name: "agent3", QueueLoc: "darwin.agent3", Version: "2", Env: mig.AgentEnv{OS: "darwin"}},
+	} {
+		agt.PID = i
+		agt.Status = mig.AgtStatusOnline
+		agt.HeartBeatTS = now
+		err := s.InsertAgent(agt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	breakdown, err := s.AgentsBreakdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]float64)
+	for _, b := range breakdown {
+		counts[b.Category+":"+b.Value] = b.Count
+	}
+	if counts["os:linux"] != 2 || counts["version:2"] != 2 || counts["tag:operator=IT"] != 1 ||
+		counts["loader:"] != 3 {
+		t.Fatalf("unexpected breakdown %v", breakdown)
+	}
+
+	a := mig.Action{ID: 1, Name: "slow", Status: "completed", StartTime: now.Add(-time.Hour),
+		Operations: []mig.Operation{{Module: "file"}}}
+	err = s.InsertAction(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, _ := s.AgentByQueueAndPID("linux.agent1", 0)
+	_, err = s.InsertCommands([]mig.Command{
+		{ID: 1, Action: a, Agent: agt, Status: mig.StatusSent, StartTime: now.Add(-time.Hour)},
+		{ID: 2, Action: a, Agent: agt, Status: mig.StatusSent, StartTime: now.Add(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.FinishCommand(mig.Command{ID: 1, Agent: agt, Status: mig.StatusSuccess,
+		FinishTime: now.Add(-time.Hour + time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	throughput, err := s.ActionThroughput(now.Add(-48 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions, commands float64
+	for _, day := range throughput {
+		actions += day.Actions
+		commands += day.Commands
+	}
+	if len(throughput) < 3 || actions != 1 || commands != 2 {
+		t.Fatalf("unexpected throughput %v", throughput)
+	}
+	slowest, err := s.SlowestModules(now.Add(-48*time.Hour), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slowest) != 1 || slowest[0].Module != "file" || slowest[0].Commands != 1 ||
+		slowest[0].AverageDuration != 60 {
+		t.Fatalf("unexpected module durations %v", slowest)
+	}
+}
+
+func TestDuplicateDecisions(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i, q := range []string{"linux.host1", "linux.host2", "linux.host1"} {
+		err := s.InsertDuplicateDecision(mig.DuplicateDecision{ID: float64(i + 1), QueueLoc: q,
+			Kind: mig.DupKindDuplicate, Decision: mig.DupDecisionAlert,
+			CreatedAt: now.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	last, _ := s.DuplicateDecisionsByQueue("linux.host1", 1)
+	if len(last) != 1 || last[0].ID != 3 {
+		t.Fatalf("expected the last decision on linux.host1 to be 3, got %v", last)
+	}
+	recent, _ := s.DuplicateDecisionsSince(now, 10)
+	if len(recent) != 2 || recent[0].ID != 3 || recent[1].ID != 2 {
+		t.Fatalf("expected decisions 3 and 2 since now, got %v", recent)
+	}
+}
+
+// TestActiveAgentsByQueueClones verifies that the agents returned for a queue
+// location carry the environment clone detection relies on
+func TestActiveAgentsByQueueClones(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i, ip := range []string{"192.0.2.1", "198.51.100.1"} {
+		err := s.InsertAgent(mig.Agent{ID: float64(i + 1), Name: "host1", QueueLoc: "linux.host1",
+			PID: 100 + i, Status: mig.AgtStatusOnline, StartTime: now, HeartBeatTS: now,
+			Env: mig.AgentEnv{PublicIP: ip}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	agents, err := s.ActiveAgentsByQueue("linux.host1", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 {
+		t.Fatalf("expected 2 agents on linux.host1, got %d", len(agents))
+	}
+	d, kill := mig.ResolveDuplicateAgents("linux.host1", mig.DupPolicyKillOldest, agents)
+	if d.Kind != mig.DupKindClone || kill != nil {
+		t.Errorf("expected agents with distinct public ips to be clones, got a %s", d.Kind)
+	}
+}
+
+func TestIterSearchAgents(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		err := s.InsertAgent(mig.Agent{Name: "agent", QueueLoc: "linux.agent", PID: i,
+			Status: mig.AgtStatusOnline, HeartBeatTS: now.Add(-time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := search.NewParameters()
+	p.Type = "agent"
+	p.Limit = 2
+	var pids []int
+	err := s.IterSearchAgents(p, func(agt mig.Agent) error {
+		pids = append(pids, agt.PID)
+		return nil
+	})
+	if err != nil || len(pids) != 2 || pids[0] != 0 {
+		t.Fatalf("expected the 2 most recent agents, got %v (%v)", pids, err)
+	}
+	p.Limit = -1
+	count := 0
+	err = s.IterSearchAgents(p, func(agt mig.Agent) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 5 {
+		t.Fatalf("expected a negative limit to return all 5 agents, got %d (%v)", count, err)
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	s := New()
+	now := time.Now()
+	err := s.InsertWebhook(mig.Webhook{ID: 1, Name: "soc", URL: "https://hooks.example.net/mig",
+		Events: []string{mig.WebhookEventActionDone}, Status: mig.WebhookStatusActive, CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		queued, err := s.QueueWebhookDelivery(mig.WebhookDelivery{ID: float64(10 + i), WebhookID: 1,
+			Event: mig.WebhookEventActionDone, ActionID: 5, NextAttempt: now, CreatedAt: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if queued != (i == 0) {
+			t.Fatalf("attempt %d: expected queued=%t", i, i == 0)
+		}
+	}
+	due, _ := s.DueWebhookDeliveries(now, 10)
+	if len(due) != 1 || due[0].ID != 10 {
+		t.Fatalf("expected delivery 10 to be due, got %v", due)
+	}
+	due[0].Attempts = 1
+	due[0].NextAttempt = now.Add(mig.WebhookRetryDelay(1))
+	err = s.UpdateWebhookDelivery(due[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, _ = s.DueWebhookDeliveries(now, 10)
+	if len(due) != 0 {
+		t.Fatalf("expected no delivery to be due before the retry delay, got %v", due)
+	}
+	err = s.UpdateWebhookStatus(1, mig.WebhookStatusDisabled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := s.WebhookByID(1)
+	if w.Status != mig.WebhookStatusDisabled {
+		t.Fatalf("expected webhook to be disabled, got %s", w.Status)
+	}
+	log, _ := s.WebhookDeliveries(1, 10)
+	if len(log) != 1 || log[0].Attempts != 1 {
+		t.Fatalf("expected one delivery with one attempt, got %v", log)
+	}
+}
+
+func TestCases(t *testing.T) {
+	s := New()
+	iid, err := s.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "ABCD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		err = s.InsertAction(mig.Action{ID: float64(i), Name: "find things", Status: "pending",
+			ValidFrom: time.Now().Add(-time.Minute), ExpireAfter: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UTC()
+	c := mig.Case{ID: 100, Title: "compromised bastion", Status: mig.CaseStatusOpen, CreatedBy: iid,
+		CreatedAt: now, LastModified: now, Assignees: []mig.CaseAssignee{{InvestigatorID: iid}}}
+	err = s.InsertCase(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddCaseAction(c.ID, 3)
+	if err == nil {
+		t.Fatal("expected an unknown action to not be attached")
+	}
+	err = s.AddCaseAction(c.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddCaseNote(c.ID, mig.CaseNote{ID: 1, InvestigatorID: iid, Text: "ssh keys replaced", CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddCaseAgent(c.ID, mig.CaseAgent{AgentID: 5, Name: "bastion1", QueueLoc: "linux.bastion1", AddedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddCaseAgent(c.ID, mig.CaseAgent{AgentID: 6, Name: "bastion1", QueueLoc: "linux.bastion1", AddedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.CaseByID(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Assignees) != 1 || got.Assignees[0].Name != "Bob" || len(got.Notes) != 1 ||
+		got.Notes[0].Author != "Bob" || len(got.Agents) != 1 || got.Agents[0].AgentID != 6 ||
+		!got.HasAction(2) || got.HasAction(1) {
+		t.Fatalf("unexpected case %+v", got)
+	}
+
+	p := search.NewParameters()
+	p.CaseID = "100"
+	actions, err := s.SearchActions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].ID != 2 {
+		t.Fatalf("expected action 2 to be found by case, got %v", actions)
+	}
+
+	got.Status = mig.CaseStatusClosed
+	got.Assignees = nil
+	err = s.UpdateCase(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, _ := s.Cases(mig.CaseStatusOpen, 10)
+	closed, _ := s.Cases(mig.CaseStatusClosed, 10)
+	if len(open) != 0 || len(closed) != 1 || len(closed[0].Assignees) != 0 {
+		t.Fatalf("expected the case to be closed and unassigned, got %v and %v", open, closed)
+	}
+	err = s.RemoveCaseAction(c.ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, _ = s.SearchActions(p)
+	if len(actions) != 0 {
+		t.Fatalf("expected no action in the case, got %v", actions)
+	}
+}
@@ -0,0 +1,390 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/search"
+)
+
+// filter evaluates search parameters against the records of the store, the
+// way the searches of the database package do with joins
+type filter struct {
+	p             search.Parameters
+	before, after bool
+	ids           map[string]float64
+}
+
+func newFilter(p search.Parameters) (f filter, err error) {
+	if p.ResultPath != "" || p.ResultValue != "" || p.ResultText != "" {
+		err = fmt.Errorf("searching inside command results is not supported by the memory store")
+		return
+	}
+	f.p = p
+	f.before = p.Before.Before(time.Now().Add(search.DefaultWindow - time.Hour))
+	f.after = p.After.After(time.Now().Add(-(search.DefaultWindow - time.Hour)))
+	f.ids = make(map[string]float64)
+	for name, id := range map[string]string{"action": p.ActionID, "agent": p.AgentID,
+		"command": p.CommandID, "investigator": p.InvestigatorID, "loader": p.LoaderID,
+		"manifest": p.ManifestID, "case": p.CaseID} {
+		if id == "∞" {
+			continue
+		}
+		f.ids[name], err = strconv.ParseFloat(id, 64)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// id returns true if value is the identifier searched for the given kind of
+// record, or if no identifier is searched for it
+func (f filter) id(kind string, value float64) bool {
+	id, ok := f.ids[kind]
+	return !ok || id == value
+}
+
+func (f filter) window(t time.Time) bool {
+	if f.before && t.After(f.p.Before) {
+		return false
+	}
+	if f.after && t.Before(f.p.After) {
+		return false
+	}
+	return true
+}
+
+func (f filter) hasActionFilter() bool {
+	return f.p.ActionID != "∞" || f.p.ActionName != "%" || f.p.ThreatFamily != "%"
+}
+
+func (f filter) hasAgentFilter() bool {
+	return f.p.AgentID != "∞" || f.p.AgentName != "%" || f.p.AgentVersion != "%"
+}
+
+func (f filter) hasInvestigatorFilter() bool {
+	return f.p.InvestigatorID != "∞" || f.p.InvestigatorName != "%"
+}
+
+func (f filter) action(a mig.Action) bool {
+	return f.id("action", a.ID) && ilike(a.Name, f.p.ActionName) &&
+		ilike(a.Threat.Family, f.p.ThreatFamily)
+}
+
+func (f filter) agent(agt mig.Agent) bool {
+	return f.id("agent", agt.ID) && ilike(agt.Name, f.p.AgentName) &&
+		ilike(agt.Version, f.p.AgentVersion)
+}
+
+func (f filter) investigator(inv mig.Investigator) bool {
+	return f.id("investigator", inv.ID) && ilike(inv.Name, f.p.InvestigatorName)
+}
+
+// signedBy returns true if action aid was signed by an investigator that
+// matches the investigator filters
+func (s *Store) signedBy(f filter, aid float64) bool {
+	for _, sig := range s.signatures {
+		if sig.actionID != aid {
+			continue
+		}
+		i := s.investigatorIndex(sig.investigatorID)
+		if i >= 0 && f.investigator(s.investigators[i].inv) {
+			return true
+		}
+	}
+	return false
+}
+
+// paginate applies the offset and limit of the search parameters
+func paginate(p search.Parameters, n int) (start, end int) {
+	start = int(p.Offset)
+	if start > n {
+		start = n
+	}
+	end = n
+	if p.Limit >= 0 && start+int(p.Limit) < n {
+		end = start + int(p.Limit)
+	}
+	return
+}
+
+// SearchCommands returns an array of commands that match search parameters
+func (s *Store) SearchCommands(p search.Parameters, doFoundAnything bool) (commands []mig.Command, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stored := range s.commands {
+		cmd, ok := s.joinCommand(stored)
+		if !ok || !f.window(cmd.StartTime) || !f.id("command", cmd.ID) ||
+			!ilike(cmd.Status, p.Status) || !f.action(cmd.Action) || !f.agent(cmd.Agent) ||
+			!s.signedBy(f, cmd.Action.ID) {
+			continue
+		}
+		if doFoundAnything {
+			if cmd.Status != mig.StatusSuccess {
+				continue
+			}
+			found := false
+			for _, r := range cmd.Results {
+				if r.FoundAnything == p.FoundAnything {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		cmd, err = copyResults(cmd)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	sort.SliceStable(commands, func(i, j int) bool {
+		return commands[i].StartTime.After(commands[j].StartTime)
+	})
+	start, end := paginate(p, len(commands))
+	commands = commands[start:end]
+	for i := range commands {
+		commands[i].Action.Counters = s.actionCounters(commands[i].Action.ID)
+		commands[i].Action.Investigators = s.signers(commands[i].Action.ID)
+	}
+	return
+}
+
+// signers returns the investigators that signed action aid
+func (s *Store) signers(aid float64) (invs []mig.Investigator) {
+	for _, sig := range s.signatures {
+		if sig.actionID != aid {
+			continue
+		}
+		i := s.investigatorIndex(sig.investigatorID)
+		if i < 0 {
+			continue
+		}
+		inv := s.investigators[i].inv
+		inv.PublicKey = nil
+		inv.PrivateKey = nil
+		invs = append(invs, inv)
+	}
+	return
+}
+
+// SearchActions returns an array of actions that match search parameters
+func (s *Store) SearchActions(p search.Parameters) (actions []mig.Action, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, a := range s.actions {
+		if (f.before && a.ExpireAfter.After(p.Before)) || (f.after && a.ValidFrom.Before(p.After)) ||
+			!ilike(a.Status, p.Status) || !f.action(a) {
+			continue
+		}
+		if f.hasInvestigatorFilter() && !s.signedBy(f, a.ID) {
+			continue
+		}
+		if p.CaseID != "∞" && !s.inCase(f.ids["case"], a.ID) {
+			continue
+		}
+		if f.hasAgentFilter() || p.CommandID != "∞" {
+			found := false
+			for _, stored := range s.commands {
+				cmd, ok := s.joinCommand(stored)
+				if ok && cmd.Action.ID == a.ID && f.id("command", cmd.ID) && f.agent(cmd.Agent) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		actions = append(actions, a)
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].ValidFrom.After(actions[j].ValidFrom)
+	})
+	start, end := paginate(p, len(actions))
+	actions = actions[start:end]
+	for i := range actions {
+		actions[i].Counters = s.actionCounters(actions[i].ID)
+		actions[i].Investigators = s.signers(actions[i].ID)
+	}
+	return
+}
+
+// SearchAgents returns an array of agents that match search parameters
+func (s *Store) SearchAgents(p search.Parameters) (agents []mig.Agent, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, agt := range s.agents {
+		if !f.window(agt.HeartBeatTS) || !f.agent(agt) || !ilike(agt.Status, p.Status) {
+			continue
+		}
+		if f.hasActionFilter() || f.hasInvestigatorFilter() || p.CommandID != "∞" {
+			found := false
+			for _, stored := range s.commands {
+				cmd, ok := s.joinCommand(stored)
+				if !ok || cmd.Agent.ID != agt.ID || !f.id("command", cmd.ID) || !f.action(cmd.Action) {
+					continue
+				}
+				if f.hasInvestigatorFilter() && !s.signedBy(f, cmd.Action.ID) {
+					continue
+				}
+				found = true
+				break
+			}
+			if !found {
+				continue
+			}
+		}
+		agents = append(agents, agt)
+	}
+	sort.SliceStable(agents, func(i, j int) bool {
+		return agents[i].HeartBeatTS.After(agents[j].HeartBeatTS)
+	})
+	start, end := paginate(p, len(agents))
+	return agents[start:end], nil
+}
+
+// IterSearchAgents calls fn on each agent that matches search parameters. A
+// negative limit returns all the matching agents. If fn returns an error, the
+// iteration stops and the error is returned.
+func (s *Store) IterSearchAgents(p search.Parameters, fn func(mig.Agent) error) (err error) {
+	agents, err := s.SearchAgents(p)
+	if err != nil {
+		return
+	}
+	for _, agt := range agents {
+		err = fn(agt)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SearchInvestigators returns an array of investigators that match search parameters
+func (s *Store) SearchInvestigators(p search.Parameters) (investigators []mig.Investigator, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stored := range s.investigators {
+		inv := stored.inv
+		if !f.window(inv.LastModified) || !f.investigator(inv) || !ilike(inv.Status, p.Status) {
+			continue
+		}
+		if f.hasActionFilter() || f.hasAgentFilter() || p.CommandID != "∞" {
+			found := false
+			for _, sig := range s.signatures {
+				if sig.investigatorID != inv.ID {
+					continue
+				}
+				ai := s.actionIndex(sig.actionID)
+				if ai < 0 || !f.action(s.actions[ai]) {
+					continue
+				}
+				if !f.hasAgentFilter() && p.CommandID == "∞" {
+					found = true
+					break
+				}
+				for _, c := range s.commands {
+					cmd, ok := s.joinCommand(c)
+					if ok && cmd.Action.ID == sig.actionID && f.id("command", cmd.ID) && f.agent(cmd.Agent) {
+						found = true
+						break
+					}
+				}
+				if found {
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		inv.PublicKey = nil
+		inv.PrivateKey = nil
+		investigators = append(investigators, inv)
+	}
+	sort.SliceStable(investigators, func(i, j int) bool {
+		return investigators[i].ID < investigators[j].ID
+	})
+	start, end := paginate(p, len(investigators))
+	return investigators[start:end], nil
+}
+
+// SearchManifests returns an array of manifest records that match search parameters
+func (s *Store) SearchManifests(p search.Parameters) (mrecords []mig.ManifestRecord, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, m := range s.manifests {
+		if !f.window(m.Timestamp) || !ilike(m.Name, p.ManifestName) ||
+			!f.id("manifest", m.ID) || !ilike(m.Status, p.Status) {
+			continue
+		}
+		mrecords = append(mrecords, mig.ManifestRecord{ID: m.ID, Name: m.Name,
+			Status: m.Status, Target: m.Target, Timestamp: m.Timestamp})
+	}
+	sort.SliceStable(mrecords, func(i, j int) bool {
+		return mrecords[i].Timestamp.After(mrecords[j].Timestamp)
+	})
+	return
+}
+
+// SearchLoaders returns an array of loader entries that match search parameters
+func (s *Store) SearchLoaders(p search.Parameters) (lrecords []mig.LoaderEntry, err error) {
+	f, err := newFilter(p)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.loaders {
+		if !f.window(l.entry.LastSeen) || !ilike(l.entry.Name, p.LoaderName) ||
+			!f.id("loader", l.entry.ID) {
+			continue
+		}
+		if p.AgentName != "%" && (l.entry.AgentName == "" || !ilike(l.entry.AgentName, p.AgentName)) {
+			continue
+		}
+		le := mig.LoaderEntry{ID: l.entry.ID, Name: l.entry.Name, AgentName: l.entry.AgentName,
+			LastSeen: l.entry.LastSeen, Enabled: l.entry.Enabled}
+		if le.AgentName == "" {
+			le.AgentName = "unset"
+		}
+		lrecords = append(lrecords, le)
+	}
+	sort.SliceStable(lrecords, func(i, j int) bool {
+		return lrecords[i].Name < lrecords[j].Name
+	})
+	return
+}
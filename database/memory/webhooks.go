@@ -0,0 +1,137 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package memory /* import "github.com/mozilla/mig/database/memory" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// InsertWebhook stores a new webhook
+func (s *Store) InsertWebhook(w mig.Webhook) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, existing := range s.webhooks {
+		if existing.ID == w.ID {
+			return fmt.Errorf("Failed to insert webhook: duplicate id %.0f", w.ID)
+		}
+	}
+	w.Events = append([]string(nil), w.Events...)
+	s.webhooks = append(s.webhooks, w)
+	return
+}
+
+// WebhookByID retrieves a webhook, including its secret
+func (s *Store) WebhookByID(id float64) (w mig.Webhook, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	err = fmt.Errorf("Error while retrieving webhook: 'sql: no rows in result set'")
+	return
+}
+
+// Webhooks returns all the webhooks, including their secrets
+func (s *Store) Webhooks() (webhooks []mig.Webhook, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	webhooks = append(webhooks, s.webhooks...)
+	sort.SliceStable(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+	return
+}
+
+// UpdateWebhookStatus enables or disables a webhook
+func (s *Store) UpdateWebhookStatus(id float64, status string) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.webhooks {
+		if s.webhooks[i].ID == id {
+			s.webhooks[i].Status = status
+			return
+		}
+	}
+	return fmt.Errorf("Failed to update webhook status, 0 rows affected")
+}
+
+// QueueWebhookDelivery stores a pending delivery, unless the same event was
+// already queued for the webhook and action
+func (s *Store) QueueWebhookDelivery(d mig.WebhookDelivery) (queued bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, existing := range s.deliveries {
+		if existing.WebhookID == d.WebhookID && existing.ActionID == d.ActionID && existing.Event == d.Event {
+			return false, nil
+		}
+	}
+	d.Status = mig.WebhookDeliveryPending
+	d.Attempts = 0
+	s.deliveries = append(s.deliveries, d)
+	return true, nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next attempt
+// is before pointInTime, oldest first
+func (s *Store) DueWebhookDeliveries(pointInTime time.Time, limit int) (deliveries []mig.WebhookDelivery, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, d := range s.deliveries {
+		if d.Status == mig.WebhookDeliveryPending && !d.NextAttempt.After(pointInTime) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(deliveries[j].NextAttempt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return
+}
+
+// WebhookDeliveries returns the last deliveries of a webhook, most recent first
+func (s *Store) WebhookDeliveries(webhookid float64, limit int) (deliveries []mig.WebhookDelivery, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookid {
+			deliveries = append(deliveries, d)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt to deliver a webhook
+func (s *Store) UpdateWebhookDelivery(d mig.WebhookDelivery) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == d.ID {
+			s.deliveries[i].Status = d.Status
+			s.deliveries[i].Attempts = d.Attempts
+			s.deliveries[i].NextAttempt = d.NextAttempt
+			s.deliveries[i].LastAttempt = d.LastAttempt
+			s.deliveries[i].ResponseCode = d.ResponseCode
+			s.deliveries[i].LastError = d.LastError
+			return
+		}
+	}
+	return fmt.Errorf("Failed to update webhook delivery: unknown delivery %.0f", d.ID)
+}
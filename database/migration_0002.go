@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0002 stores the PGP keys of investigators in their own table, so
+// an investigator can rotate keys without losing its history. The current key
+// of each investigator is copied into the new table.
+const migration0002 = `CREATE SEQUENCE investigator_keys_id_seq START 1;
+CREATE TABLE investigator_keys (
+    id              numeric NOT NULL DEFAULT nextval('investigator_keys_id_seq'),
+    investigatorid  numeric NOT NULL,
+    pgpfingerprint  character varying(128) NOT NULL,
+    publickey       bytea NOT NULL,
+    status          character varying(255) NOT NULL,
+    validfrom       timestamp with time zone NOT NULL,
+    expireafter     timestamp with time zone,
+    createdat       timestamp with time zone NOT NULL,
+    revokedat       timestamp with time zone
+);
+ALTER TABLE public.investigator_keys OWNER TO migadmin;
+ALTER TABLE ONLY investigator_keys
+    ADD CONSTRAINT investigator_keys_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY investigator_keys
+    ADD CONSTRAINT investigator_keys_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+CREATE UNIQUE INDEX investigator_keys_pgpfingerprint_idx ON investigator_keys USING btree (pgpfingerprint);
+CREATE INDEX investigator_keys_investigatorid_idx ON investigator_keys USING btree (investigatorid);
+
+INSERT INTO investigator_keys (investigatorid, pgpfingerprint, publickey, status, validfrom, createdat)
+    SELECT id, pgpfingerprint, publickey, 'active', createdat, createdat FROM investigators
+    WHERE pgpfingerprint IS NOT NULL AND publickey IS NOT NULL;
+
+GRANT SELECT, INSERT ON investigator_keys TO migscheduler;
+GRANT USAGE ON SEQUENCE investigator_keys_id_seq TO migscheduler;
+GRANT SELECT, INSERT ON investigator_keys TO migapi;
+GRANT UPDATE (status, expireafter, revokedat) ON investigator_keys TO migapi;
+GRANT UPDATE (pgpfingerprint, publickey) ON investigators TO migapi;
+GRANT USAGE ON SEQUENCE investigator_keys_id_seq TO migapi;
+GRANT SELECT (id, investigatorid, pgpfingerprint, publickey, status, validfrom, expireafter, createdat, revokedat) ON investigator_keys TO migreadonly;
+`
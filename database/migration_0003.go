@@ -0,0 +1,36 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0003 stores the policies the API renders agent ACLs from: the
+// minimum weight of each module, and the weight of investigators in it
+const migration0003 = `CREATE TABLE aclpolicies (
+    module          character varying(256) NOT NULL,
+    minimumweight   integer NOT NULL,
+    lastmodified    timestamp with time zone NOT NULL
+);
+ALTER TABLE public.aclpolicies OWNER TO migadmin;
+ALTER TABLE ONLY aclpolicies
+    ADD CONSTRAINT aclpolicies_pkey PRIMARY KEY (module);
+
+CREATE TABLE aclweights (
+    module          character varying(256) NOT NULL,
+    investigatorid  numeric NOT NULL,
+    weight          integer NOT NULL
+);
+ALTER TABLE public.aclweights OWNER TO migadmin;
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_pkey PRIMARY KEY (module, investigatorid);
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_module_fkey FOREIGN KEY (module) REFERENCES aclpolicies(module) ON DELETE CASCADE;
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+GRANT SELECT ON aclpolicies, aclweights TO migscheduler;
+GRANT SELECT, INSERT, UPDATE, DELETE ON aclpolicies, aclweights TO migapi;
+GRANT SELECT ON aclpolicies, aclweights TO migreadonly;
+`
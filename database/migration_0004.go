@@ -0,0 +1,13 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0004 indexes the timestamps the dashboard selects time windows on
+const migration0004 = `CREATE INDEX agents_stats_timestamp_idx ON agents_stats(timestamp DESC);
+CREATE INDEX actions_starttime_idx ON actions(starttime DESC);
+CREATE INDEX commands_starttime_idx ON commands(starttime DESC);
+`
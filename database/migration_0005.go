@@ -0,0 +1,64 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0005 records when the lifecycle status of agents was last set, and
+// creates the tables decommissioned agents and their commands are archived in.
+// Investigators that can update other investigators are given the permission
+// to change the lifecycle of agents (bit 19).
+const migration0005 = `ALTER TABLE agents ADD COLUMN lifecycletime timestamp with time zone;
+
+CREATE TABLE agents_archive (
+    id                  numeric NOT NULL,
+    name                character varying(2048) NOT NULL,
+    queueloc            character varying(2048) NOT NULL,
+    mode                character varying(2048) NOT NULL,
+    version             character varying(2048) NOT NULL,
+    pid                 integer NOT NULL,
+    starttime           timestamp with time zone NOT NULL,
+    destructiontime     timestamp with time zone,
+    heartbeattime       timestamp with time zone NOT NULL,
+    refreshtime         timestamp with time zone NOT NULL,
+    status              character varying(255),
+    environment         json,
+    tags                json,
+    loadername          character varying(2048),
+    lifecycletime       timestamp with time zone,
+    archivedat          timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agents_archive OWNER TO migadmin;
+ALTER TABLE ONLY agents_archive
+    ADD CONSTRAINT agents_archive_pkey PRIMARY KEY (id);
+CREATE INDEX agents_archive_queueloc_idx ON agents_archive(queueloc);
+
+CREATE TABLE commands_archive (
+    id          numeric NOT NULL,
+    actionid    numeric NOT NULL,
+    agentid     numeric NOT NULL,
+    status      character varying(255) NOT NULL,
+    results     jsonb,
+    starttime   timestamp with time zone NOT NULL,
+    finishtime  timestamp with time zone
+);
+ALTER TABLE public.commands_archive OWNER TO migadmin;
+ALTER TABLE ONLY commands_archive
+    ADD CONSTRAINT commands_archive_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY commands_archive
+    ADD CONSTRAINT commands_archive_agentid_fkey FOREIGN KEY (agentid) REFERENCES agents_archive(id);
+CREATE INDEX commands_archive_agentid ON commands_archive(agentid DESC);
+CREATE INDEX commands_archive_actionid ON commands_archive(actionid DESC);
+
+ALTER TABLE agents_stats ADD COLUMN quarantined_endpoints numeric;
+ALTER TABLE agents_stats ADD COLUMN decommissioned_endpoints numeric;
+
+UPDATE investigators SET permissions = permissions | 524288 WHERE (permissions & 262144) != 0;
+
+GRANT SELECT, INSERT ON agents_archive, commands_archive TO migscheduler;
+GRANT DELETE ON agents, commands, invagtmodperm TO migscheduler;
+GRANT SELECT ON agents_archive, commands_archive TO migapi;
+GRANT SELECT ON agents_archive, commands_archive TO migreadonly;
+`
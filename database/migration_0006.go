@@ -0,0 +1,29 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0006 creates the table the scheduler records its decisions on
+// duplicate and cloned agents in
+const migration0006 = `CREATE TABLE agents_duplicates (
+    id          numeric NOT NULL,
+    queueloc    character varying(2048) NOT NULL,
+    kind        character varying(32) NOT NULL,
+    policy      character varying(32) NOT NULL,
+    decision    character varying(32) NOT NULL,
+    evidence    json NOT NULL,
+    createdat   timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agents_duplicates OWNER TO migadmin;
+ALTER TABLE ONLY agents_duplicates
+    ADD CONSTRAINT agents_duplicates_pkey PRIMARY KEY (id);
+CREATE INDEX agents_duplicates_queueloc_idx ON agents_duplicates(queueloc, createdat DESC);
+CREATE INDEX agents_duplicates_createdat_idx ON agents_duplicates(createdat DESC);
+
+GRANT SELECT, INSERT ON agents_duplicates TO migscheduler;
+GRANT SELECT ON agents_duplicates TO migapi;
+GRANT SELECT ON agents_duplicates TO migreadonly;
+`
@@ -0,0 +1,60 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0007 creates the webhooks notified of action events and the log of
+// their deliveries. A delivery is unique per webhook, action and event, so
+// each event is delivered once. Investigators that can update other
+// investigators are given the permission to manage webhooks (bit 20).
+const migration0007 = `CREATE TABLE webhooks (
+    id                  numeric NOT NULL,
+    name                character varying(1024) NOT NULL,
+    url                 character varying(2048) NOT NULL,
+    secret              character varying(128) NOT NULL,
+    events              json NOT NULL,
+    investigatorid      numeric,
+    threatfamily        character varying(256),
+    target              character varying(2048),
+    failurethreshold    integer NOT NULL DEFAULT 0,
+    status              character varying(32) NOT NULL,
+    createdat           timestamp with time zone NOT NULL
+);
+ALTER TABLE public.webhooks OWNER TO migadmin;
+ALTER TABLE ONLY webhooks
+    ADD CONSTRAINT webhooks_pkey PRIMARY KEY (id);
+
+CREATE TABLE webhook_deliveries (
+    id              numeric NOT NULL,
+    webhookid       numeric NOT NULL,
+    event           character varying(64) NOT NULL,
+    actionid        numeric NOT NULL,
+    payload         json NOT NULL,
+    status          character varying(32) NOT NULL,
+    attempts        integer NOT NULL DEFAULT 0,
+    nextattempt     timestamp with time zone NOT NULL,
+    lastattempt     timestamp with time zone,
+    responsecode    integer,
+    lasterror       text,
+    createdat       timestamp with time zone NOT NULL
+);
+ALTER TABLE public.webhook_deliveries OWNER TO migadmin;
+ALTER TABLE ONLY webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_webhookid_fkey FOREIGN KEY (webhookid) REFERENCES webhooks(id);
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries(webhookid, actionid, event);
+CREATE INDEX webhook_deliveries_nextattempt_idx ON webhook_deliveries(status, nextattempt);
+
+UPDATE investigators SET permissions = permissions | 1048576 WHERE (permissions & 262144) != 0;
+
+GRANT SELECT, INSERT, UPDATE ON webhooks TO migapi;
+GRANT SELECT ON webhook_deliveries TO migapi;
+GRANT SELECT ON webhooks TO migscheduler;
+GRANT SELECT, INSERT, UPDATE ON webhook_deliveries TO migscheduler;
+GRANT SELECT (id, name, url, events, investigatorid, threatfamily, target, failurethreshold, status, createdat) ON webhooks TO migreadonly;
+GRANT SELECT ON webhook_deliveries TO migreadonly;
+`
@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0008 creates the cases investigations are tracked in, with their
+// assignees, notes, actions and agents of interest. Agents are recorded by
+// name and queue location rather than by reference, since agent rows are
+// archived when endpoints are decommissioned. Investigators that can read
+// actions are given the permission to manage cases (bit 21).
+const migration0008 = `CREATE TABLE cases (
+    id              numeric NOT NULL,
+    title           character varying(2048) NOT NULL,
+    status          character varying(32) NOT NULL,
+    createdby       numeric NOT NULL,
+    createdat       timestamp with time zone NOT NULL,
+    lastmodified    timestamp with time zone NOT NULL
+);
+ALTER TABLE public.cases OWNER TO migadmin;
+ALTER TABLE ONLY cases
+    ADD CONSTRAINT cases_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY cases
+    ADD CONSTRAINT cases_createdby_fkey FOREIGN KEY (createdby) REFERENCES investigators(id);
+CREATE INDEX cases_status_idx ON cases(status);
+
+CREATE TABLE case_assignees (
+    caseid          numeric NOT NULL,
+    investigatorid  numeric NOT NULL
+);
+ALTER TABLE public.case_assignees OWNER TO migadmin;
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_pkey PRIMARY KEY (caseid, investigatorid);
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+CREATE TABLE case_notes (
+    id              numeric NOT NULL,
+    caseid          numeric NOT NULL,
+    investigatorid  numeric NOT NULL,
+    note            text NOT NULL,
+    createdat       timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_notes OWNER TO migadmin;
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+CREATE INDEX case_notes_caseid_idx ON case_notes(caseid);
+
+CREATE TABLE case_actions (
+    caseid          numeric NOT NULL,
+    actionid        numeric NOT NULL,
+    addedat         timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_actions OWNER TO migadmin;
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_pkey PRIMARY KEY (caseid, actionid);
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_actionid_fkey FOREIGN KEY (actionid) REFERENCES actions(id);
+CREATE INDEX case_actions_actionid_idx ON case_actions(actionid);
+
+CREATE TABLE case_agents (
+    caseid          numeric NOT NULL,
+    agentid         numeric NOT NULL,
+    name            character varying(2048) NOT NULL,
+    queueloc        character varying(2048) NOT NULL,
+    addedat         timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_agents OWNER TO migadmin;
+ALTER TABLE ONLY case_agents
+    ADD CONSTRAINT case_agents_pkey PRIMARY KEY (caseid, queueloc);
+ALTER TABLE ONLY case_agents
+    ADD CONSTRAINT case_agents_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+
+UPDATE investigators SET permissions = permissions | 2097152 WHERE (permissions & 2) != 0;
+
+GRANT SELECT, INSERT, UPDATE ON cases TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_assignees TO migapi;
+GRANT SELECT, INSERT ON case_notes TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_actions TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_agents TO migapi;
+GRANT SELECT ON cases, case_assignees, case_notes, case_actions, case_agents TO migreadonly;
+`
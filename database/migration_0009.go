@@ -0,0 +1,29 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0009 stores the public IPs agents report, by address family and
+// proxy, in an array next to their environment, so targets can match any of
+// them with '198.51.100.4' = ANY(publicips). Existing agents are given the
+// single public IP of their environment. Values that are not valid addresses,
+// such as empty strings or the placeholders of old agents, would abort the
+// cast, so they are converted by a function that returns NULL instead, and
+// those agents are left without public IPs.
+const migration0009 = `ALTER TABLE agents ADD COLUMN publicips inet[];
+ALTER TABLE agents_archive ADD COLUMN publicips inet[];
+CREATE FUNCTION migration_0009_inet(ip text) RETURNS inet AS $$
+BEGIN
+    RETURN ip::inet;
+EXCEPTION WHEN invalid_text_representation THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+UPDATE agents SET publicips = ARRAY[migration_0009_inet(environment->>'publicip')]
+    WHERE migration_0009_inet(environment->>'publicip') IS NOT NULL;
+DROP FUNCTION migration_0009_inet(text);
+CREATE INDEX agents_publicips_idx ON agents USING gin (publicips);
+`
@@ -0,0 +1,15 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+// migration0010 stores the nonce that signers choose for version 3 actions,
+// which is part of the signed payload. Nonces are unique, so the API refuses
+// to create an action that replays a signed one. Legacy actions have an empty
+// nonce.
+const migration0010 = `ALTER TABLE actions ADD COLUMN nonce character varying(256) NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX actions_nonce_idx ON actions (nonce) WHERE nonce != '';
+`
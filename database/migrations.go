@@ -0,0 +1,253 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Migration is a change of the database schema. Migrations are applied in
+// order of version, each in its own transaction, and the versions applied
+// are recorded in the schema_version table.
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+}
+
+// migrations is the ordered list of all the schema migrations. New
+// migrations are appended at the end with the next version number, and
+// existing migrations must never be modified once released.
+var migrations = []Migration{
+	{Version: 1, Description: "initial schema", Up: migration0001},
+	{Version: 2, Description: "investigator keys", Up: migration0002},
+	{Version: 3, Description: "agent acl policies", Up: migration0003},
+	{Version: 4, Description: "dashboard indexes", Up: migration0004},
+	{Version: 5, Description: "agent lifecycle", Up: migration0005},
+	{Version: 6, Description: "duplicate agents decisions", Up: migration0006},
+	{Version: 7, Description: "webhooks", Up: migration0007},
+	{Version: 8, Description: "cases", Up: migration0008},
+	{Version: 9, Description: "agents public ips", Up: migration0009},
+	{Version: 10, Description: "actions nonce", Up: migration0010},
+}
+
+// migrationLockID is the key of the postgres advisory lock held while
+// migrations are applied, so concurrent runs apply each migration once
+const migrationLockID int64 = 0x6d69672d6462 // "mig-db"
+
+// schemaVersionTable creates the table that records the migrations applied
+const schemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+    version     integer NOT NULL PRIMARY KEY,
+    description character varying(1024) NOT NULL,
+    appliedat   timestamp with time zone NOT NULL DEFAULT now()
+);
+ALTER TABLE public.schema_version OWNER TO migadmin;
+GRANT SELECT ON schema_version TO migapi, migscheduler;
+`
+
+// baselineUpgrade brings a database created with schema.sql before schema
+// migrations were introduced to the schema of migration 1. The statements
+// can be run on a database that is already partially upgraded.
+const baselineUpgrade = `ALTER TABLE commands ALTER COLUMN results TYPE jsonb USING results::jsonb;
+CREATE INDEX IF NOT EXISTS commands_results_idx ON commands USING gin (results);
+CREATE INDEX IF NOT EXISTS commands_results_fts_idx ON commands USING gin (to_tsvector('simple', results));
+ALTER TABLE investigators ADD COLUMN IF NOT EXISTS apikeyprefix character varying(8);
+CREATE UNIQUE INDEX IF NOT EXISTS investigators_apikeyprefix_idx ON investigators USING btree (apikeyprefix);
+GRANT SELECT (apikeyprefix), UPDATE (apikeyprefix) ON investigators TO migapi;
+`
+
+// Migrations returns the list of schema migrations, ordered by version
+func Migrations() []Migration {
+	ret := make([]Migration, len(migrations))
+	copy(ret, migrations)
+	return ret
+}
+
+// LatestSchemaVersion returns the version of the schema this package expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaSQL returns the SQL statements that create the latest schema in an
+// empty database, including the schema_version table. database/schema.sql is
+// generated with this function for installations that do not use mig-dbmigrate.
+func SchemaSQL() string {
+	var b strings.Builder
+	b.WriteString(schemaVersionTable)
+	for _, m := range migrations {
+		fmt.Fprintf(&b, "\n-- migration %d: %s\n", m.Version, m.Description)
+		b.WriteString(m.Up)
+		fmt.Fprintf(&b, "INSERT INTO schema_version (version, description) VALUES (%d, '%s');\n",
+			m.Version, strings.Replace(m.Description, "'", "''", -1))
+	}
+	return b.String()
+}
+
+// SchemaVersion returns the version of the schema of the database, or zero
+// if no migration has ever been applied
+func (db *DB) SchemaVersion() (version int, err error) {
+	var table sql.NullString
+	err = db.c.QueryRow(`SELECT to_regclass('public.schema_version')::text`).Scan(&table)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to look up schema_version table: '%v'", err)
+	}
+	if !table.Valid {
+		return 0, nil
+	}
+	err = db.c.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to retrieve schema version: '%v'", err)
+	}
+	return
+}
+
+// CheckSchemaVersion returns an error if the schema of the database is not
+// the one expected by this version of MIG. Components call it at startup and
+// refuse to run against a database that needs to be migrated.
+func (db *DB) CheckSchemaVersion() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d but version %d is required, run mig-dbmigrate to upgrade it", version, latest)
+	}
+	if version > latest {
+		return fmt.Errorf("database schema is at version %d, which is newer than the version %d supported by this release", version, latest)
+	}
+	return nil
+}
+
+// Migrate applies the migrations that have not been applied to the database
+// yet, and returns them. Each migration runs in a transaction that holds an
+// advisory lock, so concurrent calls do not apply a migration twice.
+//
+// A database that contains MIG tables but no schema_version table was created
+// before migrations were introduced, and must be adopted with Baseline first.
+func (db *DB) Migrate() (applied []Migration, err error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return
+	}
+	if version == 0 {
+		var table sql.NullString
+		err = db.c.QueryRow(`SELECT to_regclass('public.actions')::text`).Scan(&table)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up actions table: '%v'", err)
+		}
+		if table.Valid {
+			return nil, fmt.Errorf("database has no schema version but already contains MIG tables, it must be baselined first")
+		}
+	}
+	for _, m := range migrations {
+		var ok bool
+		ok, err = db.applyMigration(m)
+		if err != nil {
+			return
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return
+}
+
+// applyMigration applies migration m if the database is at the version
+// that precedes it, and returns true if it did
+func (db *DB) applyMigration(m Migration) (applied bool, err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return false, fmt.Errorf("Failed to start migration %d: '%v'", m.Version, err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID)
+	if err != nil {
+		return false, fmt.Errorf("Failed to acquire migration lock: '%v'", err)
+	}
+	_, err = tx.Exec(schemaVersionTable)
+	if err != nil {
+		return false, fmt.Errorf("Failed to create schema_version table: '%v'", err)
+	}
+	var version int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return false, fmt.Errorf("Failed to retrieve schema version: '%v'", err)
+	}
+	if version >= m.Version {
+		// already applied, possibly by a concurrent run
+		return false, tx.Rollback()
+	}
+	if version != m.Version-1 {
+		return false, fmt.Errorf("cannot apply migration %d to a database at version %d", m.Version, version)
+	}
+	_, err = tx.Exec(m.Up)
+	if err != nil {
+		return false, fmt.Errorf("Migration %d failed: '%v'", m.Version, err)
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description) VALUES ($1, $2)`,
+		m.Version, m.Description)
+	if err != nil {
+		return false, fmt.Errorf("Failed to record migration %d: '%v'", m.Version, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("Failed to commit migration %d: '%v'", m.Version, err)
+	}
+	return true, nil
+}
+
+// Baseline adopts a database created before migrations were introduced. It
+// upgrades the existing tables to the schema of migration 1 and records it
+// as applied, after which Migrate can apply the following migrations.
+func (db *DB) Baseline() (err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return fmt.Errorf("Failed to start baseline: '%v'", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID)
+	if err != nil {
+		return fmt.Errorf("Failed to acquire migration lock: '%v'", err)
+	}
+	_, err = tx.Exec(schemaVersionTable)
+	if err != nil {
+		return fmt.Errorf("Failed to create schema_version table: '%v'", err)
+	}
+	var version int
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve schema version: '%v'", err)
+	}
+	if version != 0 {
+		return fmt.Errorf("database is already at schema version %d", version)
+	}
+	_, err = tx.Exec(baselineUpgrade)
+	if err != nil {
+		return fmt.Errorf("Baseline upgrade failed: '%v'", err)
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description) VALUES ($1, $2)`,
+		migrations[0].Version, migrations[0].Description)
+	if err != nil {
+		return fmt.Errorf("Failed to record baseline: '%v'", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Failed to commit baseline: '%v'", err)
+	}
+	return
+}
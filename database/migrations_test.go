@@ -0,0 +1,33 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range Migrations() {
+		if m.Version != i+1 {
+			t.Fatalf("migration at position %d has version %d, expected %d", i, m.Version, i+1)
+		}
+		if m.Description == "" || m.Up == "" {
+			t.Fatalf("migration %d has no description or statements", m.Version)
+		}
+	}
+}
+
+func TestSchemaSQLUpToDate(t *testing.T) {
+	buf, err := ioutil.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != SchemaSQL() {
+		t.Fatal("schema.sql is out of date, regenerate it with mig-dbmigrate -print")
+	}
+}
@@ -8,6 +8,7 @@ package search /* import "github.com/mozilla/mig/database/search" */
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -21,6 +22,7 @@ type Parameters struct {
 	AgentName        string    `json:"agentname"`
 	AgentVersion     string    `json:"agentversion"`
 	Before           time.Time `json:"before"`
+	CaseID           string    `json:"caseid"`
 	CommandID        string    `json:"commandid"`
 	FoundAnything    bool      `json:"foundanything"`
 	InvestigatorID   string    `json:"investigatorid"`
@@ -32,6 +34,9 @@ type Parameters struct {
 	ManifestName     string    `json:"manifestname"`
 	Offset           float64   `json:"offset"`
 	Report           string    `json:"report"`
+	ResultPath       string    `json:"resultpath"`
+	ResultText       string    `json:"resulttext"`
+	ResultValue      string    `json:"resultvalue"`
 	Status           string    `json:"status"`
 	Target           string    `json:"target"`
 	ThreatFamily     string    `json:"threatfamily"`
@@ -50,6 +55,7 @@ func NewParameters() (p Parameters) {
 	p.AgentName = "%"
 	p.AgentVersion = "%"
 	p.Before = time.Now().Add(DefaultWindow).UTC()
+	p.CaseID = "∞"
 	p.CommandID = "∞"
 	p.InvestigatorID = "∞"
 	p.InvestigatorName = "%"
@@ -83,6 +89,9 @@ func (p Parameters) String() (query string) {
 	if p.AgentVersion != "%" {
 		query += fmt.Sprintf("&agentversion=%s", p.AgentVersion)
 	}
+	if p.CaseID != "∞" {
+		query += fmt.Sprintf("&caseid=%s", p.CaseID)
+	}
 	if p.CommandID != "∞" {
 		query += fmt.Sprintf("&commandid=%s", p.CommandID)
 	}
@@ -119,5 +128,16 @@ func (p Parameters) String() (query string) {
 	query = strings.Replace(query, "*", "%25", -1)
 	// replace + character with a wildcard
 	query = strings.Replace(query, "+", "%25", -1)
+	// results parameters are free text, they are escaped as a whole and
+	// keep their wildcards encoded as themselves
+	if p.ResultPath != "" {
+		query += fmt.Sprintf("&resultpath=%s", url.QueryEscape(p.ResultPath))
+	}
+	if p.ResultText != "" {
+		query += fmt.Sprintf("&resulttext=%s", url.QueryEscape(p.ResultText))
+	}
+	if p.ResultValue != "" {
+		query += fmt.Sprintf("&resultvalue=%s", url.QueryEscape(p.ResultValue))
+	}
 	return
 }
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package search /* import "github.com/mozilla/mig/database/search" */
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// resultPathKeyRe restricts the keys that can be used in a results path
+var resultPathKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+// resultNumberRe matches values that are compared to numbers in results
+var resultNumberRe = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// ResultsJSONPath transforms the ResultPath and ResultValue parameters into
+// a postgres jsonpath expression evaluated against the results of commands.
+//
+// ResultPath is a dot separated list of keys relative to a single module
+// result, such as `elements.*.file` or `foundanything`. A `*` segment matches
+// any key, and `**` matches any number of levels. Because search parameters
+// are urlencoded with `*` converted to `%`, `%` and `%%` are accepted as
+// wildcards as well. Arrays are traversed implicitly. When ResultPath is empty,
+// the value is searched in every field of the results.
+//
+// ResultValue is compared to the value found at the end of the path. Values
+// that contain `%` or `*` wildcards are matched case insensitively against
+// string values, other values must match exactly, and numbers and booleans
+// also match their string representation.
+func (p Parameters) ResultsJSONPath() (jsonpath string, err error) {
+	path := p.ResultPath
+	if path == "" {
+		if p.ResultValue == "" {
+			return "", fmt.Errorf("results search requires a resultpath or a resultvalue")
+		}
+		path = "**"
+	}
+	jsonpath = "$[*]"
+	for _, key := range strings.Split(path, ".") {
+		switch key {
+		case "*", "%":
+			jsonpath += ".*"
+		case "**", "%%":
+			jsonpath += ".**"
+		default:
+			if !resultPathKeyRe.MatchString(key) {
+				return "", fmt.Errorf("invalid key '%s' in results path '%s'", key, path)
+			}
+			jsonpath += fmt.Sprintf(`."%s"`, key)
+		}
+	}
+	if p.ResultValue == "" {
+		// without a value, match commands that have the path in their results
+		return
+	}
+	value := p.ResultValue
+	if strings.ContainsAny(value, "%*") {
+		re := "^"
+		for _, c := range value {
+			switch c {
+			case '%', '*':
+				re += ".*"
+			default:
+				re += regexp.QuoteMeta(string(c))
+			}
+		}
+		re += "$"
+		jre, err := json.Marshal(re)
+		if err != nil {
+			return "", err
+		}
+		jsonpath += fmt.Sprintf(` ? (@ like_regex %s flag "i")`, jre)
+		return jsonpath, nil
+	}
+	jvalue, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	cond := fmt.Sprintf(`@ == %s`, jvalue)
+	if value == "true" || value == "false" {
+		cond += fmt.Sprintf(` || @ == %s`, value)
+	} else if resultNumberRe.MatchString(value) {
+		cond += fmt.Sprintf(` || @ == %s`, value)
+	}
+	jsonpath += fmt.Sprintf(` ? (%s)`, cond)
+	return
+}
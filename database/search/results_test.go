@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package search /* import "github.com/mozilla/mig/database/search" */
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResultsJSONPath(t *testing.T) {
+	var tests = []struct {
+		path, value string
+		expect      string
+		fails       bool
+	}{
+		{path: "foundanything", expect: `$[*]."foundanything"`},
+		{path: "elements.*.file", expect: `$[*]."elements".*."file"`},
+		{path: "elements.%.file", expect: `$[*]."elements".*."file"`},
+		{path: "elements.**.file", expect: `$[*]."elements".**."file"`},
+		{path: "elements.%%.file", expect: `$[*]."elements".**."file"`},
+		{path: "elements.pkg-name_1", expect: `$[*]."elements"."pkg-name_1"`},
+		{path: "foundanything", value: "true",
+			expect: `$[*]."foundanything" ? (@ == "true" || @ == true)`},
+		{path: "statistics.exectime", value: "-1.5",
+			expect: `$[*]."statistics"."exectime" ? (@ == "-1.5" || @ == -1.5)`},
+		{path: "statistics.count", value: "42",
+			expect: `$[*]."statistics"."count" ? (@ == "42" || @ == 42)`},
+		{path: "elements.*.file", value: "/etc/passwd",
+			expect: `$[*]."elements".*."file" ? (@ == "/etc/passwd")`},
+		{path: "elements.*.file", value: `a"b\c`,
+			expect: `$[*]."elements".*."file" ? (@ == "a\"b\\c")`},
+		{path: "elements.*.file", value: "/etc/%.conf",
+			expect: `$[*]."elements".*."file" ? (@ like_regex "^/etc/.*\\.conf$" flag "i")`},
+		{path: "elements.*.file", value: "*passwd",
+			expect: `$[*]."elements".*."file" ? (@ like_regex "^.*passwd$" flag "i")`},
+		{value: "1.2.3.4", expect: `$[*].** ? (@ == "1.2.3.4")`},
+		{fails: true},
+		{path: `elements."x"`, fails: true},
+		{path: "elements.a b", fails: true},
+		{path: "elements..file", fails: true},
+		{path: "elements.$.file", fails: true},
+		{path: "elements.***", fails: true},
+		{path: "elements.file) || (true", value: "x", fails: true},
+	}
+	for i, tc := range tests {
+		p := NewParameters()
+		p.ResultPath = tc.path
+		p.ResultValue = tc.value
+		jsonpath, err := p.ResultsJSONPath()
+		if tc.fails {
+			if err == nil {
+				t.Errorf("test %d: expected path %q to be rejected, got %s", i, tc.path, jsonpath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if jsonpath != tc.expect {
+			t.Errorf("test %d: expected %s, got %s", i, tc.expect, jsonpath)
+		}
+	}
+}
+
+func TestParametersStringResults(t *testing.T) {
+	p := NewParameters()
+	p.ResultPath = "elements.*.file"
+	p.ResultText = "a&b=c"
+	p.ResultValue = "/etc/my file+%.conf"
+	q, err := url.ParseQuery(p.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Get("resultpath") != p.ResultPath {
+		t.Errorf("expected resultpath %q, got %q", p.ResultPath, q.Get("resultpath"))
+	}
+	if q.Get("resulttext") != p.ResultText {
+		t.Errorf("expected resulttext %q, got %q", p.ResultText, q.Get("resulttext"))
+	}
+	if q.Get("resultvalue") != p.ResultValue {
+		t.Errorf("expected resultvalue %q, got %q", p.ResultValue, q.Get("resultvalue"))
+	}
+	if q.Get("status") != "" || q.Get("type") != "action" {
+		t.Errorf("unexpected parameters in query %s", p.String())
+	}
+}
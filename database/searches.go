@@ -26,6 +26,7 @@ type IDs struct {
 	minInvID, maxInvID         float64
 	minManID, maxManID         float64
 	minLdrID, maxLdrID         float64
+	caseID                     float64
 }
 
 const MAXFLOAT64 float64 = 9007199254740991 // 2^53-1
@@ -85,6 +86,12 @@ func makeIDsFromParams(p search.Parameters) (ids IDs, err error) {
 		}
 		ids.maxLdrID = ids.minLdrID
 	}
+	if p.CaseID != "∞" {
+		ids.caseID, err = strconv.ParseFloat(p.CaseID, 64)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -100,7 +107,7 @@ func (db *DB) SearchCommands(p search.Parameters, doFoundAnything bool) (command
 	query := `SELECT commands.id, commands.status, commands.results, commands.starttime, commands.finishtime,
 			actions.id, actions.name, actions.target, actions.description, actions.threat,
 			actions.operations, actions.validfrom, actions.expireafter, actions.pgpsignatures,
-			actions.syntaxversion, agents.id, agents.name, agents.version, agents.tags, agents.environment
+			actions.syntaxversion, actions.nonce, agents.id, agents.name, agents.version, agents.tags, agents.environment
 		FROM	commands
 			INNER JOIN actions ON ( commands.actionid = actions.id)
 			INNER JOIN signatures ON ( actions.id = signatures.actionid )
@@ -201,14 +208,31 @@ func (db *DB) SearchCommands(p search.Parameters, doFoundAnything bool) (command
 		if valctr > 0 {
 			query += " AND "
 		}
-		query += fmt.Sprintf(`commands.status = $%d
-			AND commands.id IN (	SELECT commands.id FROM commands, actions, json_array_elements(commands.results) as r
-						WHERE commands.actionid=actions.id
-						AND actions.id >= $%d AND actions.id <= $%d
-						AND r#>>'{foundanything}' = $%d) `,
-			valctr+1, valctr+2, valctr+3, valctr+4)
-		vals = append(vals, mig.StatusSuccess, ids.minActionID, ids.maxActionID, p.FoundAnything)
-		valctr += 4
+		query += fmt.Sprintf(`commands.status = $%d AND commands.results @> $%d::jsonb `,
+			valctr+1, valctr+2)
+		vals = append(vals, mig.StatusSuccess, fmt.Sprintf(`[{"foundanything": %t}]`, p.FoundAnything))
+		valctr += 2
+	}
+	if p.ResultPath != "" || p.ResultValue != "" {
+		var jsonpath string
+		jsonpath, err = p.ResultsJSONPath()
+		if err != nil {
+			return
+		}
+		if valctr > 0 {
+			query += " AND "
+		}
+		query += fmt.Sprintf(`commands.results @? $%d::jsonpath `, valctr+1)
+		vals = append(vals, jsonpath)
+		valctr += 1
+	}
+	if p.ResultText != "" {
+		if valctr > 0 {
+			query += " AND "
+		}
+		query += fmt.Sprintf(`to_tsvector('simple', commands.results) @@ plainto_tsquery('simple', $%d) `, valctr+1)
+		vals = append(vals, p.ResultText)
+		valctr += 1
 	}
 	if p.ThreatFamily != "%" {
 		if valctr > 0 {
@@ -243,7 +267,7 @@ func (db *DB) SearchCommands(p search.Parameters, doFoundAnything bool) (command
 		var cmd mig.Command
 		err = rows.Scan(&cmd.ID, &cmd.Status, &jRes, &cmd.StartTime, &cmd.FinishTime,
 			&cmd.Action.ID, &cmd.Action.Name, &cmd.Action.Target, &jDesc, &jThreat, &jOps,
-			&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion,
+			&cmd.Action.ValidFrom, &cmd.Action.ExpireAfter, &jSig, &cmd.Action.SyntaxVersion, &cmd.Action.Nonce,
 			&cmd.Agent.ID, &cmd.Agent.Name, &cmd.Agent.Version, &jAgtTags, &jAgtEnv)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve command: '%v'", err)
@@ -314,7 +338,7 @@ func (db *DB) SearchActions(p search.Parameters) (actions []mig.Action, err erro
 	}
 	columns := `actions.id, actions.name, actions.target,  actions.description, actions.threat, actions.operations,
 		actions.validfrom, actions.expireafter, actions.starttime, actions.finishtime, actions.lastupdatetime,
-		actions.status, actions.pgpsignatures, actions.syntaxversion `
+		actions.status, actions.pgpsignatures, actions.syntaxversion, actions.nonce `
 	join := ""
 	where := ""
 	vals := []interface{}{}
@@ -416,6 +440,15 @@ func (db *DB) SearchActions(p search.Parameters) (actions []mig.Action, err erro
 		valctr += 2
 		joinCommand = true
 	}
+	if p.CaseID != "∞" {
+		if valctr > 0 {
+			where += " AND "
+		}
+		where += fmt.Sprintf(`case_actions.caseid = $%d`, valctr+1)
+		vals = append(vals, ids.caseID)
+		valctr += 1
+		join += " INNER JOIN case_actions ON ( case_actions.actionid = actions.id ) "
+	}
 	if joinCommand {
 		join += "INNER JOIN commands ON ( commands.actionid = actions.id) "
 	}
@@ -461,7 +494,7 @@ func (db *DB) SearchActions(p search.Parameters) (actions []mig.Action, err erro
 		err = rows.Scan(&a.ID, &a.Name, &a.Target,
 			&jDesc, &jThreat, &jOps, &a.ValidFrom, &a.ExpireAfter,
 			&a.StartTime, &a.FinishTime, &a.LastUpdateTime, &a.Status,
-			&jSig, &a.SyntaxVersion)
+			&jSig, &a.SyntaxVersion, &a.Nonce)
 		if err != nil {
 			err = fmt.Errorf("Error while retrieving action: '%v'", err)
 			return
@@ -506,6 +539,19 @@ func (db *DB) SearchActions(p search.Parameters) (actions []mig.Action, err erro
 
 // SearchAgents returns an array of agents that match search parameters
 func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error) {
+	err = db.IterSearchAgents(p, func(agent mig.Agent) error {
+		agents = append(agents, agent)
+		return nil
+	})
+	return
+}
+
+// IterSearchAgents calls fn on each agent that matches search parameters. Agents
+// are read from the database one at a time, so that large inventories can be
+// exported without loading them in memory. A negative limit returns all the
+// matching agents. If fn returns an error, the iteration stops and the error is
+// returned.
+func (db *DB) IterSearchAgents(p search.Parameters, fn func(mig.Agent) error) (err error) {
 	var (
 		rows                                      *sql.Rows
 		joinAction, joinInvestigator, joinCommand bool = false, false, false
@@ -516,7 +562,8 @@ func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error)
 	}
 	columns := `agents.id, agents.name, agents.queueloc, agents.mode,
 		agents.version, agents.pid, agents.starttime, agents.destructiontime,
-		agents.heartbeattime, agents.status, agents.tags, agents.environment`
+		agents.heartbeattime, agents.status, agents.tags, agents.environment,
+		agents.loadername`
 	join := ""
 	where := ""
 	vals := []interface{}{}
@@ -640,10 +687,20 @@ func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error)
 		join += ` INNER JOIN signatures ON ( actions.id = signatures.actionid )
 			INNER JOIN investigators ON ( signatures.investigatorid = investigators.id ) `
 	}
+	if where == "" {
+		// an export of all the agents does not set any filter
+		where = "TRUE"
+	}
+	limit := "ALL"
+	if p.Limit >= 0 {
+		limit = fmt.Sprintf("$%d", valctr+1)
+		vals = append(vals, uint64(p.Limit))
+		valctr += 1
+	}
 	query := fmt.Sprintf(`SELECT %s FROM agents %s WHERE %s GROUP BY agents.id
-		ORDER BY agents.heartbeattime DESC LIMIT $%d OFFSET $%d;`,
-		columns, join, where, valctr+1, valctr+2)
-	vals = append(vals, uint64(p.Limit), uint64(p.Offset))
+		ORDER BY agents.heartbeattime DESC LIMIT %s OFFSET $%d;`,
+		columns, join, where, limit, valctr+1)
+	vals = append(vals, uint64(p.Offset))
 
 	stmt, err := db.c.Prepare(query)
 	if stmt != nil {
@@ -664,13 +721,17 @@ func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error)
 	for rows.Next() {
 		var agent mig.Agent
 		var jTags, jEnv []byte
+		var loaderName sql.NullString
 		err = rows.Scan(&agent.ID, &agent.Name, &agent.QueueLoc, &agent.Mode, &agent.Version,
 			&agent.PID, &agent.StartTime, &agent.DestructionTime, &agent.HeartBeatTS,
-			&agent.Status, &jTags, &jEnv)
+			&agent.Status, &jTags, &jEnv, &loaderName)
 		if err != nil {
 			err = fmt.Errorf("Failed to retrieve agent data: '%v'", err)
 			return
 		}
+		if loaderName.Valid {
+			agent.LoaderName = loaderName.String
+		}
 		err = json.Unmarshal(jTags, &agent.Tags)
 		if err != nil {
 			return
@@ -679,7 +740,10 @@ func (db *DB) SearchAgents(p search.Parameters) (agents []mig.Agent, err error)
 		if err != nil {
 			return
 		}
-		agents = append(agents, agent)
+		err = fn(agent)
+		if err != nil {
+			return
+		}
 	}
 	if err := rows.Err(); err != nil {
 		err = fmt.Errorf("Failed to complete database query: '%v'", err)
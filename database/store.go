@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/search"
+)
+
+// AgentStore abstracts over the storage of agents and of their statistics
+type AgentStore interface {
+	AgentByQueueAndPID(queueloc string, pid int) (mig.Agent, error)
+	AgentByID(id float64) (mig.Agent, error)
+	AgentsActiveSince(pointInTime time.Time) ([]mig.Agent, error)
+	InsertAgent(agt mig.Agent) error
+	UpdateAgentHeartbeat(agt mig.Agent) error
+	ReplaceRefreshedAgent(agt mig.Agent) error
+	ListMultiAgentsQueues(pointInTime time.Time) ([]string, error)
+	ActiveAgentsByQueue(queueloc string, pointInTime time.Time) ([]mig.Agent, error)
+	ActiveAgentsByTarget(target string) ([]mig.Agent, error)
+	IterActiveAgentsByTarget(target string, fn func(mig.Agent) error) error
+	MarkAgentDestroyed(agent mig.Agent) error
+	SetEndpointLifecycle(queueloc, status string, from []string) (float64, error)
+	ArchiveDecommissionedAgents(pointInTime time.Time) (float64, error)
+	MarkOfflineAgents(pointInTime time.Time) error
+	MarkIdleAgents(pointInTime time.Time) error
+	GetAgentsStats(limit int) ([]mig.AgentsStats, error)
+	AgentsStatsSince(pointInTime time.Time) ([]mig.AgentsStats, error)
+	AgentsBreakdown() ([]mig.AgentsBreakdown, error)
+	StoreAgentsStats(stats mig.AgentsStats) error
+	SumOnlineAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+	SumIdleAgentsByVersion() ([]mig.AgentsVersionsSum, error)
+	CountOnlineEndpoints() (float64, error)
+	CountIdleEndpoints() (float64, error)
+	CountEndpointsByStatus(status string) (float64, error)
+	CountNewEndpoints(recent, old time.Time) (float64, error)
+	CountDoubleAgents() (float64, error)
+	CountDisappearedEndpoints(pointInTime time.Time) (float64, error)
+	GetDisappearedEndpoints(oldest time.Time) ([]string, error)
+	CountFlappingEndpoints() (float64, error)
+	SearchAgents(p search.Parameters) ([]mig.Agent, error)
+	IterSearchAgents(p search.Parameters, fn func(mig.Agent) error) error
+	InsertDuplicateDecision(d mig.DuplicateDecision) error
+	DuplicateDecisionsByQueue(queueloc string, limit int) ([]mig.DuplicateDecision, error)
+	DuplicateDecisionsSince(pointInTime time.Time, limit int) ([]mig.DuplicateDecision, error)
+}
+
+// ActionStore abstracts over the storage of actions, of their signatures and
+// of the events published while they run
+type ActionStore interface {
+	LastActions(limit int) ([]mig.Action, error)
+	ActionByID(id float64) (mig.Action, error)
+	ActionMetaByID(id float64) (mig.Action, error)
+	InsertAction(a mig.Action) error
+	UpdateAction(a mig.Action) error
+	InsertOrUpdateAction(a mig.Action) (bool, error)
+	UpdateActionStatus(a mig.Action) error
+	UpdateRunningAction(a mig.Action) error
+	FinishAction(a mig.Action) error
+	InsertSignature(aid, iid float64, sig string) error
+	GetActionCounters(aid float64) (mig.ActionCounters, error)
+	ActionThroughput(pointInTime time.Time) ([]mig.ActionThroughput, error)
+	SetupRunnableActions() ([]mig.Action, error)
+	NotifyActionEvent(ev mig.ActionEvent) error
+	ListenActionEvents() (chan mig.ActionEvent, error)
+	SearchActions(p search.Parameters) ([]mig.Action, error)
+}
+
+// CommandStore abstracts over the storage of commands and of their results
+type CommandStore interface {
+	CommandByID(id float64) (mig.Command, error)
+	CommandsByActionID(actionid float64) ([]mig.Command, error)
+	IterCommandsByActionID(actionid float64, fn func(mig.Command) error) error
+	InsertCommand(cmd mig.Command, agt mig.Agent) error
+	InsertCommands(cmds []mig.Command) (int64, error)
+	UpdateSentCommand(cmd mig.Command) error
+	FinishCommand(cmd mig.Command) error
+	SlowestModules(pointInTime time.Time, limit int) ([]mig.ModuleDuration, error)
+	SearchCommands(p search.Parameters, doFoundAnything bool) ([]mig.Command, error)
+}
+
+// InvestigatorStore abstracts over the storage of investigators and of
+// their credentials
+type InvestigatorStore interface {
+	ActiveInvestigatorsPubKeys() ([][]byte, error)
+	InvestigatorByID(iid float64) (mig.Investigator, error)
+	InvestigatorByFingerprint(fp string) (mig.Investigator, error)
+	InvestigatorKeys(iid float64) ([]mig.InvestigatorKey, error)
+	RotateInvestigatorKey(key mig.InvestigatorKey, overlap time.Duration) (mig.InvestigatorKey, error)
+	RevokeInvestigatorKey(iid float64, fp string) error
+	InvestigatorAPIKeyAuthHelpers() ([]mig.InvestigatorAPIAuthHelper, error)
+	InvestigatorAPIKeyAuthHelperByPrefix(prefix string) (mig.InvestigatorAPIAuthHelper, error)
+	InvestigatorByActionID(aid float64) ([]mig.Investigator, error)
+	InsertInvestigator(inv mig.Investigator) (float64, error)
+	InsertSchedulerInvestigator(inv mig.Investigator) (float64, error)
+	UpdateInvestigatorStatus(inv mig.Investigator) error
+	UpdateInvestigatorAPIKey(inv mig.Investigator, prefix string, key []byte, salt []byte) error
+	UpdateInvestigatorPerms(inv mig.Investigator) error
+	GetSchedulerPrivKey() ([]byte, error)
+	GetSchedulerInvestigator() (mig.Investigator, error)
+	SearchInvestigators(p search.Parameters) ([]mig.Investigator, error)
+}
+
+// ACLStore abstracts over the storage of the policies agent ACLs are rendered from
+type ACLStore interface {
+	ACLPolicies() ([]mig.ACLPolicy, error)
+	SetACLPolicy(p mig.ACLPolicy) error
+	DeleteACLPolicy(module string) error
+}
+
+// LoaderStore abstracts over the storage of loader entries
+type LoaderStore interface {
+	GetLoaderEntryID(key string) (float64, error)
+	GetLoaderAuthDetails(prefix string) (mig.LoaderAuthDetails, error)
+	GetLoaderName(id float64) (string, error)
+	UpdateLoaderEntry(lid float64, agt mig.Agent) error
+	CompareLoaderExpectEnv(lid float64) error
+	GetLoaderFromID(lid float64) (mig.LoaderEntry, error)
+	LoaderUpdateStatus(lid float64, status bool) error
+	LoaderUpdateExpect(lid float64, eenv string) error
+	LoaderUpdateKey(lid float64, hashkey []byte, salt []byte) error
+	LoaderAdd(le mig.LoaderEntry, hashkey []byte, salt []byte) (mig.LoaderEntry, error)
+	SearchLoaders(p search.Parameters) ([]mig.LoaderEntry, error)
+}
+
+// ManifestStore abstracts over the storage of manifests and of their signatures
+type ManifestStore interface {
+	ManifestAdd(mr mig.ManifestRecord) error
+	ManifestAddSignature(mid float64, sig string, invid float64, reqsig int) error
+	ManifestDisable(mid float64) error
+	ManifestUpdateStatus(mid float64, reqsig int) error
+	ManifestClearSignatures(mid float64) error
+	GetManifestFromID(mid float64) (mig.ManifestRecord, error)
+	ManifestIDFromLoaderID(lid float64) (float64, error)
+	AllLoadersFromManifestID(mid float64) ([]mig.LoaderEntry, error)
+	SearchManifests(p search.Parameters) ([]mig.ManifestRecord, error)
+}
+
+// WebhookStore abstracts over the storage of webhooks and of their deliveries
+type WebhookStore interface {
+	InsertWebhook(w mig.Webhook) error
+	WebhookByID(id float64) (mig.Webhook, error)
+	Webhooks() ([]mig.Webhook, error)
+	UpdateWebhookStatus(id float64, status string) error
+	QueueWebhookDelivery(d mig.WebhookDelivery) (bool, error)
+	DueWebhookDeliveries(pointInTime time.Time, limit int) ([]mig.WebhookDelivery, error)
+	WebhookDeliveries(webhookid float64, limit int) ([]mig.WebhookDelivery, error)
+	UpdateWebhookDelivery(d mig.WebhookDelivery) error
+}
+
+// CaseStore abstracts over the storage of cases and of what is attached to them
+type CaseStore interface {
+	InsertCase(c mig.Case) error
+	UpdateCase(c mig.Case) error
+	CaseByID(id float64) (mig.Case, error)
+	Cases(status string, limit int) ([]mig.Case, error)
+	AddCaseNote(caseid float64, n mig.CaseNote) error
+	AddCaseAction(caseid, actionid float64) error
+	RemoveCaseAction(caseid, actionid float64) error
+	AddCaseAgent(caseid float64, agt mig.CaseAgent) error
+	RemoveCaseAgent(caseid float64, queueloc string) error
+}
+
+// Store is the storage backend used by the API and the scheduler. DB is the
+// Postgres implementation, and the memory package provides an implementation
+// that keeps everything in memory, for tests.
+type Store interface {
+	AgentStore
+	ActionStore
+	CommandStore
+	InvestigatorStore
+	ACLStore
+	LoaderStore
+	ManifestStore
+	WebhookStore
+	CaseStore
+	Close()
+}
+
+var _ Store = (*DB)(nil)
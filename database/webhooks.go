@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package database /* import "github.com/mozilla/mig/database" */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mozilla/mig"
+)
+
+const webhookColumns = `id, name, url, secret, events, COALESCE(investigatorid, 0),
+	COALESCE(threatfamily, ''), COALESCE(target, ''), failurethreshold, status, createdat`
+
+const webhookDeliveryColumns = `id, webhookid, event, actionid, payload, status, attempts,
+	nextattempt, lastattempt, COALESCE(responsecode, 0), COALESCE(lasterror, ''), createdat`
+
+// InsertWebhook stores a new webhook
+func (db *DB) InsertWebhook(w mig.Webhook) (err error) {
+	jEvents, err := json.Marshal(w.Events)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal webhook events: '%v'", err)
+	}
+	_, err = db.c.Exec(`INSERT INTO webhooks (id, name, url, secret, events, investigatorid,
+		threatfamily, target, failurethreshold, status, createdat)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11)`,
+		w.ID, w.Name, w.URL, w.Secret, jEvents, w.InvestigatorID, w.ThreatFamily, w.Target,
+		w.FailureThreshold, w.Status, w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Failed to insert webhook: '%v'", err)
+	}
+	return
+}
+
+// WebhookByID retrieves a webhook, including its secret
+func (db *DB) WebhookByID(id float64) (w mig.Webhook, err error) {
+	rows, err := db.c.Query(`SELECT `+webhookColumns+` FROM webhooks WHERE id=$1`, id)
+	webhooks, err := scanWebhooks(rows, err)
+	if err != nil {
+		return
+	}
+	if len(webhooks) == 0 {
+		err = fmt.Errorf("Error while retrieving webhook: '%v'", sql.ErrNoRows)
+		return
+	}
+	return webhooks[0], nil
+}
+
+// Webhooks returns all the webhooks, including their secrets
+func (db *DB) Webhooks() (webhooks []mig.Webhook, err error) {
+	rows, err := db.c.Query(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY createdat`)
+	return scanWebhooks(rows, err)
+}
+
+// UpdateWebhookStatus enables or disables a webhook
+func (db *DB) UpdateWebhookStatus(id float64, status string) (err error) {
+	res, err := db.c.Exec(`UPDATE webhooks SET status=$1 WHERE id=$2`, status, id)
+	if err != nil {
+		return fmt.Errorf("Failed to update webhook status: '%v'", err)
+	}
+	ctr, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Failed to update webhook status: '%v'", err)
+	}
+	if ctr != 1 {
+		return fmt.Errorf("Failed to update webhook status, %d rows affected", ctr)
+	}
+	return
+}
+
+func scanWebhooks(rows *sql.Rows, qerr error) (webhooks []mig.Webhook, err error) {
+	if rows != nil {
+		defer rows.Close()
+	}
+	if qerr != nil {
+		err = fmt.Errorf("Error while retrieving webhooks: '%v'", qerr)
+		return
+	}
+	for rows.Next() {
+		var (
+			w       mig.Webhook
+			jEvents []byte
+		)
+		err = rows.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &jEvents, &w.InvestigatorID,
+			&w.ThreatFamily, &w.Target, &w.FailureThreshold, &w.Status, &w.CreatedAt)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve webhook: '%v'", err)
+			return
+		}
+		err = json.Unmarshal(jEvents, &w.Events)
+		if err != nil {
+			err = fmt.Errorf("Failed to unmarshal webhook events: '%v'", err)
+			return
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
+
+// QueueWebhookDelivery stores a pending delivery. Each event is delivered once
+// per webhook and action, so queued is false if the event was already queued.
+func (db *DB) QueueWebhookDelivery(d mig.WebhookDelivery) (queued bool, err error) {
+	res, err := db.c.Exec(`INSERT INTO webhook_deliveries (id, webhookid, event, actionid,
+		payload, status, attempts, nextattempt, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $8)
+		ON CONFLICT (webhookid, actionid, event) DO NOTHING`,
+		d.ID, d.WebhookID, d.Event, d.ActionID, []byte(d.Payload), mig.WebhookDeliveryPending,
+		d.NextAttempt, d.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("Failed to queue webhook delivery: '%v'", err)
+	}
+	ctr, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Failed to queue webhook delivery: '%v'", err)
+	}
+	return ctr == 1, nil
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next attempt
+// is before pointInTime, oldest first
+func (db *DB) DueWebhookDeliveries(pointInTime time.Time, limit int) (deliveries []mig.WebhookDelivery, err error) {
+	rows, err := db.c.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE status=$1 AND nextattempt <= $2 ORDER BY nextattempt ASC LIMIT $3`,
+		mig.WebhookDeliveryPending, pointInTime, limit)
+	return scanWebhookDeliveries(rows, err)
+}
+
+// WebhookDeliveries returns the last deliveries of a webhook, most recent first
+func (db *DB) WebhookDeliveries(webhookid float64, limit int) (deliveries []mig.WebhookDelivery, err error) {
+	rows, err := db.c.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+		WHERE webhookid=$1 ORDER BY createdat DESC LIMIT $2`, webhookid, limit)
+	return scanWebhookDeliveries(rows, err)
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt to deliver a webhook
+func (db *DB) UpdateWebhookDelivery(d mig.WebhookDelivery) (err error) {
+	_, err = db.c.Exec(`UPDATE webhook_deliveries SET status=$1, attempts=$2, nextattempt=$3,
+		lastattempt=$4, responsecode=NULLIF($5, 0), lasterror=NULLIF($6, '') WHERE id=$7`,
+		d.Status, d.Attempts, d.NextAttempt, d.LastAttempt, d.ResponseCode, d.LastError, d.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to update webhook delivery: '%v'", err)
+	}
+	return
+}
+
+func scanWebhookDeliveries(rows *sql.Rows, qerr error) (deliveries []mig.WebhookDelivery, err error) {
+	if rows != nil {
+		defer rows.Close()
+	}
+	if qerr != nil {
+		err = fmt.Errorf("Error while retrieving webhook deliveries: '%v'", qerr)
+		return
+	}
+	for rows.Next() {
+		var (
+			d           mig.WebhookDelivery
+			payload     []byte
+			lastAttempt pq.NullTime
+		)
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.ActionID, &payload, &d.Status,
+			&d.Attempts, &d.NextAttempt, &lastAttempt, &d.ResponseCode, &d.LastError, &d.CreatedAt)
+		if err != nil {
+			err = fmt.Errorf("Failed to retrieve webhook delivery: '%v'", err)
+			return
+		}
+		d.Payload = payload
+		if lastAttempt.Valid {
+			d.LastAttempt = lastAttempt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Failed to complete database query: '%v'", err)
+	}
+	return
+}
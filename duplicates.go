@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policies the scheduler applies to endpoints that run more than one agent
+const (
+	DupPolicyKillOldest string = "killoldest"
+	DupPolicyKillNewest string = "killnewest"
+	DupPolicyAlert      string = "alert"
+)
+
+// Kinds of duplicate agents. Duplicates are several agents running on the
+// same host. Clones are agents that share a queue location but report
+// different public IPs or AWS instance IDs, which happens when the disk of a
+// host is copied to another one.
+const (
+	DupKindDuplicate string = "duplicate"
+	DupKindClone     string = "clone"
+)
+
+// Decisions taken on duplicate agents
+const (
+	DupDecisionKill  string = "kill"
+	DupDecisionAlert string = "alert"
+)
+
+// DuplicateDecision records how the scheduler resolved an endpoint that runs
+// more than one agent, and the evidence the decision was taken on
+type DuplicateDecision struct {
+	ID        float64           `json:"id"`
+	QueueLoc  string            `json:"queueloc"`
+	Kind      string            `json:"kind"`
+	Policy    string            `json:"policy"`
+	Decision  string            `json:"decision"`
+	Evidence  DuplicateEvidence `json:"evidence"`
+	CreatedAt time.Time         `json:"createdat"`
+}
+
+// DuplicateEvidence lists the agents found on a queue location, and the
+// distinct public IPs and AWS instance IDs they reported
+type DuplicateEvidence struct {
+	Agents      []DuplicateAgent `json:"agents"`
+	PublicIPs   []string         `json:"publicips,omitempty"`
+	InstanceIDs []string         `json:"instanceids,omitempty"`
+}
+
+// DuplicateAgent is an agent listed in the evidence of a decision. Killed is
+// set on the agent the decision issued a destruction order to.
+type DuplicateAgent struct {
+	ID          float64   `json:"id"`
+	Name        string    `json:"name"`
+	PID         int       `json:"pid"`
+	Version     string    `json:"version"`
+	StartTime   time.Time `json:"starttime"`
+	HeartBeatTS time.Time `json:"heartbeatts"`
+	PublicIP    string    `json:"publicip,omitempty"`
+	InstanceID  string    `json:"instanceid,omitempty"`
+	Killed      bool      `json:"killed"`
+}
+
+// ValidateDuplicatePolicy returns an error if policy is not a known policy
+func ValidateDuplicatePolicy(policy string) error {
+	switch policy {
+	case DupPolicyKillOldest, DupPolicyKillNewest, DupPolicyAlert:
+		return nil
+	}
+	return fmt.Errorf("unknown duplicate agents policy '%s', must be one of %s, %s or %s",
+		policy, DupPolicyKillOldest, DupPolicyKillNewest, DupPolicyAlert)
+}
+
+// ResolveDuplicateAgents applies a policy to the agents running on a queue
+// location, and returns the decision along with the agent to kill, if any.
+// Clones are never killed, since the agents run on distinct hosts, and only
+// raise an alert. Otherwise, kill policies pick the oldest or the newest
+// agent by start time, one agent at a time.
+func ResolveDuplicateAgents(queueloc, policy string, agents []Agent) (d DuplicateDecision, kill *Agent) {
+	d = DuplicateDecision{
+		QueueLoc:  queueloc,
+		Kind:      DupKindDuplicate,
+		Policy:    policy,
+		Decision:  DupDecisionAlert,
+		CreatedAt: time.Now(),
+	}
+	ips := make(map[string]bool)
+	instances := make(map[string]bool)
+	for _, agt := range agents {
+		d.Evidence.Agents = append(d.Evidence.Agents, DuplicateAgent{
+			ID:          agt.ID,
+			Name:        agt.Name,
+			PID:         agt.PID,
+			Version:     agt.Version,
+			StartTime:   agt.StartTime,
+			HeartBeatTS: agt.HeartBeatTS,
+			PublicIP:    agt.Env.PublicIP,
+			InstanceID:  agt.Env.AWS.InstanceID,
+		})
+		if agt.Env.PublicIP != "" && !ips[agt.Env.PublicIP] {
+			ips[agt.Env.PublicIP] = true
+			d.Evidence.PublicIPs = append(d.Evidence.PublicIPs, agt.Env.PublicIP)
+		}
+		if agt.Env.AWS.InstanceID != "" && !instances[agt.Env.AWS.InstanceID] {
+			instances[agt.Env.AWS.InstanceID] = true
+			d.Evidence.InstanceIDs = append(d.Evidence.InstanceIDs, agt.Env.AWS.InstanceID)
+		}
+	}
+	sort.Strings(d.Evidence.PublicIPs)
+	sort.Strings(d.Evidence.InstanceIDs)
+	if len(d.Evidence.PublicIPs) > 1 || len(d.Evidence.InstanceIDs) > 1 {
+		d.Kind = DupKindClone
+		return
+	}
+	if policy != DupPolicyKillOldest && policy != DupPolicyKillNewest {
+		return
+	}
+	target := -1
+	for i, agt := range agents {
+		if agt.Status != AgtStatusOnline {
+			continue
+		}
+		if target < 0 ||
+			(policy == DupPolicyKillOldest && agt.StartTime.Before(agents[target].StartTime)) ||
+			(policy == DupPolicyKillNewest && agt.StartTime.After(agents[target].StartTime)) {
+			target = i
+		}
+	}
+	if target < 0 {
+		return
+	}
+	d.Decision = DupDecisionKill
+	d.Evidence.Agents[target].Killed = true
+	kill = &agents[target]
+	return
+}
+
+// SameAs returns true if two decisions were taken on the same agents with the
+// same outcome, and can be recorded only once
+func (d DuplicateDecision) SameAs(other DuplicateDecision) bool {
+	if d.QueueLoc != other.QueueLoc || d.Kind != other.Kind || d.Policy != other.Policy ||
+		d.Decision != other.Decision || len(d.Evidence.Agents) != len(other.Evidence.Agents) {
+		return false
+	}
+	ids := make(map[float64]bool)
+	for _, agt := range other.Evidence.Agents {
+		ids[agt.ID] = true
+	}
+	for _, agt := range d.Evidence.Agents {
+		if !ids[agt.ID] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDuplicateAgents(t *testing.T) {
+	now := time.Now()
+	agents := []Agent{
+		{ID: 1, PID: 100, Status: AgtStatusOnline, StartTime: now.Add(-time.Hour),
+			Env: AgentEnv{PublicIP: "192.0.2.1"}},
+		{ID: 2, PID: 200, Status: AgtStatusOnline, StartTime: now,
+			Env: AgentEnv{PublicIP: "192.0.2.1"}},
+		{ID: 3, PID: 300, Status: AgtStatusDestroyed, StartTime: now.Add(-2 * time.Hour),
+			Env: AgentEnv{PublicIP: "192.0.2.1"}},
+	}
+	testcases := []struct {
+		policy   string
+		decision string
+		killed   float64
+	}{
+		{DupPolicyKillOldest, DupDecisionKill, 1},
+		{DupPolicyKillNewest, DupDecisionKill, 2},
+		{DupPolicyAlert, DupDecisionAlert, 0},
+	}
+	for _, tc := range testcases {
+		d, kill := ResolveDuplicateAgents("linux.host1", tc.policy, agents)
+		if d.Kind != DupKindDuplicate || d.Decision != tc.decision {
+			t.Errorf("policy %s: expected a %s decision on a duplicate, got %s on a %s",
+				tc.policy, tc.decision, d.Decision, d.Kind)
+		}
+		if tc.killed == 0 {
+			if kill != nil {
+				t.Errorf("policy %s: expected no agent to be killed, got %.0f", tc.policy, kill.ID)
+			}
+			continue
+		}
+		if kill == nil || kill.ID != tc.killed {
+			t.Errorf("policy %s: expected agent %.0f to be killed, got %v", tc.policy, tc.killed, kill)
+			continue
+		}
+		for _, agt := range d.Evidence.Agents {
+			if agt.Killed != (agt.ID == tc.killed) {
+				t.Errorf("policy %s: wrong killed flag on agent %.0f in evidence", tc.policy, agt.ID)
+			}
+		}
+	}
+
+	// agents reporting distinct instance IDs are clones and are never killed
+	agents[1].Env.AWS.InstanceID = "i-0123"
+	agents[0].Env.AWS.InstanceID = "i-4567"
+	d, kill := ResolveDuplicateAgents("linux.host1", DupPolicyKillOldest, agents)
+	if d.Kind != DupKindClone || d.Decision != DupDecisionAlert || kill != nil {
+		t.Fatalf("expected an alert on a clone, got %s on a %s", d.Decision, d.Kind)
+	}
+	if len(d.Evidence.InstanceIDs) != 2 || d.Evidence.InstanceIDs[0] != "i-0123" {
+		t.Fatalf("expected sorted instance IDs in evidence, got %v", d.Evidence.InstanceIDs)
+	}
+	other, _ := ResolveDuplicateAgents("linux.host1", DupPolicyKillOldest, agents)
+	if !d.SameAs(other) {
+		t.Fatal("expected identical decisions to be the same")
+	}
+	other, _ = ResolveDuplicateAgents("linux.host1", DupPolicyKillOldest, agents[:2])
+	if d.SameAs(other) {
+		t.Fatal("expected decisions on different agents to differ")
+	}
+}
+
+func TestValidateDuplicatePolicy(t *testing.T) {
+	for _, p := range []string{DupPolicyKillOldest, DupPolicyKillNewest, DupPolicyAlert} {
+		if err := ValidateDuplicatePolicy(p); err != nil {
+			t.Errorf("policy %s: %v", p, err)
+		}
+	}
+	if ValidateDuplicatePolicy("killall") == nil {
+		t.Error("expected unknown policy to fail")
+	}
+}
@@ -8,6 +8,7 @@ package mig /* import "github.com/mozilla/mig" */
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -24,6 +25,81 @@ type Investigator struct {
 	APIKey         string    `json:"apikey,omitempty"`
 
 	Permissions InvestigatorPerms `json:"permissions"`
+
+	// Keys lists the PGP keys the investigator has used over time. PGPFingerprint
+	// and PublicKey always refer to the most recent key.
+	Keys []InvestigatorKey `json:"keys,omitempty"`
+}
+
+// CurrentKey returns the key the investigator should be identified with at
+// time now: the key set on the investigator if it is valid, or the most
+// recent valid key otherwise. ok is false if the investigator is disabled or
+// has no valid key.
+func (i Investigator) CurrentKey(now time.Time) (key InvestigatorKey, ok bool) {
+	if i.Status != StatusActiveInvestigator {
+		return
+	}
+	for _, k := range i.Keys {
+		if !k.ValidAt(now) {
+			continue
+		}
+		if strings.ToUpper(k.PGPFingerprint) == strings.ToUpper(i.PGPFingerprint) {
+			return k, true
+		}
+		if !ok || k.ValidFrom.After(key.ValidFrom) {
+			key, ok = k, true
+		}
+	}
+	return
+}
+
+// SigningKey returns the key of the investigator that has fingerprint fp, if
+// it can be used to sign at time now. ok is false if the investigator is
+// disabled, or if the key is unknown, revoked, expired or not yet valid. The
+// Keys of the investigator must be loaded.
+func (i Investigator) SigningKey(fp string, now time.Time) (key InvestigatorKey, ok bool) {
+	if i.Status != StatusActiveInvestigator {
+		return
+	}
+	for _, k := range i.Keys {
+		if strings.ToUpper(k.PGPFingerprint) != strings.ToUpper(fp) {
+			continue
+		}
+		if !k.ValidAt(now) {
+			return
+		}
+		return k, true
+	}
+	return
+}
+
+// InvestigatorKey is a PGP key of an investigator. A key can be used to sign
+// actions and tokens between ValidFrom and ExpireAfter, unless it is revoked.
+// A zero ExpireAfter means the key does not expire.
+type InvestigatorKey struct {
+	ID             float64   `json:"id"`
+	InvestigatorID float64   `json:"investigatorid"`
+	PGPFingerprint string    `json:"pgpfingerprint"`
+	PublicKey      []byte    `json:"publickey,omitempty"`
+	Status         string    `json:"status"`
+	ValidFrom      time.Time `json:"validfrom"`
+	ExpireAfter    time.Time `json:"expireafter,omitempty"`
+	CreatedAt      time.Time `json:"createdat"`
+	RevokedAt      time.Time `json:"revokedat,omitempty"`
+}
+
+// ValidAt returns true if the key is active and within its validity window at time t
+func (k InvestigatorKey) ValidAt(t time.Time) bool {
+	if k.Status != StatusActiveKey {
+		return false
+	}
+	if t.Before(k.ValidFrom) {
+		return false
+	}
+	if !k.ExpireAfter.IsZero() && !t.Before(k.ExpireAfter) {
+		return false
+	}
+	return true
 }
 
 // CheckPermission validates if an investigator has given permission pv
@@ -65,6 +141,12 @@ func (i *Investigator) CheckPermission(pv int64) bool {
 		return i.Permissions.InvestigatorCreate
 	case PermInvestigatorUpdate:
 		return i.Permissions.InvestigatorUpdate
+	case PermAgentLifecycle:
+		return i.Permissions.AgentLifecycle
+	case PermWebhook:
+		return i.Permissions.Webhook
+	case PermCase:
+		return i.Permissions.Case
 	}
 	return false
 }
@@ -90,6 +172,9 @@ type InvestigatorPerms struct {
 	Investigator       bool `json:"investigator"`
 	InvestigatorCreate bool `json:"investigator_create"`
 	InvestigatorUpdate bool `json:"investigator_update"`
+	AgentLifecycle     bool `json:"agent_lifecycle"`
+	Webhook            bool `json:"webhook"`
+	Case               bool `json:"case"`
 }
 
 // FromMask converts a permission bit mask into a boolean permission set
@@ -151,6 +236,15 @@ func (ip *InvestigatorPerms) FromMask(mask int64) {
 	if (mask & PermInvestigatorUpdate) != 0 {
 		ip.InvestigatorUpdate = true
 	}
+	if (mask & PermAgentLifecycle) != 0 {
+		ip.AgentLifecycle = true
+	}
+	if (mask & PermWebhook) != 0 {
+		ip.Webhook = true
+	}
+	if (mask & PermCase) != 0 {
+		ip.Case = true
+	}
 }
 
 // ToMask converts a boolean permission set to a permission bit mask
@@ -212,6 +306,15 @@ func (ip *InvestigatorPerms) ToMask() (ret int64) {
 	if ip.InvestigatorUpdate {
 		ret |= PermInvestigatorUpdate
 	}
+	if ip.AgentLifecycle {
+		ret |= PermAgentLifecycle
+	}
+	if ip.Webhook {
+		ret |= PermWebhook
+	}
+	if ip.Case {
+		ret |= PermCase
+	}
 	return ret
 }
 
@@ -318,6 +421,7 @@ func (ip *InvestigatorPerms) DefaultSet() {
 	ip.Command = true
 	ip.Agent = true
 	ip.Dashboard = true
+	ip.Case = true
 }
 
 // ManifestSet sets manifest related permissions on the investigator
@@ -343,6 +447,8 @@ func (ip *InvestigatorPerms) AdminSet() {
 	ip.Investigator = true
 	ip.InvestigatorCreate = true
 	ip.InvestigatorUpdate = true
+	ip.AgentLifecycle = true
+	ip.Webhook = true
 }
 
 // Permissions that can be assigned to investigators
@@ -366,6 +472,9 @@ const (
 	PermInvestigator
 	PermInvestigatorCreate
 	PermInvestigatorUpdate
+	PermAgentLifecycle
+	PermWebhook
+	PermCase
 )
 
 // Possible status values for an investigator
@@ -374,6 +483,12 @@ const (
 	StatusDisabledInvestigator string = "disabled"
 )
 
+// Possible status values for an investigator key
+const (
+	StatusActiveKey  string = "active"
+	StatusRevokedKey string = "revoked"
+)
+
 // InvestigatorAPIAuthHelper is a small struct used to pass information between
 // the database and the API, and is used primarily for authorizing requests using
 // API keys.
@@ -369,6 +369,7 @@ var bundleEntryLinux = []BundleDictionaryEntry{
 	{"agentkey", "/etc/mig/agent.key", "", 0600},
 	{"cacert", "/etc/mig/ca.crt", "", 0644},
 	{"loaderconfig", "/etc/mig/mig-loader.cfg", "", 0600},
+	{"authbundle", "/etc/mig/authbundle.json", "", 0600},
 }
 
 var bundleEntryDarwin = []BundleDictionaryEntry{
@@ -379,6 +380,7 @@ var bundleEntryDarwin = []BundleDictionaryEntry{
 	{"agentkey", "/etc/mig/agent.key", "", 0600},
 	{"cacert", "/etc/mig/ca.crt", "", 0644},
 	{"loaderconfig", "/etc/mig/mig-loader.cfg", "", 0600},
+	{"authbundle", "/etc/mig/authbundle.json", "", 0600},
 }
 
 var bundleEntryWindows = []BundleDictionaryEntry{
@@ -389,6 +391,7 @@ var bundleEntryWindows = []BundleDictionaryEntry{
 	{"agentkey", "C:\\mig\\agent.key", "", 0600},
 	{"cacert", "C:\\mig\\ca.crt", "", 0644},
 	{"loaderconfig", "C:\\mig\\mig-loader.cfg", "", 0600},
+	{"authbundle", "C:\\mig\\authbundle.json", "", 0600},
 }
 
 // BundleDictionary maps GOOS platform names to specific bundle entry values
@@ -0,0 +1,348 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+// Package metrics implements counters, gauges and histograms that MIG
+// components expose over HTTP in the Prometheus text exposition format.
+// It has no external dependency, and only implements what MIG needs.
+package metrics /* import "github.com/mozilla/mig/metrics" */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets suited to durations in seconds, from
+// a few milliseconds to a few minutes
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metric is implemented by all the types of metrics of a registry
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics and writes them in the text exposition
+// format. A Registry implements http.Handler to serve them.
+type Registry struct {
+	mu      sync.Mutex
+	names   map[string]bool
+	metrics []metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %q is already registered", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all the metrics of the registry to w, in the order they
+// were registered
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics of the registry in an HTTP response
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	r.WriteTo(w)
+}
+
+// series stores the values of a metric for each combination of label values
+type series struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]*value
+}
+
+type value struct {
+	labelValues []string
+	v           float64
+	buckets     []uint64 // histograms only, non-cumulative
+	sum         float64  // histograms only
+	count       uint64   // histograms only
+}
+
+func newSeries(name, help string, labels []string) series {
+	return series{name: name, help: help, labels: labels, values: make(map[string]*value)}
+}
+
+// get returns the value for a set of label values, creating it if needed.
+// The caller must hold the lock.
+func (s *series) get(labelValues []string) *value {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metric %q expects %d label values, got %d", s.name, len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v, ok := s.values[key]
+	if !ok {
+		v = &value{labelValues: append([]string(nil), labelValues...)}
+		s.values[key] = v
+	}
+	return v
+}
+
+// sorted returns the values ordered by label values, so the output is stable
+// between scrapes. The caller must hold the lock.
+func (s *series) sorted() (values []*value) {
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values = append(values, s.values[k])
+	}
+	return
+}
+
+func (s *series) writeHeader(w *bufio.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.name, escapeHelp(s.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.name, typ)
+}
+
+// Counter is a metric that can only increase, such as a number of requests
+type Counter struct {
+	series
+}
+
+// NewCounter registers a new counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newSeries(name, help, labels)}
+	r.register(name, c)
+	return c
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter by v, which must not be negative
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %q cannot decrease", c.name))
+	}
+	c.mu.Lock()
+	c.get(labelValues).v += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w, "counter")
+	for _, v := range c.sorted() {
+		writeSample(w, c.name, c.labels, v.labelValues, "", "", v.v)
+	}
+}
+
+// Gauge is a metric that can go up and down, such as a queue depth
+type Gauge struct {
+	series
+}
+
+// NewGauge registers a new gauge with the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newSeries(name, help, labels)}
+	r.register(name, g)
+	return g
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).v = v
+	g.mu.Unlock()
+}
+
+// Add adds v to the gauge, v can be negative
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).v += v
+	g.mu.Unlock()
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w, "gauge")
+	for _, v := range g.sorted() {
+		writeSample(w, g.name, g.labels, v.labelValues, "", "", v.v)
+	}
+}
+
+// GaugeFunc is a gauge without labels whose value is computed by a function
+// each time the metrics are collected
+type GaugeFunc struct {
+	series
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls fn to obtain its value
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{series: newSeries(name, help, nil), fn: fn}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w, "gauge")
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// Histogram counts observations, such as durations, in buckets
+type Histogram struct {
+	series
+	upperBounds []float64
+}
+
+// NewHistogram registers a new histogram with the given buckets upper bounds
+// and label names. If buckets is nil, DefaultBuckets are used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &Histogram{series: newSeries(name, help, labels), upperBounds: bounds}
+	r.register(name, h)
+	return h
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(o float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := h.get(labelValues)
+	if v.buckets == nil {
+		v.buckets = make([]uint64, len(h.upperBounds))
+	}
+	i := sort.SearchFloat64s(h.upperBounds, o)
+	if i < len(v.buckets) {
+		v.buckets[i]++
+	}
+	v.sum += o
+	v.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, v := range h.sorted() {
+		var cumul uint64
+		for i, bound := range h.upperBounds {
+			if v.buckets != nil {
+				cumul += v.buckets[i]
+			}
+			writeSample(w, h.name+"_bucket", h.labels, v.labelValues, "le", formatFloat(bound), float64(cumul))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, v.labelValues, "le", "+Inf", float64(v.count))
+		writeSample(w, h.name+"_sum", h.labels, v.labelValues, "", "", v.sum)
+		writeSample(w, h.name+"_count", h.labels, v.labelValues, "", "", float64(v.count))
+	}
+}
+
+// writeSample writes a single line of the exposition format, with an
+// optional extra label used by histogram buckets
+func writeSample(w *bufio.Writer, name string, labels, labelValues []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(labelValues[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
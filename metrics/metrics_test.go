@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package metrics /* import "github.com/mozilla/mig/metrics" */
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("mig_test_requests_total", "Requests handled.", "method", "code")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Add(3, "POST", "400")
+	g := r.NewGauge("mig_test_queue_depth", "Items in the queue.\nSecond line.")
+	g.Set(7)
+	g.Dec()
+	r.NewGaugeFunc("mig_test_answer", "The answer.", func() float64 { return 42 })
+	h := r.NewHistogram("mig_test_duration_seconds", "Durations.", []float64{1, 0.1}, "module")
+	h.Observe(0.05, `fi"le`)
+	h.Observe(0.5, `fi"le`)
+	h.Observe(2, `fi"le`)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP mig_test_requests_total Requests handled.
+# TYPE mig_test_requests_total counter
+mig_test_requests_total{method="GET",code="200"} 2
+mig_test_requests_total{method="POST",code="400"} 3
+# HELP mig_test_queue_depth Items in the queue.\nSecond line.
+# TYPE mig_test_queue_depth gauge
+mig_test_queue_depth 6
+# HELP mig_test_answer The answer.
+# TYPE mig_test_answer gauge
+mig_test_answer 42
+# HELP mig_test_duration_seconds Durations.
+# TYPE mig_test_duration_seconds histogram
+mig_test_duration_seconds_bucket{module="fi\"le",le="0.1"} 1
+mig_test_duration_seconds_bucket{module="fi\"le",le="1"} 2
+mig_test_duration_seconds_bucket{module="fi\"le",le="+Inf"} 3
+mig_test_duration_seconds_sum{module="fi\"le"} 2.55
+mig_test_duration_seconds_count{module="fi\"le"} 3
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestLabelMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("mig_test_total", "Test.", "status")
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("expected a panic when label values do not match label names")
+		}
+	}()
+	c.Inc()
+}
+
+func TestDuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("mig_test_total", "Test.")
+	defer func() {
+		if e := recover(); e == nil {
+			t.Fatal("expected a panic when registering a metric twice")
+		}
+	}()
+	r.NewGauge("mig_test_total", "Test.")
+}
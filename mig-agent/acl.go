@@ -41,6 +41,10 @@ func checkActionAuthorization(a mig.Action, ctx *Context) (err error) {
 		ctx.Channels.Log <- mig.Log{ActionID: a.ID, Desc: desc}.Err()
 		panic(desc)
 	}
+	if a.SyntaxVersion == mig.ActionVersionLegacy && !settings().acceptLegacyActions {
+		ctx.Channels.Log <- mig.Log{ActionID: a.ID, Desc: "refusing legacy action"}.Err()
+		panic(fmt.Sprintf("action syntax version %d is not accepted, version %d is required", a.SyntaxVersion, mig.ActionVersion))
+	}
 	// Validate() checks that the action hasn't expired, but we need to
 	// check the start time ourselves
 	if time.Now().Before(a.ValidFrom) {
@@ -49,7 +53,7 @@ func checkActionAuthorization(a mig.Action, ctx *Context) (err error) {
 	}
 
 	// check ACLs, includes verifying signatures
-	err = a.VerifyACL(ctx.ACL, keyring, ONLYVERIFYPUBKEY)
+	err = a.VerifyACL(ctx.ACL, keyring, settings().onlyVerifyPubKey)
 	if err != nil {
 		desc := fmt.Sprintf("action ACL verification failed: %v", err)
 		ctx.Channels.Log <- mig.Log{ActionID: a.ID, Desc: desc}.Err()
@@ -18,6 +18,7 @@ import (
 	"os/exec"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -48,6 +49,8 @@ type runtimeOptions struct {
 	showversion   bool
 	norunpersist  bool
 	printsettings bool
+	sandbox       string
+	container     string
 }
 
 type moduleResult struct {
@@ -61,25 +64,28 @@ type moduleResult struct {
 type moduleOp struct {
 	err          error
 	id           float64
+	commandID    float64
 	mode         string
 	isCompressed bool
 	params       interface{}
 	resultChan   chan moduleResult
 	position     int
 	expireafter  time.Time
+	container    string
 }
 
 // Environment contains information about the environment an agent is running in.
 type Environment struct {
-	Init      string   `json:"init"`
-	Ident     string   `json:"ident"`
-	OS        string   `json:"os"`
-	Arch      string   `json:"arch"`
-	IsProxied bool     `json:"isProxied"`
-	Proxy     string   `json:"proxy"`
-	Addresses []string `json:"addresses"`
-	PublicIP  string   `json:"publicIP"`
-	Modules   []string `json:"modules"`
+	Init      string              `json:"init"`
+	Ident     string              `json:"ident"`
+	OS        string              `json:"os"`
+	Arch      string              `json:"arch"`
+	IsProxied bool                `json:"isProxied"`
+	Proxy     string              `json:"proxy"`
+	Addresses []string            `json:"addresses"`
+	PublicIP  string              `json:"publicIP"`
+	PublicIPs []mig.AgentPublicIP `json:"publicIPs,omitempty"`
+	Modules   []string            `json:"modules"`
 }
 
 // Tag is a label associated with an agent.
@@ -101,7 +107,34 @@ type Heartbeat struct {
 	Tags        []Tag       `json:"tags"`
 }
 
-var runningOps = make(map[float64]moduleOp)
+// runningOps holds the operations being executed by the agent, indexed by
+// operation ID. It is protected by runningOpsLock.
+var (
+	runningOps     = make(map[float64]moduleOp)
+	runningOpsLock sync.Mutex
+)
+
+func addRunningOp(op moduleOp) {
+	runningOpsLock.Lock()
+	defer runningOpsLock.Unlock()
+	runningOps[op.id] = op
+}
+
+func removeRunningOp(id float64) {
+	runningOpsLock.Lock()
+	defer runningOpsLock.Unlock()
+	delete(runningOps, id)
+}
+
+// listRunningOps returns a copy of the running operations
+func listRunningOps() (ops []moduleOp) {
+	runningOpsLock.Lock()
+	defer runningOpsLock.Unlock()
+	for _, op := range runningOps {
+		ops = append(ops, op)
+	}
+	return
+}
 
 func main() {
 	var (
@@ -129,6 +162,8 @@ func main() {
 	flag.BoolVar(&runOpt.norunpersist, "n", false, "Force disable persistent modules.")
 	flag.BoolVar(&runOpt.showversion, "V", false, "Print Agent version to stdout and exit.")
 	flag.BoolVar(&runOpt.printsettings, "S", false, "Print Agent configuration settings.")
+	flag.StringVar(&runOpt.sandbox, "sandbox", "", "Used by the agent to run a module in its sandbox.")
+	flag.StringVar(&runOpt.container, "container", "", "Used by the agent to run a module in a container.")
 
 	flag.Parse()
 
@@ -223,6 +258,29 @@ func main() {
 	case "persist":
 		runModulePersist(runOpt.persistmode)
 	default:
+		sandbox := func() {
+			err = enterSandbox(runOpt.mode, runOpt.sandbox, runOpt.container != "")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[critical] %s: %v\n", sandboxErrorPrefix, err)
+				os.Exit(1)
+			}
+		}
+		// a module that runs in a container enters it between the setup and
+		// the enforce stages of its sandbox, as the system call filter
+		// installed in the enforce stage denies chroot
+		if runOpt.sandbox == sandboxSetup {
+			sandbox()
+		}
+		if runOpt.container != "" {
+			err = enterContainer(runOpt.container)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[critical] failed to enter container: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if runOpt.sandbox != "" && runOpt.sandbox != sandboxSetup {
+			sandbox()
+		}
 		fmt.Printf("%s", runModuleDirectly(runOpt.mode, nil, runOpt.pretty))
 	}
 exit:
@@ -266,6 +324,9 @@ func executeAction(action mig.Action, prettyPrint bool) (cmd mig.Command, err er
 
 	// launch each operation consecutively
 	for _, op := range action.Operations {
+		if op.Container != "" {
+			panic("operations in containers cannot be run from a file")
+		}
 		out := runModuleDirectly(op.Module, op.Parameters, prettyPrint)
 		var res modules.Result
 		err = json.Unmarshal([]byte(out), &res)
@@ -430,7 +491,7 @@ done:
 	// wait until all running operations are done
 	for {
 		time.Sleep(1 * time.Second)
-		if len(runningOps) == 0 {
+		if len(listRunningOps()) == 0 {
 			break
 		}
 	}
@@ -519,6 +580,15 @@ func runAgent(runOpt runtimeOptions) (err error) {
 		}
 		svc.Stop()
 		time.Sleep(time.Hour) // wait to be killed
+	} else if exitReason == upgradeExitReason || exitReason == upgradeRollbackReason {
+		// restart on the binary that was just installed through the upgrade code
+		// path. an agent controlled by a service manager exits with an error
+		// below, and is restarted by it.
+		if ctx.Agent.Respawn {
+			cmd := exec.Command(ctx.Agent.BinPath, "-u")
+			_ = cmd.Start()
+			os.Exit(0)
+		}
 	} else {
 		// I'll be back!
 		if ctx.Agent.Respawn {
@@ -598,6 +668,19 @@ func startRoutines(ctx *Context) (err error) {
 		ctx.Channels.Log <- mig.Log{Desc: "periodic environment refresh is disabled"}
 	}
 
+	// GoRoutine that replays the commands and results left in the journal
+	// by a previous run of the agent
+	go replayJournal(ctx)
+
+	// GoRoutine that rolls back an upgrade if it is not confirmed in time
+	go watchUpgrade(ctx)
+
+	// Serve the control API on the control socket
+	go initControl(ctx)
+
+	// GoRoutine that reloads the configuration on SIGHUP
+	go reloadOnSignal(ctx)
+
 	return
 }
 
@@ -606,6 +689,8 @@ func getCommands(ctx *Context) (err error) {
 	for m := range ctx.MQ.Bind.Chan {
 		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("received message '%s'", m.Body)}.Debug()
 
+		metricAMQPConsumed.Inc()
+
 		// Ack this message only
 		err := m.Ack(true)
 		if err != nil {
@@ -666,6 +751,29 @@ func parseCommands(ctx *Context, msg []byte) (err error) {
 		panic(err)
 	}
 
+	// refuse actions that were already received in another command. the
+	// journal replays commands under their ID, so it is consulted before the
+	// command is journaled again.
+	known := ctx.Journal != nil && cmd.ID > 0 && ctx.Journal.knows(cmd.ID)
+	err = ctx.Nonces.record(cmd.Action, cmd.ID, known)
+	if err != nil {
+		ctx.Stats.importAction(cmd.Action, false)
+		panic(err)
+	}
+
+	// journal the command so it can be replayed if the agent restarts before
+	// answering it, and drop commands that have already been received
+	if ctx.Journal != nil && cmd.ID > 0 {
+		dup, err := ctx.Journal.recordCommand(cmd.ID, msg)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: fmt.Sprintf("failed to journal command: %v", err)}.Err()
+		}
+		if dup {
+			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: "dropping duplicate command"}
+			return nil
+		}
+	}
+
 	// Note this as a successful command for statistics
 	ctx.Stats.importAction(cmd.Action, true)
 
@@ -677,26 +785,32 @@ func parseCommands(ctx *Context, msg []byte) (err error) {
 		// create an module operation object
 		currentOp := moduleOp{
 			id:           mig.GenID(),
+			commandID:    cmd.ID,
 			mode:         operation.Module,
 			isCompressed: operation.IsCompressed,
 			params:       operation.Parameters,
 			resultChan:   resultChan,
 			position:     counter,
 			expireafter:  cmd.Action.ExpireAfter,
+			container:    operation.Container,
 		}
 
 		desc := fmt.Sprintf("sending operation %d to module %s", counter, operation.Module)
 		ctx.Channels.Log <- mig.Log{OpID: currentOp.id, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: desc}
 
-		// check that the module is available and pass the command to the execution channel
-		if _, ok := modules.Available[operation.Module]; ok {
+		// check that the module is available and pass the command to the execution channel.
+		// upgrade operations are handled by the agent itself.
+		if operation.Module == upgradeModule {
+			addRunningOp(currentOp)
+			go runUpgrade(ctx, currentOp)
+		} else if _, ok := modules.Available[operation.Module]; ok {
 			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: fmt.Sprintf("calling module '%s'", operation.Module)}.Debug()
-			runningOps[currentOp.id] = currentOp
+			addRunningOp(currentOp)
 			ctx.Channels.RunAgentCommand <- currentOp
 		} else {
 			// no module is available, return an error
 			currentOp.err = fmt.Errorf("module '%s' is not available", operation.Module)
-			runningOps[currentOp.id] = currentOp
+			addRunningOp(currentOp)
 			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: fmt.Sprintf("module '%s' not available", operation.Module)}
 		}
 		opsCounter++
@@ -717,6 +831,7 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 	var result moduleResult
 	result.id = op.id
 	result.position = op.position
+	start := time.Now()
 	defer func() {
 		if e := recover(); e != nil {
 			// if running the module failed, store the error in the module result
@@ -725,8 +840,9 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 			result.err = err
 			result.status = mig.StatusFailed
 		}
+		metricModuleDuration.Observe(time.Now().Sub(start).Seconds(), op.mode, result.status)
 		// upon exit, remove the op from the running Ops
-		delete(runningOps, op.id)
+		removeRunningOp(op.id)
 		// whatever happens, always send the results
 		op.resultChan <- result
 		ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: "leaving runModule()"}.Debug()
@@ -735,12 +851,12 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 	ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("executing module %q", op.mode)}.Debug()
 	// waiter is a channel that receives a message when the timeout expires
 	waiter := make(chan error, 1)
-	var out bytes.Buffer
+	var out, stderr bytes.Buffer
 
 	// calculate the max exec time by taking the smallest duration between the expiration date
 	// sent with the command, and the default MODULETIMEOUT value from the agent configuration
-	execTimeOut := MODULETIMEOUT
-	if op.expireafter.Before(time.Now().Add(MODULETIMEOUT)) {
+	execTimeOut := settings().moduleTimeout
+	if op.expireafter.Before(time.Now().Add(execTimeOut)) {
 		execTimeOut = op.expireafter.Sub(time.Now())
 	}
 
@@ -773,17 +889,52 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 		}
 	}
 
-	// build the command line and execute
+	// build the command line and execute. modules that declare the privileges
+	// they need run in a sandbox restricted to these privileges.
 	cmd := exec.Command(ctx.Agent.BinPath, "-m", strings.ToLower(op.mode))
+	sandboxed := false
+	if priv, ok := modulePrivileges(op.mode); ok && settings().sandboxModules {
+		if op.container != "" {
+			priv.Capabilities = append(priv.Capabilities, "CAP_SYS_CHROOT")
+		}
+		sandboxed, err = sandboxCommand(cmd, priv)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if op.container != "" {
+		pid, err := containerPid(op.mode, op.container, sandboxed)
+		if err != nil {
+			panic(err)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("running module %q in container %q with init pid %d", op.mode, op.container, pid)}.Debug()
+		cmd.Args = append(cmd.Args, "-container", strconv.Itoa(pid))
+	}
 	stdinpipe, err := cmd.StdinPipe()
 	if err != nil {
 		panic(err)
 	}
 	cmd.Stdout = &out
+	cmd.Stderr = &stderr
 	if err := cmd.Start(); err != nil {
 		panic(err)
 	}
 
+	// apply the resource policy of the module, if any. this is done before the
+	// parameters are written to stdin, so the module is still waiting for them
+	// and has not started its work yet.
+	var lp limitedProcess
+	limits, haslimits := limitsFor(op.mode)
+	if haslimits {
+		lp, err = applyLimits(limits, op.id, cmd.Process.Pid)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			panic(err)
+		}
+		defer lp.release()
+	}
+
 	// Spawn a goroutine to write the parameter data to stdin of the module
 	// if required. Doing this in a goroutine ensures the timeout logic
 	// later in this function will fire if for some reason the module does
@@ -815,6 +966,7 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 
 		// update the command status and send the response back
 		result.status = mig.StatusTimeout
+		metricModuleTimeouts.Inc(op.mode)
 
 		// kill the command
 		err := cmd.Process.Kill()
@@ -826,7 +978,17 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 	// Normal exit case: command has run successfully
 	case err := <-waiter:
 		if err != nil {
-			ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: "command failed."}.Err()
+			if breach := lp.breach(stderr.Bytes(), result.output); breach != "" {
+				limitBreached(ctx, op, &result, breach)
+				break
+			}
+			if sandboxed {
+				// report why the sandbox stopped the module
+				if serr := sandboxFailure(cmd.ProcessState, stderr.Bytes()); serr != nil {
+					err = serr
+				}
+			}
+			ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("command failed: %v", err)}.Err()
 			panic(err)
 
 		} else {
@@ -835,8 +997,12 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 			if err != nil {
 				panic(err)
 			}
-			// mark command status as successfully completed
+			// mark command status as successfully completed, unless the module
+			// reported errors caused by its resource limits
 			result.status = mig.StatusSuccess
+			if breach := lp.breach(stderr.Bytes(), result.output); breach != "" {
+				limitBreached(ctx, op, &result, breach)
+			}
 		}
 	}
 	// return executes the defer block at the top of the function, which passes module result
@@ -844,6 +1010,14 @@ func runModule(ctx *Context, op moduleOp) (err error) {
 	return
 }
 
+// limitBreached marks the result of a module that exceeded its resource limits
+func limitBreached(ctx *Context, op moduleOp, result *moduleResult, breach string) {
+	ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("command exceeded its resource limits: %s", breach)}.Err()
+	result.status = mig.StatusResourceLimit
+	result.err = fmt.Errorf("%s", breach)
+	metricModuleLimitBreaches.Inc(op.mode)
+}
+
 // receiveResult listens on a temporary channels for results coming from modules. It aggregates them, and
 // when all are received, it builds a response that is passed to the Result channel
 func receiveModuleResults(ctx *Context, cmd mig.Command, resultChan chan moduleResult, opsCounter int) (err error) {
@@ -864,9 +1038,10 @@ func receiveModuleResults(ctx *Context, cmd mig.Command, resultChan chan moduleR
 	// assume everything went fine, and reset the status if errors are found
 	cmd.Status = mig.StatusSuccess
 
-	// process failed operations first
-	for _, op := range runningOps {
-		if op.err != nil {
+	// process failed operations of this command first
+	for _, op := range listRunningOps() {
+		if op.err != nil && op.commandID == cmd.ID {
+			removeRunningOp(op.id)
 			ctx.Channels.Log <- mig.Log{OpID: op.id, CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: "process error for module"}.Debug()
 			cmd.Status = "failed"
 			err = json.Unmarshal([]byte(fmt.Sprintf(`{"errors": ["%v"]}`, op.err)), &cmd.Results[op.position])
@@ -923,11 +1098,34 @@ func sendResults(ctx *Context, result mig.Command) (err error) {
 		panic(err)
 	}
 
+	// keep the results in the journal until they are published, so they
+	// are sent again if the agent restarts
+	journaled := ctx.Journal != nil && result.ID > 0
+	if journaled {
+		err = ctx.Journal.recordResults(result.ID, body)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{CommandID: result.ID, ActionID: result.Action.ID, Desc: fmt.Sprintf("failed to journal results: %v", err)}.Err()
+		}
+	}
+
 	err = publish(ctx, mig.ExchangeToSchedulers, mig.QueueAgentResults, body)
 	if err != nil {
 		panic(err)
 	}
 
+	if journaled {
+		err = ctx.Journal.complete(result.ID)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{CommandID: result.ID, ActionID: result.Action.ID, Desc: fmt.Sprintf("failed to remove results from journal: %v", err)}.Err()
+			err = nil
+		}
+	}
+
+	// the results of an upgrade are sent, restart on the new binary
+	if upgradeRequested(ctx, result.ID) {
+		ctx.Channels.Terminate <- upgradeExitReason
+	}
+
 	return
 }
 
@@ -962,6 +1160,7 @@ func heartbeat(ctx *Context) (err error) {
 				Proxy:     ctx.Agent.Env.Proxy,
 				Addresses: ctx.Agent.Env.Addresses,
 				PublicIP:  ctx.Agent.Env.PublicIP,
+				PublicIPs: ctx.Agent.Env.PublicIPs,
 				Modules:   ctx.Agent.Env.Modules,
 			},
 			Tags: tags,
@@ -976,13 +1175,13 @@ func heartbeat(ctx *Context) (err error) {
 			ctx.Channels.Log <- mig.Log{Desc: desc}.Err()
 			// Don't treat this error as fatal, sleep for a period of time
 			// (as occurs at the end of this loop) and retry
-			time.Sleep(ctx.Sleeper)
+			time.Sleep(settings().heartBeatFreq)
 			continue
 		}
 		desc := fmt.Sprintf("heartbeat %q", body)
 		ctx.Channels.Log <- mig.Log{Desc: desc}.Debug()
 
-		heartbeatURL, err := url.Parse(APIURL)
+		heartbeatURL, err := url.Parse(settings().apiURL)
 		heartbeatURL.Path = path.Join(heartbeatURL.Path, "heartbeat")
 		heartbeatAPIURL := heartbeatURL.String()
 
@@ -995,6 +1194,9 @@ func heartbeat(ctx *Context) (err error) {
 			content, _ := ioutil.ReadAll(response.Body)
 			desc := fmt.Sprintf("Expected status code %d but got %d \n %s", http.StatusOK, response.StatusCode, string(content))
 			ctx.Channels.Log <- mig.Log{Desc: desc}.Err()
+		} else {
+			// the API received the heartbeat, an upgrade to this binary is successful
+			confirmUpgrade(ctx)
 		}
 
 		// update the local heartbeat file
@@ -1003,7 +1205,7 @@ func heartbeat(ctx *Context) (err error) {
 			ctx.Channels.Log <- mig.Log{Desc: "Failed to write mig-agent.ok to disk"}.Err()
 		}
 		os.Chmod(path.Join(ctx.Agent.RunDir, "mig-agent.ok"), 0644)
-		time.Sleep(ctx.Sleeper)
+		time.Sleep(settings().heartBeatFreq)
 	}
 	return
 }
@@ -1024,7 +1226,7 @@ func publish(ctx *Context, exchange, routingKey string, body []byte) (err error)
 		DeliveryMode: amqp.Persistent,
 		Timestamp:    time.Now(),
 		ContentType:  "text/plain",
-		Expiration:   fmt.Sprintf("%d", int64(ctx.Sleeper/time.Millisecond)*10),
+		Expiration:   fmt.Sprintf("%d", int64(settings().heartBeatFreq/time.Millisecond)*10),
 		Body:         []byte(body),
 	}
 	for tries := 0; tries < 2; tries++ {
@@ -1033,10 +1235,12 @@ func publish(ctx *Context, exchange, routingKey string, body []byte) (err error)
 			false, // is immediate
 			msg)   // AMQP message
 		if err == nil { // success! exit the function
+			metricAMQPPublished.Inc(routingKey)
 			desc := fmt.Sprintf("Message published to exchange %q with routing key %q and body %q", exchange, routingKey, msg.Body)
 			ctx.Channels.Log <- mig.Log{Desc: desc}.Debug()
 			return
 		}
+		metricAMQPPublishErrors.Inc()
 		ctx.Channels.Log <- mig.Log{Desc: "Publishing failed. Retrying..."}.Err()
 		time.Sleep(10 * time.Second)
 	}
@@ -1053,9 +1257,12 @@ func printConfigSettings() {
 	fmt.Println("MUSTINSTALLSERVICE: ", MUSTINSTALLSERVICE)
 	fmt.Println("DISCOVERPUBLICIP  : ", DISCOVERPUBLICIP)
 	fmt.Println("DISCOVERAWSMETA   : ", DISCOVERAWSMETA)
+	fmt.Println("FACTS             : ", FACTS)
+	fmt.Println("DISCOVERCONTAINERS: ", DISCOVERCONTAINERS)
 	fmt.Println("CHECKIN           : ", CHECKIN)
 	fmt.Println("EXTRAPRIVACYMODE  : ", EXTRAPRIVACYMODE)
 	fmt.Println("SPAWNPERSISTENT   : ", SPAWNPERSISTENT)
+	fmt.Println("SANDBOXMODULES    : ", SANDBOXMODULES)
 	fmt.Println("REFRESHENV        : ", REFRESHENV)
 	fmt.Println("AMQPBROKER        : ", AMQPBROKER)
 	fmt.Println("APIURL            : ", APIURL)
@@ -1064,4 +1271,8 @@ func printConfigSettings() {
 	fmt.Println("HEARTBEATFREQ     : ", HEARTBEATFREQ)
 	fmt.Println("MODULETIMEOUT     : ", MODULETIMEOUT)
 	fmt.Println("ONLYVERIFYPUBKEY  : ", ONLYVERIFYPUBKEY)
+	fmt.Println("UPGRADESIGNATURES : ", UPGRADESIGNATURES)
+	fmt.Println("UPGRADETIMEOUT    : ", UPGRADETIMEOUT)
+	fmt.Println("UPGRADEALLOWDOWNGRADE : ", UPGRADEALLOWDOWNGRADE)
+	fmt.Println("CONTROLSOCKET     : ", CONTROLSOCKET)
 }
@@ -14,11 +14,12 @@ package agentcontext /* import "github.com/mozilla/mig/mig-agent/agentcontext" *
 import (
 	"fmt"
 	"github.com/kardianos/osext"
+	"github.com/mozilla/mig"
 	"io/ioutil"
 	mrand "math/rand"
-	"github.com/mozilla/mig"
 	"os"
 	"path"
+	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -28,19 +29,24 @@ import (
 
 // Information from the system the agent is running on
 type AgentContext struct {
-	Hostname     string   // Hostname
-	BinPath      string   // Path to invoked binary
-	RunDir       string   // Agent runtime directory
-	OS           string   // Operating System
-	OSIdent      string   // OS release identifier
-	Init         string   // OS Init
-	Architecture string   // System architecture
-	Addresses    []string // IP addresses
-	PublicIP     string   // Systems public IP from perspective of API
-	UID          string   // Agent ID
-	QueueLoc     string   // Agent queue location
+	Hostname     string              // Hostname
+	BinPath      string              // Path to invoked binary
+	RunDir       string              // Agent runtime directory
+	OS           string              // Operating System
+	OSIdent      string              // OS release identifier
+	Init         string              // OS Init
+	Architecture string              // System architecture
+	Addresses    []string            // IP addresses
+	PublicIP     string              // Systems public IP from perspective of API
+	PublicIPs    []mig.AgentPublicIP // Public IPs by address family and proxy
+	UID          string              // Agent ID
+	QueueLoc     string              // Agent queue location
 
 	AWS AWSContext // AWS specific information
+
+	Facts mig.AgentFacts // Facts gathered by the facts collectors
+
+	Containers []mig.AgentContainer // Containers running on the endpoint
 }
 
 func (ctx *AgentContext) IsZero() bool {
@@ -67,6 +73,11 @@ func (ctx *AgentContext) Differs(comp AgentContext) bool {
 		ctx.AWS.InstanceType != comp.AWS.InstanceType {
 		return true
 	}
+	if !reflect.DeepEqual(ctx.Facts, comp.Facts) ||
+		!reflect.DeepEqual(ctx.PublicIPs, comp.PublicIPs) ||
+		!reflect.DeepEqual(ctx.Containers, comp.Containers) {
+		return true
+	}
 	if ctx.Addresses == nil && comp.Addresses == nil {
 		return false
 	}
@@ -94,19 +105,24 @@ func (ctx *AgentContext) ToAgent() (ret mig.Agent) {
 	ret.Env.Init = ctx.Init
 	ret.Env.Addresses = ctx.Addresses
 	ret.Env.PublicIP = ctx.PublicIP
+	ret.Env.PublicIPs = ctx.PublicIPs
 	ret.Env.AWS.InstanceID = ctx.AWS.InstanceID
 	ret.Env.AWS.LocalIPV4 = ctx.AWS.LocalIPV4
 	ret.Env.AWS.AMIID = ctx.AWS.AMIID
 	ret.Env.AWS.InstanceType = ctx.AWS.InstanceType
+	ret.Env.Facts = ctx.Facts
+	ret.Env.Containers = ctx.Containers
 	return
 }
 
 // Passed to NewAgentContext() to inform environment discovery
 type AgentContextHints struct {
-	APIUrl           string   // MIG API URL
-	Proxies          []string // Proxies avialable for use in discovery
-	DiscoverPublicIP bool     // Attempt to discover public IP
-	DiscoverAWSMeta  bool     // Attempt to discover AWS metadata
+	APIUrl             string   // MIG API URL
+	Proxies            []string // Proxies avialable for use in discovery
+	DiscoverPublicIP   bool     // Attempt to discover public IP
+	DiscoverAWSMeta    bool     // Attempt to discover AWS metadata
+	Facts              []string // Facts collectors to run
+	DiscoverContainers bool     // Attempt to discover containers
 }
 
 // Information used for agents running in AWS environments
@@ -210,6 +226,19 @@ func NewAgentContext(lch chan mig.Log, hints AgentContextHints) (ret AgentContex
 		}
 	}
 
+	if len(hints.Facts) > 0 {
+		ret.Facts = collectFacts(hints.Facts)
+	}
+
+	if hints.DiscoverContainers {
+		ret.Containers, err = FindContainers()
+		if err != nil {
+			// a failure to list containers is not fatal to the agent
+			logChan <- mig.Log{Desc: fmt.Sprintf("failed to discover containers: %v", err)}.Debug()
+			err = nil
+		}
+	}
+
 	return
 }
 
@@ -0,0 +1,218 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// DockerSocket is the location of the API socket of the docker daemon
+var DockerSocket = "/var/run/docker.sock"
+
+// cgroupContainers match the cgroup paths of the processes of a container, as
+// created by the common container runtimes, and extract the container ID
+var cgroupContainers = []struct {
+	runtime string
+	re      *regexp.Regexp
+}{
+	{"containerd", regexp.MustCompile(`cri-containerd-([0-9a-f]{64})\.scope`)},
+	{"crio", regexp.MustCompile(`crio-([0-9a-f]{64})\.scope`)},
+	{"podman", regexp.MustCompile(`libpod-([0-9a-f]{64})\.scope`)},
+	{"docker", regexp.MustCompile(`(?:/docker/|docker-)([0-9a-f]{64})`)},
+	{"kubernetes", regexp.MustCompile(`/kubepods[^ ]*/([0-9a-f]{64})$`)},
+	{"lxc", regexp.MustCompile(`/lxc(?:\.payload)?[./]([^/]+)`)},
+}
+
+// FindContainers returns the containers running on the endpoint. Containers
+// are found in the cgroups of the processes that run in their own mount
+// namespace, and completed with the names and images known to the docker
+// daemon if it runs. Containers are only supported on Linux.
+func FindContainers() (containers []mig.AgentContainer, err error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	return findContainers("/proc", DockerSocket)
+}
+
+func findContainers(procDir, dockerSock string) (containers []mig.AgentContainer, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("findContainers() -> %v", e)
+		}
+	}()
+	ownns, err := os.Readlink(path.Join(procDir, "self/ns/mnt"))
+	if err != nil {
+		panic(err)
+	}
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		panic(err)
+	}
+	found := make(map[string]*mig.AgentContainer)
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		// processes exit while we scan, skip the ones that are gone
+		ns, err := os.Readlink(path.Join(procDir, dir.Name(), "ns/mnt"))
+		if err != nil || ns == ownns {
+			continue
+		}
+		fd, err := os.Open(path.Join(procDir, dir.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		rt, id := containerFromCgroup(fd)
+		fd.Close()
+		if id == "" {
+			continue
+		}
+		// the process with the lowest pid is the init of the container
+		if c, ok := found[id]; ok && c.Pid < pid {
+			continue
+		}
+		found[id] = &mig.AgentContainer{ID: id, Runtime: rt, Pid: pid}
+	}
+	if dockerSock != "" {
+		err = addDockerContainers(found, dockerSock)
+		if err != nil && logChan != nil {
+			logChan <- mig.Log{Desc: fmt.Sprintf("failed to list docker containers: %v", err)}.Debug()
+		}
+		err = nil
+	}
+	for _, c := range found {
+		containers = append(containers, *c)
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	return
+}
+
+// containerFromCgroup returns the runtime and ID of the container a process
+// belongs to, given the content of its cgroup file
+func containerFromCgroup(r io.Reader) (rt, id string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, cc := range cgroupContainers {
+			m := cc.re.FindStringSubmatch(fields[2])
+			if m != nil {
+				return cc.runtime, m[1]
+			}
+		}
+	}
+	return "", ""
+}
+
+// dockerContainer is a container returned by the container list of the
+// docker API
+type dockerContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Image string   `json:"Image"`
+}
+
+// addDockerContainers completes the containers found in cgroups with their
+// names and images from the docker API, and adds the docker containers that
+// were not found in cgroups
+func addDockerContainers(found map[string]*mig.AgentContainer, sock string) error {
+	fi, err := os.Stat(sock)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		// docker is not running
+		return nil
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout("unix", sock, time.Second)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+	var list []dockerContainer
+	err = dockerGet(client, "/containers/json", &list)
+	if err != nil {
+		return err
+	}
+	for _, dc := range list {
+		c, ok := found[dc.ID]
+		if !ok {
+			var inspect struct {
+				State struct {
+					Pid int `json:"Pid"`
+				} `json:"State"`
+			}
+			err = dockerGet(client, "/containers/"+dc.ID+"/json", &inspect)
+			if err != nil || inspect.State.Pid == 0 {
+				continue
+			}
+			c = &mig.AgentContainer{ID: dc.ID, Pid: inspect.State.Pid}
+			found[dc.ID] = c
+		}
+		c.Runtime = "docker"
+		c.Image = dc.Image
+		if len(dc.Names) > 0 {
+			c.Name = strings.TrimPrefix(dc.Names[0], "/")
+		}
+	}
+	return nil
+}
+
+func dockerGet(client *http.Client, endpoint string, v interface{}) error {
+	resp, err := client.Get("http://docker" + endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker API returned %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 10*1024*1024)).Decode(v)
+}
+
+// SelectContainer returns the container that matches the selector, which is
+// the ID of a container, a unique prefix of its ID, or its name
+func SelectContainer(containers []mig.AgentContainer, selector string) (c mig.AgentContainer, err error) {
+	if selector == "" {
+		return c, fmt.Errorf("empty container selector")
+	}
+	var matches []mig.AgentContainer
+	for _, ctr := range containers {
+		if ctr.ID == selector || ctr.Name == selector {
+			return ctr, nil
+		}
+		if strings.HasPrefix(ctr.ID, selector) {
+			matches = append(matches, ctr)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return c, fmt.Errorf("no container matches %q", selector)
+	case 1:
+		return matches[0], nil
+	}
+	return c, fmt.Errorf("%d containers match %q", len(matches), selector)
+}
@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/mig"
+)
+
+const (
+	testDockerID     = "4f3c2b1a0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a"
+	testContainerdID = "a1b2c3d4e5f60718293a4b5c6d7e8f9012345678901234567890abcdefabcdef"
+	testOrphanID     = "ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100"
+)
+
+func TestContainerFromCgroup(t *testing.T) {
+	testcases := []struct {
+		cgroup, runtime, id string
+	}{
+		{"12:memory:/docker/" + testDockerID + "\n", "docker", testDockerID},
+		{"0::/system.slice/docker-" + testDockerID + ".scope\n", "docker", testDockerID},
+		{"0::/kubepods.slice/kubepods-besteffort.slice/cri-containerd-" + testContainerdID + ".scope\n", "containerd", testContainerdID},
+		{"11:pids:/kubepods/burstable/pod1234/" + testContainerdID + "\n", "kubernetes", testContainerdID},
+		{"0::/machine.slice/libpod-" + testDockerID + ".scope\n", "podman", testDockerID},
+		{"0::/lxc.payload.web1\n", "lxc", "web1"},
+		{"12:memory:/user.slice\n0::/init.scope\n", "", ""},
+	}
+	for _, tc := range testcases {
+		rt, id := containerFromCgroup(strings.NewReader(tc.cgroup))
+		if rt != tc.runtime || id != tc.id {
+			t.Errorf("cgroup %q: got runtime %q and id %q, expected %q and %q", tc.cgroup, rt, id, tc.runtime, tc.id)
+		}
+	}
+}
+
+func TestFindContainers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migcontainers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	procDir := path.Join(dir, "proc")
+	mkproc := func(name, mntns, cgroup string) {
+		err := os.MkdirAll(path.Join(procDir, name, "ns"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Symlink(mntns, path.Join(procDir, name, "ns/mnt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path.Join(procDir, name, "cgroup"), []byte(cgroup), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkproc("self", "mnt:[1]", "0::/system.slice/mig-agent.service\n")
+	mkproc("1", "mnt:[1]", "0::/init.scope\n")
+	mkproc("1200", "mnt:[2]", "0::/system.slice/docker-"+testDockerID+".scope\n")
+	mkproc("1180", "mnt:[2]", "0::/system.slice/docker-"+testDockerID+".scope\n")
+	mkproc("2000", "mnt:[3]", "0::/kubepods.slice/cri-containerd-"+testContainerdID+".scope\n")
+	// a process that shares the mount namespace of the agent is not in a
+	// container, whatever its cgroup says
+	mkproc("3000", "mnt:[1]", "0::/system.slice/docker-"+testOrphanID+".scope\n")
+
+	sock := path.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			fmt.Fprintf(w, `[{"Id": %q, "Names": ["/web"], "Image": "nginx:1.21"},
+				{"Id": %q, "Names": ["/cache"], "Image": "redis:6"}]`, testDockerID, testOrphanID)
+		case "/containers/" + testOrphanID + "/json":
+			fmt.Fprint(w, `{"State": {"Pid": 3100}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	containers, err := findContainers(procDir, sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mig.AgentContainer{
+		{ID: testDockerID, Name: "web", Image: "nginx:1.21", Runtime: "docker", Pid: 1180},
+		{ID: testContainerdID, Runtime: "containerd", Pid: 2000},
+		{ID: testOrphanID, Name: "cache", Image: "redis:6", Runtime: "docker", Pid: 3100},
+	}
+	if !reflect.DeepEqual(containers, expect) {
+		t.Errorf("unexpected containers %+v", containers)
+	}
+
+	// without a docker daemon, containers are only found in cgroups
+	containers, err = findContainers(procDir, path.Join(dir, "nosuchsocket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 2 || containers[0].Name != "" {
+		t.Errorf("unexpected containers without docker %+v", containers)
+	}
+}
+
+func TestSelectContainer(t *testing.T) {
+	containers := []mig.AgentContainer{
+		{ID: testDockerID, Name: "web", Pid: 1180},
+		{ID: testContainerdID, Pid: 2000},
+		{ID: "a1b2ffff", Pid: 2100},
+	}
+	testcases := []struct {
+		selector string
+		pid      int
+		fails    bool
+	}{
+		{"web", 1180, false},
+		{testDockerID, 1180, false},
+		{"4f3c", 1180, false},
+		{"a1b2c3", 2000, false},
+		{"a1b2", 0, true},
+		{"db", 0, true},
+		{"", 0, true},
+	}
+	for _, tc := range testcases {
+		c, err := SelectContainer(containers, tc.selector)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("expected selector %q to fail", tc.selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("selector %q failed: %v", tc.selector, err)
+			continue
+		}
+		if c.Pid != tc.pid {
+			t.Errorf("selector %q returned pid %d, expected %d", tc.selector, c.Pid, tc.pid)
+		}
+	}
+}
@@ -8,10 +8,12 @@ package agentcontext
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/mozilla/mig"
@@ -35,19 +37,25 @@ func findLocalIPs(orig_ctx AgentContext) (ctx AgentContext, err error) {
 	return
 }
 
-// findPublicIP queries the ip endpoint of the mig api to discover the
-// public ip of the agent
+// findPublicIP queries the ip endpoint of the mig api to discover the public
+// ips of the agent. The api is queried through each configured proxy, and
+// directly over ipv4 and ipv6, as dual-stack and multi-homed endpoints egress
+// from different addresses depending on the route. The proxies of the
+// environment are honored for the direct queries.
 func findPublicIP(orig_ctx AgentContext, hints AgentContextHints) (ctx AgentContext, err error) {
 	ctx = orig_ctx
-
-	tr := &http.Transport{
-		Dial: (&net.Dialer{Timeout: 5 * time.Second}).Dial,
+	ctx.PublicIP = ""
+	ctx.PublicIPs = nil
+	seen := make(map[string]bool)
+	add := func(ip mig.AgentPublicIP) {
+		if seen[ip.IP+"|"+ip.Proxy] {
+			return
+		}
+		seen[ip.IP+"|"+ip.Proxy] = true
+		ctx.PublicIPs = append(ctx.PublicIPs, ip)
+		logChan <- mig.Log{Desc: fmt.Sprintf("Found public ip %s (%s, proxy %q, source %q)", ip.IP, ip.Family, ip.Proxy, ip.Source)}.Debug()
 	}
-	client := &http.Client{Transport: tr}
-	var resp *http.Response
 
-	// If any proxies have been configured, try to use those first, fall back to a
-	// direct connection.
 	for _, proxy := range hints.Proxies {
 		logChan <- mig.Log{Desc: fmt.Sprintf("Trying proxy %v for public IP retrieval", proxy)}.Debug()
 		pu, err := url.Parse("http://" + proxy)
@@ -55,41 +63,79 @@ func findPublicIP(orig_ctx AgentContext, hints AgentContextHints) (ctx AgentCont
 			logChan <- mig.Log{Desc: fmt.Sprintf("Failed to parse proxy url http://%s - %v", proxy, err)}.Info()
 			continue
 		}
-		tr.Proxy = http.ProxyURL(pu)
-		resp, err = client.Get(hints.APIUrl + "/ip")
+		ip, err := queryPublicIP(hints.APIUrl, http.ProxyURL(pu), "tcp")
 		if err != nil {
 			logChan <- mig.Log{Desc: fmt.Sprintf("Public IP retrieval failed through proxy http://%s - %v", proxy, err)}.Info()
 			continue
-		} else {
-			goto parseBody
 		}
+		add(ip)
 	}
-
-	// Try a direct connection, but also take into consideration any proxies that may
-	// have been configured in the proxy related environment variables.
-	logChan <- mig.Log{Desc: "Trying proxy from environment otherwise direct connection for public IP retrieval"}.Debug()
-	tr.Proxy = http.ProxyFromEnvironment
-	resp, err = client.Get(hints.APIUrl + "/ip")
-	if err != nil {
-		logChan <- mig.Log{Desc: fmt.Sprintf("Public IP retrieval from API failed. Error was: %v", err)}.Info()
-	} else {
-		goto parseBody
+	for _, network := range []string{"tcp4", "tcp6"} {
+		logChan <- mig.Log{Desc: fmt.Sprintf("Trying proxy from environment otherwise direct %s connection for public IP retrieval", network)}.Debug()
+		ip, err := queryPublicIP(hints.APIUrl, http.ProxyFromEnvironment, network)
+		if err != nil {
+			// endpoints commonly lack a route in one of the families
+			logChan <- mig.Log{Desc: fmt.Sprintf("Public IP retrieval over %s failed: %v", network, err)}.Debug()
+			continue
+		}
+		add(ip)
 	}
-
-	// exit here if no connection succeeded
-	logChan <- mig.Log{Desc: fmt.Sprintf("Failed to retrieve public ip from api: %v", err)}.Err()
+	if len(ctx.PublicIPs) == 0 {
+		logChan <- mig.Log{Desc: "Failed to retrieve public ip from api"}.Err()
+		return
+	}
+	// the first address found is kept as the public ip of the agent, for
+	// the components that only handle one
+	ctx.PublicIP = ctx.PublicIPs[0].IP
 	return
+}
+
+// publicIPSourceHeader is set by the api in the responses of the ip endpoint
+// to indicate how it determined the address
+const publicIPSourceHeader = "X-MIGIPSOURCE"
 
-parseBody:
+// queryPublicIP retrieves the public ip of the agent from the ip endpoint of
+// the api, connecting through proxy over network, which is tcp, tcp4 or tcp6
+func queryPublicIP(apiURL string, proxy func(*http.Request) (*url.URL, error), network string) (ip mig.AgentPublicIP, err error) {
+	var used *url.URL
+	tr := &http.Transport{
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			u, err := proxy(r)
+			used = u
+			return u, err
+		},
+		Dial: func(_, addr string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: 5 * time.Second}).Dial(network, addr)
+		},
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: tr, Timeout: 10 * time.Second}
+	resp, err := client.Get(apiURL + "/ip")
+	if err != nil {
+		return
+	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	ip := net.ParseIP(string(body))
-	if ip == nil {
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("Public IP API returned status %d", resp.StatusCode)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return
+	}
+	parsed := net.ParseIP(strings.TrimSpace(string(body)))
+	if parsed == nil {
 		err = fmt.Errorf("Public IP API returned bad results")
-		logChan <- mig.Log{Desc: fmt.Sprintf("%v", err)}.Err()
 		return
 	}
-	ctx.PublicIP = ip.String()
-	logChan <- mig.Log{Desc: fmt.Sprintf("Found public ip %s", ctx.PublicIP)}.Debug()
+	ip.IP = parsed.String()
+	ip.Family = "ipv6"
+	if parsed.To4() != nil {
+		ip.Family = "ipv4"
+	}
+	if used != nil {
+		ip.Proxy = used.Host
+	}
+	ip.Source = resp.Header.Get(publicIPSourceHeader)
 	return
 }
@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/mig"
+)
+
+func TestFindPublicIP(t *testing.T) {
+	logChan = make(chan mig.Log)
+	go func(c chan mig.Log) {
+		for range c {
+		}
+	}(logChan)
+	defer func() {
+		close(logChan)
+		logChan = nil
+	}()
+
+	// the test server is both the api and the proxy, proxied requests carry
+	// the absolute url of the api
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/ip" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-MIGIPSOURCE", "x-forwarded-for")
+		if r.URL.IsAbs() {
+			fmt.Fprint(w, "2001:DB8::7")
+			return
+		}
+		fmt.Fprint(w, "198.51.100.4")
+	}))
+	defer srv.Close()
+	proxy, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints := AgentContextHints{
+		APIUrl:  srv.URL + "/api/v1",
+		Proxies: []string{proxy.Host, "invalid proxy:%%"},
+	}
+	ctx, err := findPublicIP(AgentContext{PublicIP: "192.0.2.1"}, hints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the test server only listens on ipv4, the direct ipv6 query fails
+	expect := []mig.AgentPublicIP{
+		{IP: "2001:db8::7", Family: "ipv6", Proxy: proxy.Host, Source: "x-forwarded-for"},
+		{IP: "198.51.100.4", Family: "ipv4", Source: "x-forwarded-for"},
+	}
+	if !reflect.DeepEqual(ctx.PublicIPs, expect) {
+		t.Errorf("unexpected public ips %+v", ctx.PublicIPs)
+	}
+	if ctx.PublicIP != "2001:db8::7" {
+		t.Errorf("unexpected public ip %q", ctx.PublicIP)
+	}
+
+	srv.Close()
+	ctx, err = findPublicIP(ctx, hints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.PublicIP != "" || len(ctx.PublicIPs) != 0 {
+		t.Errorf("expected no public ip when the api is unreachable, got %q %+v", ctx.PublicIP, ctx.PublicIPs)
+	}
+}
@@ -0,0 +1,169 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// A FactsCollector gathers a set of facts about the endpoint. It returns a nil
+// map if the facts do not apply to the endpoint, for example the metadata of a
+// cloud provider the endpoint does not run in. Collectors must not return
+// values that change on every call, as any change in the facts is reported as
+// a change of the agent environment.
+type FactsCollector func() (facts map[string]string, err error)
+
+// FactsCollectors is the set of registered facts collectors, by name
+var FactsCollectors = make(map[string]FactsCollector)
+
+// RegisterFacts registers a new facts collector. The facts it gathers are
+// stored in the agent environment under its name.
+func RegisterFacts(name string, c FactsCollector) {
+	if _, exist := FactsCollectors[name]; exist {
+		panic("RegisterFacts: a facts collector named " + name + " has already been registered")
+	}
+	FactsCollectors[name] = c
+}
+
+func init() {
+	RegisterFacts("gce", gceFacts)
+	RegisterFacts("azure", azureFacts)
+}
+
+// collectFacts runs the collectors listed in names. Failures of a collector
+// are logged and do not prevent the others from running.
+func collectFacts(names []string) (facts mig.AgentFacts) {
+	facts = make(mig.AgentFacts)
+	for _, name := range names {
+		c, ok := FactsCollectors[name]
+		if !ok {
+			logChan <- mig.Log{Desc: fmt.Sprintf("facts collector %q is not available on this platform", name)}.Debug()
+			continue
+		}
+		f, err := c()
+		if err != nil {
+			logChan <- mig.Log{Desc: fmt.Sprintf("facts collector %q failed: %v", name, err)}.Debug()
+			continue
+		}
+		if len(f) > 0 {
+			facts[name] = f
+		}
+	}
+	return
+}
+
+// gceMetaURL and azureMetaURL are the locations of the metadata services of
+// Google Compute Engine and Azure
+var (
+	gceMetaURL   = "http://metadata.google.internal/computeMetadata/v1/"
+	azureMetaURL = "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01"
+)
+
+// fetchMeta retrieves a document from a cloud metadata service. The proxies
+// of the environment are not used, as metadata services are link-local.
+func fetchMeta(url string, header map[string]string) (body []byte, respHeader http.Header, err error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{Timeout: time.Second}).Dial,
+		},
+		Timeout: 3 * time.Second,
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("invalid HTTP response code returned by metadata service: %v", resp.StatusCode)
+		return
+	}
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, FETCHBODYMAX))
+	return body, resp.Header, err
+}
+
+// gceFacts gathers the instance metadata of Google Compute Engine
+func gceFacts() (facts map[string]string, err error) {
+	get := func(endpoint string) (string, error) {
+		body, header, err := fetchMeta(gceMetaURL+endpoint, map[string]string{"Metadata-Flavor": "Google"})
+		if err != nil {
+			return "", err
+		}
+		if header.Get("Metadata-Flavor") != "Google" {
+			return "", fmt.Errorf("invalid metadata flavor %q", header.Get("Metadata-Flavor"))
+		}
+		return strings.TrimSpace(string(body)), nil
+	}
+	id, err := get("instance/id")
+	if err != nil {
+		// not running in GCE
+		return nil, nil
+	}
+	facts = map[string]string{"instanceid": id}
+	for name, endpoint := range map[string]string{
+		"zone":        "instance/zone",
+		"machinetype": "instance/machine-type",
+		"projectid":   "project/project-id",
+	} {
+		value, err := get(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		// zones and machine types are returned as resource paths, such as
+		// projects/1234/zones/us-east1-b
+		facts[name] = path.Base(value)
+	}
+	return
+}
+
+// azureFacts gathers the instance metadata of Azure
+func azureFacts() (facts map[string]string, err error) {
+	body, _, err := fetchMeta(azureMetaURL, map[string]string{"Metadata": "true"})
+	if err != nil {
+		// not running in Azure
+		return nil, nil
+	}
+	var compute struct {
+		VMID              string `json:"vmId"`
+		Name              string `json:"name"`
+		Location          string `json:"location"`
+		VMSize            string `json:"vmSize"`
+		ResourceGroupName string `json:"resourceGroupName"`
+		SubscriptionID    string `json:"subscriptionId"`
+	}
+	err = json.Unmarshal(body, &compute)
+	if err != nil {
+		return nil, err
+	}
+	if compute.VMID == "" {
+		return nil, fmt.Errorf("metadata service returned no vm id")
+	}
+	facts = map[string]string{
+		"vmid":           compute.VMID,
+		"name":           compute.Name,
+		"location":       compute.Location,
+		"vmsize":         compute.VMSize,
+		"resourcegroup":  compute.ResourceGroupName,
+		"subscriptionid": compute.SubscriptionID,
+	}
+	return
+}
@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	RegisterFacts("kernel", kernelFacts)
+	RegisterFacts("system", systemFacts)
+}
+
+// sysctl returns the values of the named kernel variables
+func sysctl(names ...string) (values []string, err error) {
+	out, err := exec.Command("sysctl", append([]string{"-n"}, names...)...).Output()
+	if err != nil {
+		return
+	}
+	values = strings.Split(strings.TrimSpace(string(out)), "\n")
+	return
+}
+
+// kernelFacts returns the release and version of the running kernel
+func kernelFacts() (facts map[string]string, err error) {
+	values, err := sysctl("kern.osrelease", "kern.version")
+	if err != nil || len(values) != 2 {
+		return nil, err
+	}
+	return map[string]string{"release": values[0], "version": values[1]}, nil
+}
+
+// systemFacts returns the number and model of the processors, and the total
+// memory in bytes
+func systemFacts() (facts map[string]string, err error) {
+	values, err := sysctl("hw.ncpu", "machdep.cpu.brand_string", "hw.memsize")
+	if err != nil || len(values) != 3 {
+		return nil, err
+	}
+	return map[string]string{"cpus": values[0], "cpumodel": values[1], "memtotal": values[2]}, nil
+}
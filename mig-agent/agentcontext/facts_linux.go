@@ -0,0 +1,146 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterFacts("kernel", func() (map[string]string, error) { return kernelFacts("/") })
+	RegisterFacts("system", func() (map[string]string, error) { return systemFacts("/") })
+	RegisterFacts("container", func() (map[string]string, error) { return containerFacts("/") })
+	RegisterFacts("machineid", func() (map[string]string, error) { return machineIDFacts("/") })
+}
+
+// kernelFacts returns the release and version of the running kernel
+func kernelFacts(root string) (facts map[string]string, err error) {
+	facts = make(map[string]string)
+	for _, name := range []string{"osrelease", "version"} {
+		buf, err := ioutil.ReadFile(path.Join(root, "proc/sys/kernel", name))
+		if err != nil {
+			return nil, err
+		}
+		facts[strings.TrimPrefix(name, "os")] = strings.TrimSpace(string(buf))
+	}
+	return
+}
+
+// systemFacts returns the number and model of the processors, and the total
+// memory in bytes
+func systemFacts(root string) (facts map[string]string, err error) {
+	facts = make(map[string]string)
+	fd, err := os.Open(path.Join(root, "proc/cpuinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	cpus := 0
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(fields[0]) {
+		case "processor":
+			cpus++
+		case "model name":
+			facts["cpumodel"] = strings.TrimSpace(fields[1])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	facts["cpus"] = strconv.Itoa(cpus)
+
+	buf, err := ioutil.ReadFile(path.Join(root, "proc/meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MemTotal %q", fields[1])
+		}
+		facts["memtotal"] = strconv.FormatUint(kb*1024, 10)
+	}
+	return
+}
+
+// containerRuntimeSockets are the sockets of the container runtimes that can
+// run on the endpoint
+var containerRuntimeSockets = []struct {
+	runtime, socket string
+}{
+	{"docker", "var/run/docker.sock"},
+	{"containerd", "run/containerd/containerd.sock"},
+	{"crio", "var/run/crio/crio.sock"},
+	{"podman", "run/podman/podman.sock"},
+}
+
+// containerFacts reports whether the agent runs in a container, and which
+// container runtimes are available on the endpoint
+func containerFacts(root string) (facts map[string]string, err error) {
+	facts = map[string]string{"incontainer": "false"}
+	runtime := ""
+	if _, err := os.Stat(path.Join(root, ".dockerenv")); err == nil {
+		runtime = "docker"
+	} else if _, err := os.Stat(path.Join(root, "run/.containerenv")); err == nil {
+		runtime = "podman"
+	} else if buf, err := ioutil.ReadFile(path.Join(root, "proc/1/cgroup")); err == nil {
+		cgroup := string(buf)
+		for _, rt := range []string{"kubepods", "docker", "containerd", "lxc"} {
+			if strings.Contains(cgroup, "/"+rt) {
+				runtime = rt
+				break
+			}
+		}
+	}
+	if runtime == "kubepods" {
+		runtime = "kubernetes"
+	}
+	if runtime != "" {
+		facts["incontainer"] = "true"
+		facts["runtime"] = runtime
+	}
+	var available []string
+	for _, rt := range containerRuntimeSockets {
+		fi, err := os.Stat(path.Join(root, rt.socket))
+		if err == nil && fi.Mode()&os.ModeSocket != 0 {
+			available = append(available, rt.runtime)
+		}
+	}
+	if len(available) > 0 {
+		facts["runtimes"] = strings.Join(available, ",")
+	}
+	return facts, nil
+}
+
+// machineIDFacts returns the machine ID set by systemd or dbus
+func machineIDFacts(root string) (facts map[string]string, err error) {
+	for _, f := range []string{"etc/machine-id", "var/lib/dbus/machine-id"} {
+		buf, err := ioutil.ReadFile(path.Join(root, f))
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSpace(string(buf))
+		if id != "" {
+			return map[string]string{"id": id}, nil
+		}
+	}
+	return nil, nil
+}
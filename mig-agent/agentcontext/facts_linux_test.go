@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func writeTestRoot(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := path.Join(root, name)
+		err := os.MkdirAll(path.Dir(p), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLinuxFacts(t *testing.T) {
+	root, err := ioutil.TempDir("", "migfacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTestRoot(t, root, map[string]string{
+		"proc/sys/kernel/osrelease": "4.15.0-20-generic\n",
+		"proc/sys/kernel/version":   "#21-Ubuntu SMP Tue Apr 24 06:16:15 UTC 2018\n",
+		"proc/cpuinfo": "processor\t: 0\nmodel name\t: Intel(R) Xeon(R) CPU E5-2686 v4 @ 2.30GHz\n\n" +
+			"processor\t: 1\nmodel name\t: Intel(R) Xeon(R) CPU E5-2686 v4 @ 2.30GHz\n",
+		"proc/meminfo":   "MemTotal:        2048000 kB\nMemFree:          102400 kB\n",
+		"proc/1/cgroup":  "12:pids:/kubepods/besteffort/pod1234/abcd\n",
+		"etc/machine-id": "0123456789abcdef0123456789abcdef\n",
+	})
+
+	facts, err := kernelFacts(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts["release"] != "4.15.0-20-generic" || facts["version"] != "#21-Ubuntu SMP Tue Apr 24 06:16:15 UTC 2018" {
+		t.Errorf("unexpected kernel facts %v", facts)
+	}
+	facts, err = systemFacts(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"cpus": "2", "cpumodel": "Intel(R) Xeon(R) CPU E5-2686 v4 @ 2.30GHz",
+		"memtotal": "2097152000"}
+	if !reflect.DeepEqual(facts, expect) {
+		t.Errorf("unexpected system facts %v", facts)
+	}
+	facts, err = containerFacts(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts["incontainer"] != "true" || facts["runtime"] != "kubernetes" {
+		t.Errorf("unexpected container facts %v", facts)
+	}
+	facts, err = machineIDFacts(root)
+	if err != nil || facts["id"] != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("unexpected machine id facts %v %v", facts, err)
+	}
+
+	// an endpoint that is not a container
+	writeTestRoot(t, root, map[string]string{"proc/1/cgroup": "0::/init.scope\n"})
+	facts, err = containerFacts(root)
+	if err != nil || !reflect.DeepEqual(facts, map[string]string{"incontainer": "false"}) {
+		t.Errorf("unexpected container facts %v %v", facts, err)
+	}
+}
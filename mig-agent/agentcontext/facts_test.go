@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/mozilla/mig"
+)
+
+func TestGCEFacts(t *testing.T) {
+	meta := map[string]string{
+		"/computeMetadata/v1/instance/id":           "4520031799277581759",
+		"/computeMetadata/v1/instance/zone":         "projects/123456789/zones/us-east1-b",
+		"/computeMetadata/v1/instance/machine-type": "projects/123456789/machineTypes/n1-standard-1",
+		"/computeMetadata/v1/project/project-id":    "mig-test",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing metadata flavor", http.StatusForbidden)
+			return
+		}
+		v, ok := meta[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		fmt.Fprint(w, v)
+	}))
+	defer srv.Close()
+	defer func(u string) { gceMetaURL = u }(gceMetaURL)
+
+	gceMetaURL = srv.URL + "/computeMetadata/v1/"
+	facts, err := gceFacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"instanceid": "4520031799277581759", "zone": "us-east1-b",
+		"machinetype": "n1-standard-1", "projectid": "mig-test"}
+	if !reflect.DeepEqual(facts, expect) {
+		t.Errorf("unexpected gce facts %v", facts)
+	}
+
+	// a server that is not a gce metadata service is ignored
+	gceMetaURL = srv.URL + "/notgce/"
+	facts, err = gceFacts()
+	if err != nil || facts != nil {
+		t.Errorf("expected no gce facts, got %v %v", facts, err)
+	}
+}
+
+func TestAzureFacts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("api-version") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"location": "westeurope", "name": "db1", "resourceGroupName": "mig",
+			"subscriptionId": "8d10da13-8125-4ba9-a717-bf7490507b3d",
+			"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6", "vmSize": "Standard_D2s_v3"}`)
+	}))
+	defer srv.Close()
+	defer func(u string) { azureMetaURL = u }(azureMetaURL)
+
+	azureMetaURL = srv.URL + "/metadata/instance/compute?api-version=2021-02-01"
+	facts, err := azureFacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts["vmid"] != "02aab8a4-74ef-476e-8182-f6d2ba4166a6" || facts["location"] != "westeurope" ||
+		facts["vmsize"] != "Standard_D2s_v3" || facts["resourcegroup"] != "mig" {
+		t.Errorf("unexpected azure facts %v", facts)
+	}
+
+	azureMetaURL = srv.URL + "/metadata/instance/compute"
+	facts, err = azureFacts()
+	if err != nil || facts != nil {
+		t.Errorf("expected no azure facts, got %v %v", facts, err)
+	}
+}
+
+func TestCollectFacts(t *testing.T) {
+	logChan = make(chan mig.Log, 10)
+	defer func() { logChan = nil }()
+	FactsCollectors["testfacts"] = func() (map[string]string, error) {
+		return map[string]string{"answer": "42"}, nil
+	}
+	FactsCollectors["testempty"] = func() (map[string]string, error) {
+		return nil, nil
+	}
+	FactsCollectors["testfail"] = func() (map[string]string, error) {
+		return map[string]string{"answer": "0"}, fmt.Errorf("failed")
+	}
+	defer func() {
+		delete(FactsCollectors, "testfacts")
+		delete(FactsCollectors, "testempty")
+		delete(FactsCollectors, "testfail")
+	}()
+	facts := collectFacts([]string{"testfacts", "testempty", "testfail", "nosuchcollector"})
+	expect := mig.AgentFacts{"testfacts": {"answer": "42"}}
+	if !reflect.DeepEqual(facts, expect) {
+		t.Errorf("unexpected facts %v", facts)
+	}
+}
@@ -0,0 +1,20 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package agentcontext
+
+import (
+	"runtime"
+	"strconv"
+)
+
+func init() {
+	RegisterFacts("system", systemFacts)
+}
+
+// systemFacts returns the number of processors
+func systemFacts() (facts map[string]string, err error) {
+	return map[string]string{"cpus": strconv.Itoa(runtime.NumCPU())}, nil
+}
@@ -9,8 +9,11 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/mozilla/mig"
@@ -21,30 +24,45 @@ import (
 
 type config struct {
 	Agent struct {
-		IsImmortal       bool
-		InstallService   bool
-		DiscoverPublicIP bool
-		DiscoverAWSMeta  bool
-		CheckIn          bool
-		Proxies          string
-		Relay            string
-		Socket           string
-		HeartbeatFreq    string
-		ModuleTimeout    string
-		Api              string
-		RefreshEnv       string
-		NoPersistMods    bool
-		ExtraPrivacyMode bool
-		OnlyVerifyPubKey bool
-		Tags             []string
+		IsImmortal          bool
+		InstallService      bool
+		DiscoverPublicIP    bool
+		DiscoverAWSMeta     bool
+		Facts               string
+		CheckIn             bool
+		Proxies             string
+		Relay               string
+		Socket              string
+		HeartbeatFreq       string
+		ModuleTimeout       string
+		Api                 string
+		RefreshEnv          string
+		NoPersistMods       bool
+		NoSandbox           bool
+		NoContainers        bool
+		ExtraPrivacyMode    bool
+		OnlyVerifyPubKey    bool
+		AcceptLegacyActions bool
+		Tags                []string
 	}
 	Stats struct {
 		MaxActions int
 	}
+	Upgrade struct {
+		RequiredSignatures int
+		Timeout            string
+		LoaderKeyFile      string
+		AllowDowngrade     bool
+	}
+	Control struct {
+		Disabled            bool
+		Socket, Mode, Group string
+	}
 	Certs struct {
 		Ca, Cert, Key string
 	}
 	Logging mig.Logging
+	Module  map[string]*moduleLimitsConfig
 }
 
 // configDefault returns the default agent configuration file path for the
@@ -53,6 +71,30 @@ func configDefault() string {
 	return path.Join(agentcontext.GetConfDir(), "mig-agent.cfg")
 }
 
+// loadedConfig is the path of the configuration file the agent loaded at
+// startup, and reloads on request. It is empty if the agent runs on its
+// built-in configuration.
+var loadedConfig string
+
+// builtinTags keeps the tags of the built-in configuration, so that tags
+// removed from the configuration file are removed on reload
+var builtinTags map[string]string
+
+// liveSettings holds the settings of the running agent. A reload replaces
+// them as a whole while operations and the heartbeat read them, so the
+// settings that can be reloaded must be read with settings() rather than from
+// the global variables.
+var liveSettings atomic.Value
+
+// settings returns the settings the agent is running with. Until a
+// configuration is applied, these are the built-in settings.
+func settings() *globals {
+	if g, ok := liveSettings.Load().(*globals); ok {
+		return g
+	}
+	return newGlobals()
+}
+
 // configLoad reads a local configuration file and overwrite the global conf
 // variable with the parameters from the file
 func configLoad(path string) (err error) {
@@ -65,10 +107,15 @@ func configLoad(path string) (err error) {
 	if err = gcfg.ReadFileInto(&config, path); err != nil {
 		panic(err)
 	}
+	builtinTags = make(map[string]string)
+	for k, v := range TAGS {
+		builtinTags[k] = v
+	}
 	var globals = newGlobals()
 	if err = globals.parseConfig(config); err != nil {
 		panic(err)
 	}
+	loadedConfig = path
 	return
 }
 
@@ -88,6 +135,12 @@ type globals struct {
 	// attempt to discover meta-data for instances running in AWS
 	discoverAWSMeta bool
 
+	// facts collectors to run when gathering the agent environment
+	facts []string
+
+	// list the containers of the endpoint in the agent environment
+	discoverContainers bool
+
 	// in check-in mode, the agent connects to the relay, runs all pending commands
 	// and exits. this mode is used to run the agent as a cron job, not a daemon.
 	checkin bool
@@ -101,6 +154,9 @@ type globals struct {
 	// disabled at run-time using a config option or command line flag
 	spawnPersistent bool
 
+	// run the modules that declare their privileges in a sandbox
+	sandboxModules bool
+
 	// directory to look in for persistent module configuration files
 	persistConfigDir string
 
@@ -138,9 +194,32 @@ type globals struct {
 	// if true, only the investigator's public key is verified on actions and not ACLs.
 	onlyVerifyPubKey bool
 
+	// if true, actions signed with the legacy syntax, that can be replayed, are accepted
+	acceptLegacyActions bool
+
 	// Maximum number of past actions to keep statistics on in the agent, 0 to disable
 	statsMaxActions int
 
+	// resource policies applied to modules, by module name
+	moduleLimits map[string]moduleLimits
+
+	// number of valid signatures an upgrade manifest must have
+	upgradeSignatures int
+
+	// unix socket serving the JSON control API, its permissions and group
+	controlSocket     string
+	controlSocketMode os.FileMode
+	controlGroup      string
+
+	// time an upgraded agent has to confirm the upgrade before it is rolled back
+	upgradeTimeout time.Duration
+
+	// file holding the loader key the agent authenticates with to fetch upgrades
+	loaderKeyFile string
+
+	// if true, upgrades to a binary that is not newer than the running one are accepted
+	allowDowngrade bool
+
 	// Not supported by config
 	// Control modules permissions by PGP keys
 	// AGENTACL [...]string
@@ -169,27 +248,39 @@ type globals struct {
 
 func newGlobals() *globals {
 	return &globals{
-		isImmortal:         ISIMMORTAL,
-		mustInstallService: MUSTINSTALLSERVICE,
-		discoverPulicIP:    DISCOVERPUBLICIP,
-		discoverAWSMeta:    DISCOVERAWSMETA,
-		checkin:            CHECKIN,
-		extraPrivacyMode:   EXTRAPRIVACYMODE,
-		spawnPersistent:    SPAWNPERSISTENT,
-		refreshEnv:         REFRESHENV,
-		loggingConf:        LOGGINGCONF,
-		amqBroker:          AMQPBROKER,
-		apiURL:             APIURL,
-		proxies:            PROXIES,
-		socket:             SOCKET,
-		heartBeatFreq:      HEARTBEATFREQ,
-		moduleTimeout:      MODULETIMEOUT,
-		onlyVerifyPubKey:   ONLYVERIFYPUBKEY,
-		statsMaxActions:    STATSMAXACTIONS,
-		caCert:             CACERT,
-		agentCert:          AGENTCERT,
-		agentKey:           AGENTKEY,
-		tags:               TAGS,
+		isImmortal:          ISIMMORTAL,
+		mustInstallService:  MUSTINSTALLSERVICE,
+		discoverPulicIP:     DISCOVERPUBLICIP,
+		discoverAWSMeta:     DISCOVERAWSMETA,
+		facts:               FACTS,
+		discoverContainers:  DISCOVERCONTAINERS,
+		checkin:             CHECKIN,
+		extraPrivacyMode:    EXTRAPRIVACYMODE,
+		spawnPersistent:     SPAWNPERSISTENT,
+		sandboxModules:      SANDBOXMODULES,
+		refreshEnv:          REFRESHENV,
+		loggingConf:         LOGGINGCONF,
+		amqBroker:           AMQPBROKER,
+		apiURL:              APIURL,
+		proxies:             PROXIES,
+		socket:              SOCKET,
+		heartBeatFreq:       HEARTBEATFREQ,
+		moduleTimeout:       MODULETIMEOUT,
+		onlyVerifyPubKey:    ONLYVERIFYPUBKEY,
+		acceptLegacyActions: ACCEPTLEGACYACTIONS,
+		statsMaxActions:     STATSMAXACTIONS,
+		moduleLimits:        MODULELIMITS,
+		upgradeSignatures:   UPGRADESIGNATURES,
+		upgradeTimeout:      UPGRADETIMEOUT,
+		loaderKeyFile:       LOADERKEYFILE,
+		allowDowngrade:      UPGRADEALLOWDOWNGRADE,
+		controlSocket:       CONTROLSOCKET,
+		controlSocketMode:   CONTROLSOCKETMODE,
+		controlGroup:        CONTROLGROUP,
+		caCert:              CACERT,
+		agentCert:           AGENTCERT,
+		agentKey:            AGENTKEY,
+		tags:                TAGS,
 	}
 }
 
@@ -212,6 +303,18 @@ func stringPair(pair string) (key, value string) {
 // parseConfig converts config settings into usable types for global vars
 // and reports errors when converting settings into go types.
 func (g globals) parseConfig(config config) error {
+	err := g.parse(config)
+	if err != nil {
+		return err
+	}
+
+	// set global vars
+	g.apply()
+	return nil
+}
+
+// parse converts config settings into g without applying them
+func (g *globals) parse(config config) error {
 	var err error
 
 	// In addition to any tags that have already been included in the
@@ -232,11 +335,34 @@ func (g globals) parseConfig(config config) error {
 	g.mustInstallService = config.Agent.InstallService
 	g.discoverPulicIP = config.Agent.DiscoverPublicIP
 	g.discoverAWSMeta = config.Agent.DiscoverAWSMeta
+	switch config.Agent.Facts {
+	case "":
+	case "none":
+		g.facts = []string{}
+	default:
+		g.facts = []string{}
+		for _, name := range strings.Split(config.Agent.Facts, ",") {
+			name = strings.TrimSpace(name)
+			// collectors that do not exist on the platform are skipped
+			// when gathering facts, so a configuration can be shared
+			// between platforms
+			if name == "" {
+				continue
+			}
+			g.facts = append(g.facts, name)
+		}
+	}
 	g.checkin = config.Agent.CheckIn
 	g.extraPrivacyMode = config.Agent.ExtraPrivacyMode
 	if config.Agent.NoPersistMods {
 		g.spawnPersistent = false
 	}
+	if config.Agent.NoSandbox {
+		g.sandboxModules = false
+	}
+	if config.Agent.NoContainers {
+		g.discoverContainers = false
+	}
 	if config.Agent.RefreshEnv != "" {
 		g.refreshEnv, err = time.ParseDuration(config.Agent.RefreshEnv)
 		if err != nil {
@@ -248,6 +374,7 @@ func (g globals) parseConfig(config config) error {
 	g.amqBroker = config.Agent.Relay
 	g.apiURL = config.Agent.Api
 	g.onlyVerifyPubKey = config.Agent.OnlyVerifyPubKey
+	g.acceptLegacyActions = config.Agent.AcceptLegacyActions
 	if config.Agent.Proxies != "" {
 		g.proxies = strings.Split(config.Agent.Proxies, ",")
 	}
@@ -264,6 +391,31 @@ func (g globals) parseConfig(config config) error {
 	if g.statsMaxActions > 30 || g.statsMaxActions < 0 {
 		return fmt.Errorf("config.Stats.MaxActions must be from 0 - 30")
 	}
+	if len(config.Module) > 0 {
+		g.moduleLimits = make(map[string]moduleLimits)
+		for name, modconf := range config.Module {
+			g.moduleLimits[strings.ToLower(name)], err = parseModuleLimits(*modconf)
+			if err != nil {
+				return fmt.Errorf("config.Module.%s %v", name, err)
+			}
+		}
+	}
+	if config.Upgrade.RequiredSignatures != 0 {
+		if config.Upgrade.RequiredSignatures < 1 {
+			return fmt.Errorf("config.Upgrade.RequiredSignatures must be at least 1")
+		}
+		g.upgradeSignatures = config.Upgrade.RequiredSignatures
+	}
+	if config.Upgrade.Timeout != "" {
+		g.upgradeTimeout, err = time.ParseDuration(config.Upgrade.Timeout)
+		if err != nil {
+			return fmt.Errorf("config.Upgrade.Timeout %v", err)
+		}
+	}
+	if config.Upgrade.LoaderKeyFile != "" {
+		g.loaderKeyFile = config.Upgrade.LoaderKeyFile
+	}
+	g.allowDowngrade = config.Upgrade.AllowDowngrade
 	if config.Certs.Ca != "" {
 		cacert, err := ioutil.ReadFile(config.Certs.Ca)
 		if err != nil {
@@ -291,21 +443,36 @@ func (g globals) parseConfig(config config) error {
 			g.agentKey = agentkey
 		}
 	}
-
-	// set global vars
-	g.apply()
+	if config.Control.Disabled {
+		g.controlSocket = ""
+	} else if config.Control.Socket != "" {
+		g.controlSocket = config.Control.Socket
+	}
+	if config.Control.Mode != "" {
+		mode, err := strconv.ParseUint(config.Control.Mode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("config.Control.Mode invalid permissions %q", config.Control.Mode)
+		}
+		g.controlSocketMode = os.FileMode(mode)
+	}
+	g.controlGroup = config.Control.Group
 	return nil
 }
 
-// apply sets global variables with config settings.
+// apply sets global variables with config settings, and publishes them as the
+// settings of the running agent. The global variables are not synchronized, so
+// apply is only called before the agent starts running.
 func (g globals) apply() {
 	ISIMMORTAL = g.isImmortal
 	MUSTINSTALLSERVICE = g.mustInstallService
 	DISCOVERPUBLICIP = g.discoverPulicIP
 	DISCOVERAWSMETA = g.discoverAWSMeta
+	FACTS = g.facts
+	DISCOVERCONTAINERS = g.discoverContainers
 	CHECKIN = g.checkin
 	EXTRAPRIVACYMODE = g.extraPrivacyMode
 	SPAWNPERSISTENT = g.spawnPersistent
+	SANDBOXMODULES = g.sandboxModules
 	REFRESHENV = g.refreshEnv
 	LOGGINGCONF = g.loggingConf
 	AMQPBROKER = g.amqBroker
@@ -315,9 +482,19 @@ func (g globals) apply() {
 	HEARTBEATFREQ = g.heartBeatFreq
 	MODULETIMEOUT = g.moduleTimeout
 	ONLYVERIFYPUBKEY = g.onlyVerifyPubKey
+	ACCEPTLEGACYACTIONS = g.acceptLegacyActions
 	STATSMAXACTIONS = g.statsMaxActions
+	MODULELIMITS = g.moduleLimits
+	UPGRADESIGNATURES = g.upgradeSignatures
+	UPGRADETIMEOUT = g.upgradeTimeout
+	LOADERKEYFILE = g.loaderKeyFile
+	UPGRADEALLOWDOWNGRADE = g.allowDowngrade
+	CONTROLSOCKET = g.controlSocket
+	CONTROLSOCKETMODE = g.controlSocketMode
+	CONTROLGROUP = g.controlGroup
 	CACERT = g.caCert
 	AGENTCERT = g.agentCert
 	AGENTKEY = g.agentKey
 	TAGS = g.tags
+	liveSettings.Store(&g)
 }
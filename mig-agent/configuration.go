@@ -8,6 +8,9 @@ package main
 
 import (
 	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"os"
+	"path"
 	"time"
 )
 
@@ -35,6 +38,16 @@ var DISCOVERPUBLICIP = true
 // service and include instance details in it's environment.
 var DISCOVERAWSMETA = true
 
+// FACTS lists the facts collectors the agent runs to gather facts about the
+// endpoint, such as the kernel version or the cloud instance it runs in. The
+// gce and azure collectors query the metadata services of these providers.
+var FACTS = []string{"kernel", "system", "container", "machineid"}
+
+// DISCOVERCONTAINERS if true causes the agent to list the containers running on
+// the endpoint in its environment, so they can be targeted. Containers are only
+// discovered on Linux.
+var DISCOVERCONTAINERS = true
+
 // CHECKIN if true sets the agent in check-in mode. In check-in mode, the agent will
 // start up, attempt to locate any outstanding actions/commands, execute them and
 // exit once all actions are responded to.
@@ -48,6 +61,11 @@ var EXTRAPRIVACYMODE = false
 // it has been built with.
 var SPAWNPERSISTENT = true
 
+// SANDBOXMODULES if true causes the agent to run modules that declare the privileges
+// they need in a sandbox restricted to these privileges. Sandboxing is only supported
+// on Linux, when the agent runs as root.
+var SANDBOXMODULES = true
+
 // REFRESHENV controls how often the agent will refresh it's environment. If zero
 // the agent will only do this once on startup.
 var REFRESHENV = time.Minute * 5
@@ -82,11 +100,51 @@ var HEARTBEATFREQ = 300 * time.Second
 // always execute.
 var MODULETIMEOUT = 300 * time.Second
 
+// MODULELIMITS holds the resource policies applied to modules by the agent, indexed
+// by module name. The "default" policy applies to modules without a policy of their
+// own. Policies are typically set in [module "<name>"] sections of the agent
+// configuration file.
+var MODULELIMITS = map[string]moduleLimits{}
+
+// UPGRADESIGNATURES is the number of valid signatures from MANIFESTPGPKEYS a manifest
+// must have for the agent to upgrade itself to the binary it contains.
+var UPGRADESIGNATURES = 1
+
+// UPGRADETIMEOUT is the time an upgraded agent has to send a heartbeat after it first
+// starts. If it does not, the previous binary is restored.
+var UPGRADETIMEOUT = 10 * time.Minute
+
+// UPGRADEALLOWDOWNGRADE allows the agent to upgrade itself to a binary that is not
+// newer than the running one. Such upgrades are refused by default.
+var UPGRADEALLOWDOWNGRADE = false
+
+// LOADERKEYFILE is the file holding the key of the loader entry the agent was deployed
+// with. The agent authenticates with it to fetch upgrade manifests from the API, and
+// only gets manifests that target that loader entry.
+var LOADERKEYFILE = path.Join(agentcontext.GetConfDir(), "mig-loader.key")
+
+// CONTROLSOCKET is the unix socket the agent serves its JSON control API on, for local
+// tooling. The control API is disabled if empty, and is not supported on windows.
+var CONTROLSOCKET = path.Join(agentcontext.GetRunDir(), "mig-agent.sock")
+
+// CONTROLSOCKETMODE are the file permissions of CONTROLSOCKET. Anyone with write access
+// to the socket can use the control API.
+var CONTROLSOCKETMODE os.FileMode = 0600
+
+// CONTROLGROUP if set is the group given ownership of CONTROLSOCKET, to let its members
+// use the control API when combined with CONTROLSOCKETMODE.
+var CONTROLGROUP = ""
+
 // ONLYVERIFYPUBKEY if true will cause the agent to ignore ACLs (e.g., weight comparisons
 // for verification) and the agent will execute the module if a signature matches any
 // key in the agents keyring.
 var ONLYVERIFYPUBKEY = false
 
+// ACCEPTLEGACYACTIONS if true will cause the agent to run actions signed with the
+// version 2 syntax. Their signature does not cover a nonce, and they can be replayed
+// to the agent. Leave it off once all investigators sign version 3 actions.
+var ACCEPTLEGACYACTIONS = false
+
 // STATSMAXACTIONS controls the number of actions the agent will store and display
 // over it's status socket if queried. This can be used to view history of actions an
 // agent has received.
@@ -107,6 +165,10 @@ var AGENTACL = `{
 // being sent to the agent.
 var PUBLICPGPKEYS = []string{}
 
+// MANIFESTPGPKEYS is a slice of keys used to verify the signatures on the manifests the
+// agent upgrades itself from. Upgrades are disabled if no key is present.
+var MANIFESTPGPKEYS = []string{}
+
 // CACERT is a byte slice containing the CA certificate used to validate the connection
 // to the RabbitMQ relay.
 var CACERT = []byte("")
@@ -0,0 +1,46 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/modules"
+)
+
+// containerPid returns the pid of the init process of the container selected
+// by an operation, after checking that its module can run in containers. The
+// files of a container are controlled by whoever built its image, so modules
+// only enter containers when they run sandboxed. The containers are listed
+// again, as they may have changed since the last refresh of the environment.
+func containerPid(mode, selector string, sandboxed bool) (pid int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("containerPid() -> %v", e)
+		}
+	}()
+	mod, ok := modules.Available[mode]
+	if !ok {
+		panic(fmt.Sprintf("module %q is not available", mode))
+	}
+	run, ok := mod.NewRun().(modules.HasContainerSupport)
+	if !ok || !run.ContainerSupport() {
+		panic(fmt.Sprintf("module %q does not support containers", mode))
+	}
+	if !sandboxed {
+		panic(fmt.Sprintf("module %q must run in a sandbox to enter a container", mode))
+	}
+	containers, err := agentcontext.FindContainers()
+	if err != nil {
+		panic(err)
+	}
+	c, err := agentcontext.SelectContainer(containers, selector)
+	if err != nil {
+		panic(err)
+	}
+	return c.Pid, nil
+}
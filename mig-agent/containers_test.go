@@ -0,0 +1,55 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+func TestContainerPid(t *testing.T) {
+	var tests = []struct {
+		mode      string
+		sandboxed bool
+		expect    string
+	}{
+		{"file", false, "must run in a sandbox"},
+		{"pkg", true, "does not support containers"},
+		{"ping", true, "does not support containers"},
+		{"nosuchmodule", true, "is not available"},
+	}
+	for _, tc := range tests {
+		_, err := containerPid(tc.mode, "web", tc.sandboxed)
+		if err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.mode, tc.expect, err)
+		}
+	}
+}
+
+// TestRunModuleContainerWithoutSandbox verifies that operations on containers
+// are refused when sandboxing is disabled in the agent configuration
+func TestRunModuleContainerWithoutSandbox(t *testing.T) {
+	orig := settings()
+	defer liveSettings.Store(orig)
+	g := *orig
+	g.sandboxModules = false
+	liveSettings.Store(&g)
+
+	ctx := testControlContext()
+	op := moduleOp{id: 1, mode: "file", container: "web", position: 0,
+		resultChan: make(chan moduleResult, 1), expireafter: time.Now().Add(time.Minute)}
+	err := runModule(ctx, op)
+	if err == nil || !strings.Contains(err.Error(), "must run in a sandbox") {
+		t.Fatalf("expected the container operation to be refused, got %v", err)
+	}
+	res := <-op.resultChan
+	if res.status != mig.StatusFailed {
+		t.Errorf("expected the operation to fail, got status %q", res.status)
+	}
+}
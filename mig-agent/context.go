@@ -80,13 +80,14 @@ type Context struct {
 			Chan       <-chan amqp.Delivery
 		}
 	}
-	OpID    float64       // ID of the current operation, used for tracking
-	Sleeper time.Duration // timer used when the agent has to sleep for a while
-	Socket  struct {
+	OpID   float64 // ID of the current operation, used for tracking
+	Socket struct {
 		Bind string
 	}
 	Logging mig.Logging
 	Stats   agentStats
+	Journal *commandJournal // on-disk journal of commands and unsent results
+	Nonces  *nonceStore     // nonces of the actions already received
 }
 
 // Update volatile/dynamic fields in c.Agent using information stored in
@@ -101,10 +102,13 @@ func (c *Context) updateVolatileFromAgentContext(actx agentcontext.AgentContext)
 	c.Agent.Env.Init = actx.Init
 	c.Agent.Env.Addresses = actx.Addresses
 	c.Agent.Env.PublicIP = actx.PublicIP
+	c.Agent.Env.PublicIPs = actx.PublicIPs
 	c.Agent.Env.AWS.InstanceID = actx.AWS.InstanceID
 	c.Agent.Env.AWS.LocalIPV4 = actx.AWS.LocalIPV4
 	c.Agent.Env.AWS.AMIID = actx.AWS.AMIID
 	c.Agent.Env.AWS.InstanceType = actx.AWS.InstanceType
+	c.Agent.Env.Facts = actx.Facts
+	c.Agent.Env.Containers = actx.Containers
 	if c.Agent.lastAgentContext.IsZero() {
 		c.Agent.lastAgentContext = actx
 		c.Agent.RefreshTS = ts
@@ -166,10 +170,12 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 	// Gather new agent context information to use as the context for this
 	// agent invocation
 	hints := agentcontext.AgentContextHints{
-		DiscoverPublicIP: DISCOVERPUBLICIP,
-		DiscoverAWSMeta:  DISCOVERAWSMETA,
-		APIUrl:           APIURL,
-		Proxies:          PROXIES[:],
+		DiscoverPublicIP:   DISCOVERPUBLICIP,
+		DiscoverAWSMeta:    DISCOVERAWSMETA,
+		APIUrl:             APIURL,
+		Proxies:            PROXIES[:],
+		Facts:              FACTS,
+		DiscoverContainers: DISCOVERCONTAINERS,
 	}
 	actx, err := agentcontext.NewAgentContext(ctx.Channels.Log, hints)
 	if err != nil {
@@ -198,6 +204,12 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 	ctx.Agent.RunDir = actx.RunDir
 	ctx.Agent.BinPath = actx.BinPath
 
+	// an upgraded binary must confirm the upgrade in time, or be rolled back
+	err = checkUpgrade(ctx)
+	if err != nil {
+		panic(err)
+	}
+
 	// get the agent ID
 	ctx.Agent.UID = actx.UID
 
@@ -214,7 +226,23 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 		}
 	}
 
-	ctx.Sleeper = HEARTBEATFREQ
+	if err != nil {
+		panic(err)
+	}
+
+	// open the command journal, which is replayed once the agent routines
+	// have started. the agent can run without it, but will then lose the
+	// commands it is running if it restarts.
+	ctx.Journal, err = newJournal(path.Join(ctx.Agent.RunDir, "journal"))
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("command journal is disabled: %v", err)}.Err()
+		ctx.Journal = nil
+		err = nil
+	}
+
+	// open the store of action nonces. without it, the agent could not
+	// refuse replayed actions.
+	ctx.Nonces, err = newNonceStore(path.Join(ctx.Agent.RunDir, "nonces"))
 	if err != nil {
 		panic(err)
 	}
@@ -225,12 +253,24 @@ func Init(foreground, upgrade bool) (ctx Context, err error) {
 		panic(err)
 	}
 
+	// load the keys that sign upgrade manifests
+	ctx, err = initManifestKeyring(ctx)
+	if err != nil {
+		panic(err)
+	}
+
 	// parse the ACLs
 	ctx, err = initACL(ctx)
 	if err != nil {
 		panic(err)
 	}
 
+	// replace the ACL and keyring with a signed bundle if one is present
+	ctx, err = initAuthBundle(ctx)
+	if err != nil {
+		panic(err)
+	}
+
 	connected := false
 	// connect to the message broker
 	//
@@ -367,6 +407,103 @@ func initKeyring(orig_ctx Context) (ctx Context, err error) {
 	return
 }
 
+// initAuthBundle loads the signed bundle of ACL and keys rendered by the API from the
+// agent configuration directory if present. The bundle is only applied if it is signed
+// by investigators the ACL and keyring loaded so far authorize to do so, under the
+// authbundle ACL entry, or the default entry if there is none. A bundle that fails
+// verification is ignored, and the agent continues with its existing configuration.
+// The state of the bundle applied is kept in the run directory, and bundles older
+// than it are refused, so a bundle cannot be rolled back to a previous version.
+func initAuthBundle(orig_ctx Context) (ctx Context, err error) {
+	ctx = orig_ctx
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("initAuthBundle() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{Desc: "leaving initAuthBundle()"}.Debug()
+	}()
+
+	bpath := path.Join(agentcontext.GetConfDir(), "authbundle.json")
+	buf, err := ioutil.ReadFile(bpath)
+	if err != nil && os.IsNotExist(err) {
+		return ctx, nil
+	} else if err != nil {
+		panic(err)
+	}
+	var b mig.AuthBundle
+	err = json.Unmarshal(buf, &b)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring invalid bundle %v: %v", bpath, err)}.Warning()
+		return ctx, nil
+	}
+	err = b.Validate()
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring invalid bundle %v: %v", bpath, err)}.Warning()
+		return ctx, nil
+	}
+	var keys [][]byte
+	for _, pk := range PUBLICPGPKEYS {
+		keys = append(keys, []byte(pk))
+	}
+	keyring, _, err := pgp.ArmoredKeysToKeyring(keys)
+	if err != nil {
+		panic(err)
+	}
+	spath := path.Join(ctx.Agent.RunDir, authBundleStateFile)
+	last, err := readAuthBundleState(spath)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring bundle %v: %v", bpath, err)}.Warning()
+		return ctx, nil
+	}
+	err = b.Verify(ctx.ACL, keyring, last)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring bundle %v: %v", bpath, err)}.Warning()
+		return ctx, nil
+	}
+	st, err := b.State()
+	if err != nil {
+		panic(err)
+	}
+	if st.Digest != last.Digest {
+		buf, err = json.Marshal(st)
+		if err != nil {
+			panic(err)
+		}
+		// a bundle that cannot be recorded could be rolled back later
+		err = writeFileAtomic(spath, buf)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring bundle %v: %v", bpath, err)}.Warning()
+			return ctx, nil
+		}
+	}
+	ctx.ACL = b.ACL
+	PUBLICPGPKEYS = b.PublicKeys
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("loaded acl and %d keys from bundle %v generated at %s",
+		len(b.PublicKeys), bpath, b.GeneratedAt.Format(time.RFC3339))}.Info()
+	return
+}
+
+// authBundleStateFile is the file of the run directory that records the state
+// of the last authorization bundle applied
+const authBundleStateFile = "authbundle.state"
+
+// readAuthBundleState returns the state of the last authorization bundle
+// applied, or an empty state if no bundle was applied yet
+func readAuthBundleState(spath string) (st mig.AuthBundleState, err error) {
+	buf, err := ioutil.ReadFile(spath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(buf, &st)
+	if err != nil {
+		err = fmt.Errorf("invalid bundle state %v: %v", spath, err)
+	}
+	return
+}
+
 func initMQ(orig_ctx Context, try_proxy bool, proxy string) (ctx Context, err error) {
 	ctx = orig_ctx
 	defer func() {
@@ -392,7 +529,7 @@ func initMQ(orig_ctx Context, try_proxy bool, proxy string) (ctx Context, err er
 
 	// create an AMQP configuration with specific timers
 	var dialConfig amqp.Config
-	dialConfig.Heartbeat = 2 * ctx.Sleeper
+	dialConfig.Heartbeat = 2 * settings().heartBeatFreq
 	if try_proxy {
 		// if in try_proxy mode, the agent will try to connect to the relay using a CONNECT proxy
 		// but because CONNECT is a HTTP method, not available in AMQP, we need to establish
@@ -580,23 +717,34 @@ func serviceDeploy(orig_ctx Context) (ctx Context, err error) {
 
 func refreshAgentEnvironment(ctx *Context) {
 	for {
-		time.Sleep(REFRESHENV)
-		ctx.Channels.Log <- mig.Log{Desc: "refreshing agent environment"}.Info()
-		ctx.Agent.Lock()
-		hints := agentcontext.AgentContextHints{
-			DiscoverPublicIP: DISCOVERPUBLICIP,
-			DiscoverAWSMeta:  DISCOVERAWSMETA,
-			APIUrl:           APIURL,
-			Proxies:          PROXIES[:],
-		}
-		actx, err := agentcontext.NewAgentContext(ctx.Channels.Log, hints)
-		if err != nil {
-			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("error obtaining new agent context: %v", err)}.Err()
-		} else {
-			if ctx.updateVolatileFromAgentContext(actx) {
-				ctx.Channels.Log <- mig.Log{Desc: "agent environment has changed"}.Info()
-			}
-		}
-		ctx.Agent.Unlock()
+		time.Sleep(settings().refreshEnv)
+		refreshEnvironment(ctx)
+	}
+}
+
+// refreshEnvironment gathers a new agent context and updates the agent
+// environment with it. It returns true if the environment has changed.
+func refreshEnvironment(ctx *Context) (changed bool, err error) {
+	ctx.Channels.Log <- mig.Log{Desc: "refreshing agent environment"}.Info()
+	ctx.Agent.Lock()
+	defer ctx.Agent.Unlock()
+	s := settings()
+	hints := agentcontext.AgentContextHints{
+		DiscoverPublicIP:   s.discoverPulicIP,
+		DiscoverAWSMeta:    s.discoverAWSMeta,
+		APIUrl:             s.apiURL,
+		Proxies:            s.proxies[:],
+		Facts:              s.facts,
+		DiscoverContainers: s.discoverContainers,
 	}
+	actx, err := agentcontext.NewAgentContext(ctx.Channels.Log, hints)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("error obtaining new agent context: %v", err)}.Err()
+		return
+	}
+	changed = ctx.updateVolatileFromAgentContext(actx)
+	if changed {
+		ctx.Channels.Log <- mig.Log{Desc: "agent environment has changed"}.Info()
+	}
+	return
 }
@@ -8,10 +8,16 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/pgp"
 )
 
 func TestInitKeyring(t *testing.T) {
@@ -104,3 +110,109 @@ func TestInitNoACLOrKeyring(t *testing.T) {
 		t.Errorf("original PUBLICPGPKEYS value not intact")
 	}
 }
+
+func TestInitAuthBundle(t *testing.T) {
+	ctx := testContext
+
+	pub, priv, fp, err := pgp.GenerateKeyPair("admin", "", "admin@example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	inv := mig.Investigator{ID: 1, Name: "admin", Status: mig.StatusActiveInvestigator, PGPFingerprint: fp,
+		Keys: []mig.InvestigatorKey{{PGPFingerprint: fp, PublicKey: pub,
+			Status: mig.StatusActiveKey, ValidFrom: now.Add(-time.Hour)}}}
+	b, err := mig.NewAuthBundle([]mig.ACLPolicy{
+		{Module: "default", MinimumWeight: 1, Weights: []mig.ACLPolicyWeight{{InvestigatorID: 1, Weight: 1}}},
+	}, []mig.Investigator{inv}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secring, _, err := pgp.ArmoredKeysToKeyring([][]byte{priv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Sign(fp, secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "migauthbundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buf, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, "authbundle.json"), buf, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentcontext.EnableTestHooks(dir)
+	ctx.Agent.RunDir = dir
+
+	// the bundle is ignored if its signer is not trusted by the agent
+	PUBLICPGPKEYS = []string{}
+	ctx.ACL = mig.ACL{}
+	ctx, err = initAuthBundle(ctx)
+	if err != nil {
+		t.Fatalf("initAuthBundle: %v", err)
+	}
+	if len(ctx.ACL) != 0 || len(PUBLICPGPKEYS) != 0 {
+		t.Fatalf("untrusted bundle should have been ignored")
+	}
+
+	// the bundle is applied if its signer is trusted
+	PUBLICPGPKEYS = []string{string(pub)}
+	ctx.ACL = mig.ACL{"default": b.ACL["default"]}
+	ctx, err = initAuthBundle(ctx)
+	if err != nil {
+		t.Fatalf("initAuthBundle: %v", err)
+	}
+	if _, ok := ctx.ACL["default"].Investigators["admin (1)"]; !ok || len(PUBLICPGPKEYS) != 1 {
+		t.Fatalf("trusted bundle should have been applied")
+	}
+
+	// the same bundle is applied again when the agent restarts
+	PUBLICPGPKEYS = []string{string(pub)}
+	ctx.ACL = mig.ACL{"default": b.ACL["default"]}
+	ctx, err = initAuthBundle(ctx)
+	if err != nil {
+		t.Fatalf("initAuthBundle: %v", err)
+	}
+	if _, ok := ctx.ACL["default"].Investigators["admin (1)"]; !ok {
+		t.Fatalf("bundle already applied should have been applied again")
+	}
+
+	// a bundle older than the one applied is refused
+	old, err := mig.NewAuthBundle([]mig.ACLPolicy{
+		{Module: "default", MinimumWeight: 1, Weights: []mig.ACLPolicyWeight{{InvestigatorID: 1, Weight: 1}}},
+		{Module: "file", MinimumWeight: 1, Weights: []mig.ACLPolicyWeight{{InvestigatorID: 1, Weight: 1}}},
+	}, []mig.Investigator{inv}, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secring.Seek(0, 0)
+	err = old.Sign(fp, secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, "authbundle.json"), buf, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	PUBLICPGPKEYS = []string{string(pub)}
+	ctx.ACL = mig.ACL{"default": b.ACL["default"]}
+	ctx, err = initAuthBundle(ctx)
+	if err != nil {
+		t.Fatalf("initAuthBundle: %v", err)
+	}
+	if _, ok := ctx.ACL["file"]; ok {
+		t.Fatalf("older bundle should have been refused")
+	}
+}
@@ -0,0 +1,323 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// The control API is a JSON API served on a local unix socket. It lets the
+// administrator of the endpoint inspect the running agent, refresh its
+// environment, reload its configuration, and run signed actions locally.
+// Access to the API is controlled by the permissions of the socket.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/modules"
+)
+
+// controlQueryMaxSize is the maximum size of an action submitted to the
+// query endpoint
+const controlQueryMaxSize = 1 << 20
+
+// controlOp is the representation of a running operation in the control API
+type controlOp struct {
+	ID          float64   `json:"id"`
+	CommandID   float64   `json:"commandid"`
+	Module      string    `json:"module"`
+	Position    int       `json:"position"`
+	ExpireAfter time.Time `json:"expireafter"`
+	Container   string    `json:"container,omitempty"`
+}
+
+// initControl starts the control API on the control socket, if enabled
+func initControl(ctx *Context) {
+	if CONTROLSOCKET == "" || runtime.GOOS == "windows" {
+		return
+	}
+	l, err := listenControl(CONTROLSOCKET, CONTROLSOCKETMODE, CONTROLGROUP)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("failed to start control API: %v", err)}.Err()
+		return
+	}
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("control API listening on %s", CONTROLSOCKET)}
+	err = http.Serve(l, controlHandler(ctx))
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("error from control API: %v", err)}.Err()
+	}
+}
+
+// listenControl creates the control socket with the given permissions, and
+// gives ownership of it to group if set
+func listenControl(sock string, mode os.FileMode, group string) (l net.Listener, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("listenControl() -> %v", e)
+			if l != nil {
+				l.Close()
+			}
+		}
+	}()
+	// remove the socket left by a previous instance of the agent
+	fi, err := os.Lstat(sock)
+	if err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			panic(fmt.Sprintf("%s exists and is not a socket", sock))
+		}
+		err = os.Remove(sock)
+		if err != nil {
+			panic(err)
+		}
+	}
+	l, err = net.Listen("unix", sock)
+	if err != nil {
+		panic(err)
+	}
+	err = os.Chmod(sock, mode)
+	if err != nil {
+		panic(err)
+	}
+	if group != "" {
+		grp, err := user.LookupGroup(group)
+		if err != nil {
+			panic(err)
+		}
+		gid, err := strconv.Atoi(grp.Gid)
+		if err != nil {
+			panic(err)
+		}
+		err = os.Chown(sock, -1, gid)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return l, nil
+}
+
+// controlHandler returns the handler of the control API endpoints
+func controlHandler(ctx *Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", controlMethod("GET", func(w http.ResponseWriter, req *http.Request) {
+		ctx.Agent.Lock()
+		defer ctx.Agent.Unlock()
+		controlRespond(w, http.StatusOK, struct {
+			Pid      int               `json:"pid"`
+			Version  string            `json:"version"`
+			Mode     string            `json:"mode"`
+			Hostname string            `json:"hostname"`
+			Env      mig.AgentEnv      `json:"environment"`
+			Tags     map[string]string `json:"tags"`
+		}{os.Getpid(), mig.Version, ctx.Agent.Mode, ctx.Agent.Hostname, ctx.Agent.Env, ctx.Agent.Tags})
+	}))
+	mux.HandleFunc("/ops", controlMethod("GET", func(w http.ResponseWriter, req *http.Request) {
+		ops := make([]controlOp, 0)
+		for _, op := range listRunningOps() {
+			ops = append(ops, controlOp{
+				ID:          op.id,
+				CommandID:   op.commandID,
+				Module:      op.mode,
+				Position:    op.position,
+				ExpireAfter: op.expireafter,
+				Container:   op.container,
+			})
+		}
+		controlRespond(w, http.StatusOK, ops)
+	}))
+	mux.HandleFunc("/persist", controlMethod("GET", func(w http.ResponseWriter, req *http.Request) {
+		controlRespond(w, http.StatusOK, persistModRegister.status())
+	}))
+	mux.HandleFunc("/actions", controlMethod("GET", func(w http.ResponseWriter, req *http.Request) {
+		ctx.Stats.Lock()
+		actions := append([]agentStatsAction{}, ctx.Stats.Actions...)
+		ctx.Stats.Unlock()
+		controlRespond(w, http.StatusOK, actions)
+	}))
+	mux.HandleFunc("/refresh", controlMethod("POST", func(w http.ResponseWriter, req *http.Request) {
+		changed, err := refreshEnvironment(ctx)
+		if err != nil {
+			controlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		controlRespond(w, http.StatusOK, map[string]bool{"changed": changed})
+	}))
+	mux.HandleFunc("/reload", controlMethod("POST", func(w http.ResponseWriter, req *http.Request) {
+		res, err := reloadConfig(ctx)
+		if err != nil {
+			controlError(w, http.StatusInternalServerError, err)
+			return
+		}
+		controlRespond(w, http.StatusOK, res)
+	}))
+	mux.HandleFunc("/query", controlMethod("POST", func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, controlQueryMaxSize))
+		if err != nil {
+			controlError(w, http.StatusBadRequest, err)
+			return
+		}
+		var a mig.Action
+		err = json.Unmarshal(body, &a)
+		if err != nil {
+			controlError(w, http.StatusBadRequest, err)
+			return
+		}
+		cmd, err := runLocalAction(ctx, a)
+		if err != nil {
+			controlError(w, http.StatusForbidden, err)
+			return
+		}
+		controlRespond(w, http.StatusOK, struct {
+			Status  string           `json:"status"`
+			Results []modules.Result `json:"results"`
+		}{cmd.Status, cmd.Results})
+	}))
+	return mux
+}
+
+// controlMethod restricts a control endpoint to a single HTTP method
+func controlMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			controlError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+			return
+		}
+		h(w, req)
+	}
+}
+
+func controlRespond(w http.ResponseWriter, code int, data interface{}) {
+	buf, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		code = http.StatusInternalServerError
+		buf = []byte(fmt.Sprintf(`{"error": %q}`, err.Error()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(buf)
+	w.Write([]byte("\n"))
+}
+
+func controlError(w http.ResponseWriter, code int, err error) {
+	controlRespond(w, code, map[string]string{"error": err.Error()})
+}
+
+// runLocalAction verifies a signed action submitted to the control API and
+// runs its operations, as it would for an action received from the relay.
+// The results are returned to the caller and not sent to the scheduler.
+func runLocalAction(ctx *Context, a mig.Action) (cmd mig.Command, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("runLocalAction() -> %v", e)
+		}
+	}()
+	err = a.Validate()
+	if err != nil {
+		panic(err)
+	}
+	err = checkActionAuthorization(a, ctx)
+	if err != nil {
+		ctx.Stats.importAction(a, false)
+		panic(err)
+	}
+	err = ctx.Nonces.record(a, 0, false)
+	if err != nil {
+		ctx.Stats.importAction(a, false)
+		panic(err)
+	}
+	for _, operation := range a.Operations {
+		if _, ok := modules.Available[operation.Module]; !ok {
+			ctx.Stats.importAction(a, false)
+			panic(fmt.Sprintf("module '%s' is not available", operation.Module))
+		}
+	}
+	ctx.Stats.importAction(a, true)
+	ctx.Channels.Log <- mig.Log{ActionID: a.ID, Desc: fmt.Sprintf("running action %q from control API", a.Name)}
+
+	cmd.Action = a
+	cmd.Status = mig.StatusSuccess
+	cmd.Results = make([]modules.Result, len(a.Operations))
+	resultChan := make(chan moduleResult, len(a.Operations))
+	for counter, operation := range a.Operations {
+		op := moduleOp{
+			id:           mig.GenID(),
+			mode:         operation.Module,
+			isCompressed: operation.IsCompressed,
+			params:       operation.Parameters,
+			resultChan:   resultChan,
+			position:     counter,
+			expireafter:  a.ExpireAfter,
+			container:    operation.Container,
+		}
+		addRunningOp(op)
+		ctx.Channels.RunAgentCommand <- op
+	}
+	for range a.Operations {
+		result := <-resultChan
+		if cmd.Status == mig.StatusSuccess && result.status != mig.StatusSuccess {
+			cmd.Status = result.status
+		}
+		cmd.Results[result.position] = result.output
+		if result.err != nil {
+			cmd.Results[result.position].Errors = append(cmd.Results[result.position].Errors, result.err.Error())
+		}
+	}
+	return
+}
+
+// controlQuery sends a query to the control API of a running agent and
+// returns the response
+func controlQuery(sock, query string) (resp string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("controlQuery() -> %v", e)
+		}
+	}()
+	var method string
+	switch query {
+	case "status", "ops", "persist", "actions":
+		method = "GET"
+	case "refresh", "reload":
+		method = "POST"
+	default:
+		panic(fmt.Sprintf("unknown command %q", query))
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", sock)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://localhost/"+query, nil)
+	if err != nil {
+		panic(err)
+	}
+	httpresp, err := client.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer httpresp.Body.Close()
+	buf, err := ioutil.ReadAll(httpresp.Body)
+	if err != nil {
+		panic(err)
+	}
+	if httpresp.StatusCode != http.StatusOK {
+		var cerr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(buf, &cerr)
+		panic(cerr.Error)
+	}
+	return string(bytes.TrimSpace(buf)), nil
+}
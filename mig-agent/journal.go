@@ -0,0 +1,300 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// The command journal keeps a copy on disk of the commands the agent has
+// accepted but not yet answered, and of the results it has not yet managed
+// to publish to the relay. When the agent restarts, the journal is replayed:
+// unanswered commands are executed again, and unsent results are published.
+//
+// Each command is journaled under its ID. A received command is stored in
+// <id>.cmd, and replaced by <id>.res once its results are ready. Both files
+// are removed once the results have been published, and the ID is added to
+// the list of completed commands, which is used to drop duplicate deliveries
+// of a command the agent has already answered.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+const (
+	journalCommandExt   = ".cmd"
+	journalResultsExt   = ".res"
+	journalCompleted    = "completed"
+	journalMaxCompleted = 128
+)
+
+type commandJournal struct {
+	dir       string
+	inflight  map[float64]bool
+	completed []float64
+	sync.Mutex
+}
+
+// journalEntry is a command or a set of results found in the journal
+type journalEntry struct {
+	id      float64
+	results bool
+	data    []byte
+}
+
+// newJournal opens the journal stored in dir, creating the directory if needed
+func newJournal(dir string) (j *commandJournal, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("newJournal() -> %v", e)
+		}
+	}()
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		panic(err)
+	}
+	// the journal contains results of investigations, keep it private
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		panic(err)
+	}
+	j = &commandJournal{dir: dir, inflight: make(map[float64]bool)}
+	data, err := ioutil.ReadFile(filepath.Join(dir, journalCompleted))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	err = nil
+	for _, field := range strings.Fields(string(data)) {
+		id, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid command ID %q in completed list", field))
+		}
+		j.completed = append(j.completed, id)
+	}
+	return
+}
+
+func (j *commandJournal) path(id float64, ext string) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%.0f%s", id, ext))
+}
+
+// recordCommand stores a received command in the journal. It returns true
+// if the command is a duplicate of one that is already running, has results
+// waiting to be sent, or has already been answered, in which case the
+// command must not be executed again.
+func (j *commandJournal) recordCommand(id float64, msg []byte) (dup bool, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("recordCommand() -> %v", e)
+		}
+	}()
+	j.Lock()
+	defer j.Unlock()
+	if j.inflight[id] || j.isCompleted(id) {
+		return true, nil
+	}
+	_, err = os.Stat(j.path(id, journalResultsExt))
+	if err == nil {
+		return true, nil
+	}
+	j.inflight[id] = true
+	err = writeFileAtomic(j.path(id, journalCommandExt), msg)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// recordResults stores the results of a command in the journal, replacing
+// the command itself
+func (j *commandJournal) recordResults(id float64, body []byte) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("recordResults() -> %v", e)
+		}
+	}()
+	j.Lock()
+	defer j.Unlock()
+	err = writeFileAtomic(j.path(id, journalResultsExt), body)
+	if err != nil {
+		panic(err)
+	}
+	err = os.Remove(j.path(id, journalCommandExt))
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	return nil
+}
+
+// complete removes a command from the journal once its results have been
+// published, and remembers its ID to drop later duplicates
+func (j *commandJournal) complete(id float64) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("complete() -> %v", e)
+		}
+	}()
+	j.Lock()
+	defer j.Unlock()
+	delete(j.inflight, id)
+	for _, ext := range []string{journalCommandExt, journalResultsExt} {
+		err = os.Remove(j.path(id, ext))
+		if err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
+	if j.isCompleted(id) {
+		return nil
+	}
+	j.completed = append(j.completed, id)
+	if len(j.completed) > journalMaxCompleted {
+		j.completed = j.completed[len(j.completed)-journalMaxCompleted:]
+	}
+	var ids []string
+	for _, c := range j.completed {
+		ids = append(ids, fmt.Sprintf("%.0f", c))
+	}
+	err = writeFileAtomic(filepath.Join(j.dir, journalCompleted), []byte(strings.Join(ids, "\n")+"\n"))
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// pending returns the commands and results left in the journal by a previous
+// run of the agent, ordered by command ID. A command for which results were
+// also found is discarded, as it has already been executed.
+func (j *commandJournal) pending() (entries []journalEntry, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("pending() -> %v", e)
+		}
+	}()
+	j.Lock()
+	defer j.Unlock()
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		panic(err)
+	}
+	results := make(map[float64]bool)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), journalResultsExt) {
+			id, err := strconv.ParseFloat(strings.TrimSuffix(f.Name(), journalResultsExt), 64)
+			if err == nil {
+				results[id] = true
+			}
+		}
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if ext != journalCommandExt && ext != journalResultsExt {
+			continue
+		}
+		id, err := strconv.ParseFloat(strings.TrimSuffix(f.Name(), ext), 64)
+		if err != nil {
+			continue
+		}
+		if ext == journalCommandExt && results[id] {
+			os.Remove(j.path(id, journalCommandExt))
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(j.dir, f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		entries = append(entries, journalEntry{id: id, results: ext == journalResultsExt, data: data})
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].id < entries[b].id })
+	return
+}
+
+// knows returns true if the command is running, has been answered recently,
+// or was left in the journal by a previous run of the agent
+func (j *commandJournal) knows(id float64) bool {
+	j.Lock()
+	defer j.Unlock()
+	if j.inflight[id] || j.isCompleted(id) {
+		return true
+	}
+	for _, ext := range []string{journalCommandExt, journalResultsExt} {
+		if _, err := os.Stat(j.path(id, ext)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *commandJournal) isCompleted(id float64) bool {
+	for _, c := range j.completed {
+		if c == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// the target, then renames it, so that a crash never leaves a partial file
+func writeFileAtomic(target string, data []byte) (err error) {
+	fd, err := ioutil.TempFile(filepath.Dir(target), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = fd.Write(data)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+		return
+	}
+	return os.Rename(fd.Name(), target)
+}
+
+// replayJournal resubmits the commands and results left in the journal by a
+// previous run of the agent. Commands that expired while the agent was down
+// are dropped, as the scheduler has already given up on them.
+func replayJournal(ctx *Context) {
+	if ctx.Journal == nil {
+		return
+	}
+	entries, err := ctx.Journal.pending()
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("failed to read command journal: %v", err)}.Err()
+		return
+	}
+	for _, e := range entries {
+		var cmd mig.Command
+		err = json.Unmarshal(e.data, &cmd)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{CommandID: e.id, Desc: fmt.Sprintf("dropping unreadable journal entry: %v", err)}.Err()
+			ctx.Journal.complete(e.id)
+			continue
+		}
+		if e.results {
+			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: "replaying unsent command results from journal"}
+			ctx.Channels.Results <- cmd
+			continue
+		}
+		if cmd.Action.ExpireAfter.Before(time.Now()) {
+			ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: "dropping expired command from journal"}
+			ctx.Journal.complete(e.id)
+			continue
+		}
+		ctx.Channels.Log <- mig.Log{CommandID: cmd.ID, ActionID: cmd.Action.ID, Desc: "replaying unanswered command from journal"}
+		ctx.Channels.NewCommand <- e.data
+	}
+}
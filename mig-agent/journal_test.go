@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migjournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := newJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []float64{1001, 1002, 1003} {
+		dup, err := j.recordCommand(id, []byte(`{"id": 1}`))
+		if err != nil || dup {
+			t.Fatalf("command %.0f: expected a new command, got dup=%t err=%v", id, dup, err)
+		}
+	}
+	if dup, _ := j.recordCommand(1001, []byte(`{}`)); !dup {
+		t.Error("expected a running command to be a duplicate")
+	}
+	err = j.recordResults(1002, []byte(`{"status": "success"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = j.recordResults(1003, []byte(`{"status": "success"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = j.complete(1003)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the journal as a restarted agent would
+	j, err = newJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := j.pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", len(entries))
+	}
+	if entries[0].id != 1001 || entries[0].results {
+		t.Errorf("expected command 1001 to be replayed, got %+v", entries[0])
+	}
+	if entries[1].id != 1002 || !entries[1].results || string(entries[1].data) != `{"status": "success"}` {
+		t.Errorf("expected results of command 1002 to be replayed, got %+v", entries[1])
+	}
+	if dup, _ := j.recordCommand(1001, []byte(`{"id": 1}`)); dup {
+		t.Error("expected a replayed command to be accepted")
+	}
+	if dup, _ := j.recordCommand(1002, []byte(`{}`)); !dup {
+		t.Error("expected a command with unsent results to be a duplicate")
+	}
+	if dup, _ := j.recordCommand(1003, []byte(`{}`)); !dup {
+		t.Error("expected a completed command to be a duplicate")
+	}
+}
+
+func TestJournalCompletedLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migjournal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := newJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := float64(1); id <= journalMaxCompleted+10; id++ {
+		err = j.complete(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	j, err = newJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(j.completed) != journalMaxCompleted || j.completed[0] != 11 {
+		t.Errorf("expected the last %d completed commands, got %d starting at %.0f",
+			journalMaxCompleted, len(j.completed), j.completed[0])
+	}
+}
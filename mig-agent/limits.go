@@ -0,0 +1,169 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla/mig/modules"
+)
+
+// defaultLimitsName is the name of the module section of the configuration
+// that applies to modules without a section of their own
+const defaultLimitsName = "default"
+
+// I/O scheduling classes, as defined by ioprio_set(2)
+const (
+	ioClassNone       = 0
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// moduleLimits is the resource policy applied to the process running a module
+type moduleLimits struct {
+	// scheduling priority of the module, from 0 (unchanged) to 19
+	nice int
+
+	// I/O scheduling class and priority level within that class
+	ioClass, ioLevel int
+
+	// memory ceiling of the module in bytes, 0 for unlimited
+	maxMemory uint64
+
+	// maximum number of file descriptors the module can open, 0 for unlimited
+	maxOpenFiles uint64
+}
+
+// moduleLimitsConfig is a [module "name"] section of the agent configuration
+type moduleLimitsConfig struct {
+	Nice         int
+	IONice       string
+	MaxMemory    string
+	MaxOpenFiles int
+}
+
+// parseModuleLimits converts the configuration of a module section into a
+// moduleLimits policy
+func parseModuleLimits(conf moduleLimitsConfig) (l moduleLimits, err error) {
+	if conf.Nice < 0 || conf.Nice > 19 {
+		return l, fmt.Errorf("nice must be between 0 and 19, got %d", conf.Nice)
+	}
+	l.nice = conf.Nice
+	l.ioClass, l.ioLevel, err = parseIONice(conf.IONice)
+	if err != nil {
+		return
+	}
+	l.maxMemory, err = parseByteSize(conf.MaxMemory)
+	if err != nil {
+		return l, fmt.Errorf("maxmemory %v", err)
+	}
+	if conf.MaxOpenFiles < 0 {
+		return l, fmt.Errorf("maxopenfiles must be positive, got %d", conf.MaxOpenFiles)
+	}
+	l.maxOpenFiles = uint64(conf.MaxOpenFiles)
+	return
+}
+
+// parseIONice parses an ionice setting, which is either empty, "idle" or
+// "besteffort:<level>" with a level from 0 (highest priority) to 7
+func parseIONice(val string) (class, level int, err error) {
+	val = strings.ToLower(strings.TrimSpace(val))
+	switch {
+	case val == "":
+		return ioClassNone, 0, nil
+	case val == "idle":
+		return ioClassIdle, 0, nil
+	case strings.HasPrefix(val, "besteffort:"):
+		level, err = strconv.Atoi(strings.TrimPrefix(val, "besteffort:"))
+		if err != nil || level < 0 || level > 7 {
+			return ioClassNone, 0, fmt.Errorf("ionice best effort level must be between 0 and 7 in %q", val)
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return ioClassNone, 0, fmt.Errorf("ionice must be 'idle' or 'besteffort:<level>', got %q", val)
+}
+
+// parseByteSize parses a size such as "512M" or "2G" into a number of bytes.
+// Units are powers of 1024, a value without unit is in bytes.
+func parseByteSize(val string) (size uint64, err error) {
+	val = strings.ToUpper(strings.TrimSpace(val))
+	if val == "" {
+		return 0, nil
+	}
+	var mult uint64 = 1
+	switch val[len(val)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		val = val[:len(val)-1]
+	}
+	size, err = strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+	return size * mult, nil
+}
+
+// isSet returns true if the policy restricts the module in any way
+func (l moduleLimits) isSet() bool {
+	return l.nice != 0 || l.ioClass != ioClassNone || l.maxMemory != 0 || l.maxOpenFiles != 0
+}
+
+// limitsFor returns the resource policy of a module, falling back to the
+// default policy if the module has none
+func limitsFor(mode string) (l moduleLimits, ok bool) {
+	limits := settings().moduleLimits
+	l, ok = limits[strings.ToLower(mode)]
+	if !ok {
+		l, ok = limits[defaultLimitsName]
+	}
+	if ok && !l.isSet() {
+		ok = false
+	}
+	return
+}
+
+// limitedProcess is a module process running under a resource policy
+type limitedProcess struct {
+	limits moduleLimits
+	pid    int
+
+	// path of the cgroup the process runs in, if the memory ceiling
+	// is enforced with cgroups
+	cgroup string
+}
+
+// breach returns a description of the limit a module exceeded, or an empty
+// string if it stayed within its policy. It looks at the memory accounting
+// of the cgroup, if any, and at the errors reported by the module and the
+// Go runtime when an allocation or an open call is refused.
+func (lp limitedProcess) breach(stderr []byte, res modules.Result) string {
+	if lp.limits.maxMemory > 0 {
+		if lp.memoryCeilingReached() || bytes.Contains(stderr, []byte("out of memory")) {
+			return fmt.Sprintf("module exceeded its memory ceiling of %d bytes", lp.limits.maxMemory)
+		}
+	}
+	if lp.limits.maxOpenFiles > 0 {
+		reached := bytes.Contains(stderr, []byte("too many open files"))
+		for _, e := range res.Errors {
+			if strings.Contains(e, "too many open files") {
+				reached = true
+			}
+		}
+		if reached {
+			return fmt.Sprintf("module exceeded its limit of %d open files", lp.limits.maxOpenFiles)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// applyLimits enforces a resource policy on a running module process. MacOS
+// does not allow setting the limits of another process, so only the nice
+// value of the policy is applied.
+func applyLimits(l moduleLimits, opid float64, pid int) (lp limitedProcess, err error) {
+	// only record the part of the policy that is enforced, so that
+	// memory exhaustion is not reported as a limit breach
+	lp.limits = moduleLimits{nice: l.nice}
+	lp.pid = pid
+	if l.nice != 0 {
+		err = syscall.Setpriority(syscall.PRIO_PROCESS, pid, l.nice)
+		if err != nil {
+			err = fmt.Errorf("applyLimits() -> failed to set nice value: %v", err)
+		}
+	}
+	return
+}
+
+func (lp limitedProcess) memoryCeilingReached() bool {
+	return false
+}
+
+func (lp limitedProcess) release() {
+	return
+}
@@ -0,0 +1,143 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 unified hierarchy. Modules
+// run in a cgroup created under cgroupRoot/mig-agent.
+const cgroupRoot = "/sys/fs/cgroup"
+
+const ioprioWhoProcess = 1
+
+// applyLimits enforces a resource policy on a running module process. The
+// memory ceiling is enforced by a cgroup when cgroups v2 are available, and by
+// limiting the address space of the process otherwise.
+func applyLimits(l moduleLimits, opid float64, pid int) (lp limitedProcess, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("applyLimits() -> %v", e)
+			lp.release()
+		}
+	}()
+	lp.limits = l
+	lp.pid = pid
+	if l.nice != 0 {
+		err = syscall.Setpriority(syscall.PRIO_PROCESS, pid, l.nice)
+		if err != nil {
+			panic(fmt.Sprintf("failed to set nice value: %v", err))
+		}
+	}
+	if l.ioClass != ioClassNone {
+		prio := l.ioClass<<13 | l.ioLevel
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio))
+		if errno != 0 {
+			panic(fmt.Sprintf("failed to set io priority: %v", errno))
+		}
+	}
+	if l.maxMemory > 0 {
+		lp.cgroup, err = joinCgroup(opid, pid, l.maxMemory)
+		if err != nil {
+			// cgroups are unavailable or not writable, fall back to rlimits
+			lp.cgroup = ""
+			err = prlimit(pid, syscall.RLIMIT_AS, l.maxMemory)
+			if err != nil {
+				panic(fmt.Sprintf("failed to set memory limit: %v", err))
+			}
+		}
+	}
+	if l.maxOpenFiles > 0 {
+		err = prlimit(pid, syscall.RLIMIT_NOFILE, l.maxOpenFiles)
+		if err != nil {
+			panic(fmt.Sprintf("failed to set open files limit: %v", err))
+		}
+	}
+	return
+}
+
+// joinCgroup creates a cgroup for an operation with a memory ceiling and
+// moves the module process into it
+func joinCgroup(opid float64, pid int, maxMemory uint64) (cgroup string, err error) {
+	controllers, err := ioutil.ReadFile(path.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return
+	}
+	if !strings.Contains(string(controllers), "memory") {
+		return "", fmt.Errorf("memory controller is not available")
+	}
+	parent := path.Join(cgroupRoot, "mig-agent")
+	err = os.Mkdir(parent, 0755)
+	if err != nil && !os.IsExist(err) {
+		return
+	}
+	err = ioutil.WriteFile(path.Join(parent, "cgroup.subtree_control"), []byte("+memory"), 0644)
+	if err != nil {
+		return
+	}
+	cgroup = path.Join(parent, fmt.Sprintf("op%.0f", opid))
+	err = os.Mkdir(cgroup, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path.Join(cgroup, "memory.max"), []byte(strconv.FormatUint(maxMemory, 10)), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(path.Join(cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+	}
+	if err != nil {
+		os.Remove(cgroup)
+		return "", err
+	}
+	return
+}
+
+// prlimit sets both the soft and hard limits of a resource of process pid
+func prlimit(pid int, resource int, limit uint64) error {
+	rlim := syscall.Rlimit{Cur: limit, Max: limit}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(&rlim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// memoryCeilingReached returns true if the kernel killed a process of the
+// module's cgroup because it reached the memory ceiling
+func (lp limitedProcess) memoryCeilingReached() bool {
+	if lp.cgroup == "" {
+		return false
+	}
+	fd, err := os.Open(path.Join(lp.cgroup, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" && fields[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// release removes the cgroup of the module, once its process has exited
+func (lp limitedProcess) release() {
+	if lp.cgroup != "" {
+		os.Remove(lp.cgroup)
+	}
+}
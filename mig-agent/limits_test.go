@@ -0,0 +1,130 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"testing"
+
+	"github.com/mozilla/mig/modules"
+	"gopkg.in/gcfg.v1"
+)
+
+func TestParseModuleLimits(t *testing.T) {
+	testcases := []struct {
+		conf   moduleLimitsConfig
+		expect moduleLimits
+		valid  bool
+	}{
+		{moduleLimitsConfig{}, moduleLimits{}, true},
+		{moduleLimitsConfig{Nice: 10, IONice: "idle"}, moduleLimits{nice: 10, ioClass: ioClassIdle}, true},
+		{moduleLimitsConfig{IONice: "besteffort:7"}, moduleLimits{ioClass: ioClassBestEffort, ioLevel: 7}, true},
+		{moduleLimitsConfig{MaxMemory: "512M", MaxOpenFiles: 64}, moduleLimits{maxMemory: 512 << 20, maxOpenFiles: 64}, true},
+		{moduleLimitsConfig{MaxMemory: "2g"}, moduleLimits{maxMemory: 2 << 30}, true},
+		{moduleLimitsConfig{MaxMemory: "4096"}, moduleLimits{maxMemory: 4096}, true},
+		{moduleLimitsConfig{Nice: 20}, moduleLimits{}, false},
+		{moduleLimitsConfig{Nice: -5}, moduleLimits{}, false},
+		{moduleLimitsConfig{IONice: "realtime"}, moduleLimits{}, false},
+		{moduleLimitsConfig{IONice: "besteffort:8"}, moduleLimits{}, false},
+		{moduleLimitsConfig{MaxMemory: "lots"}, moduleLimits{}, false},
+		{moduleLimitsConfig{MaxOpenFiles: -1}, moduleLimits{}, false},
+	}
+	for i, tc := range testcases {
+		l, err := parseModuleLimits(tc.conf)
+		if (err == nil) != tc.valid {
+			t.Errorf("case %d: expected valid=%t, got %v", i, tc.valid, err)
+			continue
+		}
+		if tc.valid && l != tc.expect {
+			t.Errorf("case %d: expected %+v, got %+v", i, tc.expect, l)
+		}
+	}
+}
+
+// TestConfigModuleLimits verifies module sections of the configuration are
+// parsed into MODULELIMITS
+func TestConfigModuleLimits(t *testing.T) {
+	orig := MODULELIMITS
+	defer func() { MODULELIMITS = orig }()
+
+	var config config
+	err := gcfg.ReadStringInto(&config, `
+[agent]
+    heartbeatfreq = "300s"
+    moduletimeout = "300s"
+[module "default"]
+    nice = 10
+[module "Memory"]
+    ionice = "idle"
+    maxmemory = "512M"
+    maxopenfiles = 64
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals := newGlobals()
+	err = globals.parseConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := moduleLimits{ioClass: ioClassIdle, maxMemory: 512 << 20, maxOpenFiles: 64}
+	if MODULELIMITS["memory"] != expect {
+		t.Errorf("expected memory module limits %+v, got %+v", expect, MODULELIMITS["memory"])
+	}
+	if MODULELIMITS[defaultLimitsName].nice != 10 {
+		t.Errorf("expected default nice value of 10, got %+v", MODULELIMITS[defaultLimitsName])
+	}
+
+	config.Module["Memory"].MaxMemory = "512MB"
+	expectErr := `config.Module.Memory maxmemory invalid size "512MB"`
+	err = globals.parseConfig(config)
+	if err == nil || err.Error() != expectErr {
+		t.Error("expected", expectErr, "got", err)
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	orig := settings()
+	defer liveSettings.Store(orig)
+
+	g := *orig
+	g.moduleLimits = map[string]moduleLimits{"memory": {maxMemory: 1024}}
+	liveSettings.Store(&g)
+	if _, ok := limitsFor("file"); ok {
+		t.Error("expected no policy for a module without a section")
+	}
+	l, ok := limitsFor("memory")
+	if !ok || l.maxMemory != 1024 {
+		t.Errorf("expected the memory module policy, got %+v", l)
+	}
+
+	g.moduleLimits[defaultLimitsName] = moduleLimits{nice: 5}
+	l, ok = limitsFor("file")
+	if !ok || l.nice != 5 {
+		t.Errorf("expected the default policy, got %+v", l)
+	}
+	g.moduleLimits["file"] = moduleLimits{}
+	if _, ok = limitsFor("file"); ok {
+		t.Error("expected an empty policy to disable limits")
+	}
+}
+
+func TestLimitBreach(t *testing.T) {
+	lp := limitedProcess{limits: moduleLimits{maxMemory: 1024, maxOpenFiles: 16}}
+	if b := lp.breach(nil, modules.Result{}); b != "" {
+		t.Errorf("expected no breach, got %q", b)
+	}
+	if b := lp.breach([]byte("fatal error: runtime: out of memory"), modules.Result{}); b == "" {
+		t.Error("expected the memory ceiling breach to be detected")
+	}
+	res := modules.Result{Errors: []string{"open /etc/passwd: too many open files"}}
+	if b := lp.breach(nil, res); b == "" {
+		t.Error("expected the open files breach to be detected")
+	}
+	lp.limits = moduleLimits{nice: 10}
+	if b := lp.breach([]byte("fatal error: runtime: out of memory"), res); b != "" {
+		t.Errorf("expected no breach without memory or open files limits, got %q", b)
+	}
+}
@@ -0,0 +1,21 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// applyLimits is a no-op on Windows, where module resource limits are not
+// supported yet
+func applyLimits(l moduleLimits, opid float64, pid int) (lp limitedProcess, err error) {
+	lp.pid = pid
+	return
+}
+
+func (lp limitedProcess) memoryCeilingReached() bool {
+	return false
+}
+
+func (lp limitedProcess) release() {
+	return
+}
@@ -0,0 +1,29 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"github.com/mozilla/mig/metrics"
+)
+
+// agentMetrics holds the metrics exposed by the agent on the /metrics
+// endpoint of the stat socket
+var agentMetrics = metrics.NewRegistry()
+
+var (
+	metricAMQPConsumed = agentMetrics.NewCounter("mig_agent_amqp_consumed_total",
+		"Commands received from the relay.")
+	metricAMQPPublished = agentMetrics.NewCounter("mig_agent_amqp_published_total",
+		"Messages published to the relay, by routing key.", "routingkey")
+	metricAMQPPublishErrors = agentMetrics.NewCounter("mig_agent_amqp_publish_errors_total",
+		"Failed attempts to publish a message to the relay.")
+	metricModuleDuration = agentMetrics.NewHistogram("mig_agent_module_run_duration_seconds",
+		"Time spent running modules, by module and status.", nil, "module", "status")
+	metricModuleTimeouts = agentMetrics.NewCounter("mig_agent_module_timeouts_total",
+		"Module runs killed after reaching their timeout, by module.", "module")
+	metricModuleLimitBreaches = agentMetrics.NewCounter("mig_agent_module_limit_breaches_total",
+		"Module runs that exceeded their resource limits, by module.", "module")
+)
@@ -0,0 +1,110 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// The nonce store keeps the nonces of the version 3 actions the agent has
+// accepted, to refuse an action that is replayed in another command, or
+// submitted again to the control API. Nonces are signed with the rest of the
+// action, so a replayed action cannot be given a new one. They are kept until
+// their action expires, after which the action is rejected anyway, and are
+// stored on disk to survive restarts of the agent.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+type nonceStore struct {
+	file   string
+	nonces map[string]seenNonce
+	sync.Mutex
+}
+
+// seenNonce records the action and the command a nonce was received in
+type seenNonce struct {
+	ActionID    float64   `json:"actionid"`
+	CommandID   float64   `json:"commandid,omitempty"`
+	ExpireAfter time.Time `json:"expireafter"`
+}
+
+// newNonceStore opens the nonce store kept in file
+func newNonceStore(file string) (s *nonceStore, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("newNonceStore() -> %v", e)
+		}
+	}()
+	s = &nonceStore{file: file, nonces: make(map[string]seenNonce)}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		panic(err)
+	}
+	err = json.Unmarshal(data, &s.nonces)
+	if err != nil {
+		panic(fmt.Sprintf("invalid nonce store %s: %v", file, err))
+	}
+	return
+}
+
+// record checks that the nonce of an action has not been seen before, and
+// stores it. Commands the journal replays after a restart are received again
+// under the same command ID, known tells whether the journal already has the
+// command, in which case the nonce is accepted and the journal decides if the
+// command runs again. Actions submitted to the control API have no command ID,
+// and are always rejected if their nonce was seen. Legacy actions have no
+// nonce, and are not recorded.
+func (s *nonceStore) record(a mig.Action, cmdID float64, known bool) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("record() -> %v", e)
+		}
+	}()
+	if a.SyntaxVersion < mig.ActionVersion {
+		return
+	}
+	if s == nil {
+		panic("the nonce store is not available, cannot verify the action is not replayed")
+	}
+	s.Lock()
+	defer s.Unlock()
+	if seen, ok := s.nonces[a.Nonce]; ok {
+		if cmdID > 0 && seen.CommandID == cmdID && known {
+			return
+		}
+		panic(fmt.Sprintf("action nonce was already received in action %.0f, refusing replayed action", seen.ActionID))
+	}
+	s.prune(time.Now())
+	s.nonces[a.Nonce] = seenNonce{ActionID: a.ID, CommandID: cmdID, ExpireAfter: a.ExpireAfter}
+	data, err := json.Marshal(s.nonces)
+	if err != nil {
+		panic(err)
+	}
+	err = writeFileAtomic(s.file, data)
+	if err != nil {
+		// an action that cannot be recorded could be replayed later
+		delete(s.nonces, a.Nonce)
+		panic(err)
+	}
+	return
+}
+
+// prune forgets the nonces of actions that expired before now
+func (s *nonceStore) prune(now time.Time) {
+	for nonce, seen := range s.nonces {
+		if seen.ExpireAfter.Before(now) {
+			delete(s.nonces, nonce)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+func TestNonceStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mignonces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "nonces")
+
+	s, err := newNonceStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mig.Action{ID: 1, SyntaxVersion: mig.ActionVersion,
+		Nonce: "0123456789abcdef", ExpireAfter: time.Now().Add(time.Hour)}
+	err = s.record(a, 1001, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the action sent again in another command is a replay
+	if s.record(a, 1002, true) == nil {
+		t.Error("expected an action replayed in another command to be refused")
+	}
+	// and so is the same command, once the journal has forgotten it
+	if s.record(a, 1001, false) == nil {
+		t.Error("expected an action replayed in a forgotten command to be refused")
+	}
+	if s.record(a, 0, false) == nil {
+		t.Error("expected an action replayed to the control api to be refused")
+	}
+	// legacy actions have no nonce and are not recorded
+	legacy := mig.Action{ID: 2, SyntaxVersion: mig.ActionVersionLegacy, ExpireAfter: time.Now().Add(time.Hour)}
+	for i := 0; i < 2; i++ {
+		err = s.record(legacy, 1003, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := mig.Action{ID: 3, SyntaxVersion: mig.ActionVersion,
+		Nonce: "fedcba9876543210", ExpireAfter: time.Now().Add(-time.Minute)}
+	err = s.record(expired, 1004, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen the store as a restarted agent would, the command the journal
+	// replays is accepted
+	s, err = newNonceStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.record(a, 1001, true)
+	if err != nil {
+		t.Errorf("expected the command replayed by the journal to be accepted: %v", err)
+	}
+	if s.record(a, 1005, false) == nil {
+		t.Error("expected the nonce to be kept across restarts")
+	}
+	// recording a nonce forgets the ones of expired actions
+	b := mig.Action{ID: 4, SyntaxVersion: mig.ActionVersion,
+		Nonce: "00112233445566778899", ExpireAfter: time.Now().Add(time.Hour)}
+	err = s.record(b, 1006, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.nonces[expired.Nonce]; ok {
+		t.Error("expected the nonce of an expired action to be pruned")
+	}
+	if len(s.nonces) != 2 {
+		t.Errorf("expected 2 nonces in the store, got %d", len(s.nonces))
+	}
+
+	var missing *nonceStore
+	if missing.record(a, 1001, false) == nil {
+		t.Error("expected actions to be refused without a nonce store")
+	}
+}
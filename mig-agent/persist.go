@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os/exec"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -48,9 +49,25 @@ var dispatchChanLock sync.Mutex
 // which case you might have something like "tcp:127.0.0.1:55000".
 type persistModuleRegister struct {
 	modules map[string]*string
+	health  map[string]*persistModuleHealth
+	// configuration last sent to each module, and channels used to request
+	// a restart of a module when its configuration changes
+	configs  map[string][]byte
+	restarts map[string]chan bool
 	sync.Mutex
 }
 
+// persistModuleHealth describes the state of a persistent module, as reported
+// by the control API
+type persistModuleHealth struct {
+	Name     string    `json:"name"`
+	Running  bool      `json:"running"`
+	Socket   string    `json:"socket,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+	LastPing time.Time `json:"lastping,omitempty"`
+	Restarts int       `json:"restarts"`
+}
+
 // Get a socket specification registered for a given persistent module
 func (p *persistModuleRegister) get(modname string) (string, error) {
 	p.Lock()
@@ -67,13 +84,113 @@ func (p *persistModuleRegister) register(modname string, spec string) {
 	p.Lock()
 	defer p.Unlock()
 	p.modules[modname] = &spec
+	p.healthOf(modname).Socket = spec
 }
 
-// Remove a socket specification for a persistent module
+// Remove a socket specification for a persistent module, which is done when
+// the module goes down
 func (p *persistModuleRegister) remove(modname string) {
 	p.Lock()
 	defer p.Unlock()
 	p.modules[modname] = nil
+	h := p.healthOf(modname)
+	h.Running = false
+	h.Socket = ""
+}
+
+// Record that a persistent module was started
+func (p *persistModuleRegister) started(modname string) {
+	p.Lock()
+	defer p.Unlock()
+	h := p.healthOf(modname)
+	if !h.Started.IsZero() {
+		h.Restarts++
+	}
+	h.Running = true
+	h.Started = time.Now()
+	h.LastPing = h.Started
+}
+
+// Record a ping response from a persistent module
+func (p *persistModuleRegister) pinged(modname string) {
+	p.Lock()
+	defer p.Unlock()
+	p.healthOf(modname).LastPing = time.Now()
+}
+
+// Return the state of the persistent modules
+func (p *persistModuleRegister) status() (ret []persistModuleHealth) {
+	p.Lock()
+	defer p.Unlock()
+	ret = make([]persistModuleHealth, 0)
+	for _, h := range p.health {
+		ret = append(ret, *h)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return
+}
+
+// Record the configuration sent to a persistent module when it started
+func (p *persistModuleRegister) configured(modname string, cfg interface{}) {
+	buf, err := json.Marshal(cfg)
+	if err != nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.configs == nil {
+		p.configs = make(map[string][]byte)
+	}
+	p.configs[modname] = buf
+}
+
+// Return the channel on which restarts of a persistent module are requested
+func (p *persistModuleRegister) restartChan(modname string) chan bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.restarts == nil {
+		p.restarts = make(map[string]chan bool)
+	}
+	c, ok := p.restarts[modname]
+	if !ok {
+		c = make(chan bool, 1)
+		p.restarts[modname] = c
+	}
+	return c
+}
+
+// Restart the running persistent modules whose configuration file has changed
+// since they were started, and return their names
+func (p *persistModuleRegister) reload() (restarted []string) {
+	p.Lock()
+	defer p.Unlock()
+	for modname, c := range p.restarts {
+		buf, err := json.Marshal(getPersistConfig(modname))
+		if err != nil || string(buf) == string(p.configs[modname]) {
+			continue
+		}
+		select {
+		case c <- true:
+		default:
+			// a restart is already pending
+		}
+		restarted = append(restarted, modname)
+	}
+	sort.Strings(restarted)
+	return
+}
+
+// healthOf returns the health entry of a module, the lock must be held
+func (p *persistModuleRegister) healthOf(modname string) *persistModuleHealth {
+	if p.health == nil {
+		p.health = make(map[string]*persistModuleHealth)
+	}
+	h, ok := p.health[modname]
+	if !ok {
+		h = &persistModuleHealth{Name: modname}
+		p.health[modname] = h
+	}
+	return h
 }
 
 var persistModRegister persistModuleRegister
@@ -155,6 +272,8 @@ func managePersistModule(ctx *Context, name string) {
 	}
 
 	pingtick := time.Tick(time.Second * 10)
+	restart := persistModRegister.restartChan(name)
+	restarting := false
 
 	for {
 		if failDelay {
@@ -181,6 +300,7 @@ func managePersistModule(ctx *Context, name string) {
 			}
 			pipein = modules.NewModuleReader(cmdpipein)
 			cfg := getPersistConfig(name)
+			persistModRegister.configured(name, cfg)
 			err = cmd.Start()
 			if err != nil {
 				logfunc("error starting module, %v", err)
@@ -202,6 +322,7 @@ func managePersistModule(ctx *Context, name string) {
 			}()
 
 			isRunning = true
+			persistModRegister.started(name)
 
 			// The module is now running, send any configuration parameters we have
 			// to it.
@@ -252,6 +373,7 @@ func managePersistModule(ctx *Context, name string) {
 			switch msg.Class {
 			case modules.MsgClassPing:
 				lastPing = time.Now()
+				persistModRegister.pinged(name)
 			case modules.MsgClassLog:
 				var lp modules.LogParams
 				buf, err := json.Marshal(msg.Parameters)
@@ -312,6 +434,10 @@ func managePersistModule(ctx *Context, name string) {
 				failDelay = true
 				break
 			}
+		case <-restart:
+			logfunc("configuration has changed, restarting module")
+			killModule = true
+			restarting = true
 		case _ = <-pingtick:
 			// If we haven't received a reply in the past 3 cycles we will
 			// kill the module
@@ -352,8 +478,11 @@ func managePersistModule(ctx *Context, name string) {
 			dispatchDealloc()
 			isRunning = false
 			persistModRegister.remove(name)
-			failDelay = true
+			// a module restarted for a configuration change can start again
+			// immediately
+			failDelay = !restarting
 			killModule = false
+			restarting = false
 		}
 	}
 }
@@ -0,0 +1,162 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/mozilla/mig"
+	"gopkg.in/gcfg.v1"
+)
+
+// reloadResult lists the settings that changed in the configuration file,
+// split between the ones that were applied to the running agent and the ones
+// that require a restart of the agent to take effect
+type reloadResult struct {
+	Applied  []string `json:"applied"`
+	Rejected []string `json:"rejected"`
+}
+
+// reloadSetting points to the running and reloaded values of a setting
+type reloadSetting struct {
+	name          string
+	running, next interface{}
+}
+
+// reloading serializes reloads requested by signal and by the control socket
+var reloading sync.Mutex
+
+// reloadConfig reads the configuration file the agent was started with and
+// applies the settings that can be changed while the agent is running. Other
+// settings are left untouched and reported as rejected. Persistent modules
+// whose configuration file changed are restarted. The new settings are
+// published with liveSettings and the global variables are left untouched.
+func reloadConfig(ctx *Context) (res reloadResult, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("reloadConfig() -> %v", e)
+		}
+	}()
+	reloading.Lock()
+	defer reloading.Unlock()
+	res.Applied = []string{}
+	res.Rejected = []string{}
+	if loadedConfig == "" {
+		panic("agent is running on its built-in configuration")
+	}
+	var config config
+	err = gcfg.ReadFileInto(&config, loadedConfig)
+	if err != nil {
+		panic(err)
+	}
+	running := settings()
+	next := new(globals)
+	*next = *running
+	next.tags = make(map[string]string)
+	for k, v := range builtinTags {
+		next.tags[k] = v
+	}
+	err = next.parse(config)
+	if err != nil {
+		panic(err)
+	}
+
+	// settings that take effect the next time they are used
+	live := []reloadSetting{
+		{"agent.tags", &running.tags, &next.tags},
+		{"agent.moduletimeout", &running.moduleTimeout, &next.moduleTimeout},
+		{"agent.nosandbox", &running.sandboxModules, &next.sandboxModules},
+		{"agent.onlyverifypubkey", &running.onlyVerifyPubKey, &next.onlyVerifyPubKey},
+		{"agent.acceptlegacyactions", &running.acceptLegacyActions, &next.acceptLegacyActions},
+		{"agent.extraprivacymode", &running.extraPrivacyMode, &next.extraPrivacyMode},
+		{"agent.api", &running.apiURL, &next.apiURL},
+		{"agent.proxies", &running.proxies, &next.proxies},
+		{"agent.facts", &running.facts, &next.facts},
+		{"agent.nocontainers", &running.discoverContainers, &next.discoverContainers},
+		{"agent.heartbeatfreq", &running.heartBeatFreq, &next.heartBeatFreq},
+		{"stats.maxactions", &running.statsMaxActions, &next.statsMaxActions},
+		{"module", &running.moduleLimits, &next.moduleLimits},
+		{"upgrade.requiredsignatures", &running.upgradeSignatures, &next.upgradeSignatures},
+		{"upgrade.timeout", &running.upgradeTimeout, &next.upgradeTimeout},
+		{"upgrade.loaderkeyfile", &running.loaderKeyFile, &next.loaderKeyFile},
+		{"upgrade.allowdowngrade", &running.allowDowngrade, &next.allowDowngrade},
+	}
+	// settings that are only read when the agent starts, they are reverted
+	// to their running value
+	restart := []reloadSetting{
+		{"agent.isimmortal", &running.isImmortal, &next.isImmortal},
+		{"agent.installservice", &running.mustInstallService, &next.mustInstallService},
+		{"agent.discoverpublicip", &running.discoverPulicIP, &next.discoverPulicIP},
+		{"agent.discoverawsmeta", &running.discoverAWSMeta, &next.discoverAWSMeta},
+		{"agent.checkin", &running.checkin, &next.checkin},
+		{"agent.nopersistmods", &running.spawnPersistent, &next.spawnPersistent},
+		{"agent.relay", &running.amqBroker, &next.amqBroker},
+		{"agent.socket", &running.socket, &next.socket},
+		{"logging", &running.loggingConf, &next.loggingConf},
+		{"certs.ca", &running.caCert, &next.caCert},
+		{"certs.cert", &running.agentCert, &next.agentCert},
+		{"certs.key", &running.agentKey, &next.agentKey},
+		{"control.socket", &running.controlSocket, &next.controlSocket},
+		{"control.mode", &running.controlSocketMode, &next.controlSocketMode},
+		{"control.group", &running.controlGroup, &next.controlGroup},
+	}
+	// the refresh loop picks up a new period, but is only started or
+	// stopped when the agent starts
+	refresh := reloadSetting{"agent.refreshenv", &running.refreshEnv, &next.refreshEnv}
+	if running.refreshEnv != 0 && next.refreshEnv != 0 {
+		live = append(live, refresh)
+	} else {
+		restart = append(restart, refresh)
+	}
+
+	for _, s := range live {
+		if !reflect.DeepEqual(s.running, s.next) {
+			res.Applied = append(res.Applied, s.name)
+		}
+	}
+	for _, s := range restart {
+		if reflect.DeepEqual(s.running, s.next) {
+			continue
+		}
+		res.Rejected = append(res.Rejected, s.name)
+		reflect.ValueOf(s.next).Elem().Set(reflect.ValueOf(s.running).Elem())
+	}
+	liveSettings.Store(next)
+
+	ctx.Agent.Lock()
+	ctx.Agent.Tags = next.tags
+	ctx.Agent.Unlock()
+
+	for _, modname := range persistModRegister.reload() {
+		res.Applied = append(res.Applied, "persist."+modname)
+	}
+
+	desc := fmt.Sprintf("configuration reloaded from %s, applied %v", loadedConfig, res.Applied)
+	if len(res.Rejected) > 0 {
+		desc += fmt.Sprintf(", changes to %v require a restart", res.Rejected)
+	}
+	ctx.Channels.Log <- mig.Log{Desc: desc}.Info()
+	return
+}
+
+// reloadOnSignal reloads the agent configuration when the agent receives a
+// SIGHUP
+func reloadOnSignal(ctx *Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		ctx.Channels.Log <- mig.Log{Desc: "received SIGHUP, reloading configuration"}
+		_, err := reloadConfig(ctx)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("configuration reload failed: %v", err)}.Err()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mozilla/mig/modules"
+)
+
+// A sandboxed module is started in the setup stage, where it restricts its own
+// environment before executing itself again in the enforce stage, where the
+// system call filter is installed and the module runs.
+const (
+	sandboxSetup   = "setup"
+	sandboxEnforce = "enforce"
+)
+
+// sandboxErrorPrefix starts the message a module writes to stderr when it
+// fails to enter its sandbox
+const sandboxErrorPrefix = "failed to sandbox module"
+
+// capabilities maps the names of Linux capabilities to their numbers, as
+// defined in capabilities(7)
+var capabilities = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// modulePrivileges returns the privileges a module declares, and false if the
+// module does not declare any
+func modulePrivileges(mode string) (priv modules.Privileges, ok bool) {
+	mod, ok := modules.Available[mode]
+	if !ok {
+		return
+	}
+	run, ok := mod.NewRun().(modules.HasPrivileges)
+	if !ok {
+		return
+	}
+	return run.Privileges(), true
+}
+
+// capabilitySet converts a list of capability names into the set of their
+// numbers. Unknown names are rejected rather than ignored, so that a typo
+// in a module does not silently grant it less, or more, than intended.
+func capabilitySet(names []string) (set map[uint]bool, err error) {
+	set = make(map[uint]bool)
+	for _, name := range names {
+		c, ok := capabilities[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+		set[c] = true
+	}
+	return
+}
+
+// sandboxFailure returns the reason a sandboxed module failed if its sandbox
+// caused the failure, or nil otherwise
+func sandboxFailure(state *os.ProcessState, stderr []byte) error {
+	if killedBySandbox(state) {
+		return fmt.Errorf("module was killed by its sandbox after making a system call outside of its declared privileges")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		if i := strings.Index(scanner.Text(), sandboxErrorPrefix); i >= 0 {
+			return fmt.Errorf("%s", scanner.Text()[i:])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mozilla/mig/modules"
+)
+
+// sandboxCommand does nothing on MacOS, where modules run with the privileges
+// of the agent
+func sandboxCommand(cmd *exec.Cmd, priv modules.Privileges) (sandboxed bool, err error) {
+	return false, nil
+}
+
+func enterSandbox(mode, stage string, inContainer bool) error {
+	return fmt.Errorf("enterSandbox() -> sandboxing modules is not supported on MacOS")
+}
+
+func killedBySandbox(state *os.ProcessState) bool {
+	return false
+}
+
+func enterContainer(pid string) error {
+	return fmt.Errorf("enterContainer() -> containers are not supported on MacOS")
+}
@@ -0,0 +1,356 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/mozilla/mig/modules"
+	"golang.org/x/sys/unix"
+)
+
+// seccomp constants from linux/seccomp.h
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetKillProcess  = 0x80000000
+	seccompRetAllow        = 0x7fff0000
+
+	// syscall numbers at or above this value belong to the x32 ABI on
+	// amd64, and bypass a filter written for native syscall numbers
+	x32SyscallBit = 0x40000000
+)
+
+// linux capabilities version 3, from linux/capability.h
+const linuxCapabilityVersion3 = 0x20080522
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// deniedSyscalls are killed by the seccomp filter of every sandboxed module.
+// No module needs them, and they are the usual way out of a sandbox or into
+// the kernel.
+var deniedSyscalls = append([]uint32{
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_SETNS, unix.SYS_UNSHARE, unix.SYS_PTRACE, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_KEXEC_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_ACCT,
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_ADJTIMEX, unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY, unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN, unix.SYS_OPEN_BY_HANDLE_AT, unix.SYS_USERFAULTFD,
+}, archDeniedSyscalls...)
+
+// sandboxCommand prepares cmd to run a module in a sandbox restricted to the
+// privileges it declares. The module gets its own mount namespace, and its own
+// empty network namespace unless it needs the network. It returns false if the
+// agent cannot sandbox modules because it does not run as root.
+func sandboxCommand(cmd *exec.Cmd, priv modules.Privileges) (sandboxed bool, err error) {
+	if os.Geteuid() != 0 {
+		return false, nil
+	}
+	_, err = capabilitySet(priv.Capabilities)
+	if err != nil {
+		return false, fmt.Errorf("sandboxCommand() -> %v", err)
+	}
+	cmd.Args = append(cmd.Args, "-sandbox", sandboxSetup)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+	if !priv.Network {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	return true, nil
+}
+
+// enterSandbox restricts the module process to the privileges of its module.
+// In the setup stage, the file system is made read-only if the module allows
+// it, and the capabilities the module does not need are removed from the
+// bounding set before the process executes itself again in the enforce stage.
+// The capabilities of the new process are then limited to the bounding set.
+// A module that runs in a container keeps CAP_SYS_CHROOT to enter it.
+func enterSandbox(mode, stage string, inContainer bool) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("enterSandbox() -> %v", e)
+		}
+	}()
+	priv, ok := modulePrivileges(mode)
+	if !ok {
+		panic(fmt.Sprintf("module %q does not declare its privileges", mode))
+	}
+	switch stage {
+	case sandboxSetup:
+		// the bounding set and no_new_privs are thread attributes, so all of
+		// the setup must happen on the thread that calls execve
+		runtime.LockOSThread()
+		// the agent starts the module in its own mount namespace, refuse to
+		// touch the mounts of the agent if that is not the case
+		ownns, err := os.Readlink("/proc/self/ns/mnt")
+		if err != nil {
+			panic(err)
+		}
+		parentns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", os.Getppid()))
+		if err != nil {
+			panic(err)
+		}
+		if ownns == parentns {
+			panic("module does not run in its own mount namespace")
+		}
+		// keep our mount changes from propagating back to the host
+		err = unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+		if err != nil {
+			panic(fmt.Sprintf("failed to make mounts private: %v", err))
+		}
+		if priv.ReadOnly {
+			err = remountReadOnly()
+			if err != nil {
+				panic(err)
+			}
+		}
+		keep, err := capabilitySet(priv.Capabilities)
+		if err != nil {
+			panic(err)
+		}
+		if inContainer {
+			keep[capabilities["CAP_SYS_CHROOT"]] = true
+		}
+		err = dropCapabilities(keep)
+		if err != nil {
+			panic(err)
+		}
+		err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+		if err != nil {
+			panic(fmt.Sprintf("failed to set no_new_privs: %v", err))
+		}
+		bin, err := os.Executable()
+		if err != nil {
+			panic(err)
+		}
+		args := make([]string, len(os.Args))
+		copy(args, os.Args)
+		for i := 1; i < len(args); i++ {
+			if args[i] == sandboxSetup && strings.TrimLeft(args[i-1], "-") == "sandbox" {
+				args[i] = sandboxEnforce
+			}
+		}
+		err = syscall.Exec(bin, args, os.Environ())
+		// exec only returns on failure
+		panic(fmt.Sprintf("failed to execute module in its sandbox: %v", err))
+	case sandboxEnforce:
+		err = installSeccompFilter()
+		if err != nil {
+			panic(err)
+		}
+	default:
+		panic(fmt.Sprintf("unknown sandbox stage %q", stage))
+	}
+	return
+}
+
+// enterContainer changes the root of the module process to the root file
+// system of the container whose init process is pid, as seen through procfs.
+// The module then sees the files, packages and processes of the container.
+func enterContainer(pid string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("enterContainer() -> %v", e)
+		}
+	}()
+	if _, err = strconv.Atoi(pid); err != nil {
+		panic(fmt.Sprintf("invalid container pid %q", pid))
+	}
+	err = unix.Chroot(path.Join("/proc", pid, "root"))
+	if err != nil {
+		panic(fmt.Sprintf("failed to change root to container: %v", err))
+	}
+	err = os.Chdir("/")
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// mountPoint is a mount listed in /proc/self/mountinfo
+type mountPoint struct {
+	path    string
+	options []string
+}
+
+// parseMountInfo reads the mount points and their options from the content
+// of /proc/self/mountinfo
+func parseMountInfo(r io.Reader) (mounts []mountPoint, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", scanner.Text())
+		}
+		mounts = append(mounts, mountPoint{
+			path:    unescapeMountPath(fields[4]),
+			options: strings.Split(fields[5], ","),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes the kernel uses for spaces and
+// other special characters in mount paths
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var out []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(c))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, path[i])
+	}
+	return string(out)
+}
+
+// remountReadOnly remounts every mount point of the namespace read-only,
+// keeping the nosuid, nodev and noexec flags it already had
+func remountReadOnly() error {
+	fd, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	mounts, err := parseMountInfo(fd)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		var flags uintptr = unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY
+		readonly := false
+		for _, opt := range m.options {
+			switch opt {
+			case "ro":
+				readonly = true
+			case "nosuid":
+				flags |= unix.MS_NOSUID
+			case "nodev":
+				flags |= unix.MS_NODEV
+			case "noexec":
+				flags |= unix.MS_NOEXEC
+			}
+		}
+		if readonly {
+			continue
+		}
+		err = unix.Mount("", m.path, "", flags, "")
+		if err != nil {
+			return fmt.Errorf("failed to remount %s read-only: %v", m.path, err)
+		}
+	}
+	return nil
+}
+
+// dropCapabilities removes the capabilities that are not in keep from the
+// bounding set of the current thread, and clears its inheritable set so that
+// executing a new program only grants the capabilities left in the bounding
+// set
+func dropCapabilities(keep map[uint]bool) error {
+	buf, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return err
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 32)
+	if err != nil {
+		return err
+	}
+	for c := uint(0); c <= uint(last); c++ {
+		if keep[c] {
+			continue
+		}
+		err = unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to drop capability %d: %v", c, err)
+		}
+	}
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	var data [2]capData
+	_, _, errno := syscall.RawSyscall(unix.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to read capabilities: %v", errno)
+	}
+	data[0].inheritable = 0
+	data[1].inheritable = 0
+	_, _, errno = syscall.RawSyscall(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to clear inheritable capabilities: %v", errno)
+	}
+	return nil
+}
+
+// seccompFilter builds a BPF program that kills the process when it makes
+// one of the denied system calls, or a system call of another architecture
+func seccompFilter() []unix.SockFilter {
+	prog := []unix.SockFilter{
+		// load seccomp_data.arch, and kill if it does not match
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 4},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: seccompAuditArch},
+		{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetKillProcess},
+		// load seccomp_data.nr, and kill x32 syscalls
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: 0},
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, Jf: 1, K: x32SyscallBit},
+		{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetKillProcess},
+	}
+	for _, nr := range deniedSyscalls {
+		prog = append(prog,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jf: 1, K: nr},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetKillProcess})
+	}
+	return append(prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: seccompRetAllow})
+}
+
+// installSeccompFilter applies the seccomp filter to all the threads of the
+// process. no_new_privs was set in the setup stage and survived execve.
+func installSeccompFilter() error {
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	r, _, errno := syscall.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTsync,
+		uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %v", errno)
+	}
+	if r != 0 {
+		return fmt.Errorf("failed to install seccomp filter on thread %d", r)
+	}
+	return nil
+}
+
+// killedBySandbox returns true if the seccomp filter killed the process
+func killedBySandbox(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGSYS
+}
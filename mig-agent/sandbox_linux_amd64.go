@@ -0,0 +1,14 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import "golang.org/x/sys/unix"
+
+// seccompAuditArch is the AUDIT_ARCH value of amd64 system calls
+const seccompAuditArch = 0xc000003e
+
+// archDeniedSyscalls are the x86 specific system calls killed by the seccomp filter
+var archDeniedSyscalls = []uint32{unix.SYS_IOPL, unix.SYS_IOPERM}
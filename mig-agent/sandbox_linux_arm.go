@@ -0,0 +1,13 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// seccompAuditArch is the AUDIT_ARCH value of arm system calls
+const seccompAuditArch = 0x40000028
+
+// archDeniedSyscalls are the architecture specific system calls killed by the
+// seccomp filter
+var archDeniedSyscalls = []uint32{}
@@ -0,0 +1,108 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mozilla/mig/modules"
+	"golang.org/x/sys/unix"
+)
+
+func TestCapabilitySet(t *testing.T) {
+	set, err := capabilitySet([]string{"CAP_NET_RAW", "cap_dac_read_search"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 || !set[13] || !set[2] {
+		t.Errorf("unexpected capability set %v", set)
+	}
+	_, err = capabilitySet([]string{"CAP_NET_RAWR"})
+	if err == nil {
+		t.Error("expected unknown capability to be rejected")
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mountinfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 8:2 / /mnt/backup\040disk ro,relatime shared:30 - ext4 /dev/sdb1 ro
+`
+	mounts, err := parseMountInfo(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 3 {
+		t.Fatalf("expected 3 mount points, got %d", len(mounts))
+	}
+	if mounts[1].path != "/proc" || len(mounts[1].options) != 5 {
+		t.Errorf("unexpected mount point %+v", mounts[1])
+	}
+	if mounts[2].path != "/mnt/backup disk" || mounts[2].options[0] != "ro" {
+		t.Errorf("unexpected mount point %+v", mounts[2])
+	}
+	_, err = parseMountInfo(strings.NewReader("22 1 8:1 /\n"))
+	if err == nil {
+		t.Error("expected truncated mountinfo line to be rejected")
+	}
+}
+
+func TestSeccompFilter(t *testing.T) {
+	prog := seccompFilter()
+	// arch check and x32 check, two instructions per denied syscall, allow
+	if len(prog) != 6+2*len(deniedSyscalls)+1 {
+		t.Fatalf("unexpected filter length %d", len(prog))
+	}
+	if prog[1].K != seccompAuditArch {
+		t.Errorf("expected the filter to check the architecture first")
+	}
+	for i, nr := range deniedSyscalls {
+		jeq, ret := prog[6+2*i], prog[7+2*i]
+		if jeq.K != nr || jeq.Jf != 1 || ret.K != seccompRetKillProcess {
+			t.Errorf("syscall %d is not killed by the filter", nr)
+		}
+	}
+	last := prog[len(prog)-1]
+	if last.Code != unix.BPF_RET|unix.BPF_K || last.K != seccompRetAllow {
+		t.Errorf("expected the filter to allow other syscalls")
+	}
+}
+
+func TestSandboxCommand(t *testing.T) {
+	cmd := exec.Command("/usr/bin/mig-agent", "-m", "pkg")
+	_, err := sandboxCommand(cmd, modules.Privileges{Capabilities: []string{"CAP_UNKNOWN"}})
+	if err == nil {
+		t.Error("expected unknown capability to be rejected")
+	}
+	sandboxed, err := sandboxCommand(cmd, modules.Privileges{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sandboxed {
+		// the agent only sandboxes modules when it runs as root
+		return
+	}
+	if cmd.Args[len(cmd.Args)-1] != sandboxSetup {
+		t.Errorf("expected the module to start in the setup stage, got %v", cmd.Args)
+	}
+	if cmd.SysProcAttr.Cloneflags&unix.CLONE_NEWNET == 0 {
+		t.Error("expected a module without network access to get its own network namespace")
+	}
+}
+
+func TestSandboxFailure(t *testing.T) {
+	stderr := []byte("[info] Using builtin conf.\n" +
+		"[critical] failed to sandbox module: enterSandbox() -> unknown sandbox stage \"foo\"\n")
+	err := sandboxFailure(nil, stderr)
+	if err == nil || !strings.HasPrefix(err.Error(), sandboxErrorPrefix) {
+		t.Errorf("expected the sandbox error to be reported, got %v", err)
+	}
+	if err = sandboxFailure(nil, []byte("panic: something else\n")); err != nil {
+		t.Errorf("expected no sandbox error, got %v", err)
+	}
+}
@@ -123,18 +123,19 @@ type templateData struct {
 }
 
 func (t *templateData) importAgentConfig() {
-	t.Immortal = ISIMMORTAL
-	t.InstallService = MUSTINSTALLSERVICE
-	t.DiscoverPublicIP = DISCOVERPUBLICIP
-	t.DiscoverAWSMeta = DISCOVERAWSMETA
-	t.Checkin = CHECKIN
-	t.OnlyVerifyPubkey = ONLYVERIFYPUBKEY
-	t.ExtraPrivacyMode = EXTRAPRIVACYMODE
-	t.RefreshEnv = REFRESHENV
-	t.SpawnPersistent = SPAWNPERSISTENT
-	t.Proxies = PROXIES
-	t.HeartBeatFreq = HEARTBEATFREQ
-	t.ModuleTimeout = MODULETIMEOUT
+	s := settings()
+	t.Immortal = s.isImmortal
+	t.InstallService = s.mustInstallService
+	t.DiscoverPublicIP = s.discoverPulicIP
+	t.DiscoverAWSMeta = s.discoverAWSMeta
+	t.Checkin = s.checkin
+	t.OnlyVerifyPubkey = s.onlyVerifyPubKey
+	t.ExtraPrivacyMode = s.extraPrivacyMode
+	t.RefreshEnv = s.refreshEnv
+	t.SpawnPersistent = s.spawnPersistent
+	t.Proxies = s.proxies
+	t.HeartBeatFreq = s.heartBeatFreq
+	t.ModuleTimeout = s.moduleTimeout
 	t.Version = mig.Version
 }
 
@@ -142,6 +143,7 @@ func initSocket(ctx *Context) {
 	sockCtx = ctx
 	http.HandleFunc("/pid", socketHandlePID)
 	http.HandleFunc("/shutdown", socketHandleShutdown)
+	http.Handle("/metrics", agentMetrics)
 	http.HandleFunc("/", socketHandleStatus)
 	for {
 		err := http.ListenAndServe(ctx.Socket.Bind, nil)
@@ -268,7 +270,7 @@ func socketQuery(bind, query string) (resp string, err error) {
 		}
 		resp = string(respbuf)
 	default:
-		return "", fmt.Errorf("unknown command %q", query)
+		return controlQuery(CONTROLSOCKET, query)
 	}
 	return
 }
@@ -32,7 +32,8 @@ type agentStats struct {
 func (s *agentStats) importAction(a mig.Action, accepted bool) error {
 	s.Lock()
 	defer s.Unlock()
-	if STATSMAXACTIONS == 0 {
+	max := settings().statsMaxActions
+	if max == 0 {
 		return nil
 	}
 	ns := agentStatsAction{}
@@ -40,7 +41,8 @@ func (s *agentStats) importAction(a mig.Action, accepted bool) error {
 	if err != nil {
 		return err
 	}
-	if len(s.Actions) == STATSMAXACTIONS {
+	// the limit may have been lowered by a configuration reload
+	for len(s.Actions) >= max {
 		s.Actions = s.Actions[1:]
 	}
 	s.Actions = append(s.Actions, ns)
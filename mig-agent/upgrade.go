@@ -0,0 +1,552 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// The agent can upgrade itself when it receives an action with an "upgrade"
+// operation. The operation names an active manifest on the API. The agent
+// fetches the manifest and the mig-agent binary it contains, verifies the
+// signatures on the manifest against its manifest keyring the same way the
+// loader does, and checks the hash of the binary against the manifest entry.
+// The new binary must report a version newer than the running agent, unless
+// downgrades are allowed in the configuration. It is then installed in place
+// of the running one, and the agent restarts on it once the results of the
+// operation have been sent.
+//
+// An upgrade is tracked by a state file in the agent runtime directory until
+// the new binary confirms it by sending a heartbeat to the API. If it has not
+// done so before the deadline, the previous binary is put back in place.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/mig-agent/agentcontext"
+	"github.com/mozilla/mig/modules"
+	"github.com/mozilla/mig/pgp"
+)
+
+// upgradeModule is the name of the operation handled by the agent itself to
+// upgrade its binary. Access to it is controlled by the "upgrade" ACL entry.
+const upgradeModule = "upgrade"
+
+// Exit reasons sent on the Terminate channel to restart the agent on a new
+// binary, or on the previous one after a failed upgrade
+const (
+	upgradeExitReason     = "upgrade requested"
+	upgradeRollbackReason = "upgrade rolled back"
+)
+
+// upgradeStateFile is the name of the file in the agent runtime directory
+// that tracks an upgrade until the new binary confirms it
+const upgradeStateFile = "upgrade.json"
+
+// upgradeParameters are the parameters of an upgrade operation
+type upgradeParameters struct {
+	ManifestID float64 `json:"manifestid"`
+}
+
+// upgradeState describes an upgrade waiting for confirmation. The deadline is
+// set when the new binary starts for the first time.
+type upgradeState struct {
+	CommandID float64   `json:"commandid"`
+	SHA256    string    `json:"sha256"`
+	Previous  string    `json:"previous"`
+	Started   bool      `json:"started"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+}
+
+// runUpgrade executes an upgrade operation. Like runModule, it always sends
+// a result for the operation, and only returns an error for logging.
+func runUpgrade(ctx *Context, op moduleOp) (err error) {
+	var result moduleResult
+	result.id = op.id
+	result.position = op.position
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("runUpgrade() -> %v", e)
+			result.err = err
+			result.status = mig.StatusFailed
+		}
+		removeRunningOp(op.id)
+		op.resultChan <- result
+		ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: "leaving runUpgrade()"}.Debug()
+	}()
+
+	if op.isCompressed {
+		panic("compressed parameters are not supported by the upgrade operation")
+	}
+	var params upgradeParameters
+	buf, err := json.Marshal(op.params)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		panic(err)
+	}
+	if params.ManifestID <= 0 {
+		panic("upgrade operation has no valid manifest ID")
+	}
+	ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("upgrading agent from manifest %.0f", params.ManifestID)}
+
+	mr, bin, err := fetchUpgrade(params.ManifestID)
+	if err != nil {
+		panic(err)
+	}
+	newhash, err := verifyUpgrade(&mr, bin, MANIFESTPGPKEYS, settings().upgradeSignatures)
+	if err != nil {
+		panic(err)
+	}
+	oldhash, err := hashFile(ctx.Agent.BinPath)
+	if err != nil {
+		panic(err)
+	}
+	elements := map[string]string{"previous": oldhash, "installed": newhash}
+	result.status = mig.StatusSuccess
+	if oldhash == newhash {
+		ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: "agent is already running the manifest binary"}
+		result.output = modules.Result{Success: true, Elements: elements}
+		return
+	}
+
+	previous, err := installUpgrade(ctx.Agent.BinPath, bin, settings().allowDowngrade)
+	if err != nil {
+		panic(err)
+	}
+	st := upgradeState{CommandID: op.commandID, SHA256: newhash, Previous: previous}
+	err = writeUpgradeState(ctx.Agent.RunDir, st)
+	if err != nil {
+		// without a state file, the upgrade could not be rolled back
+		rerr := restoreBinary(ctx.Agent.BinPath, previous)
+		if rerr != nil {
+			err = fmt.Errorf("%v, and restoring the previous binary failed: %v", err, rerr)
+		}
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: op.id, Desc: fmt.Sprintf("installed agent binary %s, restarting once results are sent", newhash)}
+	result.output = modules.Result{Success: true, FoundAnything: true, Elements: elements}
+	return
+}
+
+// fetchUpgrade retrieves an active manifest and the agent binary it contains
+// from the API
+func fetchUpgrade(manifestid float64) (mr mig.ManifestResponse, bin []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("fetchUpgrade() -> %v", e)
+		}
+	}()
+	murl, err := url.Parse(settings().apiURL)
+	if err != nil {
+		panic(err)
+	}
+	murl.Path = path.Join(murl.Path, "manifest", "upgrade") + "/"
+	murl.RawQuery = url.Values{"manifestid": {fmt.Sprintf("%.0f", manifestid)}}.Encode()
+	lkey, err := ioutil.ReadFile(settings().loaderKeyFile)
+	if err != nil {
+		panic(fmt.Sprintf("failed to read the loader key the agent authenticates with: %v", err))
+	}
+	req, err := http.NewRequest("GET", murl.String(), nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("X-LOADERKEY", strings.TrimSpace(string(lkey)))
+	client := http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	var resource *cljs.Resource
+	err = json.Unmarshal(body, &resource)
+	if err != nil {
+		panic(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		panic(fmt.Sprintf("HTTP %v, API call failed with error '%v' (code %s)", resp.StatusCode,
+			resource.Collection.Error.Message, resource.Collection.Error.Code))
+	}
+	if len(resource.Collection.Items) != 1 {
+		panic("API returned no upgrade")
+	}
+	var fetchresp mig.ManifestFetchResponse
+	for _, data := range resource.Collection.Items[0].Data {
+		buf, err := json.Marshal(data.Value)
+		if err != nil {
+			panic(err)
+		}
+		switch data.Name {
+		case "manifest":
+			err = json.Unmarshal(buf, &mr)
+		case "content":
+			err = json.Unmarshal(buf, &fetchresp)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(fetchresp.Data))
+	if err != nil {
+		panic(err)
+	}
+	bin, err = ioutil.ReadAll(gz)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// verifyUpgrade checks that a manifest carries enough valid signatures from
+// the manifest keys, and that the binary matches its mig-agent entry. It
+// returns the hash of the binary.
+func verifyUpgrade(mr *mig.ManifestResponse, bin []byte, keys []string, required int) (hash string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("verifyUpgrade() -> %v", e)
+		}
+	}()
+	if len(keys) == 0 {
+		panic("no manifest keys are configured, upgrades are disabled")
+	}
+	var armored [][]byte
+	for _, k := range keys {
+		armored = append(armored, []byte(k))
+	}
+	keyring, _, err := pgp.ArmoredKeysToKeyring(armored)
+	if err != nil {
+		panic(err)
+	}
+	cnt, err := mr.VerifySignatures(keyring)
+	if err != nil {
+		panic(err)
+	}
+	// a manifest is never accepted without a signature, whatever the configuration
+	if cnt < 1 || cnt < required {
+		panic(fmt.Sprintf("not enough valid signatures on manifest (got %d, need %d)", cnt, required))
+	}
+	var expect string
+	for _, entry := range mr.Entries {
+		if entry.Name == "mig-agent" {
+			expect = entry.SHA256
+			break
+		}
+	}
+	if expect == "" {
+		panic("manifest has no mig-agent entry")
+	}
+	hash = fmt.Sprintf("%x", sha256.Sum256(bin))
+	if hash != expect {
+		panic(fmt.Sprintf("agent binary hash %s does not match manifest entry %s", hash, expect))
+	}
+	return
+}
+
+// installUpgrade writes the new binary next to the running one, checks that
+// it executes and is newer than the running agent, and swaps it in. The
+// running binary is kept for rollbacks, and its new location is returned.
+func installUpgrade(binpath string, bin []byte, allowDowngrade bool) (previous string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("installUpgrade() -> %v", e)
+		}
+	}()
+	// keep the extension last, windows needs it to execute the staged binary
+	ext := filepath.Ext(binpath)
+	staged := strings.TrimSuffix(binpath, ext) + ".upgrade" + ext
+	previous = binpath + ".old"
+	err = ioutil.WriteFile(staged, bin, 0700)
+	if err != nil {
+		panic(err)
+	}
+	// a binary that cannot print its version would never heartbeat, and could
+	// not roll itself back. catch it before it is installed.
+	out, err := exec.Command(staged, "-V").Output()
+	if err != nil {
+		os.Remove(staged)
+		panic(fmt.Sprintf("staged binary failed to execute: %v", err))
+	}
+	version := strings.TrimSpace(string(out))
+	if version == "" {
+		os.Remove(staged)
+		panic("staged binary returned no version")
+	}
+	// the binary was verified against the manifest before it was executed,
+	// so the version it reports is the version of the manifest
+	if !allowDowngrade && !newerVersion(version, mig.Version) {
+		os.Remove(staged)
+		panic(fmt.Sprintf("staged binary version %s is not newer than running version %s, and downgrades are not allowed",
+			version, mig.Version))
+	}
+	os.Remove(previous)
+	err = os.Rename(binpath, previous)
+	if err != nil {
+		os.Remove(staged)
+		panic(err)
+	}
+	err = os.Rename(staged, binpath)
+	if err != nil {
+		os.Rename(previous, binpath)
+		panic(err)
+	}
+	return
+}
+
+// Agent versions are yearmonthdate-release.commit.env on linux and darwin, and
+// year.month.date.release on windows
+var (
+	unixVersionRe    = regexp.MustCompile(`^([0-9]{8})-([0-9]+)\.`)
+	windowsVersionRe = regexp.MustCompile(`^([0-9]{2})\.([0-9]{2})\.([0-9]{2})\.([0-9]+)$`)
+)
+
+// parseVersion returns the build date and release of an agent version. ok is
+// false if the version does not follow the format set by the Makefile, such
+// as the "0.unversioned" of development builds.
+func parseVersion(v string) (date, release int, ok bool) {
+	var err error
+	if m := unixVersionRe.FindStringSubmatch(v); m != nil {
+		date, err = strconv.Atoi(m[1])
+		if err != nil {
+			return
+		}
+		release, err = strconv.Atoi(m[2])
+		return date, release, err == nil
+	}
+	if m := windowsVersionRe.FindStringSubmatch(v); m != nil {
+		var parts [4]int
+		for i := range parts {
+			parts[i], err = strconv.Atoi(m[i+1])
+			if err != nil {
+				return
+			}
+		}
+		return 20000000 + parts[0]*10000 + parts[1]*100 + parts[2], parts[3], true
+	}
+	return
+}
+
+// newerVersion returns true if agent version v is strictly newer than version
+// running. Versions that cannot be compared are never newer.
+func newerVersion(v, running string) bool {
+	date, release, ok := parseVersion(v)
+	if !ok {
+		return false
+	}
+	rdate, rrelease, ok := parseVersion(running)
+	if !ok {
+		return false
+	}
+	if date != rdate {
+		return date > rdate
+	}
+	return release > rrelease
+}
+
+// restoreBinary puts the previous binary back in place of a failed upgrade.
+// The failed binary is moved aside first, as windows does not allow replacing
+// the binary of a running process.
+func restoreBinary(binpath, previous string) (err error) {
+	failed := binpath + ".failed"
+	os.Remove(failed)
+	err = os.Rename(binpath, failed)
+	if err != nil {
+		return
+	}
+	err = os.Rename(previous, binpath)
+	if err != nil {
+		os.Rename(failed, binpath)
+	}
+	return
+}
+
+// hashFile returns the hex encoded SHA256 of a file
+func hashFile(p string) (string, error) {
+	fd, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, fd)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func readUpgradeState(rundir string) (st upgradeState, ok bool, err error) {
+	buf, err := ioutil.ReadFile(path.Join(rundir, upgradeStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(buf, &st)
+	if err != nil {
+		return
+	}
+	return st, true, nil
+}
+
+func writeUpgradeState(rundir string, st upgradeState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(rundir, upgradeStateFile), buf)
+}
+
+// checkUpgrade is called when the agent starts. The first start of a new
+// binary sets the deadline by which it must confirm the upgrade. If the
+// deadline has passed on a later start, the previous binary is restored and
+// an error is returned, so the agent restarts on it.
+func checkUpgrade(ctx Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("checkUpgrade() -> %v", e)
+		}
+	}()
+	st, ok, err := readUpgradeState(ctx.Agent.RunDir)
+	if err != nil {
+		panic(err)
+	}
+	if !ok {
+		return
+	}
+	if st.Started {
+		if time.Now().Before(st.Deadline) {
+			return
+		}
+		err = rollbackUpgrade(ctx, st)
+		if err != nil {
+			panic(err)
+		}
+		panic(fmt.Sprintf("upgrade to %s was not confirmed before %s, rolled back to the previous binary",
+			st.SHA256, st.Deadline.Format(time.RFC3339)))
+	}
+	hash, err := hashFile(ctx.Agent.BinPath)
+	if err != nil {
+		panic(err)
+	}
+	if hash != st.SHA256 {
+		// the upgrade was replaced by another installation, forget about it
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("discarding upgrade state for binary %s", st.SHA256)}.Info()
+		return os.Remove(path.Join(ctx.Agent.RunDir, upgradeStateFile))
+	}
+	st.Started = true
+	st.Deadline = time.Now().Add(settings().upgradeTimeout)
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("running upgraded binary %s, must heartbeat before %s",
+		st.SHA256, st.Deadline.Format(time.RFC3339))}
+	return writeUpgradeState(ctx.Agent.RunDir, st)
+}
+
+// watchUpgrade rolls back an upgrade that is not confirmed by its deadline,
+// and restarts the agent on the previous binary
+func watchUpgrade(ctx *Context) {
+	st, ok, err := readUpgradeState(ctx.Agent.RunDir)
+	if err != nil || !ok || !st.Started {
+		return
+	}
+	time.Sleep(st.Deadline.Sub(time.Now()))
+	st, ok, err = readUpgradeState(ctx.Agent.RunDir)
+	if err != nil || !ok || !st.Started {
+		return
+	}
+	err = rollbackUpgrade(*ctx, st)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("failed to roll back upgrade: %v", err)}.Emerg()
+		return
+	}
+	ctx.Channels.Terminate <- upgradeRollbackReason
+}
+
+// confirmUpgrade marks an upgrade as successful once the new binary has sent
+// a heartbeat
+func confirmUpgrade(ctx *Context) {
+	st, ok, err := readUpgradeState(ctx.Agent.RunDir)
+	if err != nil || !ok || !st.Started {
+		return
+	}
+	err = os.Remove(path.Join(ctx.Agent.RunDir, upgradeStateFile))
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("failed to confirm upgrade: %v", err)}.Err()
+		return
+	}
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("upgrade to %s confirmed", st.SHA256)}
+}
+
+func rollbackUpgrade(ctx Context, st upgradeState) (err error) {
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("upgrade to %s was not confirmed in time, rolling back", st.SHA256)}.Emerg()
+	err = restoreBinary(ctx.Agent.BinPath, st.Previous)
+	if err != nil {
+		return
+	}
+	return os.Remove(path.Join(ctx.Agent.RunDir, upgradeStateFile))
+}
+
+// upgradeRequested returns true if the command installed a new binary the
+// agent has not restarted on yet
+func upgradeRequested(ctx *Context, id float64) bool {
+	st, ok, err := readUpgradeState(ctx.Agent.RunDir)
+	return err == nil && ok && !st.Started && st.CommandID == id
+}
+
+// initManifestKeyring loads the keys used to verify the signatures on upgrade
+// manifests from the manifestkeys directory of the agent configuration
+func initManifestKeyring(orig_ctx Context) (ctx Context, err error) {
+	ctx = orig_ctx
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("initManifestKeyring() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{Desc: "leaving initManifestKeyring()"}.Debug()
+	}()
+
+	krdir := path.Join(agentcontext.GetConfDir(), "manifestkeys")
+	files, err := ioutil.ReadDir(krdir)
+	if err != nil && os.IsNotExist(err) {
+		return ctx, nil
+	} else if err != nil {
+		panic(err)
+	}
+	MANIFESTPGPKEYS = MANIFESTPGPKEYS[:0]
+	for _, x := range files {
+		keypath := path.Join(krdir, x.Name())
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("loading manifest key from %v", keypath)}.Info()
+		buf, err := ioutil.ReadFile(keypath)
+		if err != nil {
+			panic(err)
+		}
+		_, err = pgp.LoadArmoredPubKey(buf)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("ignoring invalid key %v: %v", keypath, err)}.Warning()
+			continue
+		}
+		MANIFESTPGPKEYS = append(MANIFESTPGPKEYS, string(buf))
+	}
+	return
+}
@@ -0,0 +1,262 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/pgp"
+)
+
+func TestVerifyUpgrade(t *testing.T) {
+	pub, priv, fp, err := pgp.GenerateKeyPair("admin", "", "admin@example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := []byte("new agent binary")
+	// sign the manifest the same way the API does
+	signedManifest := func() mig.ManifestResponse {
+		mr := mig.ManifestResponse{
+			Entries: []mig.ManifestEntry{
+				{Name: "mig-agent", SHA256: fmt.Sprintf("%x", sha256.Sum256(bin))},
+				{Name: "configuration", SHA256: "0000"},
+			},
+			Signatures: []string{},
+		}
+		buf, err := json.Marshal(mr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secring, _, err := pgp.ArmoredKeysToKeyring([][]byte{priv})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := pgp.Sign(string(buf), fp, secring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr.Signatures = []string{sig}
+		mr.LoaderName = "ignored"
+		return mr
+	}
+	keys := []string{string(pub)}
+
+	mr := signedManifest()
+	hash, err := verifyUpgrade(&mr, bin, keys, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != fmt.Sprintf("%x", sha256.Sum256(bin)) {
+		t.Errorf("unexpected hash %s", hash)
+	}
+	mr = signedManifest()
+	if _, err = verifyUpgrade(&mr, bin, keys, 2); err == nil {
+		t.Error("expected a manifest without enough signatures to be rejected")
+	}
+	mr = signedManifest()
+	if _, err = verifyUpgrade(&mr, []byte("tampered binary"), keys, 1); err == nil {
+		t.Error("expected a binary that does not match the manifest to be rejected")
+	}
+	mr = signedManifest()
+	if _, err = verifyUpgrade(&mr, bin, nil, 1); err == nil {
+		t.Error("expected upgrades to be refused without manifest keys")
+	}
+	mr = signedManifest()
+	mr.Entries[1].SHA256 = "1111"
+	if _, err = verifyUpgrade(&mr, bin, keys, 0); err == nil {
+		t.Error("expected a modified manifest to be rejected")
+	}
+}
+
+func TestUpgradeRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migupgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ctx Context
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+	ctx.Agent.RunDir = dir
+	ctx.Agent.BinPath = path.Join(dir, "mig-agent")
+	previous := ctx.Agent.BinPath + ".old"
+	err = ioutil.WriteFile(previous, []byte("old"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(ctx.Agent.BinPath, []byte("new"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := hashFile(ctx.Agent.BinPath)
+	err = writeUpgradeState(dir, upgradeState{CommandID: 1234, SHA256: hash, Previous: previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upgradeRequested(&ctx, 1234) {
+		t.Error("expected the upgrade command to request a restart")
+	}
+
+	// the first start of the new binary sets the deadline
+	err = checkUpgrade(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok, err := readUpgradeState(dir)
+	if err != nil || !ok || !st.Started || st.Deadline.IsZero() {
+		t.Fatalf("expected the upgrade to be started, got %+v %v", st, err)
+	}
+	if upgradeRequested(&ctx, 1234) {
+		t.Error("expected a started upgrade not to request a restart")
+	}
+
+	// a later start after the deadline rolls back
+	st.Deadline = time.Now().Add(-time.Minute)
+	err = writeUpgradeState(dir, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = checkUpgrade(ctx); err == nil {
+		t.Fatal("expected the expired upgrade to be rolled back")
+	}
+	buf, err := ioutil.ReadFile(ctx.Agent.BinPath)
+	if err != nil || string(buf) != "old" {
+		t.Errorf("expected the previous binary to be restored, got %q %v", buf, err)
+	}
+	if _, ok, _ = readUpgradeState(dir); ok {
+		t.Error("expected the upgrade state to be removed after a rollback")
+	}
+}
+
+func TestUpgradeConfirm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migupgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ctx Context
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+	ctx.Agent.RunDir = dir
+
+	// the agent that installed the upgrade does not confirm it
+	err = writeUpgradeState(dir, upgradeState{SHA256: "abcd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmUpgrade(&ctx)
+	if _, ok, _ := readUpgradeState(dir); !ok {
+		t.Error("expected the upgrade to wait for the new binary")
+	}
+	err = writeUpgradeState(dir, upgradeState{SHA256: "abcd", Started: true, Deadline: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmUpgrade(&ctx)
+	if _, ok, _ := readUpgradeState(dir); ok {
+		t.Error("expected the upgrade to be confirmed")
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	var tests = []struct {
+		v, running string
+		newer      bool
+	}{
+		{"20170914-0.06824ce0.prod", "20170913-0.06824ce0.prod", true},
+		{"20170913-1.a1b2c3d4.prod", "20170913-0.06824ce0.prod", true},
+		{"20170913-0.a1b2c3d4.prod", "20170913-0.06824ce0.prod", false},
+		{"20170913-0.06824ce0.prod", "20170913-0.06824ce0.prod", false},
+		{"20170912-3.06824ce0.prod", "20170913-0.06824ce0.prod", false},
+		{"17.09.14.0", "17.09.13.0", true},
+		{"17.09.13.0", "17.09.13.1", false},
+		{"17.09.14.0", "20170913-0.06824ce0.prod", true},
+		{"20170914-0.06824ce0.prod", "0.unversioned", false},
+		{"0.unversioned", "20170913-0.06824ce0.prod", false},
+	}
+	for _, tc := range tests {
+		if newerVersion(tc.v, tc.running) != tc.newer {
+			t.Errorf("expected newerVersion(%q, %q) to be %v", tc.v, tc.running, tc.newer)
+		}
+	}
+}
+
+func TestInstallUpgradeDowngrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the staged binary is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "migupgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origVersion := mig.Version
+	defer func() { mig.Version = origVersion }()
+	mig.Version = "20170913-0.06824ce0.prod"
+
+	binpath := path.Join(dir, "mig-agent")
+	script := func(version string) []byte {
+		return []byte("#!/bin/sh\necho " + version + "\n")
+	}
+	running := script(mig.Version)
+	err = ioutil.WriteFile(binpath, running, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an older binary is refused and the running one is left in place
+	_, err = installUpgrade(binpath, script("20170912-0.a1b2c3d4.prod"), false)
+	if err == nil {
+		t.Fatal("expected a downgrade to be refused")
+	}
+	buf, err := ioutil.ReadFile(binpath)
+	if err != nil || !bytes.Equal(buf, running) {
+		t.Errorf("expected the running binary to be left in place, got %q %v", buf, err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Errorf("expected the staged binary to be removed, got %d files %v", len(files), err)
+	}
+	// the same version is not newer either
+	if _, err = installUpgrade(binpath, script(mig.Version), false); err == nil {
+		t.Error("expected an upgrade to the running version to be refused")
+	}
+	// downgrades are installed when allowed
+	older := script("20170912-0.a1b2c3d4.prod")
+	previous, err := installUpgrade(binpath, older, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ = ioutil.ReadFile(binpath)
+	if !bytes.Equal(buf, older) {
+		t.Errorf("expected the older binary to be installed, got %q", buf)
+	}
+	buf, _ = ioutil.ReadFile(previous)
+	if !bytes.Equal(buf, running) {
+		t.Errorf("expected the running binary to be kept in %s, got %q", previous, buf)
+	}
+	// and newer binaries always are
+	if _, err = installUpgrade(binpath, script("20170914-0.a1b2c3d4.prod"), false); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,183 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/pgp"
+
+	"github.com/jvehent/cljs"
+)
+
+// getACLPolicies returns the policies agent ACLs are rendered from
+func getACLPolicies(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getACLPolicies()"}.Debug()
+	}()
+	policies, err := ctx.DB.ACLPolicies()
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range policies {
+		err = resource.AddItem(cljs.Item{
+			Href: fmt.Sprintf("%s/acl/policy", ctx.Server.BaseURL),
+			Data: []cljs.Data{{Name: "aclpolicy", Value: p}},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// setACLPolicy creates or replaces the ACL policy of a module. The policy
+// is sent as a JSON document in the policy parameter.
+func setACLPolicy(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setACLPolicy()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var p mig.ACLPolicy
+	err = json.Unmarshal([]byte(request.FormValue("policy")), &p)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid policy parameter: %v", err))
+	}
+	err = ctx.DB.SetACLPolicy(p)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("ACL policy of module %s updated", p.Module)}
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/acl/policy", ctx.Server.BaseURL),
+		Data: []cljs.Data{{Name: "aclpolicy", Value: p}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// deleteACLPolicy removes the ACL policy of a module
+func deleteACLPolicy(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving deleteACLPolicy()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	module := request.FormValue("module")
+	if module == "" {
+		panic("Module must not be empty")
+	}
+	err = ctx.DB.DeleteACLPolicy(module)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("ACL policy of module %s deleted", module)}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// getAuthBundle renders the ACL and keyring of agents from the ACL policies and
+// the current keys of investigators. The bundle is returned unsigned, and must be
+// signed by investigators before it is distributed to agents.
+func getAuthBundle(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getAuthBundle()"}.Debug()
+	}()
+	policies, err := ctx.DB.ACLPolicies()
+	if err != nil {
+		panic(err)
+	}
+	if len(policies) == 0 {
+		panic("No ACL policy is defined")
+	}
+	now := time.Now()
+	var invs []mig.Investigator
+	seen := make(map[float64]bool)
+	for _, p := range policies {
+		for _, w := range p.Weights {
+			if seen[w.InvestigatorID] {
+				continue
+			}
+			seen[w.InvestigatorID] = true
+			inv, err := ctx.DB.InvestigatorByID(w.InvestigatorID)
+			if err != nil {
+				panic(err)
+			}
+			inv.Keys, err = ctx.DB.InvestigatorKeys(w.InvestigatorID)
+			if err != nil {
+				panic(err)
+			}
+			// keys that are revoked or expired in the key itself are
+			// left out, like they are from the keyring of the API
+			for i := range inv.Keys {
+				err = pgp.CheckArmoredPubKeyValidity(inv.Keys[i].PublicKey, now)
+				if err != nil {
+					ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("skipping investigator key: %v", err)}.Warning()
+					inv.Keys[i].Status = mig.StatusRevokedKey
+				}
+			}
+			invs = append(invs, inv)
+		}
+	}
+	bundle, err := mig.NewAuthBundle(policies, invs, now)
+	if err != nil {
+		panic(err)
+	}
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/acl/bundle", ctx.Server.BaseURL),
+		Data: []cljs.Data{{Name: "authbundle", Value: bundle}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
@@ -48,8 +48,12 @@ func createAction(respWriter http.ResponseWriter, request *http.Request) {
 	}
 	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("Received action for creation '%v'", action)}.Debug()
 
-	// Init action fields
-	action.ID = mig.GenID()
+	// Init action fields. The ID of version 3 actions is chosen by the signer
+	// and covered by the signatures, a reused ID or nonce is rejected when
+	// the action is written to the database.
+	if action.SyntaxVersion != mig.ActionVersion {
+		action.ID = mig.GenID()
+	}
 	date0 := time.Date(0011, time.January, 11, 11, 11, 11, 11, time.UTC)
 	date1 := time.Date(9998, time.January, 11, 11, 11, 11, 11, time.UTC)
 	action.StartTime = date0
@@ -71,18 +75,13 @@ func createAction(respWriter http.ResponseWriter, request *http.Request) {
 	if err != nil {
 		panic(err)
 	}
-	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: action.ID, Desc: "Received new action with valid signature"}
-
-	// write action to database
-	err = ctx.DB.InsertAction(action)
-	if err != nil {
-		panic(err)
-	}
-	// write signatures to database
+	// find the investigators that signed the action, with keys that are
+	// still valid
 	astr, err := action.String()
 	if err != nil {
 		panic(err)
 	}
+	var signers []mig.Investigator
 	for _, sig := range action.PGPSignatures {
 		k, err := getKeyring()
 		if err != nil {
@@ -92,11 +91,22 @@ func createAction(respWriter http.ResponseWriter, request *http.Request) {
 		if err != nil {
 			panic(err)
 		}
-		inv, err := ctx.DB.InvestigatorByFingerprint(fp)
+		inv, err := signingInvestigator(fp)
 		if err != nil {
 			panic(err)
 		}
-		err = ctx.DB.InsertSignature(action.ID, inv.ID, sig)
+		signers = append(signers, inv)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: action.ID, Desc: "Received new action with valid signature"}
+
+	// write action to database
+	err = ctx.DB.InsertAction(action)
+	if err != nil {
+		panic(err)
+	}
+	// write signatures to database
+	for i, sig := range action.PGPSignatures {
+		err = ctx.DB.InsertSignature(action.ID, signers[i].ID, sig)
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// streamKeepAlive is the interval at which a comment is written on idle
+// streams to prevent proxies from closing the connection
+const streamKeepAlive = 15 * time.Second
+
+// actionStreams dispatches the action events received from the database
+// to the clients following an action
+var actionStreams = actionStreamBroker{
+	subscribers: make(map[float64]map[chan mig.ActionEvent]bool),
+}
+
+// actionStreamBroker keeps track of the clients subscribed to the events of
+// each action. A single database listener feeds all subscribers, so the number
+// of clients following an action does not increase the load on the database.
+type actionStreamBroker struct {
+	sync.Mutex
+	enabled     bool
+	subscribers map[float64]map[chan mig.ActionEvent]bool
+}
+
+// subscribe returns a channel that receives the events of action aid
+func (b *actionStreamBroker) subscribe(aid float64) chan mig.ActionEvent {
+	b.Lock()
+	defer b.Unlock()
+	c := make(chan mig.ActionEvent, 64)
+	if _, ok := b.subscribers[aid]; !ok {
+		b.subscribers[aid] = make(map[chan mig.ActionEvent]bool)
+	}
+	b.subscribers[aid][c] = true
+	return c
+}
+
+// unsubscribe stops the delivery of events to channel c
+func (b *actionStreamBroker) unsubscribe(aid float64, c chan mig.ActionEvent) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers[aid], c)
+	if len(b.subscribers[aid]) == 0 {
+		delete(b.subscribers, aid)
+	}
+}
+
+// dispatch sends an event to the subscribers of its action. Subscribers that
+// are too slow to consume their events lose them rather than blocking others.
+func (b *actionStreamBroker) dispatch(ev mig.ActionEvent) {
+	b.Lock()
+	defer b.Unlock()
+	for c := range b.subscribers[ev.ActionID] {
+		select {
+		case c <- ev:
+		default:
+		}
+	}
+}
+
+// startActionStreams listens for action events published by the schedulers
+// and dispatches them to the stream subscribers
+func startActionStreams() (err error) {
+	events, err := ctx.DB.ListenActionEvents()
+	if err != nil {
+		return
+	}
+	actionStreams.Lock()
+	actionStreams.enabled = true
+	actionStreams.Unlock()
+	go func() {
+		for ev := range events {
+			actionStreams.dispatch(ev)
+		}
+	}()
+	return
+}
+
+// streamAction sends the progress of an action to the client as a stream of
+// server-sent events, until the action completes or expires
+func streamAction(respWriter http.ResponseWriter, request *http.Request) {
+	var (
+		err    error
+		a      mig.Action
+		events int
+	)
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving streamAction()"}.Debug()
+	}()
+	actionID, err := strconv.ParseFloat(request.URL.Query().Get("actionid"), 64)
+	if err != nil || actionID <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Action ID '%s'", request.URL.Query().Get("actionid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	actionStreams.Lock()
+	enabled := actionStreams.enabled
+	actionStreams.Unlock()
+	if !enabled {
+		// clients are expected to fall back to polling the action
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: "Action streaming is not available"})
+		respond(http.StatusServiceUnavailable, resource, respWriter, request)
+		return
+	}
+	a, err = ctx.DB.ActionByID(actionID)
+	if err != nil {
+		if a.ID == -1 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Action ID '%.0f' not found", actionID)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	flusher, ok := respWriter.(http.Flusher)
+	if !ok {
+		panic("streaming is not supported by the http server")
+	}
+
+	// subscribe before sending the initial state so no event is missed
+	// between the two
+	sub := actionStreams.subscribe(a.ID)
+	defer actionStreams.unsubscribe(a.ID, sub)
+	metricActionStreams.Inc()
+	defer metricActionStreams.Dec()
+
+	respWriter.Header().Set("Content-Type", "text/event-stream")
+	respWriter.Header().Set("Cache-Control", "no-cache")
+	respWriter.Header().Set("Connection", "keep-alive")
+	respWriter.WriteHeader(http.StatusOK)
+	start := time.Now()
+	defer func() {
+		ctx.Channels.Log <- mig.Log{
+			OpID:     opid,
+			ActionID: a.ID,
+			Desc: fmt.Sprintf("src=%s category=investigator auth=[%s %.0f] %s %s %s resp_code=%d events=%d duration=%s user-agent=%s",
+				remotePublicIP(request), getInvName(request), getInvID(request), request.Method, request.Proto,
+				request.URL.String(), http.StatusOK, events, time.Now().Sub(start).String(), request.UserAgent()),
+		}
+	}()
+
+	// send the current state of the action first, and stop here if it
+	// is already over
+	ev := mig.ActionEvent{
+		Type:     mig.ActionEventProgress,
+		ActionID: a.ID,
+		Status:   a.Status,
+		Counters: a.Counters,
+		Time:     time.Now().UTC(),
+	}
+	if actionIsOver(a) {
+		ev.Type = mig.ActionEventDone
+	}
+	err = writeActionEvent(respWriter, ev)
+	if err != nil {
+		return
+	}
+	events++
+	flusher.Flush()
+	if ev.Type == mig.ActionEventDone {
+		return
+	}
+
+	expiration := time.NewTimer(a.ExpireAfter.Add(10 * time.Second).Sub(time.Now()))
+	defer expiration.Stop()
+	keepalive := time.NewTicker(streamKeepAlive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev = <-sub:
+			err = writeActionEvent(respWriter, ev)
+			if err != nil {
+				return
+			}
+			events++
+			flusher.Flush()
+			if ev.Type == mig.ActionEventDone {
+				return
+			}
+		case <-keepalive.C:
+			_, err = fmt.Fprintf(respWriter, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-expiration.C:
+			// the action expired without the scheduler marking it done,
+			// send the final counters and close the stream
+			a, err = ctx.DB.ActionByID(a.ID)
+			if err != nil {
+				ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: a.ID, Desc: fmt.Sprintf("%v", err)}.Err()
+				return
+			}
+			writeActionEvent(respWriter, mig.ActionEvent{
+				Type:     mig.ActionEventDone,
+				ActionID: a.ID,
+				Status:   a.Status,
+				Counters: a.Counters,
+				Time:     time.Now().UTC(),
+			})
+			events++
+			flusher.Flush()
+			return
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// actionIsOver returns true if action a will not receive further updates
+func actionIsOver(a mig.Action) bool {
+	switch a.Status {
+	case "pending", "scheduled", "preparing", "inflight":
+		return time.Now().After(a.ExpireAfter.Add(10 * time.Second))
+	}
+	return true
+}
+
+// writeActionEvent writes an action event in the server-sent events format
+func writeActionEvent(w http.ResponseWriter, ev mig.ActionEvent) (err error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return
+}
@@ -0,0 +1,83 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+)
+
+func TestActionStreamBroker(t *testing.T) {
+	b := actionStreamBroker{subscribers: make(map[float64]map[chan mig.ActionEvent]bool)}
+	c1 := b.subscribe(1)
+	c2 := b.subscribe(1)
+	other := b.subscribe(2)
+
+	b.dispatch(mig.ActionEvent{Type: mig.ActionEventProgress, ActionID: 1})
+	for _, c := range []chan mig.ActionEvent{c1, c2} {
+		select {
+		case ev := <-c:
+			if ev.ActionID != 1 {
+				t.Errorf("unexpected event %+v", ev)
+			}
+		default:
+			t.Error("expected the event to be delivered to all subscribers of the action")
+		}
+	}
+	select {
+	case ev := <-other:
+		t.Errorf("unexpected event %+v delivered to the subscriber of another action", ev)
+	default:
+	}
+
+	// a subscriber that does not consume its events does not block others
+	for i := 0; i < cap(c1)+10; i++ {
+		b.dispatch(mig.ActionEvent{Type: mig.ActionEventCommand, ActionID: 1, CommandID: float64(i)})
+		<-c2
+	}
+	if len(c1) != cap(c1) {
+		t.Errorf("expected the buffer of the slow subscriber to be full, got %d events", len(c1))
+	}
+
+	b.unsubscribe(1, c1)
+	if len(b.subscribers[1]) != 1 {
+		t.Errorf("expected 1 subscriber left on action 1, got %d", len(b.subscribers[1]))
+	}
+	b.unsubscribe(1, c2)
+	b.unsubscribe(2, other)
+	if len(b.subscribers) != 0 {
+		t.Errorf("expected no action left in the broker, got %d", len(b.subscribers))
+	}
+	// dispatching without subscribers is a no-op
+	b.dispatch(mig.ActionEvent{Type: mig.ActionEventDone, ActionID: 1})
+}
+
+func TestStartActionStreams(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+	ctx.DB = db
+	err := startActionStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := actionStreams.subscribe(42)
+	defer actionStreams.unsubscribe(42, c)
+	err = db.NotifyActionEvent(mig.ActionEvent{Type: mig.ActionEventDone, ActionID: 42, Status: "completed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-c:
+		if ev.Type != mig.ActionEventDone || ev.Status != "completed" {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event published in the database was not delivered to the subscriber")
+	}
+}
@@ -65,6 +65,78 @@ func getAgent(respWriter http.ResponseWriter, request *http.Request) {
 	respond(http.StatusOK, resource, respWriter, request)
 }
 
+// setAgentLifecycle applies a lifecycle operation (quarantine, decommission or
+// release) to the endpoint an agent runs on. The status change applies to all
+// the agents that share the queue location of the target agent.
+func setAgentLifecycle(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setAgentLifecycle()"}.Debug()
+	}()
+	err = request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	agentID, err := strconv.ParseFloat(request.FormValue("agentid"), 64)
+	if err != nil || agentID <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Agent ID '%s'", request.FormValue("agentid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	operation := request.FormValue("operation")
+	to, from, err := mig.AgentLifecycleTransition(operation)
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	agt, err := ctx.DB.AgentByID(agentID)
+	if err != nil {
+		if fmt.Sprintf("%v", err) == "Error while retrieving agent: 'sql: no rows in result set'" {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Agent ID '%.0f' not found", agentID)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	count, err := ctx.DB.SetEndpointLifecycle(agt.QueueLoc, to, from)
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		resource.SetError(cljs.Error{
+			Code: fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Cannot %s endpoint '%s' from status '%s'",
+				operation, agt.QueueLoc, agt.Status)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f set endpoint '%s' to status '%s' (%.0f agents updated)",
+		getInvID(request), agt.QueueLoc, to, count)}
+	agt, err = ctx.DB.AgentByID(agentID)
+	if err != nil {
+		panic(err)
+	}
+	agentItem, err := agentToItem(agt)
+	if err != nil {
+		panic(err)
+	}
+	resource.AddItem(agentItem)
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
 // agentToItem receives an agent and returns an Item in Collection+JSON
 func agentToItem(agt mig.Agent) (item cljs.Item, err error) {
 	item.Href = fmt.Sprintf("%s/agent?agentid=%.0f", ctx.Server.BaseURL, agt.ID)
@@ -89,6 +161,8 @@ func agentsSummaryToItem(stats mig.AgentsStats, ctx Context) (item cljs.Item, er
 		{Name: "endpoints running 2 or more agents", Value: stats.MultiAgentsEndpoints},
 		{Name: "disappeared endpoints", Value: stats.DisappearedEndpoints},
 		{Name: "flapping endpoints", Value: stats.FlappingEndpoints},
+		{Name: "quarantined endpoints", Value: stats.QuarantinedEndpoints},
+		{Name: "decommissioned endpoints", Value: stats.DecommissionedEndpoints},
 	}
 	return
 }
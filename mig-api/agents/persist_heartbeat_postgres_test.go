@@ -0,0 +1,52 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Zack Mullaly zmullaly@mozilla.com [:zack]
+
+package agents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+)
+
+func TestPersistHeartbeat(t *testing.T) {
+	store := memory.New()
+	persist := NewPersistHeartbeatPostgres(store)
+	hb := Heartbeat{
+		Name:      "name",
+		Mode:      "daemon",
+		Version:   "version",
+		PID:       3210,
+		QueueLoc:  "loc",
+		StartTime: time.Now(),
+	}
+
+	err := persist.PersistHeartbeat(hb)
+	if err != nil {
+		t.Fatalf("Failed to persist first heartbeat: %v", err)
+	}
+	agent, err := store.AgentByQueueAndPID("loc", 3210)
+	if err != nil {
+		t.Fatalf("Agent was not created: %v", err)
+	}
+	if agent.Status != mig.AgtStatusOnline {
+		t.Errorf("Expected new agent to be online, got %s", agent.Status)
+	}
+
+	err = persist.PersistHeartbeat(hb)
+	if err != nil {
+		t.Fatalf("Failed to persist second heartbeat: %v", err)
+	}
+	agents, _ := store.ActiveAgentsByQueue("loc", time.Now().Add(-time.Minute))
+	if len(agents) != 1 {
+		t.Fatalf("Expected a single agent after two heartbeats, got %d", len(agents))
+	}
+	if agents[0].ID != agent.ID {
+		t.Errorf("Expected agent %.0f to be updated, got agent %.0f", agent.ID, agents[0].ID)
+	}
+}
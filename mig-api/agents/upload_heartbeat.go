@@ -47,15 +47,16 @@ func NewUploadHeartbeat(persist PersistHeartbeat, auth Authenticator) UploadHear
 
 // Environment contains information about the environment an agent is running in.
 type Environment struct {
-	Init      string   `json:"init"`
-	Ident     string   `json:"ident"`
-	OS        string   `json:"os"`
-	Arch      string   `json:"arch"`
-	IsProxied bool     `json:"isProxied"`
-	Proxy     string   `json:"proxy"`
-	Addresses []string `json:"addresses"`
-	PublicIP  string   `json:"publicIP"`
-	Modules   []string `json:"modules"`
+	Init      string              `json:"init"`
+	Ident     string              `json:"ident"`
+	OS        string              `json:"os"`
+	Arch      string              `json:"arch"`
+	IsProxied bool                `json:"isProxied"`
+	Proxy     string              `json:"proxy"`
+	Addresses []string            `json:"addresses"`
+	PublicIP  string              `json:"publicIP"`
+	PublicIPs []mig.AgentPublicIP `json:"publicIPs,omitempty"`
+	Modules   []string            `json:"modules"`
 }
 
 // Tag is a label associated with an agent.
@@ -203,6 +204,7 @@ func (hb Heartbeat) ToMigAgent() mig.Agent {
 			Proxy:     hb.Environment.Proxy,
 			Addresses: hb.Environment.Addresses,
 			PublicIP:  hb.Environment.PublicIP,
+			PublicIPs: hb.Environment.PublicIPs,
 			Modules:   hb.Environment.Modules,
 		},
 		Tags: tags,
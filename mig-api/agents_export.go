@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// exportAgents streams the agents that match search parameters in a tabular
+// format, with one row per agent and one column per selected field. The search
+// filters are the ones of agent searches, but the number of agents exported is
+// only limited if the limit parameter is set.
+func exportAgents(respWriter http.ResponseWriter, request *http.Request) {
+	var (
+		err   error
+		nrows int
+	)
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving exportAgents()"}.Debug()
+	}()
+	badRequest := func(msg string) {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: msg})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+	}
+	qp := request.URL.Query()
+	p, _, err := parseSearchParameters(qp)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	p.Type = "agent"
+	if qp.Get("limit") == "" {
+		p.Limit = -1
+	}
+	fields := mig.DefaultAgentFields
+	if qp.Get("fields") != "" {
+		fields = strings.Split(qp.Get("fields"), ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+			err = mig.ValidateAgentField(fields[i])
+			if err != nil {
+				badRequest(err.Error())
+				return
+			}
+		}
+	}
+	format := qp.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	exp, ctype, err := newResultsExporter(format, respWriter)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	// from here on the response is streamed, and errors can only be logged
+	respWriter.Header().Set("Content-Type", ctype)
+	respWriter.Header().Set("Cache-Control", "no-cache")
+	respWriter.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"agents.%s\"", format))
+	respWriter.WriteHeader(http.StatusOK)
+	defer func() {
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("agents export interrupted: %v", err)}.Err()
+		}
+		ctx.Channels.Log <- mig.Log{
+			OpID: opid,
+			Desc: fmt.Sprintf("src=%s category=investigator auth=[%s %.0f] %s %s %s resp_code=%d rows=%d user-agent=%s",
+				remotePublicIP(request), getInvName(request), getInvID(request), request.Method, request.Proto,
+				request.URL.String(), http.StatusOK, nrows, request.UserAgent()),
+		}
+	}()
+	err = exp.writeHeader(fields)
+	if err != nil {
+		return
+	}
+	writeAgent := func(agt mig.Agent) error {
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			row[i] = agt.FieldValue(f)
+		}
+		nrows++
+		return exp.writeRow(row)
+	}
+	if p.Target != "" {
+		err = ctx.DB.IterActiveAgentsByTarget(p.Target, writeAgent)
+		if err != nil {
+			return
+		}
+	} else {
+		err = ctx.DB.IterSearchAgents(p, writeAgent)
+		if err != nil {
+			return
+		}
+	}
+	err = exp.flush()
+}
@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+)
+
+func TestExportAgents(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+	ctx.DB = db
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+	now := time.Now()
+	for _, name := range []string{"db1.example.net", "web1.example.net"} {
+		err := db.InsertAgent(mig.Agent{Name: name, QueueLoc: "linux." + name, Mode: "daemon",
+			Status: mig.AgtStatusOnline, StartTime: now, HeartBeatTS: now})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		desc, query string
+		code        int
+		body        string
+	}{
+		{"target", "target=" + url.QueryEscape("name LIKE 'web%'") + "&fields=name,queueloc&format=csv",
+			http.StatusOK, "name,queueloc\nweb1.example.net,linux.web1.example.net\n"},
+		{"invalid field", "fields=name,password", http.StatusBadRequest, ""},
+		{"invalid format", "format=xml", http.StatusBadRequest, ""},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/agents/export?"+tc.query, nil)
+		w := httptest.NewRecorder()
+		exportAgents(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected HTTP %d, got %d: %s", tc.desc, tc.code, w.Code, w.Body.String())
+			continue
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: unexpected export %q", tc.desc, w.Body.String())
+		}
+	}
+}
@@ -8,11 +8,14 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
@@ -67,12 +70,27 @@ func main() {
 	}()
 	ctx.Channels.Log <- mig.Log{Desc: "Logger routine started"}
 
+	// listen for action events published by the schedulers, and make them
+	// available to clients following actions
+	err = startActionStreams()
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("Action streaming disabled: %v", err)}.Err()
+	} else {
+		ctx.Channels.Log <- mig.Log{Desc: "Action events listener started"}
+	}
+
+	// throttle authentication attempts
+	startAuthLimiter()
+
+	// expose the api metrics on their own listener
+	startMetricsListener()
+
 	// register routes
 	r := mux.NewRouter()
 	s := r.PathPrefix(ctx.Server.BaseRoute).Subrouter()
 
 	postHeartbeat := agents.NewUploadHeartbeat(
-		agents.NewPersistHeartbeatPostgres(&ctx.DB),
+		agents.NewPersistHeartbeatPostgres(ctx.DB),
 		agents.NewNilAuthenticator())
 
 	// Endpoints that replace previously direct-to-rabbitmq communications.
@@ -90,17 +108,30 @@ func main() {
 	s.HandleFunc("/manifest/fetch/",
 		authenticateLoader(getManifestFile)).Methods("POST")
 
+	// Agent upgrade endpoint, agents authenticate with the key of the loader
+	// entry they were deployed with, and only get manifests that target it
+	s.HandleFunc("/manifest/upgrade/",
+		authenticateLoader(getAgentUpgrade)).Methods("GET")
+
 	// Investigator resources that require authentication
 	s.HandleFunc("/search",
 		authenticate(search, mig.PermSearch)).Methods("GET")
 	s.HandleFunc("/action",
 		authenticate(getAction, mig.PermAction)).Methods("GET")
+	s.HandleFunc("/action/stream",
+		authenticate(streamAction, mig.PermAction)).Methods("GET")
 	s.HandleFunc("/action/create/",
 		authenticate(createAction, mig.PermActionCreate)).Methods("POST")
+	s.HandleFunc("/action/{actionid}/results/export",
+		authenticate(exportActionResults, mig.PermCommand)).Methods("GET")
 	s.HandleFunc("/command",
 		authenticate(getCommand, mig.PermCommand)).Methods("GET")
 	s.HandleFunc("/agent",
 		authenticate(getAgent, mig.PermAgent)).Methods("GET")
+	s.HandleFunc("/agent/export",
+		authenticate(exportAgents, mig.PermSearch)).Methods("GET")
+	s.HandleFunc("/agent/lifecycle/",
+		authenticate(setAgentLifecycle, mig.PermAgentLifecycle)).Methods("POST")
 	s.HandleFunc("/dashboard",
 		authenticate(getDashboard, mig.PermDashboard)).Methods("GET")
 
@@ -131,6 +162,44 @@ func main() {
 		authenticate(createInvestigator, mig.PermInvestigatorCreate)).Methods("POST")
 	s.HandleFunc("/investigator/update/",
 		authenticate(updateInvestigator, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/investigator/key/rotate/",
+		authenticate(rotateInvestigatorKey, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/investigator/key/revoke/",
+		authenticate(revokeInvestigatorKey, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/acl/policy",
+		authenticate(getACLPolicies, mig.PermInvestigator)).Methods("GET")
+	s.HandleFunc("/acl/policy/",
+		authenticate(setACLPolicy, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/acl/policy/delete/",
+		authenticate(deleteACLPolicy, mig.PermInvestigatorUpdate)).Methods("POST")
+	s.HandleFunc("/acl/bundle",
+		authenticate(getAuthBundle, mig.PermInvestigator)).Methods("GET")
+	s.HandleFunc("/webhook",
+		authenticate(getWebhooks, mig.PermWebhook)).Methods("GET")
+	s.HandleFunc("/webhook/create/",
+		authenticate(createWebhook, mig.PermWebhook)).Methods("POST")
+	s.HandleFunc("/webhook/status/",
+		authenticate(updateWebhookStatus, mig.PermWebhook)).Methods("POST")
+	s.HandleFunc("/webhook/deliveries",
+		authenticate(getWebhookDeliveries, mig.PermWebhook)).Methods("GET")
+	s.HandleFunc("/case",
+		authenticate(getCases, mig.PermCase)).Methods("GET")
+	s.HandleFunc("/case/create/",
+		authenticate(createCase, mig.PermCase)).Methods("POST")
+	s.HandleFunc("/case/update/",
+		authenticate(updateCase, mig.PermCase)).Methods("POST")
+	s.HandleFunc("/case/note/",
+		authenticate(addCaseNote, mig.PermCase)).Methods("POST")
+	s.HandleFunc("/case/action/",
+		authenticate(setCaseAction, mig.PermCase)).Methods("POST")
+	s.HandleFunc("/case/agent/",
+		authenticate(setCaseAgent, mig.PermCase)).Methods("POST")
+
+	// record the duration of the requests on every route
+	err = instrumentRoutes(r)
+	if err != nil {
+		panic(err)
+	}
 
 	ctx.Channels.Log <- mig.Log{Desc: "Starting HTTP handler"}
 
@@ -239,8 +308,11 @@ type handler func(w http.ResponseWriter, r *http.Request)
 func authenticate(pass handler, requirePerm int64) handler {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var (
-			err error
-			inv mig.Investigator
+			err        error
+			inv        mig.Investigator
+			limitKeys  []string
+			retryAfter time.Duration
+			allowed    bool
 		)
 		opid := getOpID(r)
 		context.Set(r, opID, opid)
@@ -254,9 +326,25 @@ func authenticate(pass handler, requirePerm int64) handler {
 			inv.Permissions.AdminSet()
 			goto authorized
 		}
+		// Reject clients that are locked out or above the rate limit before
+		// spending any time verifying their credentials
+		limitKeys = authLimitKeys(r)
+		retryAfter, allowed = authLimits.allow(limitKeys, time.Now())
+		if !allowed {
+			metricAuthFailures.Inc("throttled")
+			inv.Name = "auththrottled"
+			inv.ID = -1
+			w.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(retryAfter.Seconds())))
+			resource := cljs.New(fmt.Sprintf("%s%s", ctx.Server.Host, r.URL.String()))
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: "Too many authentication attempts, try again later"})
+			respond(http.StatusTooManyRequests, resource, w, r)
+			return
+		}
 		if r.Header.Get("X-PGPAUTHORIZATION") != "" {
 			inv, err = verifySignedToken(r.Header.Get("X-PGPAUTHORIZATION"))
 			if err != nil {
+				metricAuthFailures.Inc("pgp")
+				authFailed(r, limitKeys)
 				inv.Name = "authfailed"
 				inv.ID = -1
 				resource := cljs.New(fmt.Sprintf("%s%s", ctx.Server.Host, r.URL.String()))
@@ -267,6 +355,8 @@ func authenticate(pass handler, requirePerm int64) handler {
 		} else if r.Header.Get("X-MIGAPIKEY") != "" {
 			inv, err = verifyAPIKey(r.Header.Get("X-MIGAPIKEY"))
 			if err != nil {
+				metricAuthFailures.Inc("apikey")
+				authFailed(r, limitKeys)
 				inv.Name = "authfailed"
 				inv.ID = -1
 				resource := cljs.New(fmt.Sprintf("%s%s", ctx.Server.Host, r.URL.String()))
@@ -275,6 +365,7 @@ func authenticate(pass handler, requirePerm int64) handler {
 				return
 			}
 		} else {
+			metricAuthFailures.Inc("missing")
 			inv.Name = "authmissing"
 			inv.ID = -1
 			resource := cljs.New(fmt.Sprintf("%s%s", ctx.Server.Host, r.URL.String()))
@@ -286,6 +377,7 @@ func authenticate(pass handler, requirePerm int64) handler {
 		// As a final phase, validate the investigator has permission to access
 		// the endpoint
 		if !inv.CheckPermission(requirePerm) {
+			metricAuthFailures.Inc("permission")
 			inv.Name = "authfailed"
 			inv.ID = -1
 			resource := cljs.New(fmt.Sprintf("%s%s", ctx.Server.Host, r.URL.String()))
@@ -302,6 +394,16 @@ func authenticate(pass handler, requirePerm int64) handler {
 	}
 }
 
+// authFailed records an authentication failure of request r against the
+// limiter keys, and logs an audit event for each key that got locked out
+func authFailed(r *http.Request, keys []string) {
+	for _, k := range authLimits.fail(keys, time.Now()) {
+		metricAuthLockouts.Inc()
+		auditLog(r, "authlockout", fmt.Sprintf("%s failures=%d lockout=%s",
+			k, ctx.Authentication.MaxFailures, ctx.Authentication.lockoutDuration))
+	}
+}
+
 // authenticateLoader is used to authenticate requests that are made to the
 // loader API endpoints. Rather than operate on GPG signatures, the
 // authentication instead uses the submitted loader key
@@ -375,6 +477,15 @@ func remotePublicIP(r *http.Request) string {
 	return useip
 }
 
+// remotePublicIPSource returns the way remotePublicIP determines the public
+// IP of clients, peer or x-forwarded-for
+func remotePublicIPSource() string {
+	if ctx.Server.ClientPublicIPOffset == -1 {
+		return "peer"
+	}
+	return "x-forwarded-for"
+}
+
 // respond builds a Collection+JSON body and sends it to the client
 func respond(code int, response interface{}, respWriter http.ResponseWriter, r *http.Request) (err error) {
 	defer func() {
@@ -450,12 +561,15 @@ func getHeartbeat(respWriter http.ResponseWriter, request *http.Request) {
 	respond(http.StatusOK, resource, respWriter, request)
 }
 
-// getIP returns a the public IP of the caller as read from X-Forwarded-For
+// getIP returns the public IP of the caller, as read from the connection or
+// from X-Forwarded-For. The X-MIGIPSOURCE header of the response indicates
+// which one was used.
 func getIP(respWriter http.ResponseWriter, request *http.Request) {
 	opid := mig.GenID()
 	defer func() {
 		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getIP()"}.Debug()
 	}()
+	respWriter.Header().Set("X-MIGIPSOURCE", remotePublicIPSource())
 	respond(http.StatusOK, []byte(remotePublicIP(request)), respWriter, request)
 }
 
@@ -534,6 +648,35 @@ func getDashboard(respWriter http.ResponseWriter, request *http.Request) {
 		resource.AddItem(sumItem)
 	}
 
+	// add the history of the platform over the requested window
+	window := defaultDashboardWindow
+	if request.URL.Query().Get("window") != "" {
+		window, err = time.ParseDuration(request.URL.Query().Get("window"))
+		if err != nil || window <= 0 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid window parameter '%s'", request.URL.Query().Get("window"))})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+	}
+	points := defaultDashboardPoints
+	if request.URL.Query().Get("points") != "" {
+		points, err = strconv.Atoi(request.URL.Query().Get("points"))
+		if err != nil || points < 2 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid points parameter '%s'", request.URL.Query().Get("points"))})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+	}
+	histItem, err := dashboardHistoryToItem(time.Now().Add(-window), points)
+	if err != nil {
+		panic(err)
+	}
+	resource.AddItem(histItem)
+
 	// add the last 10 actions
 	actions, err := ctx.DB.LastActions(10)
 	if err != nil {
@@ -14,14 +14,48 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
-	"golang.org/x/crypto/pbkdf2"
+	"regexp"
+
 	"github.com/mozilla/mig"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 const APIKeyLength = 32
 const APIHashedKeyLength = 32
 const APISaltLength = 16
 
+// APIKeyPrefixLength is the length of the prefix of an investigator API key. The
+// prefix is stored in clear in the database and used to find the key to verify, so
+// authenticating a request only requires hashing a single key.
+const APIKeyPrefixLength = 8
+
+// apiKeyPrefixRe matches a valid API key prefix
+var apiKeyPrefixRe = regexp.MustCompile(fmt.Sprintf("^[A-Za-z0-9]{%d}$", APIKeyPrefixLength))
+
+// generateAPIKey returns a new prefixed API key, along with its prefix, hash and salt
+func generateAPIKey() (key, prefix string, hash, salt []byte, err error) {
+	prefix = mig.RandAPIKeyString(APIKeyPrefixLength)
+	suppkey := mig.RandAPIKeyString(APIKeyLength)
+	hash, salt, err = hashAPIKey(suppkey, nil, APIHashedKeyLength, APISaltLength)
+	if err != nil {
+		return
+	}
+	key = prefix + suppkey
+	return
+}
+
+// apiKeyPrefix returns the prefix of a prefixed API key, or an empty string if
+// the key is a legacy key created without a prefix
+func apiKeyPrefix(key string) string {
+	if len(key) != APIKeyPrefixLength+APIKeyLength {
+		return ""
+	}
+	if !apiKeyPrefixRe.MatchString(key[:APIKeyPrefixLength]) {
+		return ""
+	}
+	return key[:APIKeyPrefixLength]
+}
+
 func hashAPIKey(key string, salt []byte, keylen int, saltlen int) (ret []byte, retsalt []byte, err error) {
 	if key == "" {
 		err = fmt.Errorf("loader key cannot be zero length")
@@ -43,8 +77,29 @@ func hashAPIKey(key string, salt []byte, keylen int, saltlen int) (ret []byte, r
 // Verify an X-MIGAPIKEY header, if the supplied header value matches any key
 // configured for an investigator in the database, the investigator is returned,
 // otherwise an error is returned.
+//
+// Prefixed keys are looked up by their prefix. Keys created before prefixes were
+// introduced are compared to every legacy key in the database.
 func verifyAPIKey(key string) (inv mig.Investigator, err error) {
 	reterr := fmt.Errorf("API key authentication failed")
+	if prefix := apiKeyPrefix(key); prefix != "" {
+		x, err := ctx.DB.InvestigatorAPIKeyAuthHelperByPrefix(prefix)
+		if err != nil {
+			return inv, reterr
+		}
+		tryhash, _, err := hashAPIKey(key[APIKeyPrefixLength:], x.Salt, len(x.APIKey), len(x.Salt))
+		if err != nil {
+			return inv, reterr
+		}
+		if !bytes.Equal(tryhash, x.APIKey) {
+			return inv, reterr
+		}
+		inv, err = ctx.DB.InvestigatorByID(x.ID)
+		if err != nil {
+			return inv, reterr
+		}
+		return inv, nil
+	}
 	apiinvs, err := ctx.DB.InvestigatorAPIKeyAuthHelpers()
 	if err != nil {
 		return inv, reterr
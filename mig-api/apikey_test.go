@@ -0,0 +1,41 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Aaron Meihm ameihm@mozilla.com [:alm]
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key1, prefix1, hash1, salt1, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, prefix2, _, _, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keys generated back to back must not be derived from one another
+	if prefix1 == prefix2 {
+		t.Errorf("expected keys generated back to back to have different prefixes, got %q twice", prefix1)
+	}
+	if key1[APIKeyPrefixLength:] == key2[APIKeyPrefixLength:] {
+		t.Error("expected keys generated back to back to be different")
+	}
+
+	if p := apiKeyPrefix(key1); p != prefix1 {
+		t.Errorf("expected prefix %q, got %q", prefix1, p)
+	}
+	tryhash, _, err := hashAPIKey(key1[APIKeyPrefixLength:], salt1, len(hash1), len(salt1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tryhash, hash1) {
+		t.Error("expected the key to match its hash")
+	}
+}
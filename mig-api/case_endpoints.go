@@ -0,0 +1,472 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// getCases returns a single case if the caseid parameter is set, or the last
+// modified cases, optionally filtered by status
+func getCases(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getCases()"}.Debug()
+	}()
+	var (
+		cases []mig.Case
+		err   error
+	)
+	qp := request.URL.Query()
+	if qp.Get("caseid") != "" {
+		caseid, ok := caseIDParam(qp.Get("caseid"), opid, resource, respWriter, request)
+		if !ok {
+			return
+		}
+		c, ok := caseByID(caseid, opid, resource, respWriter, request)
+		if !ok {
+			return
+		}
+		cases = append(cases, c)
+	} else {
+		status := qp.Get("status")
+		if status != "" {
+			err = mig.ValidateCaseStatus(status)
+			if err != nil {
+				resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", err)})
+				respond(http.StatusBadRequest, resource, respWriter, request)
+				return
+			}
+		}
+		limit := 100
+		if qp.Get("limit") != "" {
+			limit, err = strconv.Atoi(qp.Get("limit"))
+			if err != nil || limit < 1 {
+				resource.SetError(cljs.Error{
+					Code:    fmt.Sprintf("%.0f", opid),
+					Message: fmt.Sprintf("Invalid limit '%s'", qp.Get("limit"))})
+				respond(http.StatusBadRequest, resource, respWriter, request)
+				return
+			}
+		}
+		cases, err = ctx.DB.Cases(status, limit)
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, c := range cases {
+		err = resource.AddItem(caseItem(c))
+		if err != nil {
+			panic(err)
+		}
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// createCase creates a case from the JSON document sent in the case parameter.
+// The case is created by the investigator making the request, and is open
+// unless another status is set.
+func createCase(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving createCase()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var c mig.Case
+	err = json.Unmarshal([]byte(request.FormValue("case")), &c)
+	if err == nil {
+		if c.Status == "" {
+			c.Status = mig.CaseStatusOpen
+		}
+		err = c.Validate()
+	}
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("Invalid case: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	c.ID = mig.GenID()
+	c.CreatedBy = getInvID(request)
+	c.CreatedAt = time.Now().UTC()
+	c.LastModified = c.CreatedAt
+	// notes, actions and agents are attached to existing cases only
+	c.Notes, c.ActionIDs, c.Agents = nil, nil, nil
+	err = ctx.DB.InsertCase(c)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f created case %.0f '%s'",
+		c.CreatedBy, c.ID, c.Title)}
+	c, err = ctx.DB.CaseByID(c.ID)
+	if err != nil {
+		panic(err)
+	}
+	err = resource.AddItem(caseItem(c))
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusCreated, resource, respWriter, request)
+}
+
+// updateCase changes the title, status or assignees of a case. Assignees are
+// sent as a comma separated list of investigator IDs, and replace the current
+// ones. An empty list removes all the assignees.
+func updateCase(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving updateCase()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	caseid, ok := caseIDParam(request.FormValue("caseid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	c, ok := caseByID(caseid, opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	if _, set := request.PostForm["title"]; set {
+		c.Title = request.FormValue("title")
+	}
+	if _, set := request.PostForm["status"]; set {
+		c.Status = request.FormValue("status")
+	}
+	if _, set := request.PostForm["assignees"]; set {
+		c.Assignees = nil
+		for _, param := range strings.Split(request.FormValue("assignees"), ",") {
+			param = strings.TrimSpace(param)
+			if param == "" {
+				continue
+			}
+			iid, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				resource.SetError(cljs.Error{
+					Code:    fmt.Sprintf("%.0f", opid),
+					Message: fmt.Sprintf("Invalid Investigator ID '%s'", param)})
+				respond(http.StatusBadRequest, resource, respWriter, request)
+				return
+			}
+			c.Assignees = append(c.Assignees, mig.CaseAssignee{InvestigatorID: iid})
+		}
+	}
+	err = c.Validate()
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("Invalid case: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	err = ctx.DB.UpdateCase(c)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f updated case %.0f: status=%s assignees=%d",
+		getInvID(request), c.ID, c.Status, len(c.Assignees))}
+	respondCase(c.ID, http.StatusOK, resource, respWriter, request)
+}
+
+// addCaseNote records a note on a case, authored by the investigator making
+// the request
+func addCaseNote(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving addCaseNote()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	caseid, ok := caseIDParam(request.FormValue("caseid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	text := request.FormValue("note")
+	if strings.TrimSpace(text) == "" {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: "Note must not be empty"})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	if _, ok = caseByID(caseid, opid, resource, respWriter, request); !ok {
+		return
+	}
+	err = ctx.DB.AddCaseNote(caseid, mig.CaseNote{
+		ID:             mig.GenID(),
+		InvestigatorID: getInvID(request),
+		Text:           text,
+		CreatedAt:      time.Now().UTC(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f added a note to case %.0f",
+		getInvID(request), caseid)}
+	respondCase(caseid, http.StatusOK, resource, respWriter, request)
+}
+
+// setCaseAction attaches an action to a case, or detaches it when the
+// operation parameter is set to remove
+func setCaseAction(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setCaseAction()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	caseid, ok := caseIDParam(request.FormValue("caseid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	actionid, err := strconv.ParseFloat(request.FormValue("actionid"), 64)
+	if err != nil || actionid <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Action ID '%s'", request.FormValue("actionid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	operation, ok := caseOperation(request.FormValue("operation"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	if _, ok = caseByID(caseid, opid, resource, respWriter, request); !ok {
+		return
+	}
+	if operation == "remove" {
+		err = ctx.DB.RemoveCaseAction(caseid, actionid)
+	} else {
+		var a mig.Action
+		a, err = ctx.DB.ActionByID(actionid)
+		if err != nil {
+			if a.ID == -1 {
+				resource.SetError(cljs.Error{
+					Code:    fmt.Sprintf("%.0f", opid),
+					Message: fmt.Sprintf("Action ID '%.0f' not found", actionid)})
+				respond(http.StatusNotFound, resource, respWriter, request)
+				return
+			}
+			panic(err)
+		}
+		err = ctx.DB.AddCaseAction(caseid, actionid)
+	}
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: actionid, Desc: fmt.Sprintf("investigator %.0f: %s action %.0f in case %.0f",
+		getInvID(request), operation, actionid, caseid)}
+	respondCase(caseid, http.StatusOK, resource, respWriter, request)
+}
+
+// setCaseAgent records an agent of interest in a case, or removes it when the
+// operation parameter is set to remove
+func setCaseAgent(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving setCaseAgent()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	caseid, ok := caseIDParam(request.FormValue("caseid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	agentid, err := strconv.ParseFloat(request.FormValue("agentid"), 64)
+	if err != nil || agentid <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Agent ID '%s'", request.FormValue("agentid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	operation, ok := caseOperation(request.FormValue("operation"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	c, ok := caseByID(caseid, opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	if operation == "remove" {
+		// agents are removed by queue location, since the agent recorded in
+		// the case may have been replaced
+		queueloc := ""
+		for _, agt := range c.Agents {
+			if agt.AgentID == agentid {
+				queueloc = agt.QueueLoc
+			}
+		}
+		if queueloc == "" {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Agent ID '%.0f' is not in case '%.0f'", agentid, caseid)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		err = ctx.DB.RemoveCaseAgent(caseid, queueloc)
+	} else {
+		var agt mig.Agent
+		agt, err = ctx.DB.AgentByID(agentid)
+		if err != nil {
+			if fmt.Sprintf("%v", err) == "Error while retrieving agent: 'sql: no rows in result set'" {
+				resource.SetError(cljs.Error{
+					Code:    fmt.Sprintf("%.0f", opid),
+					Message: fmt.Sprintf("Agent ID '%.0f' not found", agentid)})
+				respond(http.StatusNotFound, resource, respWriter, request)
+				return
+			}
+			panic(err)
+		}
+		err = ctx.DB.AddCaseAgent(caseid, mig.CaseAgent{
+			AgentID:  agt.ID,
+			Name:     agt.Name,
+			QueueLoc: agt.QueueLoc,
+			AddedAt:  time.Now().UTC(),
+		})
+	}
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f: %s agent %.0f in case %.0f",
+		getInvID(request), operation, agentid, caseid)}
+	respondCase(caseid, http.StatusOK, resource, respWriter, request)
+}
+
+// caseItem returns the collection item of a case
+func caseItem(c mig.Case) cljs.Item {
+	return cljs.Item{
+		Href: fmt.Sprintf("%s/case?caseid=%.0f", ctx.Server.BaseURL, c.ID),
+		Data: []cljs.Data{{Name: "case", Value: c}},
+		Links: []cljs.Link{
+			{Rel: "actions", Href: fmt.Sprintf("%s/search?type=action&caseid=%.0f", ctx.Server.BaseURL, c.ID)},
+		},
+	}
+}
+
+// respondCase responds with the current version of a case
+func respondCase(caseid float64, code int, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) {
+	c, err := ctx.DB.CaseByID(caseid)
+	if err != nil {
+		panic(err)
+	}
+	err = resource.AddItem(caseItem(c))
+	if err != nil {
+		panic(err)
+	}
+	respond(code, resource, respWriter, request)
+}
+
+// caseIDParam parses a case ID, and responds with a bad request if it is invalid
+func caseIDParam(param string, opid float64, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (id float64, ok bool) {
+	id, err := strconv.ParseFloat(param, 64)
+	if err != nil || id <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Case ID '%s'", param)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	return id, true
+}
+
+// caseByID retrieves a case, and responds with not found if it does not exist
+func caseByID(id, opid float64, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (c mig.Case, ok bool) {
+	c, err := ctx.DB.CaseByID(id)
+	if err != nil {
+		if fmt.Sprintf("%v", err) == "Error while retrieving case: 'sql: no rows in result set'" {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Case ID '%.0f' not found", id)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	return c, true
+}
+
+// caseOperation validates the operation of a request that attaches something
+// to a case, which is add unless set to remove
+func caseOperation(param string, opid float64, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (operation string, ok bool) {
+	switch param {
+	case "", "add":
+		return "add", true
+	case "remove":
+		return "remove", true
+	}
+	resource.SetError(cljs.Error{
+		Code:    fmt.Sprintf("%.0f", opid),
+		Message: fmt.Sprintf("Invalid operation '%s', must be add or remove", param)})
+	respond(http.StatusBadRequest, resource, respWriter, request)
+	return
+}
@@ -23,14 +23,20 @@ import (
 // Context is intended as a single structure that can be passed around easily.
 type Context struct {
 	Authentication struct {
-		Enabled       bool
-		TokenDuration string
-		duration      time.Duration
+		Enabled         bool
+		TokenDuration   string
+		duration        time.Duration
+		RateLimit       int
+		MaxFailures     int
+		FailureWindow   string
+		failureWindow   time.Duration
+		LockoutDuration string
+		lockoutDuration time.Duration
 	}
 	Channels struct {
 		Log chan mig.Log
 	}
-	DB      migdb.DB
+	DB      migdb.Store
 	Keyring struct {
 		Reader     io.ReadSeeker
 		Mutex      sync.Mutex
@@ -51,6 +57,9 @@ type Context struct {
 		ClientPublicIPOffset     int
 	}
 	Logging mig.Logging
+	Metrics struct {
+		Listen string
+	}
 }
 
 // Init() initializes a context from a configuration file into an
@@ -74,6 +83,29 @@ func Init(path string, debug bool) (ctx Context, err error) {
 		panic(err)
 	}
 
+	// Set the defaults of the authentication throttling, a negative rate
+	// limit or number of failures disables the corresponding protection
+	if ctx.Authentication.RateLimit == 0 {
+		ctx.Authentication.RateLimit = 600
+	}
+	if ctx.Authentication.MaxFailures == 0 {
+		ctx.Authentication.MaxFailures = 10
+	}
+	if ctx.Authentication.FailureWindow == "" {
+		ctx.Authentication.FailureWindow = "5m"
+	}
+	ctx.Authentication.failureWindow, err = time.ParseDuration(ctx.Authentication.FailureWindow)
+	if err != nil {
+		panic(err)
+	}
+	if ctx.Authentication.LockoutDuration == "" {
+		ctx.Authentication.LockoutDuration = "15m"
+	}
+	ctx.Authentication.lockoutDuration, err = time.ParseDuration(ctx.Authentication.LockoutDuration)
+	if err != nil {
+		panic(err)
+	}
+
 	// Set the mode we will use to determine a client's public IP address
 	if ctx.Server.ClientPublicIP == "" {
 		ctx.Server.ClientPublicIP = "peer"
@@ -116,13 +148,18 @@ func initDB(orig_ctx Context) (ctx Context, err error) {
 
 	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
 	ctx = orig_ctx
-	ctx.DB, err = migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
+	db, err := migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
 		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
 	if err != nil {
 		panic(err)
 	}
-	ctx.DB.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	db.SetMaxOpenConns(ctx.Postgres.MaxConn)
 	ctx.Channels.Log <- mig.Log{Desc: "Database connection opened"}
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		panic(err)
+	}
+	ctx.DB = &db
 	return
 }
 
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+const (
+	// defaultDashboardWindow is the period covered by the history of the
+	// dashboard when the window parameter is not set
+	defaultDashboardWindow = 7 * 24 * time.Hour
+
+	// defaultDashboardPoints is the number of agents statistics returned
+	// in the time series of the dashboard when the points parameter is not set
+	defaultDashboardPoints = 48
+
+	// dashboardSlowestModules is the number of modules listed by duration
+	dashboardSlowestModules = 5
+
+	// dashboardDuplicateDecisions is the number of duplicate agents decisions listed
+	dashboardDuplicateDecisions = 10
+)
+
+// dashboardHistoryToItem returns an Item with the time series of agents statistics
+// since pointInTime, the breakdowns of online agents, the throughput of actions,
+// the slowest modules and the last decisions taken on duplicate agents. The time
+// series is reduced to at most `points` samples.
+func dashboardHistoryToItem(pointInTime time.Time, points int) (item cljs.Item, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("dashboardHistoryToItem() -> %v", e)
+		}
+	}()
+	stats, err := ctx.DB.AgentsStatsSince(pointInTime)
+	if err != nil {
+		panic(err)
+	}
+	breakdown, err := ctx.DB.AgentsBreakdown()
+	if err != nil {
+		panic(err)
+	}
+	throughput, err := ctx.DB.ActionThroughput(pointInTime)
+	if err != nil {
+		panic(err)
+	}
+	slowest, err := ctx.DB.SlowestModules(pointInTime, dashboardSlowestModules)
+	if err != nil {
+		panic(err)
+	}
+	duplicates, err := ctx.DB.DuplicateDecisionsSince(pointInTime, dashboardDuplicateDecisions)
+	if err != nil {
+		panic(err)
+	}
+	// return empty lists instead of null values
+	if breakdown == nil {
+		breakdown = []mig.AgentsBreakdown{}
+	}
+	if throughput == nil {
+		throughput = []mig.ActionThroughput{}
+	}
+	if slowest == nil {
+		slowest = []mig.ModuleDuration{}
+	}
+	if duplicates == nil {
+		duplicates = []mig.DuplicateDecision{}
+	}
+	item.Href = fmt.Sprintf("%s/dashboard", ctx.Server.BaseURL)
+	item.Data = []cljs.Data{
+		{Name: "agents stats series", Value: downsampleAgentsStats(stats, points)},
+		{Name: "agents breakdown", Value: breakdown},
+		{Name: "action throughput", Value: throughput},
+		{Name: "slowest modules", Value: slowest},
+		{Name: "duplicate agents decisions", Value: duplicates},
+	}
+	return
+}
+
+// downsampleAgentsStats reduces a time series of agents statistics to at most
+// points statistics, picked at regular intervals. The first and last statistics
+// of the series are always kept.
+func downsampleAgentsStats(stats []mig.AgentsStats, points int) []mig.AgentsStats {
+	if len(stats) <= points {
+		if stats == nil {
+			return []mig.AgentsStats{}
+		}
+		return stats
+	}
+	ret := make([]mig.AgentsStats, 0, points)
+	for i := 0; i < points; i++ {
+		ret = append(ret, stats[i*(len(stats)-1)/(points-1)])
+	}
+	return ret
+}
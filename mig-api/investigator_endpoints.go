@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/pgp"
@@ -63,6 +64,14 @@ func getInvestigator(respWriter http.ResponseWriter, request *http.Request) {
 		respond(http.StatusBadRequest, resource, respWriter, request)
 		return
 	}
+	// list the keys of the investigator, without their armored form
+	inv.Keys, err = ctx.DB.InvestigatorKeys(iid)
+	if err != nil {
+		panic(err)
+	}
+	for i := range inv.Keys {
+		inv.Keys[i].PublicKey = nil
+	}
 	// store the results in the resource
 	investigatorItem, err := investigatorToItem(inv)
 	if err != nil {
@@ -194,20 +203,20 @@ func updateInvestigator(respWriter http.ResponseWriter, request *http.Request) {
 	}
 	if apikey != "" {
 		var (
-			setkey  []byte
-			setsalt []byte
-			rkey    string
+			setkey    []byte
+			setsalt   []byte
+			setprefix string
+			rkey      string
 		)
 		if apikey == "active" {
-			rkey = mig.RandAPIKeyString(APIKeyLength)
-			setkey, setsalt, err = hashAPIKey(rkey, nil, APIHashedKeyLength, APISaltLength)
+			rkey, setprefix, setkey, setsalt, err = generateAPIKey()
 			if err != nil {
 				panic(err)
 			}
 		} else if apikey != "disabled" {
 			panic("Invalid value for apikey parameter")
 		}
-		err = ctx.DB.UpdateInvestigatorAPIKey(inv, setkey, setsalt)
+		err = ctx.DB.UpdateInvestigatorAPIKey(inv, setprefix, setkey, setsalt)
 		if err != nil {
 			panic(err)
 		}
@@ -222,6 +231,140 @@ func updateInvestigator(respWriter http.ResponseWriter, request *http.Request) {
 	respond(http.StatusOK, resource, respWriter, request)
 }
 
+// rotateInvestigatorKey replaces the PGP key of an investigator with a new one.
+// The previous keys stay valid for the duration given in the overlap parameter,
+// zero by default, and the new key can be given an expiration date.
+func rotateInvestigatorKey(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving rotateInvestigatorKey()"}.Debug()
+	}()
+	var key mig.InvestigatorKey
+	err = request.ParseMultipartForm(20480)
+	if err != nil {
+		panic(err)
+	}
+	iid := request.FormValue("id")
+	if iid == "" {
+		panic("Investigator ID must not be empty")
+	}
+	key.InvestigatorID, err = strconv.ParseFloat(iid, 64)
+	if err != nil {
+		panic(err)
+	}
+	var overlap time.Duration
+	if request.FormValue("overlap") != "" {
+		overlap, err = time.ParseDuration(request.FormValue("overlap"))
+		if err != nil {
+			panic(fmt.Sprintf("Invalid overlap parameter: %v", err))
+		}
+	}
+	if request.FormValue("expireafter") != "" {
+		key.ExpireAfter, err = time.Parse(time.RFC3339, request.FormValue("expireafter"))
+		if err != nil {
+			panic(fmt.Sprintf("Invalid expireafter parameter: %v", err))
+		}
+		if !key.ExpireAfter.After(time.Now()) {
+			panic("New key must expire in the future")
+		}
+	}
+	_, keyHeader, err := request.FormFile("publickey")
+	if err != nil {
+		panic(fmt.Sprintf("Public key must be provided: %v", err))
+	}
+	keyReader, err := keyHeader.Open()
+	if err != nil {
+		panic(err)
+	}
+	key.PublicKey, err = ioutil.ReadAll(keyReader)
+	if err != nil {
+		panic(err)
+	}
+	if len(key.PublicKey) == 0 {
+		panic("Investigator Public Key must not be empty")
+	}
+	key.PGPFingerprint, err = pgp.LoadArmoredPubKey(key.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	err = pgp.CheckArmoredPubKeyValidity(key.PublicKey, time.Now())
+	if err != nil {
+		panic(err)
+	}
+	key, err = ctx.DB.RotateInvestigatorKey(key, overlap)
+	if err != nil {
+		panic(err)
+	}
+	// the cached keyring no longer matches the keys in database
+	ctx.Keyring.Mutex.Lock()
+	ctx.Keyring.UpdateTime = time.Time{}
+	ctx.Keyring.Mutex.Unlock()
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("Investigator %.0f key rotated to %s",
+		key.InvestigatorID, key.PGPFingerprint)}
+	key.PublicKey = nil
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/investigator?investigatorid=%.0f", ctx.Server.BaseURL, key.InvestigatorID),
+		Data: []cljs.Data{{Name: "investigator key", Value: key}},
+	})
+	respond(http.StatusCreated, resource, respWriter, request)
+}
+
+// revokeInvestigatorKey revokes a PGP key of an investigator, identified by its fingerprint
+func revokeInvestigatorKey(respWriter http.ResponseWriter, request *http.Request) {
+	var err error
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving revokeInvestigatorKey()"}.Debug()
+	}()
+	err = request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	iid := request.FormValue("id")
+	if iid == "" {
+		panic("Investigator ID must not be empty")
+	}
+	invid, err := strconv.ParseFloat(iid, 64)
+	if err != nil {
+		panic(err)
+	}
+	fp := request.FormValue("pgpfingerprint")
+	if fp == "" {
+		panic("Key fingerprint must not be empty")
+	}
+	err = ctx.DB.RevokeInvestigatorKey(invid, fp)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Keyring.Mutex.Lock()
+	ctx.Keyring.UpdateTime = time.Time{}
+	ctx.Keyring.Mutex.Unlock()
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("Investigator %.0f key %s revoked", invid, fp)}
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/investigator?investigatorid=%.0f", ctx.Server.BaseURL, invid),
+		Data: []cljs.Data{{Name: "investigator key", Value: mig.InvestigatorKey{
+			InvestigatorID: invid, PGPFingerprint: fp, Status: mig.StatusRevokedKey}}},
+	})
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
 // investigatorToItem receives a command and returns an Item in Collection+JSON
 func investigatorToItem(inv mig.Investigator) (item cljs.Item, err error) {
 	item.Href = fmt.Sprintf("%s/investigator?investigatorid=%.0f", ctx.Server.BaseURL, inv.ID)
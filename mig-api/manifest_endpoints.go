@@ -432,6 +432,80 @@ func getAgentManifest(respWriter http.ResponseWriter, request *http.Request) {
 	respond(http.StatusOK, resource, respWriter, request)
 }
 
+// This API entry point is used by agents to upgrade themselves without the
+// loader. Agents authenticate with a loader key, and the manifest requested
+// must be active and target that loader entry. It returns the manifest along
+// with the agent binary it contains, which the agent verifies against the
+// signatures on the manifest.
+func getAgentUpgrade(respWriter http.ResponseWriter, request *http.Request) {
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	opid := getOpID(request)
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("%v", e)}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("%v", e)})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getAgentUpgrade()"}.Debug()
+	}()
+	mid, err := strconv.ParseFloat(request.URL.Query().Get("manifestid"), 64)
+	if err != nil || mid <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Manifest ID '%s'", request.URL.Query().Get("manifestid"))})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	loaderid := getLoaderID(request)
+	if loaderid == 0 {
+		panic("Request has no valid loader ID")
+	}
+	mr, err := ctx.DB.GetManifestFromID(mid)
+	if err != nil || mr.Status != "active" || !manifestTargetsLoader(mid, loaderid) {
+		// do not reveal manifests that are staged, disabled, or meant for
+		// other loaders
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("No active manifest with ID '%.0f'", mid)})
+		respond(http.StatusNotFound, resource, respWriter, request)
+		return
+	}
+	m, err := mr.ManifestResponse()
+	if err != nil {
+		panic(err)
+	}
+	data, err := mr.ManifestObject("mig-agent")
+	if err != nil {
+		panic(err)
+	}
+	err = resource.AddItem(cljs.Item{
+		Href: request.URL.String(),
+		Data: []cljs.Data{
+			{Name: "manifest", Value: m},
+			{Name: "content", Value: mig.ManifestFetchResponse{Data: data}},
+		}})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// manifestTargetsLoader returns true if the target of manifest mid matches the
+// enabled loader entry lid
+func manifestTargetsLoader(mid, lid float64) bool {
+	loaders, err := ctx.DB.AllLoadersFromManifestID(mid)
+	if err != nil {
+		panic(err)
+	}
+	for _, le := range loaders {
+		if le.ID == lid {
+			return true
+		}
+	}
+	return false
+}
+
 // Return information describing an existing loader entry
 func getLoader(respWriter http.ResponseWriter, request *http.Request) {
 	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
@@ -0,0 +1,113 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+)
+
+// testManifestContent returns the content of a manifest record holding a
+// mig-agent binary
+func testManifestContent(t *testing.T) string {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	bin := []byte("agent binary")
+	err := tw.WriteHeader(&tar.Header{Name: "mig-agent", Mode: 0755, Size: int64(len(bin)),
+		Typeflag: tar.TypeReg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(bin)
+	tw.Close()
+	gzw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// testLoader adds an enabled loader entry to the database, and returns its ID
+// and key
+func testLoader(t *testing.T, name string) (float64, string) {
+	le := mig.LoaderEntry{Name: name, Prefix: mig.GenerateLoaderPrefix(), Key: mig.GenerateLoaderKey()}
+	hkey, salt, err := hashAPIKey(le.Key, nil, mig.LoaderHashedKeyLength, mig.LoaderSaltLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le, err = ctx.DB.LoaderAdd(le, hkey, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.DB.LoaderUpdateStatus(le.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return le.ID, le.Prefix + le.Key
+}
+
+func TestGetAgentUpgrade(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+	ctx.DB = db
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+
+	lid, lkey := testLoader(t, "webserver")
+	_, otherkey := testLoader(t, "database")
+	err := db.ManifestAdd(mig.ManifestRecord{Name: "upgrade", Content: testManifestContent(t),
+		Target: "loadername='webserver'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the store numbers records in sequence, the manifest follows the loaders
+	mid := lid + 2
+	err = db.ManifestAddSignature(mid, "signature", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := db.ManifestIDFromLoaderID(lid); err != nil || id != mid {
+		t.Fatalf("expected manifest %.0f to be active for loader %.0f, got %.0f (%v)", mid, lid, id, err)
+	}
+
+	handler := authenticateLoader(getAgentUpgrade)
+	var tests = []struct {
+		desc, key, manifestid string
+		code                  int
+	}{
+		{"no loader key", "", "", http.StatusUnauthorized},
+		{"invalid loader key", "invalidkey", "", http.StatusUnauthorized},
+		{"invalid manifest id", lkey, "abc", http.StatusBadRequest},
+		{"unknown manifest", lkey, "4242", http.StatusNotFound},
+		{"manifest of another loader", otherkey, "", http.StatusNotFound},
+		{"manifest of the loader", lkey, "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		manifestid := tc.manifestid
+		if manifestid == "" {
+			manifestid = fmt.Sprintf("%.0f", mid)
+		}
+		r := httptest.NewRequest("GET", "/api/v1/manifest/upgrade/?manifestid="+manifestid, nil)
+		if tc.key != "" {
+			r.Header.Set("X-LOADERKEY", tc.key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected HTTP %d, got %d: %s", tc.desc, tc.code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/metrics"
+)
+
+// apiMetrics holds the metrics exposed by the API on the /metrics endpoint
+// of the metrics listener
+var apiMetrics = metrics.NewRegistry()
+
+var (
+	metricRequestDuration = apiMetrics.NewHistogram("mig_api_request_duration_seconds",
+		"Time spent handling API requests, by route, method and response code.",
+		nil, "route", "method", "code")
+	metricAuthFailures = apiMetrics.NewCounter("mig_api_auth_failures_total",
+		"Investigator authentication failures, by reason.", "reason")
+	metricAuthLockouts = apiMetrics.NewCounter("mig_api_auth_lockouts_total",
+		"Source IPs and API key prefixes locked out after repeated authentication failures.")
+	metricActionStreams = apiMetrics.NewGauge("mig_api_action_streams",
+		"Clients currently following an action stream.")
+)
+
+// startMetricsListener serves the metrics of the API on the address set in
+// the configuration. Metrics are kept off the public listener of the API, so
+// they are only reachable from where the operator chooses to expose them.
+func startMetricsListener() {
+	if ctx.Metrics.Listen == "" {
+		return
+	}
+	listener := http.NewServeMux()
+	listener.Handle("/metrics", apiMetrics)
+	go func() {
+		err := http.ListenAndServe(ctx.Metrics.Listen, listener)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("metrics listener failed: %v", err)}.Err()
+		}
+	}()
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("metrics listener started on %s", ctx.Metrics.Listen)}
+}
+
+// instrumentRoutes wraps the handler of every route of the router to record
+// the duration of the requests. Routes are identified by their path template,
+// so requests on different objects are aggregated.
+func instrumentRoutes(r *mux.Router) error {
+	return r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		handler := route.GetHandler()
+		if handler == nil {
+			return nil
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		route.Handler(instrumentHandler(tpl, handler))
+		return nil
+	})
+}
+
+func instrumentHandler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		metricRequestDuration.Observe(time.Now().Sub(start).Seconds(),
+			route, r.Method, strconv.Itoa(sw.code))
+	})
+}
+
+// statusWriter records the status code of a response. It implements
+// http.Flusher so streaming handlers keep working when instrumented.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.code = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -25,10 +25,22 @@ func makeKeyring() (keyring io.ReadSeeker, err error) {
 		}
 		ctx.Channels.Log <- mig.Log{Desc: "leaving makeKeyring()"}.Debug()
 	}()
-	keys, err := ctx.DB.ActiveInvestigatorsPubKeys()
+	dbkeys, err := ctx.DB.ActiveInvestigatorsPubKeys()
 	if err != nil {
 		panic(err)
 	}
+	// the database only returns keys that are within their validity window,
+	// but the keys themselves can also be revoked or expired
+	var keys [][]byte
+	now := time.Now()
+	for _, key := range dbkeys {
+		err = pgp.CheckArmoredPubKeyValidity(key, now)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("skipping investigator key: %v", err)}.Warning()
+			continue
+		}
+		keys = append(keys, key)
+	}
 	keyring, keycount, err := pgp.ArmoredKeysToKeyring(keys)
 	if err != nil {
 		panic(err)
@@ -116,9 +128,33 @@ func verifySignedToken(token string) (inv mig.Investigator, err error) {
 	if fp == "" {
 		panic("token verification failed")
 	}
+	inv, err = signingInvestigator(fp)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// signingInvestigator returns the investigator that owns the key with
+// fingerprint fp. The keyring is only refreshed periodically, so the key that
+// matched a signature is checked to still be valid, and revoked or expired
+// keys stop working immediately.
+func signingInvestigator(fp string) (inv mig.Investigator, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("signingInvestigator() -> %v", e)
+		}
+	}()
 	inv, err = ctx.DB.InvestigatorByFingerprint(fp)
 	if err != nil {
 		panic(err)
 	}
+	inv.Keys, err = ctx.DB.InvestigatorKeys(inv.ID)
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := inv.SigningKey(fp, time.Now()); !ok {
+		panic(fmt.Sprintf("key %s of investigator %.0f is revoked, expired or disabled", fp, inv.ID))
+	}
 	return
 }
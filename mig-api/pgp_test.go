@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+)
+
+func TestSigningInvestigator(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+	ctx.DB = db
+	iid, err := db.InsertInvestigator(mig.Investigator{Name: "Bob", PGPFingerprint: "AAAA",
+		PublicKey: []byte("key a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := signingInvestigator("aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.ID != iid {
+		t.Errorf("expected investigator %.0f, got %.0f", iid, inv.ID)
+	}
+
+	// the rotated key keeps working during the overlap, until revoked
+	_, err = db.RotateInvestigatorKey(mig.InvestigatorKey{InvestigatorID: iid,
+		PGPFingerprint: "BBBB", PublicKey: []byte("key b")}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signingInvestigator("AAAA"); err != nil {
+		t.Errorf("expected the rotated key to be valid during the overlap: %v", err)
+	}
+	err = db.RevokeInvestigatorKey(iid, "AAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signingInvestigator("AAAA"); err == nil {
+		t.Error("expected the revoked key to be refused")
+	}
+
+	// a rotation without overlap expires the previous key immediately
+	_, err = db.RotateInvestigatorKey(mig.InvestigatorKey{InvestigatorID: iid,
+		PGPFingerprint: "CCCC", PublicKey: []byte("key c")}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signingInvestigator("BBBB"); err == nil {
+		t.Error("expected the expired key to be refused")
+	}
+	if _, err = signingInvestigator("CCCC"); err != nil {
+		t.Errorf("expected the current key to be valid: %v", err)
+	}
+
+	// disabled investigators cannot sign
+	err = db.UpdateInvestigatorStatus(mig.Investigator{ID: iid, Status: mig.StatusDisabledInvestigator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signingInvestigator("CCCC"); err == nil {
+		t.Error("expected the key of a disabled investigator to be refused")
+	}
+
+	if _, err = signingInvestigator("DDDD"); err == nil {
+		t.Error("expected an unknown key to be refused")
+	}
+}
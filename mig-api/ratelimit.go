@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+// Throttling of authentication attempts. Attempts are counted per source IP
+// and, for API keys, per key prefix. A source or prefix that exceeds the rate
+// limit is rejected until the end of the current window, and one that fails
+// to authenticate too many times is locked out for a while.
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// authRateWindow is the window over which authentication attempts are
+// counted for rate limiting
+const authRateWindow = time.Minute
+
+// authLimits tracks the authentication attempts and failures of the clients
+var authLimits = authLimiter{entries: make(map[string]*authLimitEntry)}
+
+type authLimiter struct {
+	sync.Mutex
+	entries map[string]*authLimitEntry
+}
+
+type authLimitEntry struct {
+	windowStart  time.Time // start of the rate limiting window
+	attempts     int       // attempts since windowStart
+	failureStart time.Time // time of the first failure counted
+	failures     int       // failures since failureStart
+	lockedUntil  time.Time // end of the lockout, if any
+}
+
+// authLimitKeys returns the keys the authentication attempts of a request
+// are counted against
+func authLimitKeys(r *http.Request) (keys []string) {
+	keys = append(keys, "src="+remotePublicIP(r))
+	if prefix := apiKeyPrefix(r.Header.Get("X-MIGAPIKEY")); prefix != "" {
+		keys = append(keys, "keyprefix="+prefix)
+	}
+	return
+}
+
+// allow records an authentication attempt for each key, and returns false
+// along with the time the client should wait if any of the keys is locked
+// out or above the rate limit
+func (l *authLimiter) allow(keys []string, now time.Time) (retryAfter time.Duration, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	for _, k := range keys {
+		e, found := l.entries[k]
+		if !found {
+			e = &authLimitEntry{windowStart: now}
+			l.entries[k] = e
+		}
+		if now.Before(e.lockedUntil) {
+			return e.lockedUntil.Sub(now), false
+		}
+		if now.Sub(e.windowStart) >= authRateWindow {
+			e.windowStart = now
+			e.attempts = 0
+		}
+		e.attempts++
+		if ctx.Authentication.RateLimit > 0 && e.attempts > ctx.Authentication.RateLimit {
+			return e.windowStart.Add(authRateWindow).Sub(now), false
+		}
+	}
+	return 0, true
+}
+
+// fail records an authentication failure for each key, and returns the keys
+// that got locked out as a result
+func (l *authLimiter) fail(keys []string, now time.Time) (locked []string) {
+	if ctx.Authentication.MaxFailures <= 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	for _, k := range keys {
+		e, found := l.entries[k]
+		if !found {
+			e = &authLimitEntry{windowStart: now}
+			l.entries[k] = e
+		}
+		if now.Sub(e.failureStart) >= ctx.Authentication.failureWindow {
+			e.failureStart = now
+			e.failures = 0
+		}
+		e.failures++
+		if e.failures >= ctx.Authentication.MaxFailures {
+			e.lockedUntil = now.Add(ctx.Authentication.lockoutDuration)
+			e.failures = 0
+			locked = append(locked, k)
+		}
+	}
+	return
+}
+
+// prune removes the entries that no longer limit anything
+func (l *authLimiter) prune(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	for k, e := range l.entries {
+		if now.Before(e.lockedUntil) {
+			continue
+		}
+		if now.Sub(e.windowStart) < authRateWindow {
+			continue
+		}
+		if e.failures > 0 && now.Sub(e.failureStart) < ctx.Authentication.failureWindow {
+			continue
+		}
+		delete(l.entries, k)
+	}
+}
+
+// startAuthLimiter periodically prunes the authentication limiter
+func startAuthLimiter() {
+	go func() {
+		for now := range time.Tick(authRateWindow) {
+			authLimits.prune(now)
+		}
+	}()
+}
+
+// auditLog records a security relevant event in the API logs. Audit events
+// use the same format as the request logs, with the audit category.
+func auditLog(r *http.Request, event string, detail string) {
+	ctx.Channels.Log <- mig.Log{
+		OpID: getOpID(r),
+		Desc: fmt.Sprintf("src=%s category=audit event=%s %s %s %s %s user-agent=%s",
+			remotePublicIP(r), event, detail, r.Method, r.Proto, r.URL.String(), r.UserAgent()),
+	}.Warning()
+}
@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setAuthLimits configures the authentication limits used by the tests
+func setAuthLimits(rate, failures int, window, lockout time.Duration) {
+	ctx.Authentication.RateLimit = rate
+	ctx.Authentication.MaxFailures = failures
+	ctx.Authentication.failureWindow = window
+	ctx.Authentication.lockoutDuration = lockout
+}
+
+func TestAuthLimitKeys(t *testing.T) {
+	ctx.Server.ClientPublicIPOffset = -1
+	var tests = []struct {
+		key    string
+		expect []string
+	}{
+		{"", []string{"src=192.0.2.1"}},
+		{"abcd1234" + "0123456789abcdef0123456789abcdef", []string{"src=192.0.2.1", "keyprefix=abcd1234"}},
+		// keys that do not have the format of an api key have no prefix
+		{"abcd1234", []string{"src=192.0.2.1"}},
+		{"abcd-234" + "0123456789abcdef0123456789abcdef", []string{"src=192.0.2.1"}},
+	}
+	for i, tc := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/", nil)
+		r.RemoteAddr = "192.0.2.1:4242"
+		if tc.key != "" {
+			r.Header.Set("X-MIGAPIKEY", tc.key)
+		}
+		keys := authLimitKeys(r)
+		if !reflect.DeepEqual(keys, tc.expect) {
+			t.Errorf("test %d: expected keys %v, got %v", i, tc.expect, keys)
+		}
+	}
+}
+
+func TestAuthLimiterAllow(t *testing.T) {
+	setAuthLimits(3, 0, 0, 0)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := []string{"src=192.0.2.1"}
+	both := []string{"src=192.0.2.2", "keyprefix=abcd1234"}
+	var tests = []struct {
+		keys       []string
+		offset     time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{src, 0, true, 0},
+		{src, time.Second, true, 0},
+		{src, 2 * time.Second, true, 0},
+		// the fourth attempt of the window is above the threshold
+		{src, 20 * time.Second, false, 40 * time.Second},
+		{src, 59 * time.Second, false, time.Second},
+		// a new window starts after a minute
+		{src, time.Minute, true, 0},
+		// another source is counted separately, but shares the key prefix
+		{both, time.Minute, true, 0},
+		{[]string{"src=192.0.2.3", "keyprefix=abcd1234"}, time.Minute, true, 0},
+		{[]string{"src=192.0.2.4", "keyprefix=abcd1234"}, time.Minute, true, 0},
+		{[]string{"src=192.0.2.5", "keyprefix=abcd1234"}, 90 * time.Second, false, 30 * time.Second},
+		// the source is still below its own limit
+		{src, 90 * time.Second, true, 0},
+	}
+	l := authLimiter{entries: make(map[string]*authLimitEntry)}
+	for i, tc := range tests {
+		retryAfter, ok := l.allow(tc.keys, start.Add(tc.offset))
+		if ok != tc.allowed || retryAfter != tc.retryAfter {
+			t.Errorf("test %d: expected allowed=%t retry after %s, got allowed=%t retry after %s",
+				i, tc.allowed, tc.retryAfter, ok, retryAfter)
+		}
+	}
+
+	// without a rate limit, attempts are always allowed
+	setAuthLimits(0, 0, 0, 0)
+	for i := 0; i < 100; i++ {
+		if _, ok := l.allow(src, start); !ok {
+			t.Fatal("expected attempts to be allowed without a rate limit")
+		}
+	}
+}
+
+func TestAuthLimiterLockout(t *testing.T) {
+	setAuthLimits(0, 3, 10*time.Minute, 30*time.Minute)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := []string{"src=192.0.2.1"}
+	key := []string{"src=192.0.2.2", "keyprefix=abcd1234"}
+	var tests = []struct {
+		keys    []string
+		offset  time.Duration
+		fail    bool
+		allowed bool
+		locked  []string
+	}{
+		{src, 0, true, true, nil},
+		{src, time.Minute, true, true, nil},
+		// failures older than the window are forgotten
+		{src, 11 * time.Minute, true, true, nil},
+		{src, 12 * time.Minute, true, true, nil},
+		{src, 13 * time.Minute, true, true, []string{"src=192.0.2.1"}},
+		// the source is locked out for the lockout duration
+		{src, 14 * time.Minute, false, false, nil},
+		{src, 42 * time.Minute, false, false, nil},
+		{src, 43 * time.Minute, false, true, nil},
+		// a key prefix is locked out from all sources
+		{key, 0, true, true, nil},
+		{[]string{"src=192.0.2.3", "keyprefix=abcd1234"}, time.Minute, true, true, nil},
+		{[]string{"src=192.0.2.4", "keyprefix=abcd1234"}, 2 * time.Minute, true, true, []string{"keyprefix=abcd1234"}},
+		{[]string{"src=192.0.2.5", "keyprefix=abcd1234"}, 3 * time.Minute, false, false, nil},
+		{[]string{"src=192.0.2.5"}, 3 * time.Minute, false, true, nil},
+	}
+	l := authLimiter{entries: make(map[string]*authLimitEntry)}
+	for i, tc := range tests {
+		now := start.Add(tc.offset)
+		_, ok := l.allow(tc.keys, now)
+		if ok != tc.allowed {
+			t.Errorf("test %d: expected allowed=%t, got %t", i, tc.allowed, ok)
+		}
+		if !tc.fail {
+			continue
+		}
+		locked := l.fail(tc.keys, now)
+		if !reflect.DeepEqual(locked, tc.locked) {
+			t.Errorf("test %d: expected locked keys %v, got %v", i, tc.locked, locked)
+		}
+	}
+
+	// failures are not counted when lockouts are disabled
+	setAuthLimits(0, 0, 10*time.Minute, 30*time.Minute)
+	l = authLimiter{entries: make(map[string]*authLimitEntry)}
+	for i := 0; i < 10; i++ {
+		if locked := l.fail(src, start); locked != nil {
+			t.Fatalf("expected no lockout when lockouts are disabled, got %v", locked)
+		}
+	}
+	if len(l.entries) != 0 {
+		t.Errorf("expected failures not to be recorded, got %d entries", len(l.entries))
+	}
+}
+
+func TestAuthLimiterPrune(t *testing.T) {
+	setAuthLimits(10, 3, 10*time.Minute, 30*time.Minute)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := authLimiter{entries: make(map[string]*authLimitEntry)}
+	l.allow([]string{"src=attempt"}, start)
+	l.allow([]string{"src=failure"}, start)
+	l.fail([]string{"src=failure"}, start)
+	for i := 0; i < 3; i++ {
+		l.fail([]string{"src=locked"}, start)
+	}
+	var tests = []struct {
+		offset time.Duration
+		expect []string
+	}{
+		{30 * time.Second, []string{"src=attempt", "src=failure", "src=locked"}},
+		// the rate limiting window of the attempt is over
+		{time.Minute, []string{"src=failure", "src=locked"}},
+		// the failure is older than the failure window
+		{10 * time.Minute, []string{"src=locked"}},
+		{29 * time.Minute, []string{"src=locked"}},
+		// the lockout is over
+		{30 * time.Minute, []string{}},
+	}
+	for i, tc := range tests {
+		l.prune(start.Add(tc.offset))
+		keys := []string{}
+		for _, k := range []string{"src=attempt", "src=failure", "src=locked"} {
+			if _, ok := l.entries[k]; ok {
+				keys = append(keys, k)
+			}
+		}
+		if !reflect.DeepEqual(keys, tc.expect) || len(l.entries) != len(tc.expect) {
+			t.Errorf("test %d: expected entries %v after pruning, got %v", i, tc.expect, keys)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/modules"
+)
+
+// jsonColumnsRowGroupSize is the number of rows buffered by the jsoncolumns
+// exporter before a row group is written
+const jsonColumnsRowGroupSize = 1024
+
+// baseExportColumns are the columns present in every exported row, before
+// the columns returned by the module flatteners
+var baseExportColumns = []string{"actionid", "commandid", "agentid", "agentname",
+	"queueloc", "status", "os", "arch", "ident", "publicip", "tags", "operation", "module"}
+
+// genericExportColumns are used for modules that do not implement
+// modules.HasResultsFlattener, in which case one row is exported per result
+var genericExportColumns = []string{"foundanything", "elements"}
+
+// resultsExporter writes rows of results in a given format
+type resultsExporter interface {
+	writeHeader(columns []string) error
+	writeRow(row []string) error
+	flush() error
+}
+
+// newResultsExporter returns an exporter for the requested format, along
+// with the content type of the response
+func newResultsExporter(format string, w io.Writer) (exp resultsExporter, ctype string, err error) {
+	switch format {
+	case "csv":
+		return &csvExporter{w: csv.NewWriter(w)}, "text/csv", nil
+	case "ndjson":
+		return &ndjsonExporter{enc: json.NewEncoder(w)}, "application/x-ndjson", nil
+	case "jsoncolumns":
+		return &jsonColumnsExporter{enc: json.NewEncoder(w)}, "application/x-ndjson", nil
+	}
+	return nil, "", fmt.Errorf("unknown export format '%s', must be csv, ndjson or jsoncolumns", format)
+}
+
+// csvExporter writes a header line followed by one line per row
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) writeHeader(columns []string) error {
+	return e.w.Write(columns)
+}
+
+func (e *csvExporter) writeRow(row []string) error {
+	return e.w.Write(row)
+}
+
+func (e *csvExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExporter writes one json object per line, keyed by column name
+type ndjsonExporter struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (e *ndjsonExporter) writeHeader(columns []string) error {
+	e.columns = columns
+	return nil
+}
+
+func (e *ndjsonExporter) writeRow(row []string) error {
+	obj := make(map[string]string)
+	for i, c := range e.columns {
+		if row[i] != "" {
+			obj[c] = row[i]
+		}
+	}
+	return e.enc.Encode(obj)
+}
+
+func (e *ndjsonExporter) flush() error {
+	return nil
+}
+
+// jsonColumnsExporter writes newline delimited JSON: a schema line followed by
+// row groups, each row group storing the values of each column in a separate
+// array. Column names are not repeated on every row as in ndjson, and the
+// arrays load directly into dataframes. It is not a binary columnar encoding
+// such as Parquet or Arrow.
+type jsonColumnsExporter struct {
+	enc    *json.Encoder
+	values [][]string
+	rows   int
+}
+
+type jsonColumnsSchema struct {
+	Format  string   `json:"format"`
+	Version int      `json:"version"`
+	Columns []string `json:"columns"`
+}
+
+type jsonColumnsRowGroup struct {
+	Rows   int        `json:"rows"`
+	Values [][]string `json:"values"`
+}
+
+func (e *jsonColumnsExporter) writeHeader(columns []string) error {
+	e.values = make([][]string, len(columns))
+	return e.enc.Encode(jsonColumnsSchema{Format: "mig-jsoncolumns", Version: 1, Columns: columns})
+}
+
+func (e *jsonColumnsExporter) writeRow(row []string) error {
+	for i := range e.values {
+		e.values[i] = append(e.values[i], row[i])
+	}
+	e.rows++
+	if e.rows >= jsonColumnsRowGroupSize {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *jsonColumnsExporter) flush() (err error) {
+	if e.rows == 0 {
+		return
+	}
+	err = e.enc.Encode(jsonColumnsRowGroup{Rows: e.rows, Values: e.values})
+	for i := range e.values {
+		e.values[i] = e.values[i][:0]
+	}
+	e.rows = 0
+	return
+}
+
+// exportOperation holds what is needed to flatten the results of one
+// operation of an action
+type exportOperation struct {
+	module    string
+	flattener modules.HasResultsFlattener
+	offset    int // position of the first module column in a row
+}
+
+// exportColumns computes the columns of an export for action a, and returns
+// them along with the flattening information of each operation. Operations
+// that use the same module share the same columns.
+func exportColumns(a mig.Action) (columns []string, ops []exportOperation) {
+	columns = append(columns, baseExportColumns...)
+	offsets := make(map[string]int)
+	for _, op := range a.Operations {
+		eop := exportOperation{module: op.Module}
+		if mod, ok := modules.Available[op.Module]; ok {
+			if fl, ok := mod.NewRun().(modules.HasResultsFlattener); ok {
+				eop.flattener = fl
+			}
+		}
+		key := op.Module
+		if eop.flattener == nil {
+			key = ""
+		}
+		offset, ok := offsets[key]
+		if !ok {
+			offset = len(columns)
+			offsets[key] = offset
+			if eop.flattener == nil {
+				columns = append(columns, genericExportColumns...)
+			} else {
+				for _, c := range eop.flattener.ResultsColumns() {
+					columns = append(columns, op.Module+"."+c)
+				}
+			}
+		}
+		eop.offset = offset
+		ops = append(ops, eop)
+	}
+	return
+}
+
+// commandToRows flattens the results of a command into export rows
+func commandToRows(cmd mig.Command, columns []string, ops []exportOperation) (rows [][]string, err error) {
+	tags := ""
+	if len(cmd.Agent.Tags) > 0 {
+		buf, err := json.Marshal(cmd.Agent.Tags)
+		if err != nil {
+			return nil, err
+		}
+		tags = string(buf)
+	}
+	base := []string{
+		fmt.Sprintf("%.0f", cmd.Action.ID),
+		fmt.Sprintf("%.0f", cmd.ID),
+		fmt.Sprintf("%.0f", cmd.Agent.ID),
+		cmd.Agent.Name,
+		cmd.Agent.QueueLoc,
+		cmd.Status,
+		cmd.Agent.Env.OS,
+		cmd.Agent.Env.Arch,
+		cmd.Agent.Env.Ident,
+		cmd.Agent.Env.PublicIP,
+		tags,
+	}
+	for i, res := range cmd.Results {
+		if i >= len(ops) {
+			break
+		}
+		newRow := func() []string {
+			row := make([]string, len(columns))
+			copy(row, base)
+			row[len(base)] = strconv.Itoa(i)
+			row[len(base)+1] = ops[i].module
+			return row
+		}
+		if ops[i].flattener == nil {
+			elements, err := json.Marshal(res.Elements)
+			if err != nil {
+				return nil, err
+			}
+			row := newRow()
+			row[ops[i].offset] = strconv.FormatBool(res.FoundAnything)
+			row[ops[i].offset+1] = string(elements)
+			rows = append(rows, row)
+			continue
+		}
+		flat, err := ops[i].flattener.FlattenResults(res)
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range flat {
+			row := newRow()
+			copy(row[ops[i].offset:], values)
+			rows = append(rows, row)
+		}
+	}
+	return
+}
+
+// exportActionResults streams the results of the commands of an action in a
+// tabular format, with one row per match returned by the agents
+func exportActionResults(respWriter http.ResponseWriter, request *http.Request) {
+	var (
+		err   error
+		nrows int
+	)
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving exportActionResults()"}.Debug()
+	}()
+	actionID, err := strconv.ParseFloat(mux.Vars(request)["actionid"], 64)
+	if err != nil || actionID <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Action ID '%s'", mux.Vars(request)["actionid"])})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	exp, ctype, err := newResultsExporter(format, respWriter)
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: err.Error()})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	a, err := ctx.DB.ActionByID(actionID)
+	if err != nil {
+		if a.ID == -1 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Action ID '%.0f' not found", actionID)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	columns, ops := exportColumns(a)
+
+	// from here on the response is streamed, and errors can only be logged
+	respWriter.Header().Set("Content-Type", ctype)
+	respWriter.Header().Set("Cache-Control", "no-cache")
+	respWriter.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%.0f-results.%s\"", a.ID, format))
+	respWriter.WriteHeader(http.StatusOK)
+	defer func() {
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{OpID: opid, ActionID: a.ID, Desc: fmt.Sprintf("results export interrupted: %v", err)}.Err()
+		}
+		ctx.Channels.Log <- mig.Log{
+			OpID:     opid,
+			ActionID: a.ID,
+			Desc: fmt.Sprintf("src=%s category=investigator auth=[%s %.0f] %s %s %s resp_code=%d rows=%d user-agent=%s",
+				remotePublicIP(request), getInvName(request), getInvID(request), request.Method, request.Proto,
+				request.URL.String(), http.StatusOK, nrows, request.UserAgent()),
+		}
+	}()
+	err = exp.writeHeader(columns)
+	if err != nil {
+		return
+	}
+	err = ctx.DB.IterCommandsByActionID(a.ID, func(cmd mig.Command) error {
+		rows, err := commandToRows(cmd, columns, ops)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			err = exp.writeRow(row)
+			if err != nil {
+				return err
+			}
+			nrows++
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	err = exp.flush()
+}
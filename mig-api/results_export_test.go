@@ -0,0 +1,294 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/database/memory"
+	"github.com/mozilla/mig/modules"
+	_ "github.com/mozilla/mig/modules/memory"
+)
+
+// exportTestResults are results returned by each module in the tests, along
+// with the module columns of the rows they are flattened into
+var exportTestResults = []struct {
+	module  string
+	result  modules.Result
+	columns []string
+	rows    [][]string
+}{
+	{"file",
+		modules.Result{FoundAnything: true, Elements: map[string]interface{}{
+			"s1": []interface{}{map[string]interface{}{
+				"file": "/etc/passwd",
+				"fileinfo": map[string]interface{}{"size": 1613, "mode": "-rw-r--r--",
+					"lastmodified": "2017-02-21 16:45:09 +0000 UTC", "sha256": "ABCDEF"},
+			}},
+		}},
+		[]string{"file.search", "file.file", "file.size", "file.mode", "file.lastmodified", "file.sha256"},
+		[][]string{{"s1", "/etc/passwd", "1613", "-rw-r--r--", "2017-02-21 16:45:09 +0000 UTC", "abcdef"}},
+	},
+	{"netstat",
+		modules.Result{FoundAnything: true, Elements: map[string]interface{}{
+			"s1": []interface{}{map[string]interface{}{"localaddr": "127.0.0.1", "localport": 22}},
+		}},
+		[]string{"foundanything", "elements"},
+		[][]string{{"true", `{"s1":[{"localaddr":"127.0.0.1","localport":22}]}`}},
+	},
+	{"memory",
+		modules.Result{FoundAnything: true, Elements: map[string]interface{}{
+			"s1": []interface{}{
+				map[string]interface{}{"process": map[string]interface{}{"name": "sshd", "pid": 812}},
+				map[string]interface{}{"process": map[string]interface{}{"name": "sshd", "pid": 4051}},
+			},
+		}},
+		[]string{"memory.search", "memory.pid", "memory.name"},
+		[][]string{{"s1", "812", "sshd"}, {"s1", "4051", "sshd"}},
+	},
+	{"pkg",
+		modules.Result{FoundAnything: true, Elements: map[string]interface{}{
+			"packages": []interface{}{
+				map[string]interface{}{"name": "openssl", "version": "1.1.0f-3", "type": "dpkg", "arch": "amd64"},
+			},
+		}},
+		[]string{"pkg.name", "pkg.version", "pkg.type", "pkg.arch"},
+		[][]string{{"openssl", "1.1.0f-3", "dpkg", "amd64"}},
+	},
+}
+
+func exportTestCommand(results ...modules.Result) mig.Command {
+	return mig.Command{
+		ID:     2,
+		Action: mig.Action{ID: 1},
+		Agent: mig.Agent{ID: 3, Name: "agt1.example.net", QueueLoc: "linux.agt1.example.net",
+			Env: mig.AgentEnv{OS: "linux", Arch: "amd64", Ident: "Debian 9", PublicIP: "192.0.2.1"}},
+		Status:  mig.StatusSuccess,
+		Results: results,
+	}
+}
+
+func TestCommandToRows(t *testing.T) {
+	base := []string{"1", "2", "3", "agt1.example.net", "linux.agt1.example.net", mig.StatusSuccess,
+		"linux", "amd64", "Debian 9", "192.0.2.1", "", "0"}
+	for _, tc := range exportTestResults {
+		columns, ops := exportColumns(mig.Action{Operations: []mig.Operation{{Module: tc.module}}})
+		expcols := append(append([]string{}, baseExportColumns...), tc.columns...)
+		if !reflect.DeepEqual(columns, expcols) {
+			t.Errorf("%s: unexpected columns %v", tc.module, columns)
+			continue
+		}
+		rows, err := commandToRows(exportTestCommand(tc.result), columns, ops)
+		if err != nil {
+			t.Errorf("%s: %v", tc.module, err)
+			continue
+		}
+		var exprows [][]string
+		for _, values := range tc.rows {
+			row := append(append([]string{}, base...), tc.module)
+			exprows = append(exprows, append(row, values...))
+		}
+		if !reflect.DeepEqual(rows, exprows) {
+			t.Errorf("%s: expected rows %q, got %q", tc.module, exprows, rows)
+		}
+	}
+}
+
+func TestCommandToRowsOperations(t *testing.T) {
+	// operations that use the same module share the same columns, and
+	// modules without a flattener share the generic columns
+	a := mig.Action{Operations: []mig.Operation{{Module: "file"}, {Module: "netstat"}, {Module: "file"}, {Module: "ping"}}}
+	columns, ops := exportColumns(a)
+	if len(columns) != len(baseExportColumns)+6+2 {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+	for i, offset := range []int{13, 19, 13, 19} {
+		if ops[i].offset != offset {
+			t.Errorf("operation %d: expected offset %d, got %d", i, offset, ops[i].offset)
+		}
+	}
+	file, netstat := exportTestResults[0].result, exportTestResults[1].result
+	rows, err := commandToRows(exportTestCommand(file, netstat, file), columns, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(rows))
+	}
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			t.Errorf("row %d: expected %d values, got %d", i, len(columns), len(row))
+		}
+		if row[11] != []string{"0", "1", "2"}[i] || row[12] != a.Operations[i].Module {
+			t.Errorf("row %d: unexpected operation %q %q", i, row[11], row[12])
+		}
+	}
+	if rows[2][14] != "/etc/passwd" || rows[2][19] != "" {
+		t.Errorf("unexpected file row %q", rows[2])
+	}
+}
+
+func TestResultsExporters(t *testing.T) {
+	columns := []string{"agentname", "pkg.name", "pkg.version"}
+	rows := [][]string{{"agt1", "openssl", "1.1.0f-3"}, {"agt2", "openssl", ""}}
+	var tests = []struct {
+		format, ctype, output string
+	}{
+		{"csv", "text/csv",
+			"agentname,pkg.name,pkg.version\nagt1,openssl,1.1.0f-3\nagt2,openssl,\n"},
+		{"ndjson", "application/x-ndjson",
+			`{"agentname":"agt1","pkg.name":"openssl","pkg.version":"1.1.0f-3"}` + "\n" +
+				`{"agentname":"agt2","pkg.name":"openssl"}` + "\n"},
+		{"jsoncolumns", "application/x-ndjson",
+			`{"format":"mig-jsoncolumns","version":1,"columns":["agentname","pkg.name","pkg.version"]}` + "\n" +
+				`{"rows":2,"values":[["agt1","agt2"],["openssl","openssl"],["1.1.0f-3",""]]}` + "\n"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		exp, ctype, err := newResultsExporter(tc.format, &buf)
+		if err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if ctype != tc.ctype {
+			t.Errorf("%s: expected content type %q, got %q", tc.format, tc.ctype, ctype)
+		}
+		err = exp.writeHeader(columns)
+		for _, row := range rows {
+			if err == nil {
+				err = exp.writeRow(row)
+			}
+		}
+		if err == nil {
+			err = exp.flush()
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if buf.String() != tc.output {
+			t.Errorf("%s: unexpected output %q", tc.format, buf.String())
+		}
+	}
+	_, _, err := newResultsExporter("parquet", &bytes.Buffer{})
+	if err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestJSONColumnsRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	exp := &jsonColumnsExporter{enc: json.NewEncoder(&buf)}
+	err := exp.writeHeader([]string{"n"})
+	for i := 0; i < jsonColumnsRowGroupSize+1 && err == nil; i++ {
+		err = exp.writeRow([]string{"x"})
+	}
+	if err == nil {
+		err = exp.flush()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a schema and 2 row groups, got %d lines", len(lines))
+	}
+	for i, nrows := range []int{jsonColumnsRowGroupSize, 1} {
+		var rg jsonColumnsRowGroup
+		err = json.Unmarshal([]byte(lines[i+1]), &rg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rg.Rows != nrows || len(rg.Values[0]) != nrows {
+			t.Errorf("row group %d: expected %d rows, got %d", i, nrows, rg.Rows)
+		}
+	}
+}
+
+func TestExportActionResults(t *testing.T) {
+	db := memory.New()
+	defer db.Close()
+	ctx.DB = db
+	ctx.Channels.Log = make(chan mig.Log, 100)
+	go func() {
+		for range ctx.Channels.Log {
+		}
+	}()
+	now := time.Now()
+	err := db.InsertAgent(mig.Agent{Name: "agt1.example.net", QueueLoc: "linux.agt1.example.net",
+		Mode: "daemon", PID: 1234, Status: mig.AgtStatusOnline, StartTime: now, HeartBeatTS: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agt, err := db.AgentByQueueAndPID("linux.agt1.example.net", 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mig.Action{ID: 1, Name: "export test", ValidFrom: now, ExpireAfter: now.Add(time.Hour)}
+	cmd := exportTestCommand()
+	for _, tc := range exportTestResults {
+		a.Operations = append(a.Operations, mig.Operation{Module: tc.module})
+		cmd.Results = append(cmd.Results, tc.result)
+	}
+	err = db.InsertAction(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.InsertCommand(cmd, agt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/action/{actionid}/results/export", exportActionResults)
+	var tests = []struct {
+		desc, path string
+		code       int
+		ctype      string
+		lines      int
+	}{
+		// a header or schema line, then 1 file, 1 netstat, 2 memory and 1 pkg rows
+		{"default format", "/api/v1/action/1/results/export", http.StatusOK, "text/csv", 6},
+		{"csv", "/api/v1/action/1/results/export?format=csv", http.StatusOK, "text/csv", 6},
+		{"ndjson", "/api/v1/action/1/results/export?format=ndjson", http.StatusOK, "application/x-ndjson", 5},
+		{"jsoncolumns", "/api/v1/action/1/results/export?format=jsoncolumns", http.StatusOK, "application/x-ndjson", 2},
+		{"unknown format", "/api/v1/action/1/results/export?format=xml", http.StatusBadRequest, "application/json", 0},
+		{"invalid action id", "/api/v1/action/abc/results/export", http.StatusBadRequest, "application/json", 0},
+		{"negative action id", "/api/v1/action/-1/results/export", http.StatusBadRequest, "application/json", 0},
+		{"unknown action", "/api/v1/action/42/results/export", http.StatusNotFound, "application/json", 0},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected HTTP %d, got %d: %s", tc.desc, tc.code, w.Code, w.Body.String())
+			continue
+		}
+		if ctype := w.Header().Get("Content-Type"); !strings.HasPrefix(ctype, tc.ctype) {
+			t.Errorf("%s: expected content type %q, got %q", tc.desc, tc.ctype, ctype)
+		}
+		if tc.lines == 0 {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != tc.lines {
+			t.Errorf("%s: expected %d lines, got %d: %s", tc.desc, tc.lines, len(lines), w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "4051") {
+			t.Errorf("%s: memory results missing from export: %s", tc.desc, w.Body.String())
+		}
+	}
+}
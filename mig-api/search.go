@@ -259,6 +259,8 @@ func parseSearchParameters(qp url.Values) (p migdbsearch.Parameters, filterFound
 			if err != nil {
 				panic("before date not in RFC3339 format")
 			}
+		case "caseid":
+			p.CaseID = qp["caseid"][0]
 		case "commandid":
 			p.CommandID = qp["commandid"][0]
 		case "foundanything":
@@ -292,6 +294,12 @@ func parseSearchParameters(qp url.Values) (p migdbsearch.Parameters, filterFound
 			if err != nil {
 				panic("invalid offset parameter")
 			}
+		case "resultpath":
+			p.ResultPath = qp["resultpath"][0]
+		case "resulttext":
+			p.ResultText = qp["resulttext"][0]
+		case "resultvalue":
+			p.ResultValue = qp["resultvalue"][0]
 		case "status":
 			p.Status = qp["status"][0]
 		case "target":
@@ -302,5 +310,19 @@ func parseSearchParameters(qp url.Values) (p migdbsearch.Parameters, filterFound
 			p.Type = qp["type"][0]
 		}
 	}
+	if p.CaseID != "∞" && p.Type != "action" {
+		panic("the caseid parameter can only be used in action searches")
+	}
+	if p.ResultPath != "" || p.ResultValue != "" || p.ResultText != "" {
+		if p.Type != "command" {
+			panic("results parameters can only be used in command searches")
+		}
+		if p.ResultPath != "" || p.ResultValue != "" {
+			_, err = p.ResultsJSONPath()
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
 	return
 }
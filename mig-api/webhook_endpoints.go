@@ -0,0 +1,251 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jvehent/cljs"
+	"github.com/mozilla/mig"
+)
+
+// getWebhooks returns the webhooks, or a single webhook if the webhookid
+// parameter is set. Secrets are never returned.
+func getWebhooks(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getWebhooks()"}.Debug()
+	}()
+	var (
+		webhooks []mig.Webhook
+		err      error
+	)
+	if request.URL.Query().Get("webhookid") != "" {
+		id, ok := webhookIDParam(request.URL.Query().Get("webhookid"), opid, resource, respWriter, request)
+		if !ok {
+			return
+		}
+		w, ok := webhookByID(id, opid, resource, respWriter, request)
+		if !ok {
+			return
+		}
+		webhooks = append(webhooks, w)
+	} else {
+		webhooks, err = ctx.DB.Webhooks()
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, w := range webhooks {
+		w.Secret = ""
+		err = resource.AddItem(cljs.Item{
+			Href: fmt.Sprintf("%s/webhook?webhookid=%.0f", ctx.Server.BaseURL, w.ID),
+			Data: []cljs.Data{{Name: "webhook", Value: w}},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// createWebhook creates a webhook from the JSON document sent in the webhook
+// parameter. The secret deliveries are signed with is generated by the API,
+// and is only returned in the response to this request.
+func createWebhook(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving createWebhook()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	var w mig.Webhook
+	err = json.Unmarshal([]byte(request.FormValue("webhook")), &w)
+	if err == nil {
+		err = w.Validate()
+	}
+	if err != nil {
+		resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: fmt.Sprintf("Invalid webhook: %v", err)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	w.ID = mig.GenID()
+	w.Status = mig.WebhookStatusActive
+	w.CreatedAt = time.Now().UTC()
+	w.Secret, err = mig.GenerateWebhookSecret()
+	if err != nil {
+		panic(err)
+	}
+	err = ctx.DB.InsertWebhook(w)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f created webhook %.0f '%s' to %s",
+		getInvID(request), w.ID, w.Name, w.URL)}
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/webhook?webhookid=%.0f", ctx.Server.BaseURL, w.ID),
+		Data: []cljs.Data{{Name: "webhook", Value: w}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusCreated, resource, respWriter, request)
+}
+
+// updateWebhookStatus enables or disables a webhook. Deliveries pending for a
+// disabled webhook are marked as failed when they are due.
+func updateWebhookStatus(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving updateWebhookStatus()"}.Debug()
+	}()
+	err := request.ParseForm()
+	if err != nil {
+		panic(err)
+	}
+	id, ok := webhookIDParam(request.FormValue("webhookid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	status := request.FormValue("status")
+	if status != mig.WebhookStatusActive && status != mig.WebhookStatusDisabled {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid webhook status '%s'", status)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	w, ok := webhookByID(id, opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	err = ctx.DB.UpdateWebhookStatus(w.ID, status)
+	if err != nil {
+		panic(err)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: opid, Desc: fmt.Sprintf("investigator %.0f set webhook %.0f to status '%s'",
+		getInvID(request), w.ID, status)}
+	w.Secret = ""
+	w.Status = status
+	err = resource.AddItem(cljs.Item{
+		Href: fmt.Sprintf("%s/webhook?webhookid=%.0f", ctx.Server.BaseURL, w.ID),
+		Data: []cljs.Data{{Name: "webhook", Value: w}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// getWebhookDeliveries returns the delivery log of a webhook, most recent first
+func getWebhookDeliveries(respWriter http.ResponseWriter, request *http.Request) {
+	opid := getOpID(request)
+	loc := fmt.Sprintf("%s%s", ctx.Server.Host, request.URL.String())
+	resource := cljs.New(loc)
+	defer func() {
+		if e := recover(); e != nil {
+			emsg := fmt.Sprintf("%v", e)
+			ctx.Channels.Log <- mig.Log{OpID: opid, Desc: emsg}.Err()
+			resource.SetError(cljs.Error{Code: fmt.Sprintf("%.0f", opid), Message: emsg})
+			respond(http.StatusInternalServerError, resource, respWriter, request)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: opid, Desc: "leaving getWebhookDeliveries()"}.Debug()
+	}()
+	id, ok := webhookIDParam(request.URL.Query().Get("webhookid"), opid, resource, respWriter, request)
+	if !ok {
+		return
+	}
+	limit := 100
+	if request.URL.Query().Get("limit") != "" {
+		var err error
+		limit, err = strconv.Atoi(request.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Invalid limit '%s'", request.URL.Query().Get("limit"))})
+			respond(http.StatusBadRequest, resource, respWriter, request)
+			return
+		}
+	}
+	if _, ok = webhookByID(id, opid, resource, respWriter, request); !ok {
+		return
+	}
+	deliveries, err := ctx.DB.WebhookDeliveries(id, limit)
+	if err != nil {
+		panic(err)
+	}
+	for _, d := range deliveries {
+		err = resource.AddItem(cljs.Item{
+			Href: fmt.Sprintf("%s/webhook/deliveries?webhookid=%.0f", ctx.Server.BaseURL, id),
+			Data: []cljs.Data{{Name: "webhookdelivery", Value: d}},
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	respond(http.StatusOK, resource, respWriter, request)
+}
+
+// webhookIDParam parses a webhook ID, and responds with a bad request if it is invalid
+func webhookIDParam(param string, opid float64, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (id float64, ok bool) {
+	id, err := strconv.ParseFloat(param, 64)
+	if err != nil || id <= 0 {
+		resource.SetError(cljs.Error{
+			Code:    fmt.Sprintf("%.0f", opid),
+			Message: fmt.Sprintf("Invalid Webhook ID '%s'", param)})
+		respond(http.StatusBadRequest, resource, respWriter, request)
+		return
+	}
+	return id, true
+}
+
+// webhookByID retrieves a webhook, and responds with not found if it does not exist
+func webhookByID(id, opid float64, resource *cljs.Resource,
+	respWriter http.ResponseWriter, request *http.Request) (w mig.Webhook, ok bool) {
+	w, err := ctx.DB.WebhookByID(id)
+	if err != nil {
+		if fmt.Sprintf("%v", err) == "Error while retrieving webhook: 'sql: no rows in result set'" {
+			resource.SetError(cljs.Error{
+				Code:    fmt.Sprintf("%.0f", opid),
+				Message: fmt.Sprintf("Webhook ID '%.0f' not found", id)})
+			respond(http.StatusNotFound, resource, respWriter, request)
+			return
+		}
+		panic(err)
+	}
+	return w, true
+}
@@ -0,0 +1,97 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+// mig-dbmigrate creates and upgrades the schema of the MIG database
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mozilla/mig"
+	migdb "github.com/mozilla/mig/database"
+	"gopkg.in/gcfg.v1"
+)
+
+// config only reads the postgres section of a configuration file, so the
+// configuration of the API or the scheduler can be used as well. Migrations
+// create tables and roles, and need a user with more privileges than the
+// ones the API and the scheduler run with.
+type config struct {
+	Postgres struct {
+		Host, User, Password, DBName, SSLMode string
+		Port                                  int
+	}
+}
+
+func main() {
+	var (
+		err  error
+		conf config
+	)
+	var cfgPath = flag.String("c", "/etc/mig/dbmigrate.cfg", "Load database configuration from file")
+	var status = flag.Bool("status", false, "Show the schema version of the database and exit")
+	var baseline = flag.Bool("baseline", false, "Adopt a database created before schema migrations, then migrate it")
+	var printSQL = flag.Bool("print", false, "Print the SQL that creates the latest schema and exit")
+	var showversion = flag.Bool("V", false, "Show build version and exit")
+	flag.Parse()
+
+	if *showversion {
+		fmt.Println(mig.Version)
+		os.Exit(0)
+	}
+	if *printSQL {
+		fmt.Print(migdb.SchemaSQL())
+		os.Exit(0)
+	}
+
+	err = gcfg.FatalOnly(gcfg.ReadFileInto(&conf, *cfgPath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to read configuration: %v\n", err)
+		os.Exit(1)
+	}
+	db, err := migdb.Open(conf.Postgres.DBName, conf.Postgres.User, conf.Postgres.Password,
+		conf.Postgres.Host, conf.Postgres.Port, conf.Postgres.SSLMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to connect to database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("database schema version is %d, latest version is %d\n", version, migdb.LatestSchemaVersion())
+	if *status {
+		if version != migdb.LatestSchemaVersion() {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
+	if *baseline {
+		err = db.Baseline()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("database baselined at schema version 1")
+	}
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Printf("applied migration %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(applied) == 0 {
+		fmt.Println("database schema is up to date")
+	}
+}
@@ -18,6 +18,7 @@ type Context struct {
 		Log        chan mig.Log
 		ExitNotify chan bool
 		Results    chan mig.RunnerResult
+		ActionDone chan float64
 	}
 	Runner struct {
 		Directory       string
@@ -47,6 +48,7 @@ func initContext(config string) (ctx Context, err error) {
 	ctx.Channels.Log = make(chan mig.Log, 37)
 	ctx.Channels.Results = make(chan mig.RunnerResult, 64)
 	ctx.Channels.ExitNotify = make(chan bool, 64)
+	ctx.Channels.ActionDone = make(chan float64, 64)
 	ctx.Entities = make(map[string]*entity)
 	err = gcfg.ReadFileInto(&ctx, config)
 	if err != nil {
@@ -199,12 +199,42 @@ func scanInFlight(reslist []mig.RunnerResult) ([]mig.RunnerResult, error) {
 	return reslist, nil
 }
 
+// Follow the progress of an action using the API action stream, and notify
+// the results processing routine when the action has completed so results
+// can be fetched without waiting for the action to expire. If the API does
+// not support streaming, the results are fetched after expiration.
+func followAction(a mig.Action) {
+	cli, err := client.NewClient(ctx.ClientConf, "mig-runner-results")
+	if err != nil {
+		mlog("%.0f: unable to follow action: %v", a.ID, err)
+		return
+	}
+	stream, err := cli.OpenActionStream(a.ID)
+	if err != nil {
+		mlog("%.0f: action stream unavailable, waiting for expiration: %v", a.ID, err)
+		return
+	}
+	defer stream.Close()
+	for {
+		ev, err := stream.Next()
+		if err != nil {
+			return
+		}
+		if ev.Type == mig.ActionEventDone {
+			ctx.Channels.ActionDone <- a.ID
+			return
+		}
+	}
+}
+
 // Results processing routine. Keeps track of known submitted actions and
 // retrieves results / runs plugins as results become available.
 func processResults() {
 	mlog("results processing routine started")
 
 	var reslist []mig.RunnerResult
+	followed := make(map[float64]bool)
+	landed := make(map[float64]bool)
 
 	for {
 		timeout := false
@@ -217,6 +247,9 @@ func processResults() {
 				continue
 			}
 			reslist = append(reslist, nr)
+		case aid := <-ctx.Channels.ActionDone:
+			mlog("action %.0f has completed", aid)
+			landed[aid] = true
 		case <-time.After(time.Duration(5) * time.Second):
 			timeout = true
 		}
@@ -230,6 +263,12 @@ func processResults() {
 		if err != nil {
 			mlog("error scanning for cached inflight requests: %v", err)
 		}
+		for _, x := range reslist {
+			if !followed[x.Action.ID] {
+				followed[x.Action.ID] = true
+				go followAction(x.Action)
+			}
+		}
 
 		resDelay := ctx.Client.DelayResults
 
@@ -247,7 +286,9 @@ func processResults() {
 					extime = extime.Add(d)
 				}
 			}
-			if time.Now().After(extime) {
+			if landed[x.Action.ID] || time.Now().After(extime) {
+				delete(landed, x.Action.ID)
+				delete(followed, x.Action.ID)
 				err := getResults(x)
 				if err != nil {
 					mlog("results error for %v: %v", x.EntityName, err)
@@ -12,15 +12,18 @@ import (
 	"time"
 )
 
-// Given an agent queue location queueLoc, send kill actions for duplicate
-// agents. Where multiple agents exist on the same host, we will attempt
-// to kill the older agents.
-func killDupAgents(queueLoc string, ctx Context) (err error) {
+// resolveDupAgents applies the duplicate agents policy to the agents running
+// on queueLoc. Destruction orders that failed are re-issued first, then the
+// remaining agents are passed to the policy engine, which decides to kill the
+// oldest or newest agent, or to only raise an alert. Agents that share a queue
+// location but run on distinct hosts are clones, and always raise an alert.
+// Decisions are recorded in the database along with their evidence.
+func resolveDupAgents(queueLoc string, ctx Context) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = fmt.Errorf("killDupAgents() -> %v", e)
+			err = fmt.Errorf("resolveDupAgents() -> %v", e)
 		}
-		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving killDupAgents()"}.Debug()
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving resolveDupAgents()"}.Debug()
 	}()
 	hbfreq, err := time.ParseDuration(ctx.Agent.HeartbeatFreq)
 	if err != nil {
@@ -28,75 +31,93 @@ func killDupAgents(queueLoc string, ctx Context) (err error) {
 	}
 	pointInTime := time.Now().Add(-hbfreq)
 	agents, err := ctx.DB.ActiveAgentsByQueue(queueLoc, pointInTime)
-	agentsCount := len(agents)
-	if agentsCount < 2 {
+	if err != nil {
+		panic(err)
+	}
+	if len(agents) < 2 {
 		return
 	}
-	destroyedAgents := 0
-	leftAloneAgents := 0
+	var remaining []mig.Agent
 	for _, agent := range agents {
-		if agent.Status == "destroyed" {
+		if agent.Status == mig.AgtStatusDestroyed {
 			// if the agent has already been marked as destroyed, check if
 			// that was done longer than 3 heartbeats ago. If it did, the
 			// destruction failed, and we need to reissue a destruction order
-			hbFreq, err := time.ParseDuration(ctx.Agent.HeartbeatFreq)
-			if err != nil {
-				panic(err)
-			}
-			pointInTime := time.Now().Add(-hbFreq * 3)
-			if agent.DestructionTime.Before(pointInTime) {
+			if agent.DestructionTime.Before(time.Now().Add(-hbfreq * 3)) {
 				err = issueKillAction(agent, ctx)
 				if err != nil {
 					panic(err)
 				}
-				destroyedAgents++
 				desc := fmt.Sprintf("Re-issuing destruction action for "+
 					"agent '%s' with PID '%d'.", agent.Name, agent.PID)
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}.Debug()
-			} else {
-				leftAloneAgents++
 			}
+			continue
 		}
+		remaining = append(remaining, agent)
 	}
-
-	remainingAgents := agentsCount - destroyedAgents - leftAloneAgents
-	if remainingAgents > 1 {
-		// there's still some agents left. if killdupagents is set, issue kill orders
-		if ctx.Agent.KillDupAgents {
-			oldest := agents[0]
-			for _, agent := range agents {
-				if agent.Status != "online" {
-					continue
-				}
-				if agent.StartTime.Before(oldest.StartTime) {
-					oldest = agent
-				}
-			}
-			desc := fmt.Sprintf("Issuing destruction action for agent '%s' "+
-				"with PID '%d'.", oldest.Name, oldest.PID)
-			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}
-			err = issueKillAction(oldest, ctx)
-			if err != nil {
-				panic(err)
-			}
-			// throttling to prevent issuing too many kill orders at the same time
-			time.Sleep(5 * time.Second)
+	if len(remaining) < 2 {
+		return
+	}
+	decision, kill := mig.ResolveDuplicateAgents(queueLoc, ctx.Agent.DuplicatePolicy, remaining)
+	err = recordDupDecision(decision, ctx)
+	if err != nil {
+		panic(err)
+	}
+	if kill != nil {
+		desc := fmt.Sprintf("Issuing destruction action for agent '%s' "+
+			"with PID '%d' (policy %s).", kill.Name, kill.PID, decision.Policy)
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}
+		err = issueKillAction(*kill, ctx)
+		if err != nil {
+			panic(err)
+		}
+		// throttling to prevent issuing too many kill orders at the same time
+		time.Sleep(5 * time.Second)
+		return
+	}
+	// Build a list of relevant agent names to include in the manual inspection
+	// notification
+	var namelist string
+	for _, agent := range remaining {
+		if namelist == "" {
+			namelist = agent.Name
 		} else {
-			// Build a list of relevant agent names to include in the manual inspection
-			// notification
-			var namelist string
-			for _, agent := range agents {
-				if namelist == "" {
-					namelist = agent.Name
-				} else {
-					namelist += ", " + agent.Name
-				}
-			}
-			desc := fmt.Sprintf("found %v agents running on %v. Require "+
-				"manual inspection (%v).", remainingAgents, queueLoc, namelist)
-			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}.Warning()
+			namelist += ", " + agent.Name
 		}
 	}
+	desc := fmt.Sprintf("found %d agents running on %s. Require "+
+		"manual inspection (%s).", len(remaining), queueLoc, namelist)
+	if decision.Kind == mig.DupKindClone {
+		desc = fmt.Sprintf("found %d cloned agents sharing queue %s from public IPs %v "+
+			"and instances %v. Require manual inspection (%s).", len(remaining), queueLoc,
+			decision.Evidence.PublicIPs, decision.Evidence.InstanceIDs, namelist)
+	}
+	ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: desc}.Warning()
+	return
+}
+
+// recordDupDecision stores a duplicate agents decision in the database, unless
+// the last decision taken on the queue was the same, so alerts raised on every
+// periodic run are only recorded once
+func recordDupDecision(decision mig.DuplicateDecision, ctx Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("recordDupDecision() -> %v", e)
+		}
+	}()
+	last, err := ctx.DB.DuplicateDecisionsByQueue(decision.QueueLoc, 1)
+	if err != nil {
+		panic(err)
+	}
+	if len(last) == 1 && decision.SameAs(last[0]) {
+		return
+	}
+	decision.ID = mig.GenID()
+	err = ctx.DB.InsertDuplicateDecision(decision)
+	if err != nil {
+		panic(err)
+	}
 	return
 }
 
@@ -116,7 +137,11 @@ func issueKillAction(agent mig.Agent, ctx Context) (err error) {
 		Target:        fmt.Sprintf("queueloc='%s'", agent.QueueLoc),
 		ValidFrom:     time.Now().Add(-60 * time.Second).UTC(),
 		ExpireAfter:   time.Now().Add(30 * time.Minute).UTC(),
-		SyntaxVersion: 2,
+		SyntaxVersion: mig.ActionVersion,
+	}
+	err = killAction.PrepareSigning()
+	if err != nil {
+		panic(err)
 	}
 	var opparams struct {
 		PID     int    `json:"pid"`
@@ -117,7 +117,7 @@ func getHeartbeats(msg amqp.Delivery, ctx Context) (err error) {
 			agt.Status = mig.AgtStatusOnline
 			// create a new agent, set starttime to now
 			agt.StartTime = time.Now()
-			err = ctx.DB.InsertAgent(agt, nil)
+			err = ctx.DB.InsertAgent(agt)
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("Heartbeat DB insertion failed with error '%v' for agent '%s'", err, agt.Name)}.Err()
 			}
@@ -33,6 +33,7 @@ type Context struct {
 		// configuration
 		TimeOut, HeartbeatFreq, Whitelist string
 		DetectMultiAgents, KillDupAgents  bool
+		DuplicatePolicy                   string
 		LogActions                        bool
 	}
 	Channels struct {
@@ -47,7 +48,7 @@ type Context struct {
 		Freq string
 	}
 	Periodic struct {
-		Freq, DeleteAfter, QueuesCleanupFreq string
+		Freq, DeleteAfter, QueuesCleanupFreq, ArchiveAfter string
 	}
 	Directories struct {
 		// configuration
@@ -61,7 +62,7 @@ type Context struct {
 			InFlight, Returned string
 		}
 	}
-	DB migdb.DB
+	DB migdb.Store
 	MQ struct {
 		// configuration
 		Host, User, Pass, Vhost string
@@ -85,7 +86,14 @@ type Context struct {
 	Stats struct {
 	}
 	Logging mig.Logging
-	Debug   struct {
+	Metrics struct {
+		Listen string
+	}
+	Webhooks struct {
+		DeliveryFreq, Timeout string
+		MaxAttempts           int
+	}
+	Debug struct {
 		Heartbeats bool
 	}
 }
@@ -103,6 +111,31 @@ func Init(path string) (ctx Context, err error) {
 	if err != nil {
 		panic(err)
 	}
+	// without an explicit duplicate agents policy, killdupagents selects
+	// between killing the oldest agent and raising an alert
+	if ctx.Agent.DuplicatePolicy == "" {
+		ctx.Agent.DuplicatePolicy = mig.DupPolicyAlert
+		if ctx.Agent.KillDupAgents {
+			ctx.Agent.DuplicatePolicy = mig.DupPolicyKillOldest
+		}
+	}
+	err = mig.ValidateDuplicatePolicy(ctx.Agent.DuplicatePolicy)
+	if err != nil {
+		panic(err)
+	}
+	// decommissioned agents are archived after 30 days unless configured otherwise
+	if ctx.Periodic.ArchiveAfter == "" {
+		ctx.Periodic.ArchiveAfter = "720h"
+	}
+	if ctx.Webhooks.DeliveryFreq == "" {
+		ctx.Webhooks.DeliveryFreq = "10s"
+	}
+	if ctx.Webhooks.Timeout == "" {
+		ctx.Webhooks.Timeout = "10s"
+	}
+	if ctx.Webhooks.MaxAttempts < 1 {
+		ctx.Webhooks.MaxAttempts = 8
+	}
 
 	ctx, err = initChannels(ctx)
 	if err != nil {
@@ -197,13 +230,18 @@ func initDB(orig_ctx Context) (ctx Context, err error) {
 
 	fmt.Fprintf(os.Stdout, "Attempting to connect to postgresql database...")
 	ctx = orig_ctx
-	ctx.DB, err = migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
+	db, err := migdb.Open(ctx.Postgres.DBName, ctx.Postgres.User, ctx.Postgres.Password,
 		ctx.Postgres.Host, ctx.Postgres.Port, ctx.Postgres.SSLMode)
 	if err != nil {
 		panic(err)
 	}
 	ctx.Channels.Log <- mig.Log{Desc: "Database connection opened"}
-	ctx.DB.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	db.SetMaxOpenConns(ctx.Postgres.MaxConn)
+	err = db.CheckSchemaVersion()
+	if err != nil {
+		panic(err)
+	}
+	ctx.DB = &db
 	return
 }
 
@@ -106,6 +106,7 @@ func flyAction(ctx Context, a mig.Action, origin string) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	metricActions.Inc("inflight")
 	desc := fmt.Sprintf("flyAction(): Action '%s' is in flight", a.Name)
 	ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: desc}.Debug()
 	return
@@ -142,6 +143,7 @@ func invalidAction(ctx Context, a mig.Action, origin string) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	metricActions.Inc("invalid")
 	desc := fmt.Sprintf("invalidAction(): Action '%s' has been marked as invalid.", a.Name)
 	ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: desc}.Debug()
 	return
@@ -183,6 +185,7 @@ func landAction(ctx Context, a mig.Action) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	metricActions.Inc("done")
 	desc = fmt.Sprintf("landAction(): Action '%s' has landed", a.Name)
 	ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: desc}.Debug()
 	return
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/mozilla/mig"
+	"github.com/mozilla/mig/metrics"
+)
+
+// schedMetrics holds the metrics exposed by the scheduler on the /metrics
+// endpoint of the metrics listener
+var schedMetrics = metrics.NewRegistry()
+
+var (
+	metricAMQPConsumed = schedMetrics.NewCounter("mig_scheduler_amqp_consumed_total",
+		"Messages consumed from the relay, by queue.", "queue")
+	metricAMQPPublished = schedMetrics.NewCounter("mig_scheduler_amqp_published_total",
+		"Commands published to agent queues on the relay.")
+	metricAMQPPublishErrors = schedMetrics.NewCounter("mig_scheduler_amqp_publish_errors_total",
+		"Commands that could not be published to the relay.")
+	metricCommands = schedMetrics.NewCounter("mig_scheduler_commands_total",
+		"Commands sent to agents, and commands returned by agents or expired, by status.", "status")
+	metricActions = schedMetrics.NewCounter("mig_scheduler_actions_total",
+		"Actions processed by the scheduler, by final state.", "state")
+	metricWebhookDeliveries = schedMetrics.NewCounter("mig_scheduler_webhook_deliveries_total",
+		"Attempts to deliver webhook notifications, by outcome.", "outcome")
+)
+
+// initMetrics registers the metrics that depend on the configuration, and
+// starts the metrics listener if an address is configured
+func initMetrics(ctx Context) {
+	schedMetrics.NewGaugeFunc("mig_scheduler_action_queue_depth",
+		"Actions waiting in the spool to be processed by the scheduler.",
+		func() float64 { return countDirEntries(ctx.Directories.Action.New) })
+	schedMetrics.NewGaugeFunc("mig_scheduler_actions_inflight",
+		"Actions currently running on agents.",
+		func() float64 { return countDirEntries(ctx.Directories.Action.InFlight) })
+	schedMetrics.NewGaugeFunc("mig_scheduler_commands_inflight",
+		"Commands sent to agents and waiting for results.",
+		func() float64 { return countDirEntries(ctx.Directories.Command.InFlight) })
+	if ctx.Metrics.Listen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", schedMetrics)
+	go func() {
+		err := http.ListenAndServe(ctx.Metrics.Listen, mux)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("metrics listener failed: %v", err)}.Err()
+		}
+	}()
+	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("metrics listener started on %s", ctx.Metrics.Listen)}
+}
+
+// countDirEntries returns the number of files in a spool directory
+func countDirEntries(path string) float64 {
+	dir, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0
+	}
+	return float64(len(names))
+}
@@ -41,6 +41,10 @@ func periodic(ctx Context) (err error) {
 	if err != nil {
 		panic(err)
 	}
+	err = archiveDecommissionedAgents(ctx)
+	if err != nil {
+		panic(err)
+	}
 	err = computeAgentsStats(ctx)
 	if err != nil {
 		panic(err)
@@ -131,6 +135,29 @@ func markIdleAgents(ctx Context) (err error) {
 	return
 }
 
+// archiveDecommissionedAgents moves agents that have been decommissioned for longer
+// than the configured ArchiveAfter period, and their commands, to the archive tables
+func archiveDecommissionedAgents(ctx Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("archiveDecommissionedAgents() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: "leaving archiveDecommissionedAgents()"}.Debug()
+	}()
+	archiveAfter, err := time.ParseDuration(ctx.Periodic.ArchiveAfter)
+	if err != nil {
+		panic(err)
+	}
+	count, err := ctx.DB.ArchiveDecommissionedAgents(time.Now().Add(-archiveAfter))
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("archived %.0f decommissioned agents", count)}
+	}
+	return
+}
+
 // save time of last hourly run
 var countNewEndpointsHourly time.Time
 
@@ -229,6 +256,21 @@ func computeAgentsStats(ctx Context) (err error) {
 		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("CountDisappearedEndpoints() took %v to run", d)}.Debug()
 	}()
 	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		stats.QuarantinedEndpoints, err = ctx.DB.CountEndpointsByStatus(mig.AgtStatusQuarantined)
+		if err != nil {
+			panic(err)
+		}
+		stats.DecommissionedEndpoints, err = ctx.DB.CountEndpointsByStatus(mig.AgtStatusDecommissioned)
+		if err != nil {
+			panic(err)
+		}
+		d := time.Since(start)
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, Desc: fmt.Sprintf("CountEndpointsByStatus() took %v to run", d)}.Debug()
+	}()
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		start := time.Now()
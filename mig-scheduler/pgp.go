@@ -24,10 +24,22 @@ func makePubring(ctx Context) (pubring io.ReadSeeker, err error) {
 		}
 		ctx.Channels.Log <- mig.Log{Desc: "leaving makePubring()"}.Debug()
 	}()
-	keys, err := ctx.DB.ActiveInvestigatorsPubKeys()
+	dbkeys, err := ctx.DB.ActiveInvestigatorsPubKeys()
 	if err != nil {
 		panic(err)
 	}
+	// the database only returns keys that are within their validity window,
+	// but the keys themselves can also be revoked or expired
+	var keys [][]byte
+	now := time.Now()
+	for _, key := range dbkeys {
+		err = pgp.CheckArmoredPubKeyValidity(key, now)
+		if err != nil {
+			ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("skipping investigator key: %v", err)}.Warning()
+			continue
+		}
+		keys = append(keys, key)
+	}
 	pubring, keycount, err := pgp.ArmoredKeysToKeyring(keys)
 	if err != nil {
 		panic(err)
@@ -144,3 +156,43 @@ func makeSchedulerInvestigator(orig_ctx Context) (inv mig.Investigator, err erro
 	ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("created migscheduler identity with ID %f and key ID %s", iid, inv.PGPFingerprint)}
 	return
 }
+
+// actionSigners returns the investigators that signed an action. The pubring
+// is only refreshed periodically, so the key that matched each signature is
+// checked to still be valid, and actions signed with revoked or expired keys
+// are refused immediately.
+func actionSigners(ctx Context, a mig.Action) (invs []mig.Investigator, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("actionSigners() -> %v", e)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: "leaving actionSigners()"}.Debug()
+	}()
+	astr, err := a.String()
+	if err != nil {
+		panic(err)
+	}
+	for _, sig := range a.PGPSignatures {
+		pubring, err := getPubring(ctx)
+		if err != nil {
+			panic(err)
+		}
+		fp, err := pgp.GetFingerprintFromSignature(astr, sig, pubring)
+		if err != nil {
+			panic(err)
+		}
+		inv, err := ctx.DB.InvestigatorByFingerprint(fp)
+		if err != nil {
+			panic(err)
+		}
+		inv.Keys, err = ctx.DB.InvestigatorKeys(inv.ID)
+		if err != nil {
+			panic(err)
+		}
+		if _, ok := inv.SigningKey(fp, time.Now()); !ok {
+			panic(fmt.Sprintf("action signed with key %s of investigator %.0f, which is revoked, expired or disabled", fp, inv.ID))
+		}
+		invs = append(invs, inv)
+	}
+	return
+}
@@ -8,6 +8,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"time"
 
@@ -31,6 +32,9 @@ func startRoutines(ctx Context) {
 	}()
 	ctx.Channels.Log <- mig.Log{Desc: "mig.ProcessLog() routine started"}
 
+	// expose the scheduler metrics
+	initMetrics(ctx)
+
 	// Goroutine that loads actions dropped into ctx.Directories.Action.New
 	go func() {
 		for actionPath := range ctx.Channels.NewAction {
@@ -171,6 +175,7 @@ func startRoutines(ctx Context) {
 	go func() {
 		for msg := range heartbeatsChan {
 			ctx.OpID = mig.GenID()
+			metricAMQPConsumed.Inc(mig.QueueAgentHeartbeat)
 			err := getHeartbeats(msg, ctx)
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("heartbeat routine failed with error '%v'", err)}.Err()
@@ -187,6 +192,7 @@ func startRoutines(ctx Context) {
 	go func() {
 		for delivery := range agtResultsChan {
 			ctx.OpID = mig.GenID()
+			metricAMQPConsumed.Inc(mig.QueueAgentResults)
 			// validate the size of the data received, and make sure its first and
 			// last bytes are valid json enclosures. if not, discard the message.
 			if len(delivery.Body) < 10 || delivery.Body[0] != '{' || delivery.Body[len(delivery.Body)-1] != '}' {
@@ -261,17 +267,39 @@ func startRoutines(ctx Context) {
 	}()
 	ctx.Channels.Log <- mig.Log{Desc: "queue cleanup routine started"}
 
+	// launch the routine that sends webhook notifications
+	go func() {
+		sleeper, err := time.ParseDuration(ctx.Webhooks.DeliveryFreq)
+		if err != nil {
+			panic(err)
+		}
+		timeout, err := time.ParseDuration(ctx.Webhooks.Timeout)
+		if err != nil {
+			panic(err)
+		}
+		client := &http.Client{Timeout: timeout}
+		for {
+			ctx.OpID = mig.GenID()
+			err = deliverWebhooks(ctx, client)
+			if err != nil {
+				ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("webhooks delivery routine failed with error '%v'", err)}.Err()
+			}
+			time.Sleep(sleeper)
+		}
+	}()
+	ctx.Channels.Log <- mig.Log{Desc: "webhooks delivery routine started"}
+
 	// launch the routine that handles multi agents on same queue
 	go func() {
 		for queueLoc := range ctx.Channels.DetectDupAgents {
 			ctx.OpID = mig.GenID()
-			err = killDupAgents(queueLoc, ctx)
+			err = resolveDupAgents(queueLoc, ctx)
 			if err != nil {
 				ctx.Channels.Log <- mig.Log{Desc: fmt.Sprintf("%v", err)}.Err()
 			}
 		}
 	}()
-	ctx.Channels.Log <- mig.Log{Desc: "killDupAgents() routine started"}
+	ctx.Channels.Log <- mig.Log{Desc: "resolveDupAgents() routine started"}
 
 	// block here until a terminate message is received
 	exitReason := <-ctx.Channels.Terminate
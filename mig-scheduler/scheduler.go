@@ -15,7 +15,6 @@ import (
 
 	"github.com/mozilla/mig"
 	"github.com/mozilla/mig/modules"
-	"github.com/mozilla/mig/pgp"
 	"github.com/streadway/amqp"
 )
 
@@ -87,6 +86,15 @@ func processNewAction(actionPath string, ctx Context) (err error) {
 		}
 		return
 	}
+	signers, err := actionSigners(ctx, action)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: action.ID, Desc: fmt.Sprintf("%v. invalidating action.", err)}.Err()
+		err = invalidAction(ctx, action, actionPath)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 	// find target agents for the action
 	agents, err := ctx.DB.ActiveAgentsByTarget(action.Target)
 	if err != nil {
@@ -110,24 +118,8 @@ func processNewAction(actionPath string, ctx Context) (err error) {
 	if inserted {
 		// action was inserted, and not updated, so we need to insert
 		// the signatures as well
-		astr, err := action.String()
-		if err != nil {
-			panic(err)
-		}
-		for _, sig := range action.PGPSignatures {
-			pubring, err := getPubring(ctx)
-			if err != nil {
-				panic(err)
-			}
-			fp, err := pgp.GetFingerprintFromSignature(astr, sig, pubring)
-			if err != nil {
-				panic(err)
-			}
-			inv, err := ctx.DB.InvestigatorByFingerprint(fp)
-			if err != nil {
-				panic(err)
-			}
-			err = ctx.DB.InsertSignature(action.ID, inv.ID, sig)
+		for i, sig := range action.PGPSignatures {
+			err = ctx.DB.InsertSignature(action.ID, signers[i].ID, sig)
 			if err != nil {
 				panic(err)
 			}
@@ -205,6 +197,7 @@ func sendCommands(cmds []mig.Command, ctx Context) (err error) {
 		panic(err)
 	}
 	ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: aid, Desc: fmt.Sprintf("%d commands inserted into database", insertCount)}
+	metricCommands.Add(float64(insertCount), mig.StatusSent)
 
 	for _, cmd := range cmds {
 		data, err := json.Marshal(cmd)
@@ -230,8 +223,10 @@ func sendCommands(cmds []mig.Command, ctx Context) (err error) {
 		go func() {
 			err = ctx.MQ.Chan.Publish(mig.ExchangeToAgents, agtQueue, true, false, msg)
 			if err != nil {
+				metricAMQPPublishErrors.Inc()
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: "publishing failed to queue" + agtQueue}.Err()
 			} else {
+				metricAMQPPublished.Inc()
 				desc := fmt.Sprintf("published to queue %s", agtQueue)
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: desc}
 			}
@@ -261,6 +256,7 @@ func returnCommands(cmdFiles []string, ctx Context) (err error) {
 			continue
 		}
 		cmd.FinishTime = time.Now().UTC()
+		metricCommands.Inc(cmd.Status)
 		// update command in database
 		go func() {
 			err = ctx.DB.FinishCommand(cmd)
@@ -269,6 +265,7 @@ func returnCommands(cmdFiles []string, ctx Context) (err error) {
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: desc}.Err()
 			} else {
 				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: "command updated in database"}.Debug()
+				publishCommandEvent(ctx, cmd)
 			}
 
 			// pass the command over to the Command Done channel
@@ -327,17 +324,79 @@ func updateAction(cmds []mig.Command, ctx Context) (err error) {
 			// delete Action from ctx.Directories.Action.InFlight
 			actFile := fmt.Sprintf("%.0f.json", a.ID)
 			os.Rename(ctx.Directories.Action.InFlight+"/"+actFile, ctx.Directories.Action.Done+"/"+actFile)
+			// publish the status stored by landAction, so clients following
+			// the action see the status the API returns. the action has
+			// landed already, so a failure to read it back is only logged
+			// and the status landAction stored is published instead.
+			var stored mig.Action
+			stored, err = ctx.DB.ActionMetaByID(a.ID)
+			if err != nil {
+				desc := fmt.Sprintf("failed to retrieve landed action: %v", err)
+				ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: desc}.Err()
+				a.Status = "completed"
+				a.FinishTime = time.Now().UTC()
+				err = nil
+			} else {
+				a.Status = stored.Status
+				a.FinishTime = stored.FinishTime
+			}
+			publishActionEvent(ctx, a, mig.ActionEventDone)
+			queueWebhooks(ctx, mig.WebhookEventActionDone, a, nil)
 		} else {
 			// store updated action in database
 			err = ctx.DB.UpdateRunningAction(a)
 			if err != nil {
 				panic(err)
 			}
-			desc := fmt.Sprintf("updated action '%s': progress=%d/%d, success=%d, cancelled=%d, expired=%d, failed=%d, timeout=%d, duration=%s",
+			desc := fmt.Sprintf("updated action '%s': progress=%d/%d, success=%d, cancelled=%d, expired=%d, failed=%d, timeout=%d, resourcelimit=%d, duration=%s",
 				a.Name, a.Counters.Done, a.Counters.Sent, a.Counters.Success, a.Counters.Cancelled, a.Counters.Expired,
-				a.Counters.Failed, a.Counters.TimeOut, a.LastUpdateTime.Sub(a.StartTime).String())
+				a.Counters.Failed, a.Counters.TimeOut, a.Counters.ResourceLimit, a.LastUpdateTime.Sub(a.StartTime).String())
 			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: desc}
+			publishActionEvent(ctx, a, mig.ActionEventProgress)
+		}
+		if a.Counters.Failed+a.Counters.TimeOut+a.Counters.ResourceLimit > 0 {
+			queueWebhooks(ctx, mig.WebhookEventFailures, a, nil)
 		}
 	}
 	return
 }
+
+// publishActionEvent notifies the processes following action a, such as the API
+// streaming endpoint, that its counters or status have changed. Failures are
+// logged but do not interrupt the processing of the action.
+func publishActionEvent(ctx Context, a mig.Action, evtype string) {
+	err := ctx.DB.NotifyActionEvent(mig.ActionEvent{
+		Type:     evtype,
+		ActionID: a.ID,
+		Status:   a.Status,
+		Counters: a.Counters,
+	})
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: fmt.Sprintf("%v", err)}.Warning()
+	}
+}
+
+// publishCommandEvent notifies the processes following an action that one of
+// its commands has been written to the database
+func publishCommandEvent(ctx Context, cmd mig.Command) {
+	ev := mig.ActionEvent{
+		Type:          mig.ActionEventCommand,
+		ActionID:      cmd.Action.ID,
+		CommandID:     cmd.ID,
+		CommandStatus: cmd.Status,
+		AgentName:     cmd.Agent.Name,
+	}
+	for _, res := range cmd.Results {
+		if res.FoundAnything {
+			ev.FoundAnything = true
+			break
+		}
+	}
+	err := ctx.DB.NotifyActionEvent(ev)
+	if err != nil {
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: cmd.Action.ID, CommandID: cmd.ID, Desc: fmt.Sprintf("%v", err)}.Warning()
+	}
+	if ev.FoundAnything {
+		queueWebhooks(ctx, mig.WebhookEventFoundAnything, mig.Action{ID: cmd.Action.ID}, &cmd)
+	}
+}
@@ -0,0 +1,175 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/mozilla/mig"
+)
+
+// queueWebhooks queues a notification of event on action a for each webhook
+// that matches the action. The status and counters of a take precedence over
+// the ones stored in the database, and cmd is set on events about a command.
+// Failures are logged but do not interrupt the processing of the action.
+func queueWebhooks(ctx Context, event string, a mig.Action, cmd *mig.Command) {
+	var err error
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("queueWebhooks() -> %v", e)
+			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID, Desc: fmt.Sprintf("%v", err)}.Warning()
+		}
+	}()
+	webhooks, err := ctx.DB.Webhooks()
+	if err != nil {
+		panic(err)
+	}
+	var candidates []mig.Webhook
+	for _, w := range webhooks {
+		if w.Status == mig.WebhookStatusActive {
+			candidates = append(candidates, w)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	stored, err := ctx.DB.ActionByID(a.ID)
+	if err != nil {
+		panic(err)
+	}
+	if a.Status != "" {
+		stored.Status = a.Status
+		stored.Counters = a.Counters
+	}
+	a = stored
+	investigators, err := ctx.DB.InvestigatorByActionID(a.ID)
+	if err != nil {
+		panic(err)
+	}
+	for _, w := range candidates {
+		if !w.Matches(event, a, investigators) {
+			continue
+		}
+		if event == mig.WebhookEventFailures && a.Counters.Failed+a.Counters.TimeOut+a.Counters.ResourceLimit <= w.FailureThreshold {
+			continue
+		}
+		p := mig.NewWebhookPayload(event, a, investigators)
+		p.DeliveryID = mig.GenID()
+		p.WebhookID = w.ID
+		if cmd != nil {
+			p.Command = &mig.WebhookCommand{ID: cmd.ID, AgentName: cmd.Agent.Name, Status: cmd.Status}
+		}
+		payload, err := json.Marshal(p)
+		if err != nil {
+			panic(err)
+		}
+		queued, err := ctx.DB.QueueWebhookDelivery(mig.WebhookDelivery{
+			ID:          p.DeliveryID,
+			WebhookID:   w.ID,
+			Event:       event,
+			ActionID:    a.ID,
+			Payload:     payload,
+			NextAttempt: p.Time,
+			CreatedAt:   p.Time,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if queued {
+			ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: a.ID,
+				Desc: fmt.Sprintf("queued %s notification %.0f to webhook %.0f", event, p.DeliveryID, w.ID)}.Debug()
+		}
+	}
+}
+
+// deliverWebhooks sends the webhook notifications that are due. Notifications
+// that fail are retried with an increasing delay, until the maximum number of
+// attempts is reached and they are marked as failed.
+func deliverWebhooks(ctx Context, client *http.Client) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("deliverWebhooks() -> %v", e)
+		}
+	}()
+	deliveries, err := ctx.DB.DueWebhookDeliveries(time.Now().UTC(), 100)
+	if err != nil {
+		panic(err)
+	}
+	webhooks := make(map[float64]mig.Webhook)
+	for _, d := range deliveries {
+		w, ok := webhooks[d.WebhookID]
+		if !ok {
+			w, err = ctx.DB.WebhookByID(d.WebhookID)
+			if err != nil {
+				panic(err)
+			}
+			webhooks[w.ID] = w
+		}
+		d.Attempts++
+		d.LastAttempt = time.Now().UTC()
+		if w.Status != mig.WebhookStatusActive {
+			d.Status = mig.WebhookDeliveryFailed
+			d.LastError = "webhook is disabled"
+		} else {
+			d.ResponseCode, err = postWebhook(client, w, d)
+			if err == nil {
+				d.Status = mig.WebhookDeliveryDelivered
+				d.LastError = ""
+			} else {
+				d.LastError = err.Error()
+				if d.Attempts >= ctx.Webhooks.MaxAttempts {
+					d.Status = mig.WebhookDeliveryFailed
+				} else {
+					d.NextAttempt = d.LastAttempt.Add(mig.WebhookRetryDelay(d.Attempts))
+				}
+			}
+		}
+		metricWebhookDeliveries.Inc(d.Status)
+		desc := fmt.Sprintf("webhook %.0f notification %.0f of %s: status=%s attempts=%d",
+			w.ID, d.ID, d.Event, d.Status, d.Attempts)
+		if d.LastError != "" {
+			desc += fmt.Sprintf(" error=%q", d.LastError)
+		}
+		ctx.Channels.Log <- mig.Log{OpID: ctx.OpID, ActionID: d.ActionID, Desc: desc}.Info()
+		err = ctx.DB.UpdateWebhookDelivery(d)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return
+}
+
+// postWebhook sends a notification to the url of a webhook, signed with its
+// secret, and returns the response code of the endpoint. Responses outside of
+// the 2xx range are errors.
+func postWebhook(client *http.Client, w mig.Webhook, d mig.WebhookDelivery) (code int, err error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mig-scheduler "+mig.Version)
+	req.Header.Set("X-MIG-Event", d.Event)
+	req.Header.Set("X-MIG-Delivery", fmt.Sprintf("%.0f", d.ID))
+	req.Header.Set(mig.WebhookSignatureHeader, mig.SignWebhookPayload(w.Secret, d.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	code = resp.StatusCode
+	if code < 200 || code > 299 {
+		err = fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return
+}
@@ -9,19 +9,33 @@ package mig /* import "github.com/mozilla/mig" */
 // Misc support functions used in various places within MIG
 
 import (
-	mrand "math/rand"
-	"time"
+	"crypto/rand"
+	"fmt"
 )
 
 // RandAPIKeyString is used for prefix and key generation, and just
 // returns a random string consisting of alphanumeric characters of
-// length characters long
+// length characters long. The characters are read from crypto/rand, as
+// prefixes are stored in clear and must not reveal the keys generated
+// alongside them.
 func RandAPIKeyString(length int) string {
-	ret := make([]byte, length)
-	lset := []byte("abcdefghijklmnopqrstuvwxyzABCDEFCHIJKLMNOPQRSTUVWXYZ0123456789")
-	r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
-	for i := 0; i < len(ret); i++ {
-		ret[i] = lset[r.Int()%len(lset)]
+	ret := make([]byte, 0, length)
+	lset := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	// bytes above the largest multiple of the set length are discarded, so
+	// each character is picked uniformly
+	max := byte(256 - 256%len(lset))
+	buf := make([]byte, length)
+	for len(ret) < length {
+		_, err := rand.Read(buf)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		for _, b := range buf {
+			if b >= max || len(ret) == length {
+				continue
+			}
+			ret = append(ret, lset[int(b)%len(lset)])
+		}
 	}
-	return string(ret[:len(ret)])
+	return string(ret)
 }
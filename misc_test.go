@@ -0,0 +1,27 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Aaron Meihm ameihm@mozilla.com [:alm]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRandAPIKeyString(t *testing.T) {
+	re := regexp.MustCompile("^[A-Za-z0-9]{32}$")
+	prev := ""
+	for i := 0; i < 100; i++ {
+		s := RandAPIKeyString(32)
+		if !re.MatchString(s) {
+			t.Fatalf("unexpected key string %q", s)
+		}
+		if s == prev {
+			t.Fatalf("expected successive key strings to differ, got %q twice", s)
+		}
+		prev = s
+	}
+}
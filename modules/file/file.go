@@ -524,6 +524,21 @@ func (c *check) hasMatch(file string) bool {
 	return false
 }
 
+// Privileges declares that the file module reads files regardless of their
+// permissions, and never writes to them
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{
+		Capabilities: []string{"CAP_DAC_READ_SEARCH"},
+		ReadOnly:     true,
+	}
+}
+
+// ContainerSupport declares that the file module can search the file system
+// of a container
+func (r *run) ContainerSupport() bool {
+	return true
+}
+
 func (r *run) ValidateParameters() (err error) {
 	var labels []string
 	for label, s := range r.Parameters.Searches {
@@ -1895,6 +1910,42 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	return
 }
 
+// ResultsColumns returns the names of the values returned by FlattenResults
+func (r *run) ResultsColumns() []string {
+	return []string{"search", "file", "size", "mode", "lastmodified", "sha256"}
+}
+
+// FlattenResults returns one row per file matched in the results
+func (r *run) FlattenResults(result modules.Result) (rows [][]string, err error) {
+	var el SearchResults
+	err = result.GetElements(&el)
+	if err != nil {
+		return
+	}
+	// sort the searches by label to return rows in a stable order
+	var labels []string
+	for label := range el {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		for _, mf := range el[label] {
+			if mf.File == "" {
+				continue
+			}
+			rows = append(rows, []string{
+				label,
+				mf.File,
+				fmt.Sprintf("%.0f", mf.FileInfo.Size),
+				mf.FileInfo.Mode,
+				mf.FileInfo.Mtime,
+				strings.ToLower(mf.FileInfo.SHA256),
+			})
+		}
+	}
+	return
+}
+
 // Enhanced privacy mode for file module, mask file names being returned by the module
 func (r *run) EnhancePrivacy(in modules.Result) (out modules.Result, err error) {
 	var el SearchResults
@@ -1081,3 +1081,41 @@ some other text`),
 		linkdest: "a",
 	},
 }
+
+func TestFlattenResults(t *testing.T) {
+	var r run
+	result := modules.Result{
+		FoundAnything: true,
+		Success:       true,
+		Elements: SearchResults{
+			"s2": SearchResult{
+				{File: "/etc/passwd", FileInfo: Info{Size: 1024, Mode: "-rw-r--r--",
+					Mtime: "2017-01-01 00:00:00 +0000 UTC", SHA256: "ABCDEF"}},
+			},
+			"s1": SearchResult{
+				{File: ""},
+				{File: "/tmp/.x", FileInfo: Info{Size: 12, Mode: "-rwxr-xr-x",
+					Mtime: "2017-02-01 00:00:00 +0000 UTC"}},
+			},
+		},
+	}
+	rows, err := r.FlattenResults(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]string{
+		{"s1", "/tmp/.x", "12", "-rwxr-xr-x", "2017-02-01 00:00:00 +0000 UTC", ""},
+		{"s2", "/etc/passwd", "1024", "-rw-r--r--", "2017-01-01 00:00:00 +0000 UTC", "abcdef"},
+	}
+	if len(rows) != len(expect) {
+		t.Fatalf("expected %d rows, got %d", len(expect), len(rows))
+	}
+	for i := range expect {
+		if len(rows[i]) != len(r.ResultsColumns()) {
+			t.Fatalf("row %d has %d values, expected %d", i, len(rows[i]), len(r.ResultsColumns()))
+		}
+		if strings.Join(rows[i], ",") != strings.Join(expect[i], ",") {
+			t.Fatalf("row %d is %v, expected %v", i, rows[i], expect[i])
+		}
+	}
+}
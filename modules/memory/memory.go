@@ -21,6 +21,7 @@ import (
 	"github.com/mozilla/masche/process"
 	"github.com/mozilla/mig/modules"
 	"regexp"
+	"sort"
 	"time"
 )
 
@@ -216,6 +217,21 @@ func (c *check) storeMatch(proc process.Process) {
 	return
 }
 
+// Privileges declares that the memory module reads the memory of processes
+// owned by other users through procfs, and never writes to the file system
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{
+		Capabilities: []string{"CAP_SYS_PTRACE", "CAP_DAC_READ_SEARCH"},
+		ReadOnly:     true,
+	}
+}
+
+// ContainerSupport declares that the memory module can inspect the processes
+// of a container, as listed in its procfs
+func (r *run) ContainerSupport() bool {
+	return true
+}
+
 func (r *run) ValidateParameters() (err error) {
 	var labels []string
 	for label, s := range r.Parameters.Searches {
@@ -873,3 +889,36 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	}
 	return
 }
+
+// ResultsColumns returns the names of the values returned by FlattenResults
+func (r *run) ResultsColumns() []string {
+	return []string{"search", "pid", "name"}
+}
+
+// FlattenResults returns one row per process matched in the results
+func (r *run) FlattenResults(result modules.Result) (rows [][]string, err error) {
+	var el searchResults
+	err = result.GetElements(&el)
+	if err != nil {
+		return
+	}
+	// sort the searches by label to return rows in a stable order
+	var labels []string
+	for label := range el {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		for _, mps := range el[label] {
+			if mps.Process.Name == "" {
+				continue
+			}
+			rows = append(rows, []string{
+				label,
+				fmt.Sprintf("%.0f", mps.Process.Pid),
+				mps.Process.Name,
+			})
+		}
+	}
+	return
+}
@@ -9,9 +9,11 @@ package memory /* import "github.com/mozilla/mig/modules/memory" */
 import (
 	"bytes"
 	"encoding/json"
+	"reflect"
+	"testing"
+
 	"github.com/mozilla/mig/modules"
 	"github.com/mozilla/mig/testutil"
-	"testing"
 )
 
 func TestRegistration(t *testing.T) {
@@ -142,3 +144,37 @@ func TestSearches(t *testing.T) {
 		}
 	}
 }
+
+func TestFlattenResults(t *testing.T) {
+	var r run
+	el := searchResults{
+		"s2": searchresult{
+			{Process: psres{Name: "sshd", Pid: 812}},
+		},
+		"s1": searchresult{
+			{Process: psres{}},
+			{Process: psres{Name: "nginx", Pid: 1180}},
+			{Process: psres{Name: "nginx", Pid: 1181}},
+		},
+	}
+	rows, err := r.FlattenResults(modules.Result{Success: true, FoundAnything: true, Elements: el})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]string{
+		{"s1", "1180", "nginx"},
+		{"s1", "1181", "nginx"},
+		{"s2", "812", "sshd"},
+	}
+	if !reflect.DeepEqual(rows, expect) {
+		t.Fatalf("expected rows %v, got %v", expect, rows)
+	}
+	for _, row := range rows {
+		if len(row) != len(r.ResultsColumns()) {
+			t.Errorf("row %v does not match columns %v", row, r.ResultsColumns())
+		}
+	}
+	if _, err = r.FlattenResults(modules.Result{Elements: "invalid"}); err == nil {
+		t.Error("expected invalid elements to be rejected")
+	}
+}
@@ -616,6 +616,16 @@ type HasResultsPrinter interface {
 	PrintResults(Result, bool) ([]string, error)
 }
 
+// HasResultsFlattener implements functions used by modules to flatten their
+// results into rows of values, for example when exporting the results of an
+// action in a tabular format. ResultsColumns returns the names of the columns,
+// and FlattenResults returns one row per match found in a Result, each row
+// containing one value per column.
+type HasResultsFlattener interface {
+	ResultsColumns() []string
+	FlattenResults(Result) ([][]string, error)
+}
+
 // GetElements reads the elements from a struct of results into the el interface
 func (r Result) GetElements(el interface{}) (err error) {
 	defer func() {
@@ -656,6 +666,33 @@ type HasEnhancedPrivacy interface {
 	EnhancePrivacy(Result) (Result, error)
 }
 
+// Privileges describes what a module needs from the system to run. The agent
+// uses it to sandbox the module on platforms that support it.
+type Privileges struct {
+	// Capabilities lists the Linux capabilities the module needs, by their
+	// names in capabilities(7), such as CAP_NET_RAW
+	Capabilities []string
+
+	// Network is true if the module opens network connections
+	Network bool
+
+	// ReadOnly is true if the module never writes to the file system
+	ReadOnly bool
+}
+
+// HasPrivileges implements a function that declares the privileges a module
+// needs. Modules that do not implement it run with the privileges of the agent.
+type HasPrivileges interface {
+	Privileges() Privileges
+}
+
+// HasContainerSupport implements a function that declares whether a module
+// can run inside a container of the endpoint. The agent runs such modules in
+// the file system of the container selected by the operation.
+type HasContainerSupport interface {
+	ContainerSupport() bool
+}
+
 // HasParamsCreator implements a function that creates module parameters
 type HasParamsCreator interface {
 	ParamsCreator() (interface{}, error)
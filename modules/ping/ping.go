@@ -137,6 +137,16 @@ func (r *run) Run(in modules.ModuleReader) (out string) {
 	return r.buildResults(el)
 }
 
+// Privileges declares that ping opens network connections, and needs raw
+// sockets to send icmp packets
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{
+		Capabilities: []string{"CAP_NET_RAW"},
+		Network:      true,
+		ReadOnly:     true,
+	}
+}
+
 func (r *run) ValidateParameters() (err error) {
 	// check if Protocol is a valid one that we support with this module
 	switch r.Parameters.Protocol {
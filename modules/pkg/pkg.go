@@ -138,6 +138,11 @@ func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	return
 }
 
+// Privileges declares that pkg only reads the package database of the system
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{ReadOnly: true}
+}
+
 func (r *run) ValidateParameters() (err error) {
 	if len(r.Parameters.PkgMatch.Matches) == 0 {
 		return fmt.Errorf("must specify at least one package to match")
@@ -194,6 +199,24 @@ func (r *run) PrintResults(result modules.Result, foundOnly bool) (prints []stri
 	return
 }
 
+// ResultsColumns returns the names of the values returned by FlattenResults
+func (r *run) ResultsColumns() []string {
+	return []string{"name", "version", "type", "arch"}
+}
+
+// FlattenResults returns one row per package matched in the results
+func (r *run) FlattenResults(result modules.Result) (rows [][]string, err error) {
+	var elem elements
+	err = result.GetElements(&elem)
+	if err != nil {
+		return
+	}
+	for _, x := range elem.Packages {
+		rows = append(rows, []string{x.Name, x.Version, x.Type, x.Arch})
+	}
+	return
+}
+
 type elements struct {
 	Packages []scribelib.PackageInfo `json:"packages"` // Results of package query.
 }
@@ -7,10 +7,48 @@
 package pkg /* import "github.com/mozilla/mig/modules/pkg" */
 
 import (
-	"github.com/mozilla/mig/testutil"
+	"reflect"
 	"testing"
+
+	"github.com/mozilla/mig/modules"
+	"github.com/mozilla/mig/testutil"
+	scribelib "github.com/mozilla/scribe"
 )
 
 func TestRegistration(t *testing.T) {
 	testutil.CheckModuleRegistration(t, "pkg")
 }
+
+func TestFlattenResults(t *testing.T) {
+	var r run
+	var tests = []struct {
+		elements interface{}
+		expect   [][]string
+	}{
+		{elements{}, nil},
+		{elements{Packages: []scribelib.PackageInfo{
+			{Name: "openssl", Version: "1.0.2g-1ubuntu4", Type: "dpkg", Arch: "amd64"},
+			{Name: "openssl-libs", Version: "1.0.2k-8.el7", Type: "rpm", Arch: "x86_64"},
+		}}, [][]string{
+			{"openssl", "1.0.2g-1ubuntu4", "dpkg", "amd64"},
+			{"openssl-libs", "1.0.2k-8.el7", "rpm", "x86_64"},
+		}},
+	}
+	for i, tc := range tests {
+		rows, err := r.FlattenResults(modules.Result{Success: true, Elements: tc.elements})
+		if err != nil {
+			t.Fatalf("test %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rows, tc.expect) {
+			t.Errorf("test %d: expected rows %v, got %v", i, tc.expect, rows)
+		}
+		for _, row := range rows {
+			if len(row) != len(r.ResultsColumns()) {
+				t.Errorf("test %d: row %v does not match columns %v", i, row, r.ResultsColumns())
+			}
+		}
+	}
+	if _, err := r.FlattenResults(modules.Result{Elements: "invalid"}); err == nil {
+		t.Error("expected invalid elements to be rejected")
+	}
+}
@@ -71,6 +71,12 @@ var NtpBackupPool = []string{
 	`2.pool.ntp.org`,
 	`3.pool.ntp.org`}
 
+// Privileges declares that timedrift only queries ntp servers, it does not
+// need to set the time of the system
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{Network: true, ReadOnly: true}
+}
+
 func (r *run) ValidateParameters() (err error) {
 	if r.Parameters.Drift != "" {
 		_, err = time.ParseDuration(r.Parameters.Drift)
@@ -104,6 +104,15 @@ func findFiles(args []string) ([]string, error) {
 	return ret, nil
 }
 
+// Privileges declares that the yara module reads files regardless of their
+// permissions, and never writes to them
+func (r *run) Privileges() modules.Privileges {
+	return modules.Privileges{
+		Capabilities: []string{"CAP_DAC_READ_SEARCH"},
+		ReadOnly:     true,
+	}
+}
+
 func (r *run) Run(in modules.ModuleReader) (resStr string) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -16,6 +16,7 @@ import (
 	"golang.org/x/crypto/openpgp/armor"
 	"io"
 	"strings"
+	"time"
 )
 
 // ArmoredKeysToKeyring takes a list of PGP keys in armored form and transforms
@@ -109,6 +110,35 @@ func LoadArmoredPubKey(pubkey []byte) (pgpfingerprint string, err error) {
 	return
 }
 
+// CheckArmoredPubKeyValidity returns an error if the armored public key has
+// been revoked by its owner, or if it has expired at time t
+func CheckArmoredPubKeyValidity(pubkey []byte, t time.Time) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("CheckArmoredPubKeyValidity() -> %v", e)
+		}
+	}()
+	el, err := openpgp.ReadArmoredKeyRing(bytes.NewBuffer(pubkey))
+	if err != nil {
+		panic(err)
+	}
+	if len(el) != 1 {
+		err = fmt.Errorf("Public GPG Key contains %d entities, wanted 1", len(el))
+		panic(err)
+	}
+	entity := el[0]
+	fp := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))
+	if len(entity.Revocations) > 0 {
+		panic(fmt.Sprintf("key %s has been revoked", fp))
+	}
+	for _, id := range entity.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.KeyExpired(t) {
+			panic(fmt.Sprintf("key %s has expired", fp))
+		}
+	}
+	return
+}
+
 func GetFingerprintFromSignature(data string, signature string, keyring io.Reader) (fingerprint string, err error) {
 	defer func() {
 		if e := recover(); e != nil {
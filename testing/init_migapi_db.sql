@@ -79,7 +79,7 @@ CREATE TABLE commands (
     actionid    numeric NOT NULL,
     agentid     numeric NOT NULL,
     status      character varying(255) NOT NULL,
-    results     json,
+    results     jsonb,
     starttime   timestamp with time zone NOT NULL,
     finishtime  timestamp with time zone
 );
@@ -88,6 +88,8 @@ ALTER TABLE ONLY commands
     ADD CONSTRAINT commands_pkey PRIMARY KEY (id);
 CREATE INDEX commands_agentid ON commands(agentid DESC);
 CREATE INDEX commands_actionid ON commands(actionid DESC);
+CREATE INDEX commands_results_idx ON commands USING gin (results);
+CREATE INDEX commands_results_fts_idx ON commands USING gin (to_tsvector('simple', results));
 
 CREATE TABLE invagtmodperm (
     investigatorid  numeric NOT NULL,
@@ -113,12 +115,14 @@ CREATE TABLE investigators (
     lastmodified    timestamp with time zone NOT NULL,
     permissions     bigint NOT NULL DEFAULT 0,
     apikey          bytea,
-    apisalt         bytea
+    apisalt         bytea,
+    apikeyprefix    character varying(8)
 );
 ALTER TABLE public.investigators OWNER TO migadmin;
 ALTER TABLE ONLY investigators
     ADD CONSTRAINT investigators_pkey PRIMARY KEY (id);
 CREATE UNIQUE INDEX investigators_pgpfingerprint_idx ON investigators USING btree (pgpfingerprint);
+CREATE UNIQUE INDEX investigators_apikeyprefix_idx ON investigators USING btree (apikeyprefix);
 
 CREATE SEQUENCE manifests_id_seq START 1;
 CREATE TABLE manifests (
@@ -216,11 +220,11 @@ GRANT USAGE ON SEQUENCE investigators_id_seq TO migscheduler;
 
 -- API has limited permissions, and cannot list scheduler private keys in the investigators table, but can update their statuses
 GRANT SELECT ON actions, agents, agents_stats, agtmodreq, commands, invagtmodperm, loaders, manifests, manifestsig, modules, signatures TO migapi;
-GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions, apikey, apisalt) ON investigators TO migapi;
+GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions, apikey, apisalt, apikeyprefix) ON investigators TO migapi;
 GRANT INSERT ON actions, signatures, manifests, manifestsig, loaders TO migapi;
 GRANT DELETE ON manifestsig TO migapi;
 GRANT INSERT (name, pgpfingerprint, publickey, status, createdat, lastmodified, permissions) ON investigators TO migapi;
-GRANT UPDATE (permissions, status, lastmodified, apikey, apisalt) ON investigators TO migapi;
+GRANT UPDATE (permissions, status, lastmodified, apikey, apisalt, apikeyprefix) ON investigators TO migapi;
 GRANT UPDATE (name, env, tags, loaderkey, salt, lastseen, enabled, expectenv, queueloc) ON loaders TO migapi;
 GRANT UPDATE (status) ON manifests TO migapi;
 GRANT USAGE ON SEQUENCE investigators_id_seq TO migapi;
@@ -235,3 +239,293 @@ GRANT SELECT (id, env, tags, expectenv, loadername, queueloc) ON loaders TO migr
 GRANT SELECT (id, name, pgpfingerprint, publickey, status, createdat, lastmodified) ON investigators TO migreadonly;
 GRANT migreadonly TO migapi;
 GRANT migreadonly TO migscheduler;
+
+-- schema version, see database/migrations.go
+CREATE TABLE schema_version (
+    version     integer NOT NULL PRIMARY KEY,
+    description character varying(1024) NOT NULL,
+    appliedat   timestamp with time zone NOT NULL DEFAULT now()
+);
+GRANT SELECT ON schema_version TO migapi, migscheduler;
+INSERT INTO schema_version (version, description) VALUES (1, 'initial schema');
+
+-- migration 2: investigator keys
+CREATE SEQUENCE investigator_keys_id_seq START 1;
+CREATE TABLE investigator_keys (
+    id              numeric NOT NULL DEFAULT nextval('investigator_keys_id_seq'),
+    investigatorid  numeric NOT NULL,
+    pgpfingerprint  character varying(128) NOT NULL,
+    publickey       bytea NOT NULL,
+    status          character varying(255) NOT NULL,
+    validfrom       timestamp with time zone NOT NULL,
+    expireafter     timestamp with time zone,
+    createdat       timestamp with time zone NOT NULL,
+    revokedat       timestamp with time zone
+);
+ALTER TABLE public.investigator_keys OWNER TO migadmin;
+ALTER TABLE ONLY investigator_keys
+    ADD CONSTRAINT investigator_keys_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY investigator_keys
+    ADD CONSTRAINT investigator_keys_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+CREATE UNIQUE INDEX investigator_keys_pgpfingerprint_idx ON investigator_keys USING btree (pgpfingerprint);
+CREATE INDEX investigator_keys_investigatorid_idx ON investigator_keys USING btree (investigatorid);
+
+INSERT INTO investigator_keys (investigatorid, pgpfingerprint, publickey, status, validfrom, createdat)
+    SELECT id, pgpfingerprint, publickey, 'active', createdat, createdat FROM investigators
+    WHERE pgpfingerprint IS NOT NULL AND publickey IS NOT NULL;
+
+GRANT SELECT, INSERT ON investigator_keys TO migscheduler;
+GRANT USAGE ON SEQUENCE investigator_keys_id_seq TO migscheduler;
+GRANT SELECT, INSERT ON investigator_keys TO migapi;
+GRANT UPDATE (status, expireafter, revokedat) ON investigator_keys TO migapi;
+GRANT UPDATE (pgpfingerprint, publickey) ON investigators TO migapi;
+GRANT USAGE ON SEQUENCE investigator_keys_id_seq TO migapi;
+GRANT SELECT (id, investigatorid, pgpfingerprint, publickey, status, validfrom, expireafter, createdat, revokedat) ON investigator_keys TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (2, 'investigator keys');
+CREATE TABLE aclpolicies (
+    module          character varying(256) NOT NULL,
+    minimumweight   integer NOT NULL,
+    lastmodified    timestamp with time zone NOT NULL
+);
+ALTER TABLE public.aclpolicies OWNER TO migadmin;
+ALTER TABLE ONLY aclpolicies
+    ADD CONSTRAINT aclpolicies_pkey PRIMARY KEY (module);
+
+CREATE TABLE aclweights (
+    module          character varying(256) NOT NULL,
+    investigatorid  numeric NOT NULL,
+    weight          integer NOT NULL
+);
+ALTER TABLE public.aclweights OWNER TO migadmin;
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_pkey PRIMARY KEY (module, investigatorid);
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_module_fkey FOREIGN KEY (module) REFERENCES aclpolicies(module) ON DELETE CASCADE;
+ALTER TABLE ONLY aclweights
+    ADD CONSTRAINT aclweights_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+GRANT SELECT ON aclpolicies, aclweights TO migscheduler;
+GRANT SELECT, INSERT, UPDATE, DELETE ON aclpolicies, aclweights TO migapi;
+GRANT SELECT ON aclpolicies, aclweights TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (3, 'agent acl policies');
+CREATE INDEX agents_stats_timestamp_idx ON agents_stats(timestamp DESC);
+CREATE INDEX actions_starttime_idx ON actions(starttime DESC);
+CREATE INDEX commands_starttime_idx ON commands(starttime DESC);
+INSERT INTO schema_version (version, description) VALUES (4, 'dashboard indexes');
+ALTER TABLE agents ADD COLUMN lifecycletime timestamp with time zone;
+
+CREATE TABLE agents_archive (
+    id                  numeric NOT NULL,
+    name                character varying(2048) NOT NULL,
+    queueloc            character varying(2048) NOT NULL,
+    mode                character varying(2048) NOT NULL,
+    version             character varying(2048) NOT NULL,
+    pid                 integer NOT NULL,
+    starttime           timestamp with time zone NOT NULL,
+    destructiontime     timestamp with time zone,
+    heartbeattime       timestamp with time zone NOT NULL,
+    refreshtime         timestamp with time zone NOT NULL,
+    status              character varying(255),
+    environment         json,
+    tags                json,
+    loadername          character varying(2048),
+    lifecycletime       timestamp with time zone,
+    archivedat          timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agents_archive OWNER TO migadmin;
+ALTER TABLE ONLY agents_archive
+    ADD CONSTRAINT agents_archive_pkey PRIMARY KEY (id);
+CREATE INDEX agents_archive_queueloc_idx ON agents_archive(queueloc);
+
+CREATE TABLE commands_archive (
+    id          numeric NOT NULL,
+    actionid    numeric NOT NULL,
+    agentid     numeric NOT NULL,
+    status      character varying(255) NOT NULL,
+    results     jsonb,
+    starttime   timestamp with time zone NOT NULL,
+    finishtime  timestamp with time zone
+);
+ALTER TABLE public.commands_archive OWNER TO migadmin;
+ALTER TABLE ONLY commands_archive
+    ADD CONSTRAINT commands_archive_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY commands_archive
+    ADD CONSTRAINT commands_archive_agentid_fkey FOREIGN KEY (agentid) REFERENCES agents_archive(id);
+CREATE INDEX commands_archive_agentid ON commands_archive(agentid DESC);
+CREATE INDEX commands_archive_actionid ON commands_archive(actionid DESC);
+
+ALTER TABLE agents_stats ADD COLUMN quarantined_endpoints numeric;
+ALTER TABLE agents_stats ADD COLUMN decommissioned_endpoints numeric;
+
+UPDATE investigators SET permissions = permissions | 524288 WHERE (permissions & 262144) != 0;
+
+GRANT SELECT, INSERT ON agents_archive, commands_archive TO migscheduler;
+GRANT DELETE ON agents, commands, invagtmodperm TO migscheduler;
+GRANT SELECT ON agents_archive, commands_archive TO migapi;
+GRANT SELECT ON agents_archive, commands_archive TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (5, 'agent lifecycle');
+CREATE TABLE agents_duplicates (
+    id          numeric NOT NULL,
+    queueloc    character varying(2048) NOT NULL,
+    kind        character varying(32) NOT NULL,
+    policy      character varying(32) NOT NULL,
+    decision    character varying(32) NOT NULL,
+    evidence    json NOT NULL,
+    createdat   timestamp with time zone NOT NULL
+);
+ALTER TABLE public.agents_duplicates OWNER TO migadmin;
+ALTER TABLE ONLY agents_duplicates
+    ADD CONSTRAINT agents_duplicates_pkey PRIMARY KEY (id);
+CREATE INDEX agents_duplicates_queueloc_idx ON agents_duplicates(queueloc, createdat DESC);
+CREATE INDEX agents_duplicates_createdat_idx ON agents_duplicates(createdat DESC);
+
+GRANT SELECT, INSERT ON agents_duplicates TO migscheduler;
+GRANT SELECT ON agents_duplicates TO migapi;
+GRANT SELECT ON agents_duplicates TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (6, 'duplicate agents decisions');
+CREATE TABLE webhooks (
+    id                  numeric NOT NULL,
+    name                character varying(1024) NOT NULL,
+    url                 character varying(2048) NOT NULL,
+    secret              character varying(128) NOT NULL,
+    events              json NOT NULL,
+    investigatorid      numeric,
+    threatfamily        character varying(256),
+    target              character varying(2048),
+    failurethreshold    integer NOT NULL DEFAULT 0,
+    status              character varying(32) NOT NULL,
+    createdat           timestamp with time zone NOT NULL
+);
+ALTER TABLE public.webhooks OWNER TO migadmin;
+ALTER TABLE ONLY webhooks
+    ADD CONSTRAINT webhooks_pkey PRIMARY KEY (id);
+
+CREATE TABLE webhook_deliveries (
+    id              numeric NOT NULL,
+    webhookid       numeric NOT NULL,
+    event           character varying(64) NOT NULL,
+    actionid        numeric NOT NULL,
+    payload         json NOT NULL,
+    status          character varying(32) NOT NULL,
+    attempts        integer NOT NULL DEFAULT 0,
+    nextattempt     timestamp with time zone NOT NULL,
+    lastattempt     timestamp with time zone,
+    responsecode    integer,
+    lasterror       text,
+    createdat       timestamp with time zone NOT NULL
+);
+ALTER TABLE public.webhook_deliveries OWNER TO migadmin;
+ALTER TABLE ONLY webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_webhookid_fkey FOREIGN KEY (webhookid) REFERENCES webhooks(id);
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries(webhookid, actionid, event);
+CREATE INDEX webhook_deliveries_nextattempt_idx ON webhook_deliveries(status, nextattempt);
+
+UPDATE investigators SET permissions = permissions | 1048576 WHERE (permissions & 262144) != 0;
+
+GRANT SELECT, INSERT, UPDATE ON webhooks TO migapi;
+GRANT SELECT ON webhook_deliveries TO migapi;
+GRANT SELECT ON webhooks TO migscheduler;
+GRANT SELECT, INSERT, UPDATE ON webhook_deliveries TO migscheduler;
+GRANT SELECT (id, name, url, events, investigatorid, threatfamily, target, failurethreshold, status, createdat) ON webhooks TO migreadonly;
+GRANT SELECT ON webhook_deliveries TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (7, 'webhooks');
+CREATE TABLE cases (
+    id              numeric NOT NULL,
+    title           character varying(2048) NOT NULL,
+    status          character varying(32) NOT NULL,
+    createdby       numeric NOT NULL,
+    createdat       timestamp with time zone NOT NULL,
+    lastmodified    timestamp with time zone NOT NULL
+);
+ALTER TABLE public.cases OWNER TO migadmin;
+ALTER TABLE ONLY cases
+    ADD CONSTRAINT cases_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY cases
+    ADD CONSTRAINT cases_createdby_fkey FOREIGN KEY (createdby) REFERENCES investigators(id);
+CREATE INDEX cases_status_idx ON cases(status);
+
+CREATE TABLE case_assignees (
+    caseid          numeric NOT NULL,
+    investigatorid  numeric NOT NULL
+);
+ALTER TABLE public.case_assignees OWNER TO migadmin;
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_pkey PRIMARY KEY (caseid, investigatorid);
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_assignees
+    ADD CONSTRAINT case_assignees_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+
+CREATE TABLE case_notes (
+    id              numeric NOT NULL,
+    caseid          numeric NOT NULL,
+    investigatorid  numeric NOT NULL,
+    note            text NOT NULL,
+    createdat       timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_notes OWNER TO migadmin;
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_pkey PRIMARY KEY (id);
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_notes
+    ADD CONSTRAINT case_notes_investigatorid_fkey FOREIGN KEY (investigatorid) REFERENCES investigators(id);
+CREATE INDEX case_notes_caseid_idx ON case_notes(caseid);
+
+CREATE TABLE case_actions (
+    caseid          numeric NOT NULL,
+    actionid        numeric NOT NULL,
+    addedat         timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_actions OWNER TO migadmin;
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_pkey PRIMARY KEY (caseid, actionid);
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+ALTER TABLE ONLY case_actions
+    ADD CONSTRAINT case_actions_actionid_fkey FOREIGN KEY (actionid) REFERENCES actions(id);
+CREATE INDEX case_actions_actionid_idx ON case_actions(actionid);
+
+CREATE TABLE case_agents (
+    caseid          numeric NOT NULL,
+    agentid         numeric NOT NULL,
+    name            character varying(2048) NOT NULL,
+    queueloc        character varying(2048) NOT NULL,
+    addedat         timestamp with time zone NOT NULL
+);
+ALTER TABLE public.case_agents OWNER TO migadmin;
+ALTER TABLE ONLY case_agents
+    ADD CONSTRAINT case_agents_pkey PRIMARY KEY (caseid, queueloc);
+ALTER TABLE ONLY case_agents
+    ADD CONSTRAINT case_agents_caseid_fkey FOREIGN KEY (caseid) REFERENCES cases(id);
+
+UPDATE investigators SET permissions = permissions | 2097152 WHERE (permissions & 2) != 0;
+
+GRANT SELECT, INSERT, UPDATE ON cases TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_assignees TO migapi;
+GRANT SELECT, INSERT ON case_notes TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_actions TO migapi;
+GRANT SELECT, INSERT, DELETE ON case_agents TO migapi;
+GRANT SELECT ON cases, case_assignees, case_notes, case_actions, case_agents TO migreadonly;
+INSERT INTO schema_version (version, description) VALUES (8, 'cases');
+
+ALTER TABLE agents ADD COLUMN publicips inet[];
+ALTER TABLE agents_archive ADD COLUMN publicips inet[];
+CREATE FUNCTION migration_0009_inet(ip text) RETURNS inet AS $$
+BEGIN
+    RETURN ip::inet;
+EXCEPTION WHEN invalid_text_representation THEN
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+UPDATE agents SET publicips = ARRAY[migration_0009_inet(environment->>'publicip')]
+    WHERE migration_0009_inet(environment->>'publicip') IS NOT NULL;
+DROP FUNCTION migration_0009_inet(text);
+CREATE INDEX agents_publicips_idx ON agents USING gin (publicips);
+INSERT INTO schema_version (version, description) VALUES (9, 'agents public ips');
+
+ALTER TABLE actions ADD COLUMN nonce character varying(256) NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX actions_nonce_idx ON actions (nonce) WHERE nonce != '';
+INSERT INTO schema_version (version, description) VALUES (10, 'actions nonce');
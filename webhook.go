@@ -0,0 +1,234 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig /* import "github.com/mozilla/mig" */
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Events webhooks can subscribe to. Each event is delivered at most once per
+// webhook and action.
+const (
+	// WebhookEventActionDone is sent when all the commands of an action have finished
+	WebhookEventActionDone string = "action.done"
+	// WebhookEventFoundAnything is sent when the first command of an action
+	// that found something returns
+	WebhookEventFoundAnything string = "action.foundanything"
+	// WebhookEventFailures is sent when the number of failed and timed out
+	// commands of an action goes over the failure threshold of the webhook
+	WebhookEventFailures string = "action.failures"
+)
+
+// Statuses of webhooks
+const (
+	WebhookStatusActive   string = "active"
+	WebhookStatusDisabled string = "disabled"
+)
+
+// Statuses of webhook deliveries
+const (
+	WebhookDeliveryPending   string = "pending"
+	WebhookDeliveryDelivered string = "delivered"
+	WebhookDeliveryFailed    string = "failed"
+)
+
+// WebhookSignatureHeader is the HTTP header that carries the HMAC-SHA256
+// signature of the body of a webhook delivery, computed with the secret of
+// the webhook and formatted as sha256=<hex>
+const WebhookSignatureHeader = "X-MIG-Signature"
+
+// WebhookSecretLength is the length in bytes of the secrets generated for webhooks
+const WebhookSecretLength = 32
+
+// Webhook describes an HTTP endpoint notified of action events. The filters
+// restrict the actions a webhook is notified of: actions signed by an
+// investigator, actions with a threat family, and actions whose target
+// contains a string, compared without case. Empty filters match all actions.
+type Webhook struct {
+	ID               float64   `json:"id"`
+	Name             string    `json:"name"`
+	URL              string    `json:"url"`
+	Secret           string    `json:"secret,omitempty"`
+	Events           []string  `json:"events"`
+	InvestigatorID   float64   `json:"investigatorid,omitempty"`
+	ThreatFamily     string    `json:"threatfamily,omitempty"`
+	Target           string    `json:"target,omitempty"`
+	FailureThreshold int       `json:"failurethreshold,omitempty"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"createdat"`
+}
+
+// Validate returns an error if the webhook has no valid URL or events
+func (w Webhook) Validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("webhook name must be set")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url %q must be an absolute http or https url", w.URL)
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("webhook must subscribe to at least one event")
+	}
+	for _, ev := range w.Events {
+		switch ev {
+		case WebhookEventActionDone, WebhookEventFoundAnything:
+		case WebhookEventFailures:
+			if w.FailureThreshold < 1 {
+				return fmt.Errorf("webhook subscribed to %s must have a failure threshold of 1 or more", ev)
+			}
+		default:
+			return fmt.Errorf("unknown webhook event %q", ev)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the webhook is active, subscribed to event, and its
+// filters match action a signed by investigators
+func (w Webhook) Matches(event string, a Action, investigators []Investigator) bool {
+	if w.Status != WebhookStatusActive {
+		return false
+	}
+	subscribed := false
+	for _, ev := range w.Events {
+		if ev == event {
+			subscribed = true
+			break
+		}
+	}
+	if !subscribed {
+		return false
+	}
+	if w.ThreatFamily != "" && !strings.EqualFold(w.ThreatFamily, a.Threat.Family) {
+		return false
+	}
+	if w.Target != "" && !strings.Contains(strings.ToLower(a.Target), strings.ToLower(w.Target)) {
+		return false
+	}
+	if w.InvestigatorID != 0 {
+		for _, inv := range investigators {
+			if inv.ID == w.InvestigatorID {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// GenerateWebhookSecret returns a random secret to sign webhook deliveries with
+func GenerateWebhookSecret() (string, error) {
+	buf := make([]byte, WebhookSecretLength)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// SignWebhookPayload returns the value of the signature header of a webhook
+// delivery of payload, signed with secret
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature returns true if signature is the signature of payload
+// with secret. Receivers of webhooks use it to authenticate deliveries.
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, payload)), []byte(signature))
+}
+
+// WebhookRetryDelay returns how long to wait before retrying a delivery that
+// failed attempts times. The delay doubles with each attempt, from 30 seconds
+// up to one hour.
+func WebhookRetryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+// WebhookDelivery is a notification sent, or to be sent, to a webhook. The
+// payload is built when the event happens, and is signed when it is sent.
+type WebhookDelivery struct {
+	ID           float64         `json:"id"`
+	WebhookID    float64         `json:"webhookid"`
+	Event        string          `json:"event"`
+	ActionID     float64         `json:"actionid"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"nextattempt"`
+	LastAttempt  time.Time       `json:"lastattempt,omitempty"`
+	ResponseCode int             `json:"responsecode,omitempty"`
+	LastError    string          `json:"lasterror,omitempty"`
+	CreatedAt    time.Time       `json:"createdat"`
+}
+
+// WebhookPayload is the JSON document posted to webhooks
+type WebhookPayload struct {
+	Event      string          `json:"event"`
+	DeliveryID float64         `json:"deliveryid"`
+	WebhookID  float64         `json:"webhookid"`
+	Time       time.Time       `json:"time"`
+	Action     WebhookAction   `json:"action"`
+	Command    *WebhookCommand `json:"command,omitempty"`
+}
+
+// WebhookAction summarizes the action an event is about
+type WebhookAction struct {
+	ID            float64        `json:"id"`
+	Name          string         `json:"name"`
+	Target        string         `json:"target"`
+	Status        string         `json:"status"`
+	Threat        Threat         `json:"threat,omitempty"`
+	Counters      ActionCounters `json:"counters"`
+	Investigators []string       `json:"investigators,omitempty"`
+}
+
+// WebhookCommand identifies the command that found something, in
+// action.foundanything events
+type WebhookCommand struct {
+	ID        float64 `json:"id"`
+	AgentName string  `json:"agentname"`
+	Status    string  `json:"status"`
+}
+
+// NewWebhookPayload returns the payload of an event on action a
+func NewWebhookPayload(event string, a Action, investigators []Investigator) (p WebhookPayload) {
+	p = WebhookPayload{
+		Event: event,
+		Time:  time.Now().UTC(),
+		Action: WebhookAction{
+			ID:       a.ID,
+			Name:     a.Name,
+			Target:   a.Target,
+			Status:   a.Status,
+			Threat:   a.Threat,
+			Counters: a.Counters,
+		},
+	}
+	for _, inv := range investigators {
+		p.Action.Investigators = append(p.Action.Investigators, inv.Name)
+	}
+	return
+}
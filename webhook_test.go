@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+//
+// Contributor: Julien Vehent jvehent@mozilla.com [:ulfr]
+
+package mig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	testcases := []struct {
+		w     Webhook
+		valid bool
+	}{
+		{Webhook{Name: "soc", URL: "https://hooks.example.net/mig", Events: []string{WebhookEventActionDone}}, true},
+		{Webhook{Name: "soc", URL: "https://hooks.example.net/mig", Events: []string{WebhookEventFailures}, FailureThreshold: 10}, true},
+		{Webhook{Name: "soc", URL: "https://hooks.example.net/mig", Events: []string{WebhookEventFailures}}, false},
+		{Webhook{Name: "soc", URL: "ftp://hooks.example.net/mig", Events: []string{WebhookEventActionDone}}, false},
+		{Webhook{Name: "soc", URL: "/mig", Events: []string{WebhookEventActionDone}}, false},
+		{Webhook{Name: "soc", URL: "https://hooks.example.net/mig", Events: []string{"action.started"}}, false},
+		{Webhook{Name: "soc", URL: "https://hooks.example.net/mig"}, false},
+		{Webhook{URL: "https://hooks.example.net/mig", Events: []string{WebhookEventActionDone}}, false},
+	}
+	for i, tc := range testcases {
+		err := tc.w.Validate()
+		if (err == nil) != tc.valid {
+			t.Errorf("case %d: expected valid=%t, got %v", i, tc.valid, err)
+		}
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	a := Action{ID: 1, Target: "environment->>'os'='linux' AND name LIKE '%.db.example.net'",
+		Threat: Threat{Family: "malware"}}
+	invs := []Investigator{{ID: 12, Name: "Bob Kelso"}}
+	testcases := []struct {
+		w       Webhook
+		event   string
+		matches bool
+	}{
+		{Webhook{Events: []string{WebhookEventActionDone}}, WebhookEventActionDone, true},
+		{Webhook{Events: []string{WebhookEventActionDone}}, WebhookEventFoundAnything, false},
+		{Webhook{Events: []string{WebhookEventActionDone}, ThreatFamily: "Malware"}, WebhookEventActionDone, true},
+		{Webhook{Events: []string{WebhookEventActionDone}, ThreatFamily: "compliance"}, WebhookEventActionDone, false},
+		{Webhook{Events: []string{WebhookEventActionDone}, Target: "DB.example"}, WebhookEventActionDone, true},
+		{Webhook{Events: []string{WebhookEventActionDone}, Target: "darwin"}, WebhookEventActionDone, false},
+		{Webhook{Events: []string{WebhookEventActionDone}, InvestigatorID: 12}, WebhookEventActionDone, true},
+		{Webhook{Events: []string{WebhookEventActionDone}, InvestigatorID: 13}, WebhookEventActionDone, false},
+	}
+	for i, tc := range testcases {
+		tc.w.Status = WebhookStatusActive
+		if tc.w.Matches(tc.event, a, invs) != tc.matches {
+			t.Errorf("case %d: expected matches=%t", i, tc.matches)
+		}
+	}
+	w := Webhook{Events: []string{WebhookEventActionDone}, Status: WebhookStatusDisabled}
+	if w.Matches(WebhookEventActionDone, a, invs) {
+		t.Error("expected disabled webhook to not match")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret, err := GenerateWebhookSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 2*WebhookSecretLength {
+		t.Fatalf("expected a secret of %d hex characters, got %d", 2*WebhookSecretLength, len(secret))
+	}
+	payload := []byte(`{"event":"action.done"}`)
+	sig := SignWebhookPayload(secret, payload)
+	if !VerifyWebhookSignature(secret, payload, sig) {
+		t.Fatal("expected signature to verify")
+	}
+	if VerifyWebhookSignature(secret, []byte(`{"event":"action.failures"}`), sig) {
+		t.Fatal("expected signature of a different payload to fail")
+	}
+	// known HMAC-SHA256 test vector from RFC 4231, test case 2
+	if SignWebhookPayload("Jefe", []byte("what do ya want for nothing?")) !=
+		"sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Fatal("signature does not match the HMAC-SHA256 test vector")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	testcases := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tc := range testcases {
+		if d := WebhookRetryDelay(tc.attempts); d != tc.delay {
+			t.Errorf("attempt %d: expected delay %s, got %s", tc.attempts, tc.delay, d)
+		}
+	}
+}